@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ad/go-llm-manager/internal/auth"
+	"github.com/ad/go-llm-manager/internal/config"
+	"github.com/ad/go-llm-manager/internal/database"
+)
+
+func TestRequireProcessorAuthDefaultRejectsSharedKey(t *testing.T) {
+	t.Setenv("REQUIRE_PROCESSOR_TOKEN", "")
+	cfg := config.Load([]string{"server"})
+	if !cfg.Auth.RequireProcessorToken {
+		t.Fatal("REQUIRE_PROCESSOR_TOKEN must default to true")
+	}
+
+	db := database.NewTestDB(t)
+	jwtAuth := auth.NewJWTAuth(cfg.Auth.JWTSecret)
+	middleware := requireProcessorAuth(auth.NewAPIKeyManager(cfg.Auth.InternalAPIKey, db), auth.NewProcessorAuth(jwtAuth), cfg.Auth.RequireProcessorToken)
+
+	var boundID string
+	handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		boundID, _ = auth.ProcessorIDFromContext(r.Context())
+	}))
+
+	// Владелец общего ключа выдаёт себя за proc-victim
+	req := httptest.NewRequest(http.MethodGet, "/api/internal/task-stream?processor_id=proc-victim", nil)
+	req.Header.Set("Authorization", "Bearer "+cfg.Auth.InternalAPIKey)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized || !strings.Contains(rr.Body.String(), "Processor token required") {
+		t.Fatalf("shared key with default config: code %d, body %s; want 401", rr.Code, rr.Body.String())
+	}
+
+	token, err := jwtAuth.GenerateToken(&database.JWTPayload{Subject: "proc-1", ProcessorID: "proc-1"}, 3600)
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+	req = httptest.NewRequest(http.MethodGet, "/api/internal/task-stream?processor_id=proc-victim&token="+token, nil)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || boundID != "proc-1" {
+		t.Fatalf("processor token: code %d, bound %q; want 200 bound to proc-1", rr.Code, boundID)
+	}
+}
@@ -2,110 +2,209 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/ad/go-llm-manager/internal/api/handlers"
+	"github.com/ad/go-llm-manager/internal/archive"
 	"github.com/ad/go-llm-manager/internal/auth"
+	"github.com/ad/go-llm-manager/internal/backup"
 	"github.com/ad/go-llm-manager/internal/config"
 	"github.com/ad/go-llm-manager/internal/database"
+	"github.com/ad/go-llm-manager/internal/logging"
 	"github.com/ad/go-llm-manager/internal/middleware"
+	"github.com/ad/go-llm-manager/internal/ratelimit"
+	"github.com/ad/go-llm-manager/internal/tracing"
 )
 
 var version = "dev" // Set by build system
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(os.Args))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "restore" {
+		os.Exit(runRestore(os.Args))
+	}
+
 	// Load configuration
 	cfg := config.Load(os.Args)
+	if err := logging.Setup(os.Stderr, cfg.Logging); err != nil {
+		fatal("Invalid logging configuration", logging.Err(err))
+	}
+	slog.Info("Loaded configuration", "config", cfg)
+
+	if !ratelimit.ValidAlgorithm(cfg.RateLimit.Algorithm) {
+		fatal("Unknown RATE_LIMIT_ALGORITHM", "algorithm", cfg.RateLimit.Algorithm)
+	}
+	if !tracing.ValidExporter(cfg.Tracing.Exporter) {
+		fatal("Unknown TRACING_EXPORTER", "exporter", cfg.Tracing.Exporter)
+	}
+
+	// Initialize tracing
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing, version)
+	if err != nil {
+		fatal("Failed to initialize tracing", logging.Err(err))
+	}
 
 	// Initialize database
 	db, err := database.NewSQLiteDB(cfg.Database.Path)
 	if err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+		fatal("Failed to initialize database", logging.Err(err))
 	}
 	defer db.Close()
+	db.SetTimeouts(dbTimeouts(cfg.Database))
 
 	// Run migrations
-	if err := db.RunMigrations(); err != nil {
-		log.Fatalf("Failed to run migrations: %v", err)
+	applied, err := db.Migrate(context.Background(), migrationSource(cfg.Database.MigrationsPath))
+	if err != nil {
+		fatal("Failed to run migrations", logging.Err(err))
+	}
+	for _, m := range applied {
+		slog.Info("Applied migration", "version", m.Version, "name", m.Name)
 	}
 
 	// Initialize auth
 	jwtAuth := auth.NewJWTAuth(cfg.Auth.JWTSecret)
-	apiKeyAuth := auth.NewAPIKeyManager(cfg.Auth.InternalAPIKey)
+	if cfg.Auth.JWTKeyringFile != "" {
+		keyring, err := auth.LoadKeyringFile(cfg.Auth.JWTKeyringFile, cfg.Auth.JWTKeyGracePeriod)
+		if err != nil {
+			fatal("Failed to load JWT keyring", logging.Err(err))
+		}
+		slog.Info("Loaded JWT keyring", "active_key_id", keyring.ActiveKeyID())
+		jwtAuth = auth.NewJWTAuthWithKeyring(keyring)
+	}
+	denylist, err := auth.NewDenylist(context.Background(), db)
+	if err != nil {
+		fatal("Failed to load token denylist", logging.Err(err))
+	}
+	denylist.Start()
+	defer denylist.Stop()
+	jwtAuth.SetDenylist(denylist)
+
+	apiKeyAuth := auth.NewAPIKeyManager(cfg.Auth.InternalAPIKey, db)
+	processorAuth := auth.NewProcessorAuth(jwtAuth)
+	requireProcessor := requireProcessorAuth(apiKeyAuth, processorAuth, cfg.Auth.RequireProcessorToken)
 
 	// Initialize handlers
 	publicHandlers := handlers.NewPublicHandlers(db, jwtAuth, cfg)
 	internalHandlers := handlers.NewInternalHandlers(db, jwtAuth)
+	internalHandlers.SetCleanup(cfg.Cleanup, archive.NewArchiver(db, cfg.Cleanup.ArchiveDir))
 	sseHandlers := handlers.NewSSEHandlers(db, jwtAuth)
 
 	// Связываем SSE manager с publicHandlers для push новых задач
 	handlers.SetSSEManager(sseHandlers.Manager())
 
+	// Отслеживание отвалившихся процессоров и возврат их задач в очередь
+	processorMonitor := handlers.NewProcessorMonitor(db, sseHandlers.Manager(), cfg.Processor)
+	sseHandlers.SetProcessorMonitor(processorMonitor)
+	processorMonitor.Start()
+	healthHandlers := handlers.NewHealthHandlers(db, processorMonitor, cfg.Health)
+
+	// Снимки БД по расписанию и по запросу
+	backups := backup.NewManager(db, cfg.Backup)
+	backups.Start()
+	defer backups.Stop()
+	backupHandlers := handlers.NewBackupHandlers(backups)
+
+	// Лимиты маршрутов по IP клиента и субъекту JWT
+	routeLimits, err := ratelimit.ParseRouteLimits(cfg.RateLimit.RouteLimits)
+	if err != nil {
+		fatal("Invalid ROUTE_RATE_LIMITS", logging.Err(err))
+	}
+	clientIPs, err := middleware.NewClientIPResolver(strings.Split(cfg.RateLimit.TrustedProxies, ","))
+	if err != nil {
+		fatal("Invalid TRUSTED_PROXIES", logging.Err(err))
+	}
+	subjectKey := jwtSubjectRateLimitKey(jwtAuth)
+	logRequests := middleware.Logging(clientIPs.ClientIP)
+	sseUserLimiter := ratelimit.NewConcurrencyLimiter(cfg.SSE.MaxConnectionsPerUser)
+	sseIPLimiter := ratelimit.NewConcurrencyLimiter(cfg.SSE.MaxConnectionsPerIP)
+
 	// Setup router
 	mux := http.NewServeMux()
 
 	// Public endpoints (with CORS)
 	mux.Handle("/", middleware.Chain(
 		http.HandlerFunc(publicHandlers.HealthCheck),
-		middleware.Logging,
+		logRequests,
 		middleware.CORS,
 		middleware.ContentType,
 	))
 
 	mux.Handle("/health", middleware.Chain(
 		http.HandlerFunc(publicHandlers.Health),
-		middleware.Logging,
+		logRequests,
+		middleware.CORS,
+		middleware.ContentType,
+	))
+
+	// Пробы Kubernetes: без Logging, чтобы не засорять логи
+	mux.Handle("/livez", middleware.Chain(
+		http.HandlerFunc(healthHandlers.Livez),
+		middleware.ContentType,
+	))
+
+	mux.Handle("/readyz", middleware.Chain(
+		http.HandlerFunc(healthHandlers.Readyz),
+		middleware.ContentType,
+	))
+
+	mux.Handle("/.well-known/jwks.json", middleware.Chain(
+		http.HandlerFunc(publicHandlers.JWKS),
+		logRequests,
 		middleware.CORS,
 		middleware.ContentType,
 	))
 
 	mux.Handle("/admin", middleware.Chain(
 		http.HandlerFunc(publicHandlers.Admin),
-		middleware.Logging,
+		logRequests,
 		middleware.CORS,
 	))
 	mux.Handle("/admin.js", middleware.Chain(
 		http.HandlerFunc(publicHandlers.AdminJS),
-		middleware.Logging,
+		logRequests,
 		middleware.CORS,
 	))
 	mux.Handle("/admin.css", middleware.Chain(
 		http.HandlerFunc(publicHandlers.AdminCSS),
-		middleware.Logging,
+		logRequests,
 		middleware.CORS,
 	))
 
 	mux.Handle("/query", middleware.Chain(
 		http.HandlerFunc(publicHandlers.Query),
-		middleware.Logging,
+		logRequests,
 		middleware.CORS,
 	))
 
 	// JWT-protected endpoints
 	mux.Handle("/api/create", middleware.Chain(
 		http.HandlerFunc(publicHandlers.CreateTask),
-		middleware.Logging,
+		logRequests,
 		middleware.CORS,
 		middleware.ContentType,
 	))
 
 	mux.Handle("/api/result", middleware.Chain(
 		http.HandlerFunc(publicHandlers.GetResult),
-		middleware.Logging,
+		logRequests,
 		middleware.CORS,
 		middleware.ContentType,
 	))
 
 	mux.Handle("/api/get", middleware.Chain(
 		http.HandlerFunc(publicHandlers.GetUserData),
-		middleware.Logging,
+		logRequests,
 		middleware.CORS,
 		middleware.ContentType,
 	))
@@ -113,7 +212,7 @@ func main() {
 	// Task voting endpoint (JWT-protected)
 	mux.Handle("/api/tasks/vote", middleware.Chain(
 		http.HandlerFunc(publicHandlers.VoteTask),
-		middleware.Logging,
+		logRequests,
 		middleware.CORS,
 		middleware.ContentType,
 	))
@@ -121,134 +220,257 @@ func main() {
 	// SSE endpoints
 	mux.Handle("/api/result-polling", middleware.Chain(
 		http.HandlerFunc(sseHandlers.ResultPolling),
-		// middleware.Logging,
+		middleware.LimitConcurrency(subjectKey, sseUserLimiter),
+		middleware.LimitConcurrency(clientIPs.RateLimitKey, sseIPLimiter),
+		// logRequests,
 		middleware.CORS,
 	))
 
 	// Internal API endpoints (API key protected)
 	mux.Handle("/api/internal/generate-token", middleware.Chain(
 		http.HandlerFunc(internalHandlers.GenerateToken),
-		requireAPIKey(apiKeyAuth),
-		middleware.Logging,
+		requireAPIKey(apiKeyAuth, auth.ScopeTokenMint),
+		logRequests,
 		middleware.CORS,
 		middleware.ContentType,
 	))
 
 	mux.Handle("/api/internal/tasks", middleware.Chain(
 		http.HandlerFunc(internalHandlers.GetTasks),
-		requireAPIKey(apiKeyAuth),
-		middleware.Logging,
+		requireAPIKey(apiKeyAuth, auth.ScopeAdminRead),
+		logRequests,
 		middleware.CORS,
 		middleware.ContentType,
 	))
 
 	mux.Handle("/api/internal/all-tasks", middleware.Chain(
 		http.HandlerFunc(internalHandlers.GetAllTasks),
-		requireAPIKey(apiKeyAuth),
-		middleware.Logging,
+		requireAPIKey(apiKeyAuth, auth.ScopeAdminRead),
+		logRequests,
+		middleware.CORS,
+		middleware.ContentType,
+	))
+
+	mux.Handle("/api/internal/search", middleware.Chain(
+		http.HandlerFunc(internalHandlers.SearchTasks),
+		requireAPIKey(apiKeyAuth, auth.ScopeAdminRead),
+		logRequests,
 		middleware.CORS,
 		middleware.ContentType,
 	))
 
 	mux.Handle("/api/internal/claim", middleware.Chain(
 		http.HandlerFunc(internalHandlers.ClaimTasks),
-		requireAPIKey(apiKeyAuth),
-		middleware.Logging,
+		requireProcessor,
+		logRequests,
 		middleware.CORS,
 		middleware.ContentType,
 	))
 
 	mux.Handle("/api/internal/heartbeat", middleware.Chain(
 		http.HandlerFunc(internalHandlers.Heartbeat),
-		requireAPIKey(apiKeyAuth),
-		middleware.Logging,
+		requireProcessor,
+		logRequests,
 		middleware.CORS,
 		middleware.ContentType,
 	))
 
 	mux.Handle("/api/internal/processor-heartbeat", middleware.Chain(
 		http.HandlerFunc(internalHandlers.ProcessorHeartbeat),
-		requireAPIKey(apiKeyAuth),
-		middleware.Logging,
+		requireProcessor,
+		logRequests,
 		middleware.CORS,
 		middleware.ContentType,
 	))
 
 	mux.Handle("/api/internal/complete", middleware.Chain(
 		http.HandlerFunc(internalHandlers.CompleteTasks),
-		requireAPIKey(apiKeyAuth),
-		middleware.Logging,
+		requireProcessor,
+		logRequests,
 		middleware.CORS,
 		middleware.ContentType,
 	))
 
 	mux.Handle("/api/internal/cleanup", middleware.Chain(
 		http.HandlerFunc(internalHandlers.Cleanup),
-		requireAPIKey(apiKeyAuth),
-		middleware.Logging,
+		requireAPIKey(apiKeyAuth, auth.ScopeAdminWrite),
+		logRequests,
 		middleware.CORS,
 		middleware.ContentType,
 	))
 
 	mux.Handle("/api/internal/cleanup/stats", middleware.Chain(
 		http.HandlerFunc(internalHandlers.CleanupStats),
-		requireAPIKey(apiKeyAuth),
-		middleware.Logging,
+		requireAPIKey(apiKeyAuth, auth.ScopeAdminRead),
+		logRequests,
+		middleware.CORS,
+		middleware.ContentType,
+	))
+
+	mux.Handle("/api/internal/archives", middleware.Chain(
+		http.HandlerFunc(internalHandlers.Archives),
+		requireAPIKey(apiKeyAuth, auth.ScopeAdminRead),
+		logRequests,
+		middleware.CORS,
+		middleware.ContentType,
+	))
+
+	mux.Handle("/api/internal/archives/import", middleware.Chain(
+		http.HandlerFunc(internalHandlers.ImportArchive),
+		requireAPIKey(apiKeyAuth, auth.ScopeAdminWrite),
+		logRequests,
 		middleware.CORS,
 		middleware.ContentType,
 	))
 
 	mux.Handle("/api/internal/work-steal", middleware.Chain(
 		http.HandlerFunc(internalHandlers.WorkSteal),
-		requireAPIKey(apiKeyAuth),
-		middleware.Logging,
+		requireProcessor,
+		logRequests,
 		middleware.CORS,
 		middleware.ContentType,
 	))
 
 	mux.Handle("/api/internal/metrics", middleware.Chain(
 		http.HandlerFunc(internalHandlers.ProcessorMetrics),
-		requireAPIKey(apiKeyAuth),
-		middleware.Logging,
+		requireAPIKey(apiKeyAuth, auth.ScopeAdminRead),
+		logRequests,
 		middleware.CORS,
 		middleware.ContentType,
 	))
 
 	mux.Handle("/api/internal/estimated-time", middleware.Chain(
 		http.HandlerFunc(internalHandlers.EstimatedTime),
-		requireAPIKey(apiKeyAuth),
-		middleware.Logging,
+		requireAPIKey(apiKeyAuth, auth.ScopeAdminRead),
+		logRequests,
 		middleware.CORS,
 		middleware.ContentType,
 	))
 
 	mux.Handle("/api/internal/task-stream", middleware.Chain(
 		http.HandlerFunc(sseHandlers.TaskStream),
-		requireAPIKey(apiKeyAuth),
-		// middleware.Logging,
+		requireProcessor,
+		// logRequests,
 		middleware.CORS,
 	))
 
 	mux.Handle("/api/internal/requeue", middleware.Chain(
 		http.HandlerFunc(internalHandlers.RequeueTask),
-		requireAPIKey(apiKeyAuth),
-		middleware.Logging,
+		requireProcessor,
+		logRequests,
+		middleware.CORS,
+		middleware.ContentType,
+	))
+
+	mux.Handle("/api/internal/processors", middleware.Chain(
+		http.HandlerFunc(internalHandlers.Processors),
+		requireAPIKeyByMethod(apiKeyAuth, auth.ScopeAdminRead, auth.ScopeAdminWrite),
+		logRequests,
+		middleware.CORS,
+		middleware.ContentType,
+	))
+
+	mux.Handle("/api/internal/processors/revoke-tokens", middleware.Chain(
+		http.HandlerFunc(internalHandlers.RevokeProcessorTokens),
+		requireAPIKey(apiKeyAuth, auth.ScopeAdminWrite),
+		logRequests,
+		middleware.CORS,
+		middleware.ContentType,
+	))
+
+	mux.Handle("/api/internal/api-keys", middleware.Chain(
+		http.HandlerFunc(internalHandlers.APIKeys),
+		requireAPIKeyByMethod(apiKeyAuth, auth.ScopeAdminRead, auth.ScopeAdminWrite),
+		logRequests,
+		middleware.CORS,
+		middleware.ContentType,
+	))
+
+	mux.Handle("/api/internal/api-keys/rotate", middleware.Chain(
+		http.HandlerFunc(internalHandlers.RotateAPIKey),
+		requireAPIKey(apiKeyAuth, auth.ScopeAdminWrite),
+		logRequests,
+		middleware.CORS,
+		middleware.ContentType,
+	))
+
+	mux.Handle("/api/internal/tokens/revoke", middleware.Chain(
+		http.HandlerFunc(internalHandlers.RevokeTokens),
+		requireAPIKey(apiKeyAuth, auth.ScopeAdminWrite),
+		logRequests,
+		middleware.CORS,
+		middleware.ContentType,
+	))
+
+	introspectLimiter := ratelimit.NewTokenBucket(cfg.RateLimit.IntrospectRate, cfg.RateLimit.IntrospectBurst)
+	mux.Handle("/api/internal/introspect", middleware.Chain(
+		http.HandlerFunc(internalHandlers.Introspect),
+		requireAPIKey(apiKeyAuth, auth.ScopeIntrospect),
+		middleware.RateLimitBy(apiKeyRateLimitKey, introspectLimiter.Allow),
+		logRequests,
+		middleware.CORS,
+		middleware.ContentType,
+	))
+
+	mux.Handle("/api/internal/token-usage", middleware.Chain(
+		http.HandlerFunc(internalHandlers.TokenUsage),
+		requireAPIKey(apiKeyAuth, auth.ScopeAdminRead),
+		logRequests,
+		middleware.CORS,
+		middleware.ContentType,
+	))
+
+	mux.Handle("/api/internal/token-limits", middleware.Chain(
+		http.HandlerFunc(internalHandlers.TokenLimits),
+		requireAPIKeyByMethod(apiKeyAuth, auth.ScopeAdminRead, auth.ScopeAdminWrite),
+		logRequests,
+		middleware.CORS,
+		middleware.ContentType,
+	))
+
+	mux.Handle("/api/internal/backup", middleware.Chain(
+		http.HandlerFunc(backupHandlers.Create),
+		requireAPIKey(apiKeyAuth, auth.ScopeAdminWrite),
+		logRequests,
+		middleware.CORS,
+		middleware.ContentType,
+	))
+
+	mux.Handle("/api/internal/backups", middleware.Chain(
+		http.HandlerFunc(backupHandlers.List),
+		requireAPIKey(apiKeyAuth, auth.ScopeAdminRead),
+		logRequests,
+		middleware.CORS,
+		middleware.ContentType,
+	))
+
+	// Prometheus scrape; API-ключ передается как bearer_token
+	mux.Handle("/metrics", middleware.Chain(
+		http.HandlerFunc(internalHandlers.PrometheusMetrics),
+		requireAPIKey(apiKeyAuth, auth.ScopeMetrics),
+		logRequests,
+	))
+
+	mux.Handle("/api/internal/usage-report", middleware.Chain(
+		http.HandlerFunc(internalHandlers.UsageReport),
+		requireAPIKey(apiKeyAuth, auth.ScopeAdminRead),
+		logRequests,
 		middleware.CORS,
 		middleware.ContentType,
 	))
 
 	mux.Handle("/api/internal/rating-stats", middleware.Chain(
 		http.HandlerFunc(internalHandlers.GetRatingStats),
-		requireAPIKey(apiKeyAuth),
-		middleware.Logging,
+		requireAPIKey(apiKeyAuth, auth.ScopeAdminRead),
+		logRequests,
 		middleware.CORS,
 		middleware.ContentType,
 	))
 
 	mux.Handle("/api/internal/rating-analytics", middleware.Chain(
 		http.HandlerFunc(internalHandlers.GetRatingAnalytics),
-		requireAPIKey(apiKeyAuth),
-		middleware.Logging,
+		requireAPIKey(apiKeyAuth, auth.ScopeAdminRead),
+		logRequests,
 		middleware.CORS,
 		middleware.ContentType,
 	))
@@ -257,7 +479,7 @@ func main() {
 	addr := fmt.Sprintf("%s:%s", cfg.Server.Host, cfg.Server.Port)
 	server := &http.Server{
 		Addr:    addr,
-		Handler: mux,
+		Handler: middleware.RequestID(tracing.Middleware(middleware.RateLimitRoutes(routeLimits, clientIPs.RateLimitKey, subjectKey)(mux))),
 		// Security timeouts
 		ReadTimeout:       0,
 		WriteTimeout:      0,
@@ -268,9 +490,9 @@ func main() {
 
 	// Start server in goroutine
 	go func(version string) {
-		log.Printf("Starting server v.%s on %s\n", version, addr)
+		slog.Info("Starting server", "version", version, "addr", addr)
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server failed to start: %v", err)
+			fatal("Server failed to start", logging.Err(err))
 		}
 	}(version)
 
@@ -279,38 +501,161 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
-	log.Println("Shutting down server...")
+	slog.Info("Shutting down server")
+
+	// /readyz отвечает 503, пока Kubernetes не перестанет направлять трафик; повторный сигнал прерывает ожидание
+	healthHandlers.StartDraining()
+	if cfg.Health.DrainDelay > 0 {
+		slog.Info("Draining before shutdown", "delay", cfg.Health.DrainDelay.String())
+		select {
+		case <-time.After(cfg.Health.DrainDelay):
+		case <-quit:
+		}
+	}
+
+	// Останавливаем монитор до закрытия соединений, чтобы не requeue-ить задачи живых процессоров
+	processorMonitor.Stop()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	if err := server.Shutdown(ctx); err != nil {
-		log.Printf("Server forced to shutdown: %v\n", err)
+		slog.Error("Server forced to shutdown", logging.Err(err))
+	}
+
+	// Отправляем накопленные спаны до выхода
+	if err := shutdownTracing(ctx); err != nil {
+		slog.Error("Failed to flush traces", logging.Err(err))
 	}
 
-	log.Println("Server exited")
+	slog.Info("Server exited")
+}
+
+// fatal logs the error and exits, like log.Fatal for the slog logger
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// API key middleware: the key must be valid and grant scope
+func requireAPIKey(apiKeyAuth *auth.APIKeyManager, scope string) func(http.Handler) http.Handler {
+	return requireAPIKeyByMethod(apiKeyAuth, scope, scope)
 }
 
-// API key middleware
-func requireAPIKey(apiKeyAuth *auth.APIKeyManager) func(http.Handler) http.Handler {
+// API key middleware for read/write endpoints: GET requests need readScope, others writeScope
+func requireAPIKeyByMethod(apiKeyAuth *auth.APIKeyManager, readScope, writeScope string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Check Authorization header first
-			auth := r.Header.Get("Authorization")
-			if auth != "" {
-				// Parse "Bearer <token>" format
-				parts := strings.SplitN(auth, " ", 2)
-				if len(parts) == 2 && parts[0] == "Bearer" && parts[1] != "" {
-					token := parts[1]
-					if apiKeyAuth.ValidateKey(token) {
-						next.ServeHTTP(w, r)
-						return
-					}
+			scope := writeScope
+			if r.Method == http.MethodGet {
+				scope = readScope
+			}
+
+			// Parse "Bearer <token>" format
+			var token string
+			if parts := strings.SplitN(r.Header.Get("Authorization"), " ", 2); len(parts) == 2 && parts[0] == "Bearer" {
+				token = parts[1]
+			}
+
+			scopes, err := apiKeyAuth.Authenticate(r.Context(), token)
+			if err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"error": "Invalid or missing API key"}`))
+				return
+			}
+
+			if !slices.Contains(scopes, scope) {
+				slog.WarnContext(r.Context(), "API key without required scope rejected", "scope", scope, "path", r.URL.Path)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusForbidden)
+				fmt.Fprintf(w, `{"error": "API key lacks required scope: %s"}`, scope)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// apiKeyRateLimitKey limits requests per API key without keeping plaintext keys in memory
+func apiKeyRateLimitKey(r *http.Request) string {
+	return auth.HashAPIKey(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+}
+
+// jwtSubjectRateLimitKey keys limiters by the subject of a valid JWT from the
+// Authorization header or ?token=. Requests without one are limited by IP only.
+func jwtSubjectRateLimitKey(jwtAuth *auth.JWTAuth) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		token := r.URL.Query().Get("token")
+		if parts := strings.SplitN(r.Header.Get("Authorization"), " ", 2); len(parts) == 2 && parts[0] == "Bearer" {
+			token = parts[1]
+		}
+		if token == "" {
+			return ""
+		}
+
+		payload, err := jwtAuth.VerifyToken(token)
+		if err != nil {
+			return ""
+		}
+
+		switch {
+		case payload.UserID != "":
+			return "user:" + payload.UserID
+		case payload.ProcessorID != "":
+			return "processor:" + payload.ProcessorID
+		case payload.Subject != "":
+			return "sub:" + payload.Subject
+		}
+		return ""
+	}
+}
+
+// Processor endpoints middleware: accepts a processor JWT (Authorization header or
+// ?token=) and binds the processor identity to the request context. An API key with
+// the processor scope is still accepted unless requireToken is set.
+func requireProcessorAuth(apiKeyAuth *auth.APIKeyManager, processorAuth *auth.ProcessorAuth, requireToken bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var headerToken string
+			if parts := strings.SplitN(r.Header.Get("Authorization"), " ", 2); len(parts) == 2 && parts[0] == "Bearer" {
+				headerToken = parts[1]
+			}
+			apiKeyValid := apiKeyAuth.HasScope(r.Context(), headerToken, auth.ScopeProcessor)
+
+			// Токен процессора: в заголовке вместо API-ключа или в query (для SSE)
+			processorToken := r.URL.Query().Get("token")
+			if !apiKeyValid && headerToken != "" {
+				processorToken = headerToken
+			}
+
+			if processorToken != "" {
+				processorID, err := processorAuth.Authenticate(processorToken)
+				if err == nil {
+					next.ServeHTTP(w, r.WithContext(auth.WithProcessorID(r.Context(), processorID)))
+					return
 				}
+				if !apiKeyValid || errors.Is(err, auth.ErrTokenRevoked) {
+					slog.WarnContext(r.Context(), "Processor token rejected", "path", r.URL.Path, logging.Err(err))
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusUnauthorized)
+					w.Write([]byte(`{"error": "Invalid, expired or revoked processor token"}`))
+					return
+				}
+			}
+
+			if apiKeyValid && !requireToken {
+				next.ServeHTTP(w, r)
+				return
 			}
 
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusUnauthorized)
+			if apiKeyValid {
+				w.Write([]byte(`{"error": "Processor token required"}`))
+				return
+			}
 			w.Write([]byte(`{"error": "Invalid or missing API key"}`))
 		})
 	}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ad/go-llm-manager/internal/config"
+	"github.com/ad/go-llm-manager/internal/database"
+	"github.com/ad/go-llm-manager/internal/logging"
+	"github.com/ad/go-llm-manager/migrations"
+)
+
+const migrateUsage = "usage: %s migrate status|up [flags]\n"
+
+// runMigrate handles "migrate status" and "migrate up". Flags and environment
+// are the same as for the server. Returns the exit code.
+func runMigrate(args []string) int {
+	if len(args) < 3 || (args[2] != "status" && args[2] != "up") {
+		fmt.Fprintf(os.Stderr, migrateUsage, args[0])
+		return 2
+	}
+	action := args[2]
+
+	cfg := config.Load(append([]string{args[0]}, args[3:]...))
+	if err := logging.Setup(os.Stderr, cfg.Logging); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid logging configuration: %v\n", err)
+		return 1
+	}
+
+	db, err := database.NewSQLiteDB(cfg.Database.Path)
+	if err != nil {
+		slog.Error("Failed to initialize database", logging.Err(err))
+		return 1
+	}
+	defer db.Close()
+	db.SetTimeouts(dbTimeouts(cfg.Database))
+
+	source := migrationSource(cfg.Database.MigrationsPath)
+
+	if action == "up" {
+		applied, err := db.Migrate(context.Background(), source)
+		for _, m := range applied {
+			fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			slog.Error("Failed to run migrations", logging.Err(err))
+			return 1
+		}
+		if len(applied) == 0 {
+			fmt.Println("database is up to date")
+		}
+		return 0
+	}
+
+	states, err := db.MigrationStatus(context.Background(), source)
+	if err != nil {
+		slog.Error("Failed to read migration status", logging.Err(err))
+		return 1
+	}
+	printMigrationStatus(os.Stdout, states)
+	return 0
+}
+
+// migrationSource returns the MIGRATIONS_PATH directory, or the embedded migrations
+// if it is not set or does not exist
+func migrationSource(dir string) fs.FS {
+	source, err := database.MigrationSource(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		slog.Warn("Migrations path not found, using embedded migrations", "path", dir)
+		return migrations.FS
+	}
+	if err != nil {
+		fatal("Invalid MIGRATIONS_PATH", logging.Err(err))
+	}
+	return source
+}
+
+func printMigrationStatus(w io.Writer, states []database.MigrationState) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+	for _, s := range states {
+		appliedAt := "-"
+		if s.AppliedAt != nil {
+			appliedAt = time.UnixMilli(*s.AppliedAt).UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%04d\t%s\t%s\t%s\n", s.Version, s.Name, s.Status, appliedAt)
+	}
+	tw.Flush()
+}
+
+// dbTimeouts returns the deadlines of database operations from the configuration
+func dbTimeouts(cfg config.DatabaseConfig) database.Timeouts {
+	return database.Timeouts{
+		Read:        cfg.ReadTimeout,
+		Write:       cfg.WriteTimeout,
+		Maintenance: cfg.MaintenanceTimeout,
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/ad/go-llm-manager/internal/backup"
+	"github.com/ad/go-llm-manager/internal/config"
+	"github.com/ad/go-llm-manager/internal/logging"
+)
+
+const restoreUsage = "usage: %s restore <snapshot> [flags]\n"
+
+// runRestore handles "restore <snapshot>": it replaces DB_PATH with a snapshot,
+// given as a path or as a name in BACKUP_DIR. The server must be stopped. Flags
+// and environment are the same as for the server. Returns the exit code.
+func runRestore(args []string) int {
+	if len(args) < 3 || args[2] == "" || args[2][0] == '-' {
+		fmt.Fprintf(os.Stderr, restoreUsage, args[0])
+		return 2
+	}
+
+	cfg := config.Load(append([]string{args[0]}, args[3:]...))
+	if err := logging.Setup(os.Stderr, cfg.Logging); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid logging configuration: %v\n", err)
+		return 1
+	}
+
+	path := args[2]
+	if filepath.Base(path) == path {
+		if _, err := os.Stat(path); err != nil {
+			path = filepath.Join(cfg.Backup.Dir, path)
+		}
+	}
+
+	ctx := context.Background()
+	if cfg.Database.MaintenanceTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Database.MaintenanceTimeout)
+		defer cancel()
+	}
+
+	preRestore, err := backup.Restore(ctx, path, cfg.Database.Path)
+	if err != nil {
+		slog.Error("Failed to restore backup", "path", path, logging.Err(err))
+		return 1
+	}
+
+	fmt.Printf("restored %s to %s\n", path, cfg.Database.Path)
+	if preRestore != "" {
+		fmt.Printf("previous database saved as %s\n", preRestore)
+	}
+	return 0
+}
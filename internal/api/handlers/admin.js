@@ -14,6 +14,9 @@ let ssePollingConnection = null;
 let ssePollingTaskId = null;
 let ssePollingTaskCompleted = false;
 let tasksAutoRefreshInterval = null;
+let allTasksLoaded = [];
+let allTasksCursor = '';
+let allTasksMorePages = false;
 let ratingPollingInterval = null;
 
 // Автоматически устанавливаем базовый URL
@@ -911,36 +914,114 @@ function displayTaskResult(taskData) {
 //    - синий: processing задачи
 //    - желтый: pending задачи
 
+// Параметры фильтра списка задач для /api/internal/all-tasks
+function taskFilterParams() {
+    const params = new URLSearchParams();
+    const fields = {
+        status: 'taskFilterStatus',
+        user_id: 'taskFilterUser',
+        processor_id: 'taskFilterProcessor',
+        model: 'taskFilterModel',
+        rating: 'taskFilterRating',
+        has_error: 'taskFilterHasError',
+        priority_min: 'taskFilterPriorityMin',
+        priority_max: 'taskFilterPriorityMax'
+    };
+    for (const [name, id] of Object.entries(fields)) {
+        const value = document.getElementById(id).value.trim();
+        if (value !== '') params.set(name, value);
+    }
+    // Даты из datetime-local в unix ms
+    const dates = {
+        created_from: 'taskFilterCreatedFrom',
+        created_to: 'taskFilterCreatedTo',
+        completed_from: 'taskFilterCompletedFrom',
+        completed_to: 'taskFilterCompletedTo'
+    };
+    for (const [name, id] of Object.entries(dates)) {
+        const value = document.getElementById(id).value;
+        if (value) params.set(name, new Date(value).getTime());
+    }
+    return params;
+}
+
+// С текстом поиска список берётся из полнотекстового поиска с теми же фильтрами
+function taskListPath(params) {
+    const query = document.getElementById('taskSearchQuery').value.trim();
+    if (query === '') return '/api/internal/all-tasks';
+    params.set('q', query);
+    return '/api/internal/search';
+}
+
+async function fetchTasks(path, params) {
+    const baseUrl = document.getElementById('baseUrl').value;
+    const apiKey = document.getElementById('apiKey').value;
+    const response = await fetch(`${baseUrl}${path}?${params}`, {
+        method: 'GET',
+        headers: {
+            'Authorization': `Bearer ${apiKey}`
+        }
+    });
+    if (!response.ok) {
+        const errorData = await response.json().catch(() => ({}));
+        throw new Error(errorData.error || `HTTP ${response.status}: ${response.statusText}`);
+    }
+    return await response.json();
+}
+
 async function loadAndDisplayAllTasks() {
     try {
-        const baseUrl = document.getElementById('baseUrl').value;
-        const apiKey = document.getElementById('apiKey').value;
-        log('📄 Загрузка всех задач...');
-        const response = await fetch(`${baseUrl}/api/internal/all-tasks`, {
-            method: 'GET',
-            headers: {
-                'Authorization': `Bearer ${apiKey}`
-            }
-        });
-        if (!response.ok) {
-            throw new Error(`HTTP ${response.status}: ${response.statusText}`);
-        }
-        const data = await response.json();
-        const allTasks = data.tasks || [];
-        const pendingTasks = allTasks.filter(t => t.status === 'pending');
+        log('📄 Загрузка задач...');
+        const pending = await fetchTasks('/api/internal/all-tasks', new URLSearchParams({ status: 'pending', limit: 100 }));
+        const pendingTasks = pending.tasks || [];
         displayPendingTasks(pendingTasks);
-        displayAllTasks(allTasks);
-        log(`✅ Загружено задач: всего ${allTasks.length}, ожидающих ${pendingTasks.length}`, 'success');
+
+        const params = taskFilterParams();
+        const data = await fetchTasks(taskListPath(params), params);
+        allTasksLoaded = data.tasks || [];
+        allTasksCursor = data.next_cursor || '';
+        allTasksMorePages = false;
+        displayAllTasks(allTasksLoaded, data.total);
+        log(`✅ Загружено задач: ${allTasksLoaded.length} из ${data.total}, ожидающих ${pending.total}`, 'success');
     } catch (error) {
+        allTasksCursor = '';
         displayPendingTasks([]);
-        displayAllTasks([]);
+        displayAllTasks([], 0);
+        log(`❌ Ошибка загрузки задач: ${error.message}`, 'error');
+    }
+}
+
+async function loadMoreTasks() {
+    if (!allTasksCursor) return;
+    try {
+        const params = taskFilterParams();
+        params.set('cursor', allTasksCursor);
+        const data = await fetchTasks(taskListPath(params), params);
+        allTasksLoaded = allTasksLoaded.concat(data.tasks || []);
+        allTasksCursor = data.next_cursor || '';
+        allTasksMorePages = true;
+        displayAllTasks(allTasksLoaded, data.total);
+        log(`✅ Загружено задач: ${allTasksLoaded.length} из ${data.total}`, 'success');
+    } catch (error) {
         log(`❌ Ошибка загрузки задач: ${error.message}`, 'error');
     }
 }
 
+function applyTaskFilters() {
+    loadAndDisplayAllTasks();
+}
+
+function resetTaskFilters() {
+    document.querySelectorAll('#taskFilters input, #taskFilters select').forEach(el => el.value = '');
+    loadAndDisplayAllTasks();
+}
+
 function startTasksAutoRefresh() {
     if (tasksAutoRefreshInterval) return;
-    tasksAutoRefreshInterval = setInterval(loadAndDisplayAllTasks, 5000);
+    // Подгруженные страницы не сбрасываются автообновлением
+    tasksAutoRefreshInterval = setInterval(() => {
+        if (!allTasksMorePages) loadAndDisplayAllTasks();
+    }, 5000);
 }
 
 function stopTasksAutoRefresh() {
@@ -1107,7 +1188,8 @@ async function getProcessorMetrics() {
                     <div class="metric-card">
                         <strong>${processor.processor_id}</strong><br>
                         <span style="color: ${statusColor};">●</span> ${statusText}<br>
-                        <small>Активных задач: ${processor.active_tasks || 0}</small><br>
+                        <small>Активных задач: ${processor.active_tasks || 0}${processor.max_concurrency > 0 ? ` / ${processor.max_concurrency}` : ''}</small><br>
+                        ${processor.saturation != null ? `<small>Загрузка: ${Math.round(processor.saturation * 100)}% (свободно слотов: ${processor.available_slots})</small><br>` : ''}
                         <small>Очередь: ${processor.queue_size}</small><br>
                         <small>CPU: ${processor.cpu_usage}%</small><br>
                         <small>Память: ${processor.memory_usage}%</small><br>
@@ -1762,10 +1844,11 @@ function displayPendingTasks(tasks) {
     });
 }
 
-function displayAllTasks(tasks) {
+function displayAllTasks(tasks, total) {
     const container = document.getElementById('allTasksList');
     const title = document.getElementById('allTasksTitle');
-    title.textContent = `📄 Все задачи (${tasks.length})`;
+    title.textContent = `📄 Задачи (${tasks.length} из ${total})`;
+    document.getElementById('loadMoreTasksBtn').style.display = allTasksCursor ? '' : 'none';
     if (tasks.length === 0) {
         container.innerHTML = '<div style="padding: 20px; text-align: center; color: #666;">Нет задач</div>';
         return;
@@ -1810,6 +1893,11 @@ function displayAllTasks(tasks) {
                         ⏱️ ${task.status === 'completed' ? 'Выполнено за:' : task.status === 'failed' ? 'Не удалось за:' : task.status === 'processing' ? 'Выполняется:' : 'В ожидании:'} ${executionTimeStr}
                     </div>
                 ` : ''}
+                ${task.snippets ? Object.entries(task.snippets).map(([field, snippet]) => `
+                    <div class="search-snippet">
+                        <strong>🔎 ${field}:</strong> ${snippet}
+                    </div>
+                `).join('') : ''}
                 <div style="max-height: 80px; overflow-y: auto; background: #f8f9fa; padding: 8px; border-radius: 4px; font-family: monospace; font-size: 0.85em;">
                     Prompt: ${(() => {
                         try {
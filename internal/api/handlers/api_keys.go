@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/ad/go-llm-manager/internal/auth"
+	"github.com/ad/go-llm-manager/internal/database"
+	"github.com/ad/go-llm-manager/internal/logging"
+	"github.com/ad/go-llm-manager/internal/utils"
+	"github.com/google/uuid"
+)
+
+// Grace period during which a rotated key stays valid by default
+const defaultAPIKeyRotationGraceSeconds = 3600
+
+// /api/internal/api-keys - Internal API key management
+func (h *InternalHandlers) APIKeys(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.listAPIKeys(w, r)
+	case http.MethodPost:
+		h.createAPIKey(w, r)
+	case http.MethodDelete:
+		h.revokeAPIKey(w, r)
+	default:
+		utils.SendError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// GET /api/internal/api-keys - List API keys (hashes are never returned)
+func (h *InternalHandlers) listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.store.ListAPIKeys(r.Context())
+	if err != nil {
+		utils.SendError(w, http.StatusInternalServerError, "Failed to list API keys")
+		return
+	}
+
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+		"success":  true,
+		"api_keys": keys,
+	})
+}
+
+// POST /api/internal/api-keys - Create API key. The plaintext key is returned only once.
+func (h *InternalHandlers) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name           string   `json:"name"`
+		Scopes         []string `json:"scopes"`
+		ExpiresInHours *int     `json:"expires_in_hours,omitempty"`
+	}
+	if err := utils.ParseJSON(r, &req); err != nil {
+		utils.SendError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	if req.Name == "" {
+		utils.SendError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	if len(req.Scopes) == 0 {
+		utils.SendError(w, http.StatusBadRequest, "scopes are required")
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !auth.IsValidScope(scope) {
+			utils.SendError(w, http.StatusBadRequest, "unknown scope: "+scope)
+			return
+		}
+	}
+	if req.ExpiresInHours != nil && *req.ExpiresInHours <= 0 {
+		utils.SendError(w, http.StatusBadRequest, "expires_in_hours must be > 0")
+		return
+	}
+
+	var expiresAt *int64
+	if req.ExpiresInHours != nil {
+		t := time.Now().Add(time.Duration(*req.ExpiresInHours) * time.Hour).UnixMilli()
+		expiresAt = &t
+	}
+
+	key, apiKey, err := h.issueAPIKey(r.Context(), req.Name, req.Scopes, expiresAt)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to create API key", "name", req.Name, logging.Err(err))
+		utils.SendError(w, http.StatusInternalServerError, "Failed to create API key")
+		return
+	}
+
+	slog.InfoContext(r.Context(), "API key created", "key_id", apiKey.ID, "name", apiKey.Name, "scopes", apiKey.Scopes)
+
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"key":     key,
+		"api_key": apiKey,
+	})
+}
+
+// DELETE /api/internal/api-keys?id=... - Revoke API key immediately
+func (h *InternalHandlers) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		utils.SendError(w, http.StatusBadRequest, "id is required")
+		return
+	}
+
+	revoked, err := h.store.RevokeAPIKey(r.Context(), id)
+	if err != nil {
+		utils.SendError(w, http.StatusInternalServerError, "Failed to revoke API key")
+		return
+	}
+	if !revoked {
+		utils.SendError(w, http.StatusNotFound, "API key not found or already revoked")
+		return
+	}
+
+	slog.InfoContext(r.Context(), "API key revoked", "key_id", id)
+
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{"success": true})
+}
+
+// POST /api/internal/api-keys/rotate - Issue a replacement key; the old key stays valid for grace_seconds
+func (h *InternalHandlers) RotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.SendError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req struct {
+		ID           string `json:"id"`
+		GraceSeconds *int   `json:"grace_seconds,omitempty"`
+	}
+	if err := utils.ParseJSON(r, &req); err != nil {
+		utils.SendError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	if req.ID == "" {
+		utils.SendError(w, http.StatusBadRequest, "id is required")
+		return
+	}
+
+	graceSeconds := defaultAPIKeyRotationGraceSeconds
+	if req.GraceSeconds != nil {
+		if *req.GraceSeconds < 0 {
+			utils.SendError(w, http.StatusBadRequest, "grace_seconds must be >= 0")
+			return
+		}
+		graceSeconds = *req.GraceSeconds
+	}
+
+	old, err := h.store.GetAPIKey(r.Context(), req.ID)
+	if err != nil {
+		utils.SendError(w, http.StatusInternalServerError, "Failed to get API key")
+		return
+	}
+	now := time.Now()
+	if old == nil || !old.IsActive(now.UnixMilli()) {
+		utils.SendError(w, http.StatusNotFound, "API key not found or inactive")
+		return
+	}
+
+	key, apiKey, err := h.issueAPIKey(r.Context(), old.Name, old.Scopes, nil)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to rotate API key", "key_id", old.ID, logging.Err(err))
+		utils.SendError(w, http.StatusInternalServerError, "Failed to rotate API key")
+		return
+	}
+
+	oldExpiresAt := now.Add(time.Duration(graceSeconds) * time.Second).UnixMilli()
+	if err := h.store.ExpireAPIKey(r.Context(), old.ID, oldExpiresAt); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to expire rotated API key", "key_id", old.ID, logging.Err(err))
+		utils.SendError(w, http.StatusInternalServerError, "Failed to rotate API key")
+		return
+	}
+
+	slog.InfoContext(r.Context(), "API key rotated", "key_id", old.ID, "new_key_id", apiKey.ID, "grace_seconds", graceSeconds)
+
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+		"success":            true,
+		"key":                key,
+		"api_key":            apiKey,
+		"rotated_key_id":     old.ID,
+		"rotated_expires_at": oldExpiresAt,
+	})
+}
+
+func (h *InternalHandlers) issueAPIKey(ctx context.Context, name string, scopes []string, expiresAt *int64) (string, *database.APIKey, error) {
+	key, err := auth.GenerateAPIKey()
+	if err != nil {
+		return "", nil, err
+	}
+
+	apiKey := &database.APIKey{
+		ID:        uuid.New().String(),
+		Name:      name,
+		KeyHash:   auth.HashAPIKey(key),
+		KeyPrefix: key[:12],
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	}
+	if err := h.store.CreateAPIKey(ctx, apiKey); err != nil {
+		return "", nil, err
+	}
+
+	return key, apiKey, nil
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ad/go-llm-manager/internal/auth"
+	"github.com/ad/go-llm-manager/internal/database"
+)
+
+func apiKeyRequest(t *testing.T, handler http.HandlerFunc, method, url string, body interface{}) (int, map[string]interface{}) {
+	t.Helper()
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	req := httptest.NewRequest(method, url, bytes.NewReader(data))
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+
+	var resp map[string]interface{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	return rr.Code, resp
+}
+
+func TestAPIKeysLifecycle(t *testing.T) {
+	db := database.NewTestDB(t)
+	h := NewInternalHandlers(db, auth.NewJWTAuth("test"))
+	keys := auth.NewAPIKeyManager("bootstrap", db)
+
+	code, _ := apiKeyRequest(t, h.APIKeys, http.MethodPost, "/", map[string]interface{}{"name": "bad", "scopes": []string{"root"}})
+	if code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown scope, got %d", code)
+	}
+
+	code, resp := apiKeyRequest(t, h.APIKeys, http.MethodPost, "/", map[string]interface{}{
+		"name":   "dashboard",
+		"scopes": []string{auth.ScopeAdminRead},
+	})
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %v", code, resp)
+	}
+	key := resp["key"].(string)
+	id := resp["api_key"].(map[string]interface{})["id"].(string)
+
+	if !keys.HasScope(t.Context(), key, auth.ScopeAdminRead) {
+		t.Fatal("expected new key to grant admin-read")
+	}
+	if keys.HasScope(t.Context(), key, auth.ScopeAdminWrite) {
+		t.Fatal("expected new key not to grant admin-write")
+	}
+	if !keys.HasScope(t.Context(), "bootstrap", auth.ScopeAdminWrite) {
+		t.Fatal("expected bootstrap key to grant all scopes")
+	}
+
+	stored, _ := db.GetAPIKey(t.Context(), id)
+	if stored == nil || stored.KeyHash == key || stored.KeyHash != auth.HashAPIKey(key) {
+		t.Fatalf("expected only the hash to be stored, got %+v", stored)
+	}
+
+	code, resp = apiKeyRequest(t, h.APIKeys, http.MethodGet, "/", nil)
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	listed := resp["api_keys"].([]interface{})
+	if len(listed) != 1 {
+		t.Fatalf("expected 1 key, got %d", len(listed))
+	}
+	if _, ok := listed[0].(map[string]interface{})["key_hash"]; ok {
+		t.Fatal("key hash must not be exposed")
+	}
+
+	code, _ = apiKeyRequest(t, h.APIKeys, http.MethodDelete, "/?id="+id, nil)
+	if code != http.StatusOK {
+		t.Fatalf("expected 200 on revoke, got %d", code)
+	}
+	if keys.ValidateKey(t.Context(), key) {
+		t.Fatal("expected revoked key to be rejected")
+	}
+	code, _ = apiKeyRequest(t, h.APIKeys, http.MethodDelete, "/?id="+id, nil)
+	if code != http.StatusNotFound {
+		t.Fatalf("expected 404 on second revoke, got %d", code)
+	}
+}
+
+func TestAPIKeyRotationOverlap(t *testing.T) {
+	db := database.NewTestDB(t)
+	h := NewInternalHandlers(db, auth.NewJWTAuth("test"))
+	keys := auth.NewAPIKeyManager("", db)
+
+	_, resp := apiKeyRequest(t, h.APIKeys, http.MethodPost, "/", map[string]interface{}{
+		"name":   "worker",
+		"scopes": []string{auth.ScopeProcessor},
+	})
+	oldKey := resp["key"].(string)
+	oldID := resp["api_key"].(map[string]interface{})["id"].(string)
+
+	code, resp := apiKeyRequest(t, h.RotateAPIKey, http.MethodPost, "/", map[string]interface{}{"id": oldID, "grace_seconds": 60})
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %v", code, resp)
+	}
+	newKey := resp["key"].(string)
+
+	// Оба ключа действуют в течение grace-периода
+	if !keys.HasScope(t.Context(), oldKey, auth.ScopeProcessor) || !keys.HasScope(t.Context(), newKey, auth.ScopeProcessor) {
+		t.Fatal("expected both keys to be valid during grace period")
+	}
+
+	code, resp = apiKeyRequest(t, h.RotateAPIKey, http.MethodPost, "/", map[string]interface{}{"id": resp["api_key"].(map[string]interface{})["id"], "grace_seconds": 0})
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %v", code, resp)
+	}
+	if keys.ValidateKey(t.Context(), newKey) {
+		t.Fatal("expected key rotated without grace to be rejected")
+	}
+	if !keys.ValidateKey(t.Context(), resp["key"].(string)) {
+		t.Fatal("expected latest key to be valid")
+	}
+}
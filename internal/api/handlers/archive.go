@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"github.com/ad/go-llm-manager/internal/archive"
+	"github.com/ad/go-llm-manager/internal/logging"
+	"github.com/ad/go-llm-manager/internal/utils"
+)
+
+// GET /api/internal/archives - List daily archives of cleaned up tasks, newest first
+func (h *InternalHandlers) Archives(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.SendError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if h.archiver == nil {
+		utils.SendError(w, http.StatusServiceUnavailable, "Archive is not configured")
+		return
+	}
+
+	files, err := h.archiver.List()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to list archives", logging.Err(err))
+		utils.SendError(w, http.StatusInternalServerError, "Failed to list archives")
+		return
+	}
+	if files == nil {
+		files = []*archive.File{}
+	}
+
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+		"success":  true,
+		"archives": files,
+	})
+}
+
+// POST /api/internal/archives/import - Import the tasks of an archive back into the database
+func (h *InternalHandlers) ImportArchive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.SendError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if h.archiver == nil {
+		utils.SendError(w, http.StatusServiceUnavailable, "Archive is not configured")
+		return
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := utils.ParseJSON(r, &req); err != nil {
+		utils.SendError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	result, err := h.archiver.Import(r.Context(), req.Name)
+	switch {
+	case errors.Is(err, archive.ErrInvalidName):
+		utils.SendError(w, http.StatusBadRequest, "Invalid archive name")
+		return
+	case errors.Is(err, os.ErrNotExist):
+		utils.SendError(w, http.StatusNotFound, "Archive not found")
+		return
+	case err != nil:
+		slog.ErrorContext(r.Context(), "Failed to import archive", "name", req.Name, logging.Err(err))
+		utils.SendJSON(w, http.StatusInternalServerError, map[string]interface{}{
+			"success": false,
+			"error":   "Failed to import archive",
+			"result":  result,
+		})
+		return
+	}
+	slog.InfoContext(r.Context(), "Imported archive", "name", req.Name, "imported", result.Imported, "skipped", result.Skipped)
+
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"result":  result,
+	})
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/ad/go-llm-manager/internal/backup"
+	"github.com/ad/go-llm-manager/internal/logging"
+	"github.com/ad/go-llm-manager/internal/utils"
+)
+
+// BackupHandlers take and list snapshots of the database
+type BackupHandlers struct {
+	backups *backup.Manager
+}
+
+func NewBackupHandlers(backups *backup.Manager) *BackupHandlers {
+	return &BackupHandlers{backups: backups}
+}
+
+// POST /api/internal/backup - Take a snapshot of the database now and apply the retention policy
+func (h *BackupHandlers) Create(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.SendError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	snapshot, err := h.backups.Create(r.Context())
+	if errors.Is(err, backup.ErrBusy) {
+		utils.SendError(w, http.StatusConflict, "Backup already in progress")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Backup failed", logging.Err(err))
+		utils.SendError(w, http.StatusInternalServerError, "Backup failed")
+		return
+	}
+	slog.InfoContext(r.Context(), "Created database backup", "name", snapshot.Name, "size", snapshot.Size)
+
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+		"success":  true,
+		"snapshot": snapshot,
+	})
+}
+
+// GET /api/internal/backups - List snapshots in the backup directory, newest first
+func (h *BackupHandlers) List(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.SendError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	snapshots, err := h.backups.List()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to list backups", logging.Err(err))
+		utils.SendError(w, http.StatusInternalServerError, "Failed to list backups")
+		return
+	}
+	if snapshots == nil {
+		snapshots = []*backup.Snapshot{}
+	}
+
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+		"success":   true,
+		"snapshots": snapshots,
+	})
+}
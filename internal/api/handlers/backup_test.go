@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ad/go-llm-manager/internal/backup"
+	"github.com/ad/go-llm-manager/internal/config"
+	"github.com/ad/go-llm-manager/internal/database"
+)
+
+func TestBackupCreateAndList(t *testing.T) {
+	db := database.NewTestDB(t)
+	h := NewBackupHandlers(backup.NewManager(db, config.BackupConfig{Dir: t.TempDir(), Compress: true}))
+
+	rr := httptest.NewRecorder()
+	h.List(rr, httptest.NewRequest(http.MethodGet, "/api/internal/backups", nil))
+	var empty struct {
+		Snapshots []backup.Snapshot `json:"snapshots"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &empty); err != nil || rr.Code != http.StatusOK || empty.Snapshots == nil || len(empty.Snapshots) != 0 {
+		t.Fatalf("empty list: code %d, body %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	h.Create(rr, httptest.NewRequest(http.MethodPost, "/api/internal/backup", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("create: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var created struct {
+		Snapshot backup.Snapshot `json:"snapshot"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &created)
+	if created.Snapshot.Name == "" || created.Snapshot.SHA256 == "" || !created.Snapshot.Compressed {
+		t.Fatalf("unexpected snapshot: %+v", created.Snapshot)
+	}
+
+	rr = httptest.NewRecorder()
+	h.List(rr, httptest.NewRequest(http.MethodGet, "/api/internal/backups", nil))
+	var list struct {
+		Snapshots []backup.Snapshot `json:"snapshots"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &list)
+	if len(list.Snapshots) != 1 || list.Snapshots[0].Name != created.Snapshot.Name {
+		t.Fatalf("list: expected the created snapshot, got %s", rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	h.Create(rr, httptest.NewRequest(http.MethodGet, "/api/internal/backup", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /api/internal/backup: expected 405, got %d", rr.Code)
+	}
+}
@@ -3,10 +3,13 @@ package handlers
 import (
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/ad/go-llm-manager/internal/archive"
 	"github.com/ad/go-llm-manager/internal/auth"
+	"github.com/ad/go-llm-manager/internal/config"
 	"github.com/ad/go-llm-manager/internal/database"
 )
 
@@ -43,10 +46,10 @@ func TestPerformCleanup_RequeueAndFail(t *testing.T) {
 		HeartbeatAt: &old,
 		Priority:    0,
 	}
-	if err := db.CreateTask(task1); err != nil {
+	if err := db.CreateTask(t.Context(), task1); err != nil {
 		t.Fatalf("failed to insert task1: %v", err)
 	}
-	if err := db.CreateTask(task2); err != nil {
+	if err := db.CreateTask(t.Context(), task2); err != nil {
 		t.Fatalf("failed to insert task2: %v", err)
 	}
 	// Прямой апдейт для выставления processor_id, heartbeat_at, retry_count, max_retries, processing_started_at
@@ -99,7 +102,7 @@ func TestPerformCleanup_RequeueAndFail(t *testing.T) {
 	}
 
 	// Проверяем статусы задач
-	t1, err := db.GetTask("task1")
+	t1, err := db.GetTask(t.Context(), "task1")
 	if err != nil {
 		t.Fatalf("failed to get task1: %v", err)
 	}
@@ -113,7 +116,7 @@ func TestPerformCleanup_RequeueAndFail(t *testing.T) {
 		t.Errorf("task1: expected error_message to be set")
 	}
 
-	t2, err := db.GetTask("task2")
+	t2, err := db.GetTask(t.Context(), "task2")
 	if err != nil {
 		t.Fatalf("failed to get task2: %v", err)
 	}
@@ -127,3 +130,51 @@ func TestPerformCleanup_RequeueAndFail(t *testing.T) {
 		t.Errorf("task2: expected error_message to be set")
 	}
 }
+
+func TestPerformCleanup_RetentionPerStatusAndArchive(t *testing.T) {
+	db := database.NewTestDB(t)
+	h := NewInternalHandlers(db, auth.NewJWTAuth("test"))
+	archiveDir := t.TempDir()
+	h.SetCleanup(config.CleanupConfig{DaysToKeep: 7, FailedDays: 30, Archive: true, ArchiveDir: archiveDir}, archive.NewArchiver(db, archiveDir))
+
+	tenDaysAgo := time.Now().Add(-10 * 24 * time.Hour).UnixMilli()
+	for _, task := range []struct{ id, status string }{{"old-completed", "completed"}, {"old-failed", "failed"}} {
+		if err := db.CreateTask(t.Context(), &database.Task{ID: task.id, UserID: task.id, ProductData: "p", Status: "pending"}); err != nil {
+			t.Fatalf("failed to insert %s: %v", task.id, err)
+		}
+		if _, err := db.Exec(`UPDATE tasks SET status = ?, completed_at = ?, updated_at = ? WHERE id = ?`, task.status, tenDaysAgo, tenDaysAgo, task.id); err != nil {
+			t.Fatalf("failed to finish %s: %v", task.id, err)
+		}
+	}
+
+	_, cleaned, err := h.performCleanup(t.Context())
+	if err != nil {
+		t.Fatalf("performCleanup: %v", err)
+	}
+	if cleaned["tasks"] != int64(1) || cleaned["archived"] != true {
+		t.Errorf("cleaned = %v, want 1 archived task", cleaned)
+	}
+
+	// completed хранится CLEANUP_DAYS, failed - CLEANUP_FAILED_DAYS
+	if _, err := db.GetTask(t.Context(), "old-completed"); err == nil {
+		t.Error("completed task past retention was not removed")
+	}
+	if _, err := db.GetTask(t.Context(), "old-failed"); err != nil {
+		t.Errorf("failed task within its retention was removed: %v", err)
+	}
+
+	files, err := h.archiver.List()
+	if err != nil || len(files) != 1 {
+		t.Fatalf("archives = %v (%v), want one", files, err)
+	}
+
+	rr := httptest.NewRecorder()
+	body := strings.NewReader(`{"name":"` + files[0].Name + `"}`)
+	h.ImportArchive(rr, httptest.NewRequest(http.MethodPost, "/api/internal/archives/import", body))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"imported":1`) {
+		t.Fatalf("import: code %d, body %s", rr.Code, rr.Body.String())
+	}
+	if _, err := db.GetTask(t.Context(), "old-completed"); err != nil {
+		t.Errorf("imported task not restored: %v", err)
+	}
+}
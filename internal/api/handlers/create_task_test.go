@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ad/go-llm-manager/internal/auth"
+	"github.com/ad/go-llm-manager/internal/config"
+	"github.com/ad/go-llm-manager/internal/database"
+)
+
+func TestCreateTaskWithProductDataHash(t *testing.T) {
+	db := database.NewTestDB(t)
+	jwtAuth := auth.NewJWTAuth("test")
+	cfg := &config.Config{Server: config.ServerConfig{MaxProductDataBytes: 512 * 1024}}
+	publicHandlers := NewPublicHandlers(db, jwtAuth, cfg)
+	internalHandlers := NewInternalHandlers(db, jwtAuth)
+
+	productData := strings.Repeat("long prompt ", 25000) // 300 KB
+
+	mint := func(userID string) string {
+		body, _ := json.Marshal(map[string]interface{}{
+			"user_id":           userID,
+			"product_data":      productData,
+			"bind_product_data": true,
+		})
+		rr := httptest.NewRecorder()
+		internalHandlers.GenerateToken(rr, httptest.NewRequest(http.MethodPost, "/api/internal/generate-token", bytes.NewReader(body)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("generate token failed: %d %s", rr.Code, rr.Body.String())
+		}
+		var resp struct {
+			Token             string `json:"token"`
+			ProductDataSHA256 string `json:"product_data_sha256"`
+		}
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		if resp.ProductDataSHA256 != auth.HashProductData(productData) {
+			t.Fatalf("unexpected hash in response: %q", resp.ProductDataSHA256)
+		}
+		return resp.Token
+	}
+
+	create := func(token string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/create", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		publicHandlers.CreateTask(rr, req)
+		return rr
+	}
+	bodyFor := func(data string) []byte {
+		b, _ := json.Marshal(map[string]string{"product_data": data})
+		return b
+	}
+
+	token := mint("u-1")
+	if len(token) > 2048 {
+		t.Fatalf("expected compact token, got %d bytes", len(token))
+	}
+
+	if rr := create(token, nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without body, got %d", rr.Code)
+	}
+	if rr := create(token, bodyFor(productData+"!")); rr.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for tampered body, got %d", rr.Code)
+	}
+
+	rr := create(token, bodyFor(productData))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var created struct {
+		TaskID string `json:"taskId"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &created)
+	task, _ := db.GetTask(t.Context(), created.TaskID)
+	if task == nil || task.ProductData != productData {
+		t.Fatal("expected task with product_data from the body")
+	}
+
+	// Тело больше лимита
+	cfg.Server.MaxProductDataBytes = 1024
+	if rr := create(mint("u-2"), bodyFor(productData)); rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 for oversized body, got %d", rr.Code)
+	}
+}
+
+func TestGenerateTokenRejectsInvalidProductDataHash(t *testing.T) {
+	db := database.NewTestDB(t)
+	h := NewInternalHandlers(db, auth.NewJWTAuth("test"))
+
+	for name, req := range map[string]map[string]interface{}{
+		"not hex":        {"user_id": "u-1", "product_data_sha256": "xyz"},
+		"wrong length":   {"user_id": "u-1", "product_data_sha256": "abcd"},
+		"both":           {"user_id": "u-1", "product_data": "p", "product_data_sha256": auth.HashProductData("p")},
+		"bind without p": {"user_id": "u-1", "bind_product_data": true},
+	} {
+		body, _ := json.Marshal(req)
+		rr := httptest.NewRecorder()
+		h.GenerateToken(rr, httptest.NewRequest(http.MethodPost, "/api/internal/generate-token", bytes.NewReader(body)))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", name, rr.Code)
+		}
+	}
+}
+
+func TestCreateTaskRateLimitHeaders(t *testing.T) {
+	db := database.NewTestDB(t)
+	jwtAuth := auth.NewJWTAuth("test")
+	cfg := &config.Config{RateLimit: config.RateLimitConfig{Algorithm: "sliding_log", MaxRequests: 100, WindowMs: 3600000}}
+	h := NewPublicHandlers(db, jwtAuth, cfg)
+
+	token, _ := jwtAuth.GenerateToken(&database.JWTPayload{
+		Subject:     "rl-user",
+		UserID:      "rl-user",
+		ProductData: "data",
+		RateLimit:   &database.RateLimitConfig{MaxRequests: 2},
+	}, 3600)
+
+	create := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/create", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		h.CreateTask(rr, req)
+		return rr
+	}
+	completeAll := func() {
+		if _, err := db.Exec(`UPDATE tasks SET status = 'completed' WHERE user_id = 'rl-user'`); err != nil {
+			t.Fatalf("failed to complete tasks: %v", err)
+		}
+	}
+	expect := func(rr *httptest.ResponseRecorder, code int, remaining string, retryAfter bool) {
+		t.Helper()
+		if rr.Code != code {
+			t.Fatalf("expected %d, got %d: %s", code, rr.Code, rr.Body.String())
+		}
+		if rr.Header().Get("X-RateLimit-Limit") != "2" || rr.Header().Get("X-RateLimit-Remaining") != remaining {
+			t.Errorf("unexpected limit headers: %v", rr.Header())
+		}
+		if rr.Header().Get("X-RateLimit-Reset") == "" {
+			t.Error("expected X-RateLimit-Reset header")
+		}
+		if (rr.Header().Get("Retry-After") != "") != retryAfter {
+			t.Errorf("unexpected Retry-After: %q", rr.Header().Get("Retry-After"))
+		}
+	}
+
+	expect(create(), http.StatusCreated, "1", false)
+
+	// Конфликт с активной задачей не списывает квоту
+	expect(create(), http.StatusConflict, "1", false)
+	expect(create(), http.StatusConflict, "1", false)
+
+	completeAll()
+	expect(create(), http.StatusCreated, "0", true)
+
+	completeAll()
+	expect(create(), http.StatusTooManyRequests, "0", true)
+}
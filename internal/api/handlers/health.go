@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ad/go-llm-manager/internal/config"
+	"github.com/ad/go-llm-manager/internal/database"
+	"github.com/ad/go-llm-manager/internal/utils"
+)
+
+// Результаты проверок готовности
+const (
+	checkOK   = "ok"
+	checkFail = "fail"
+)
+
+// HealthHandlers serve the liveness and readiness probes
+type HealthHandlers struct {
+	db       *database.DB
+	monitor  *ProcessorMonitor
+	cfg      config.HealthConfig
+	draining atomic.Bool
+}
+
+func NewHealthHandlers(db *database.DB, monitor *ProcessorMonitor, cfg config.HealthConfig) *HealthHandlers {
+	return &HealthHandlers{db: db, monitor: monitor, cfg: cfg}
+}
+
+// StartDraining makes /readyz fail, so load balancers stop routing new requests
+// before the server shuts down. /livez keeps answering.
+func (h *HealthHandlers) StartDraining() {
+	h.draining.Store(true)
+}
+
+// healthCheck is the result of one readiness check
+type healthCheck struct {
+	Status string      `json:"status"`
+	Value  interface{} `json:"value,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// GET /livez - The process is up and serving HTTP
+func (h *HealthHandlers) Livez(w http.ResponseWriter, r *http.Request) {
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{"status": checkOK})
+}
+
+// GET /readyz - Ready to take traffic: SQLite is writable, the WAL is not oversized,
+// the schema is migrated, the processor monitor runs and enough processors are online
+func (h *HealthHandlers) Readyz(w http.ResponseWriter, r *http.Request) {
+	if h.draining.Load() {
+		utils.SendJSON(w, http.StatusServiceUnavailable, map[string]interface{}{"status": "draining"})
+		return
+	}
+
+	checks := h.runChecks(r.Context())
+
+	status, code := "ready", http.StatusOK
+	for name, check := range checks {
+		if check.Status != checkOK {
+			status, code = "not_ready", http.StatusServiceUnavailable
+			slog.WarnContext(r.Context(), "Readiness check failed", "check", name, "reason", check.Error)
+		}
+	}
+
+	utils.SendJSON(w, code, map[string]interface{}{
+		"status": status,
+		"checks": checks,
+	})
+}
+
+// runChecks runs all readiness checks concurrently, each bounded by ReadyCheckTimeout
+func (h *HealthHandlers) runChecks(ctx context.Context) map[string]healthCheck {
+	// Запросы проверок, вышедших за таймаут, отменяются, а не висят в очереди БД
+	if h.cfg.ReadyCheckTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.cfg.ReadyCheckTimeout)
+		defer cancel()
+	}
+
+	checks := map[string]func() (interface{}, error){
+		"database": func() (interface{}, error) {
+			return nil, h.db.CheckWritable(ctx)
+		},
+		"wal": func() (interface{}, error) {
+			size, err := h.db.WALSize(ctx)
+			if err == nil && h.cfg.ReadyMaxWALBytes > 0 && size > h.cfg.ReadyMaxWALBytes {
+				err = fmt.Errorf("WAL is %d bytes, limit %d", size, h.cfg.ReadyMaxWALBytes)
+			}
+			return size, err
+		},
+		"migrations": func() (interface{}, error) {
+			version, err := h.db.GetSchemaVersion(ctx)
+			if latest := database.LatestSchemaVersion(); err == nil && version < latest {
+				err = fmt.Errorf("schema version %d, expected %d", version, latest)
+			}
+			return version, err
+		},
+	}
+	if h.monitor != nil {
+		checks["scheduler"] = func() (interface{}, error) {
+			return nil, h.monitor.CheckAlive()
+		}
+	}
+	if h.cfg.ReadyMinProcessors > 0 {
+		checks["processors"] = func() (interface{}, error) {
+			online, err := h.db.CountOnlineProcessors(ctx)
+			if err == nil && online < h.cfg.ReadyMinProcessors {
+				err = fmt.Errorf("%d processors online, need %d", online, h.cfg.ReadyMinProcessors)
+			}
+			return online, err
+		}
+	}
+
+	results := make(map[string]healthCheck, len(checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := runCheck(check, h.cfg.ReadyCheckTimeout)
+			mu.Lock()
+			results[name] = result
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	return results
+}
+
+// runCheck gives up on a check after timeout (a locked database blocks for busy_timeout)
+func runCheck(check func() (interface{}, error), timeout time.Duration) healthCheck {
+	type outcome struct {
+		value interface{}
+		err   error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		value, err := check()
+		done <- outcome{value, err}
+	}()
+
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case res := <-done:
+		if res.err != nil {
+			return healthCheck{Status: checkFail, Value: res.value, Error: res.err.Error()}
+		}
+		return healthCheck{Status: checkOK, Value: res.value}
+	case <-timer.C:
+		return healthCheck{Status: checkFail, Error: fmt.Sprintf("timed out after %s", timeout)}
+	}
+}
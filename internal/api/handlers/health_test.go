@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ad/go-llm-manager/internal/config"
+	"github.com/ad/go-llm-manager/internal/database"
+	"github.com/ad/go-llm-manager/internal/sse"
+)
+
+func TestReadyz(t *testing.T) {
+	db := database.NewTestDB(t)
+	monitor := NewProcessorMonitor(db, sse.NewManager(), config.ProcessorConfig{MonitorInterval: time.Hour})
+	monitor.Start()
+	h := NewHealthHandlers(db, monitor, config.HealthConfig{ReadyMinProcessors: 1, ReadyCheckTimeout: time.Second})
+
+	readyz := func() (int, map[string]healthCheck) {
+		rr := httptest.NewRecorder()
+		h.Readyz(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var resp struct {
+			Checks map[string]healthCheck `json:"checks"`
+		}
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		return rr.Code, resp.Checks
+	}
+
+	// Нет ни одного процессора online
+	code, checks := readyz()
+	if code != http.StatusServiceUnavailable || checks["processors"].Status != checkFail {
+		t.Fatalf("expected not ready without processors, got %d %+v", code, checks)
+	}
+
+	if err := db.TouchProcessor(t.Context(), "proc-1", "", ""); err != nil {
+		t.Fatalf("touch failed: %v", err)
+	}
+	code, checks = readyz()
+	if code != http.StatusOK {
+		t.Fatalf("expected ready, got %d %+v", code, checks)
+	}
+	for _, name := range []string{"database", "wal", "migrations", "scheduler", "processors"} {
+		if checks[name].Status != checkOK {
+			t.Errorf("check %s: %+v", name, checks[name])
+		}
+	}
+
+	// Схема старее, чем ожидает код
+	db.Exec(`DELETE FROM schema_migrations WHERE version = ?`, database.LatestSchemaVersion())
+	if code, checks = readyz(); code != http.StatusServiceUnavailable || checks["migrations"].Status != checkFail {
+		t.Errorf("expected migrations check to fail, got %d %+v", code, checks["migrations"])
+	}
+
+	h.cfg.ReadyMaxWALBytes = 1
+	if code, checks = readyz(); code != http.StatusServiceUnavailable || checks["wal"].Status != checkFail {
+		t.Errorf("expected WAL check to fail, got %d %+v", code, checks["wal"])
+	}
+	h.cfg.ReadyMaxWALBytes = 0
+
+	monitor.Stop()
+	if code, checks = readyz(); code != http.StatusServiceUnavailable || checks["scheduler"].Status != checkFail {
+		t.Errorf("expected scheduler check to fail, got %d %+v", code, checks["scheduler"])
+	}
+}
+
+func TestReadyzFailsWhileDraining(t *testing.T) {
+	db := database.NewTestDB(t)
+	h := NewHealthHandlers(db, nil, config.HealthConfig{})
+
+	rr := httptest.NewRecorder()
+	h.Readyz(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected ready, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	h.StartDraining()
+	rr = httptest.NewRecorder()
+	h.Readyz(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 while draining, got %d", rr.Code)
+	}
+
+	// Liveness не зависит от остановки
+	rr = httptest.NewRecorder()
+	h.Livez(rr, httptest.NewRequest(http.MethodGet, "/livez", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected livez 200, got %d", rr.Code)
+	}
+}
+
+func TestRunCheckTimesOut(t *testing.T) {
+	check := runCheck(func() (interface{}, error) {
+		time.Sleep(time.Second)
+		return nil, nil
+	}, 10*time.Millisecond)
+	if check.Status != checkFail {
+		t.Errorf("expected slow check to fail, got %+v", check)
+	}
+}
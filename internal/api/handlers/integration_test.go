@@ -110,7 +110,7 @@ func TestFullTaskLifecycleWithVoting(t *testing.T) {
 
 		// Устанавливаем задачу в статус "completed" с результатом
 		result := "Отличное описание товара для интеграционного теста!"
-		err = db.UpdateTaskStatus(createResp.TaskID, "completed", &result, nil)
+		err = db.UpdateTaskStatus(t.Context(), createResp.TaskID, "completed", &result, nil)
 		if err != nil {
 			t.Fatalf("Failed to complete task: %v", err)
 		}
@@ -184,7 +184,7 @@ func TestFullTaskLifecycleWithVoting(t *testing.T) {
 		// 6. Проверяем что рейтинг сохранился
 		t.Log("Step 6: Verifying rating persistence...")
 
-		task, err := db.GetTask(createResp.TaskID)
+		task, err := db.GetTask(t.Context(), createResp.TaskID)
 		if err != nil {
 			t.Fatalf("Failed to get task after voting: %v", err)
 		}
@@ -272,7 +272,7 @@ func TestFullTaskLifecycleWithVoting(t *testing.T) {
 		// 10. Проверяем финальное состояние
 		t.Log("Step 10: Final verification...")
 
-		task, err = db.GetTask(createResp.TaskID)
+		task, err = db.GetTask(t.Context(), createResp.TaskID)
 		if err != nil {
 			t.Fatalf("Failed to get task for final check: %v", err)
 		}
@@ -326,7 +326,7 @@ func TestRatingStatsIntegration(t *testing.T) {
 			completedAt := time.Now().UnixMilli()
 			task.CompletedAt = &completedAt
 
-			err := db.CreateTask(task)
+			err := db.CreateTask(t.Context(), task)
 			if err != nil {
 				t.Fatalf("Failed to create task %d: %v", i+1, err)
 			}
@@ -342,7 +342,7 @@ func TestRatingStatsIntegration(t *testing.T) {
 
 		for i, rating := range ratings {
 			if rating != nil {
-				err := db.UpdateTaskRating(taskIDs[i], userIDs[i], rating)
+				err := db.UpdateTaskRating(t.Context(), taskIDs[i], userIDs[i], rating)
 				if err != nil {
 					t.Fatalf("Failed to set rating for task %d: %v", i+1, err)
 				}
@@ -424,7 +424,7 @@ func TestVotingEdgeCases(t *testing.T) {
 			CreatedAt:   time.Now().UnixMilli(),
 		}
 
-		err := db.CreateTask(task)
+		err := db.CreateTask(t.Context(), task)
 		if err != nil {
 			t.Fatalf("Failed to create pending task: %v", err)
 		}
@@ -477,7 +477,7 @@ func TestVotingEdgeCases(t *testing.T) {
 		completedAt := time.Now().UnixMilli()
 		task.CompletedAt = &completedAt
 
-		err := db.CreateTask(task)
+		err := db.CreateTask(t.Context(), task)
 		if err != nil {
 			t.Fatalf("Failed to create task: %v", err)
 		}
@@ -1,76 +1,90 @@
 package handlers
 
 import (
-	"database/sql"
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"math"
 	"net/http"
 	"strconv"
-	"strings"
 	"time"
 
+	"github.com/ad/go-llm-manager/internal/archive"
 	"github.com/ad/go-llm-manager/internal/auth"
+	"github.com/ad/go-llm-manager/internal/config"
 	"github.com/ad/go-llm-manager/internal/database"
+	"github.com/ad/go-llm-manager/internal/logging"
+	"github.com/ad/go-llm-manager/internal/metrics"
+	"github.com/ad/go-llm-manager/internal/ratelimit"
 	"github.com/ad/go-llm-manager/internal/utils"
 )
 
-// retryOnBusy executes a function with retry logic for SQLite BUSY errors
-func retryOnBusy(maxRetries int, fn func() error) error {
-	var err error
-	for i := 0; i < maxRetries; i++ {
-		err = fn()
-		if err == nil {
-			return nil
-		}
-
-		// Check if it's a SQLite BUSY error and retry
-		if i < maxRetries-1 {
-			errStr := strings.ToLower(err.Error())
-			isBusyError := strings.Contains(errStr, "database is locked") ||
-				strings.Contains(errStr, "database table is locked") ||
-				strings.Contains(errStr, "busy") ||
-				strings.Contains(errStr, "sqlite_busy") ||
-				strings.Contains(errStr, "locked")
-
-			if isBusyError {
-				// Exponential backoff with jitter for BUSY errors
-				baseDelay := time.Duration(i+1) * 100 * time.Millisecond
-				jitter := time.Duration(i*50) * time.Millisecond
-				time.Sleep(baseDelay + jitter)
-				continue
-			}
-			// For other errors, also retry but with less delay
-			time.Sleep(time.Duration(i+1) * 20 * time.Millisecond)
-		}
-	}
-	return err
-}
-
 type InternalHandlers struct {
-	db      *database.DB
+	store   database.Store
 	jwtAuth *auth.JWTAuth
+
+	cleanup  config.CleanupConfig
+	archiver *archive.Archiver
 }
 
-func NewInternalHandlers(db *database.DB, jwtAuth *auth.JWTAuth) *InternalHandlers {
+func NewInternalHandlers(store database.Store, jwtAuth *auth.JWTAuth) *InternalHandlers {
 	return &InternalHandlers{
-		db:      db,
+		store:   store,
 		jwtAuth: jwtAuth,
+		cleanup: config.CleanupConfig{DaysToKeep: 7},
 	}
 }
 
+// SetCleanup sets the retention of finished tasks and the archive that keeps them
+// after cleanup (used when cfg.Archive is set and by the archive endpoints)
+func (h *InternalHandlers) SetCleanup(cfg config.CleanupConfig, archiver *archive.Archiver) {
+	h.cleanup = cfg
+	h.archiver = archiver
+}
+
+// retention returns the cutoffs of finished tasks for the configured retention
+func (h *InternalHandlers) retention(now int64) database.Retention {
+	const day = 24 * 60 * 60 * 1000
+	return database.Retention{
+		Completed: now - int64(h.cleanup.RetentionDays(database.TaskStatusCompleted))*day,
+		Failed:    now - int64(h.cleanup.RetentionDays(database.TaskStatusFailed))*day,
+	}
+}
+
+// bindProcessorID fills processorID from the processor token bound by the auth middleware.
+// A processor_id in the request that differs from the token is an impersonation attempt:
+// it is rejected with 403 and false is returned.
+func bindProcessorID(w http.ResponseWriter, r *http.Request, processorID *string) bool {
+	tokenProcessorID, ok := auth.ProcessorIDFromContext(r.Context())
+	if !ok {
+		return true
+	}
+
+	if *processorID != "" && *processorID != tokenProcessorID {
+		slog.WarnContext(r.Context(), "Processor token used on behalf of another processor", logging.ProcessorID(tokenProcessorID), "requested_processor_id", *processorID)
+		utils.SendError(w, http.StatusForbidden, "processor_id does not match token")
+		return false
+	}
+
+	*processorID = tokenProcessorID
+	return true
+}
+
 // POST /api/internal/generate-token - Generate JWT token
 func (h *InternalHandlers) GenerateToken(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		UserID        string                    `json:"user_id,omitempty"`
-		ProcessorID   string                    `json:"processor_id,omitempty"`
-		DurationHours *int                      `json:"duration_hours,omitempty"`
-		TaskID        string                    `json:"taskId,omitempty"`
-		ExpiresIn     *int                      `json:"expires_in,omitempty"`
-		ProductData   string                    `json:"product_data,omitempty"`
-		Priority      *int                      `json:"priority,omitempty"`
-		OllamaParams  *database.OllamaParams    `json:"ollama_params,omitempty"`
-		RateLimit     *database.RateLimitConfig `json:"rate_limit,omitempty"`
+		UserID            string                     `json:"user_id,omitempty"`
+		ProcessorID       string                     `json:"processor_id,omitempty"`
+		DurationHours     *int                       `json:"duration_hours,omitempty"`
+		TaskID            string                     `json:"taskId,omitempty"`
+		ExpiresIn         *int                       `json:"expires_in,omitempty"`
+		ProductData       string                     `json:"product_data,omitempty"`
+		ProductDataSHA256 string                     `json:"product_data_sha256,omitempty"` // тело передаётся в /api/create
+		BindProductData   bool                       `json:"bind_product_data,omitempty"`   // захешировать product_data вместо встраивания
+		Priority          *int                       `json:"priority,omitempty"`
+		OllamaParams      *database.OllamaParams     `json:"ollama_params,omitempty"`
+		RateLimit         *database.RateLimitConfig  `json:"rate_limit,omitempty"`
+		TokenLimit        *database.TokenLimitConfig `json:"token_limit,omitempty"`
 	}
 
 	if err := utils.ParseJSON(r, &req); err != nil {
@@ -101,6 +115,7 @@ func (h *InternalHandlers) GenerateToken(w http.ResponseWriter, r *http.Request)
 		data := map[string]interface{}{
 			"success":    true,
 			"token":      token,
+			"jti":        payload.ID,
 			"expires_in": expiresIn,
 		}
 
@@ -114,21 +129,52 @@ func (h *InternalHandlers) GenerateToken(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	productData := req.ProductData
+	productDataSHA256 := req.ProductDataSHA256
+	if productDataSHA256 != "" {
+		if productData != "" {
+			utils.SendError(w, http.StatusBadRequest, "product_data and product_data_sha256 are mutually exclusive")
+			return
+		}
+		if !auth.IsValidProductDataHash(productDataSHA256) {
+			utils.SendError(w, http.StatusBadRequest, "product_data_sha256 must be a hex-encoded SHA-256")
+			return
+		}
+	} else if req.BindProductData {
+		if productData == "" {
+			utils.SendError(w, http.StatusBadRequest, "product_data is required with bind_product_data")
+			return
+		}
+		productDataSHA256 = auth.HashProductData(productData)
+		productData = ""
+	}
+
+	if req.RateLimit != nil && !ratelimit.ValidAlgorithm(req.RateLimit.Algorithm) {
+		utils.SendError(w, http.StatusBadRequest, "rate_limit.algorithm must be one of fixed_window, sliding_log, sliding_window, token_bucket")
+		return
+	}
+	if req.TokenLimit != nil && (req.TokenLimit.MaxTokens < 0 || req.TokenLimit.WindowMs < 0) {
+		utils.SendError(w, http.StatusBadRequest, "token_limit values must not be negative")
+		return
+	}
+
 	priority := 0
 	if req.Priority != nil {
 		priority = *req.Priority
 	}
 
 	payload := &database.JWTPayload{
-		Issuer:       "llm-proxy",
-		Audience:     "llm-proxy-api",
-		Subject:      req.UserID,
-		UserID:       req.UserID,
-		TaskID:       req.TaskID,
-		ProductData:  req.ProductData,
-		Priority:     &priority,
-		OllamaParams: req.OllamaParams,
-		RateLimit:    req.RateLimit,
+		Issuer:            "llm-proxy",
+		Audience:          "llm-proxy-api",
+		Subject:           req.UserID,
+		UserID:            req.UserID,
+		TaskID:            req.TaskID,
+		ProductData:       productData,
+		ProductDataSHA256: productDataSHA256,
+		Priority:          &priority,
+		OllamaParams:      req.OllamaParams,
+		RateLimit:         req.RateLimit,
+		TokenLimit:        req.TokenLimit,
 	}
 
 	expiresIn := 3600 // 1 hour default
@@ -145,8 +191,12 @@ func (h *InternalHandlers) GenerateToken(w http.ResponseWriter, r *http.Request)
 	data := map[string]interface{}{
 		"success":    true,
 		"token":      token,
+		"jti":        payload.ID,
 		"expires_in": expiresIn,
 	}
+	if productDataSHA256 != "" {
+		data["product_data_sha256"] = productDataSHA256
+	}
 
 	utils.SendJSON(w, http.StatusOK, data)
 }
@@ -161,7 +211,7 @@ func (h *InternalHandlers) GetTasks(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	tasks, err := h.db.GetPendingTasks(limit)
+	tasks, err := h.store.GetPendingTasks(r.Context(), limit)
 	if err != nil {
 		utils.SendError(w, http.StatusInternalServerError, "Failed to get tasks")
 		return
@@ -172,7 +222,7 @@ func (h *InternalHandlers) GetTasks(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// GET /api/internal/all-tasks - Get all tasks
+// GET /api/internal/all-tasks - List tasks matching the filters, newest first, with cursor pagination
 func (h *InternalHandlers) GetAllTasks(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query()
 
@@ -184,28 +234,35 @@ func (h *InternalHandlers) GetAllTasks(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	offsetStr := query.Get("offset")
-	offset := 0
-	if offsetStr != "" {
-		if o, err := strconv.Atoi(offsetStr); err == nil && o >= 0 {
-			offset = o
-		}
+	// offset устарел: он пропускал и дублировал задачи, пока очередь меняется.
+	// Старые клиенты получают первую страницу вместо ошибки, пока не перейдут на cursor.
+	if query.Has("offset") {
+		w.Header().Set("Deprecation", "true")
+		slog.WarnContext(r.Context(), "Deprecated offset parameter ignored, use cursor", "offset", query.Get("offset"))
+	}
+
+	filter, err := parseTaskFilter(query)
+	if err != nil {
+		utils.SendError(w, http.StatusBadRequest, err.Error())
+		return
 	}
 
-	var userID *string
-	if uid := query.Get("user_id"); uid != "" {
-		userID = &uid
+	var after *database.TaskCursor
+	if c := query.Get("cursor"); c != "" {
+		if after, err = database.ParseTaskCursor(c); err != nil {
+			utils.SendError(w, http.StatusBadRequest, "Invalid cursor")
+			return
+		}
 	}
 
-	tasks, err := h.db.GetAllTasks(userID, limit, offset)
+	page, err := h.store.ListTasks(r.Context(), filter, after, limit)
 	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to list tasks", logging.Err(err))
 		utils.SendError(w, http.StatusInternalServerError, "Failed to get tasks")
 		return
 	}
 
-	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
-		"tasks": tasks,
-	})
+	utils.SendJSON(w, http.StatusOK, page)
 }
 
 // POST /api/internal/claim - Batch claim tasks
@@ -223,11 +280,20 @@ func (h *InternalHandlers) ClaimTasks(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !bindProcessorID(w, r, &req.ProcessorID) {
+		return
+	}
+
 	if req.ProcessorID == "" {
 		utils.SendError(w, http.StatusBadRequest, "processor_id is required")
 		return
 	}
 
+	// Claim тоже считается признаком жизни процессора
+	if err := h.store.TouchProcessor(r.Context(), req.ProcessorID, "", ""); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to touch processor on claim", logging.ProcessorID(req.ProcessorID), logging.Err(err))
+	}
+
 	batchSize := 5
 	if req.BatchSize != nil && *req.BatchSize > 0 && *req.BatchSize <= 20 {
 		batchSize = *req.BatchSize
@@ -248,26 +314,46 @@ func (h *InternalHandlers) ClaimTasks(w http.ResponseWriter, r *http.Request) {
 		useFairDistribution = *req.UseFairDistribution
 	}
 
+	// Ограничение по max_concurrency из реестра процессоров
+	maxConcurrency, activeTasks, err := h.store.GetProcessorCapacity(r.Context(), req.ProcessorID)
+	if err != nil {
+		utils.SendError(w, http.StatusInternalServerError, "Failed to get processor capacity")
+		return
+	}
+
 	var claimedTasks []*database.Task
 	var fairDistributionInfo string
-	var err error
 
-	if useFairDistribution {
-		claimedTasks, fairDistributionInfo, err = h.claimTasksWithFairDistribution(req.ProcessorID, batchSize, processorLoad, timeoutMs)
+	if maxConcurrency > 0 && activeTasks >= maxConcurrency {
+		claimedTasks = []*database.Task{}
+		fairDistributionInfo = fmt.Sprintf("Processor saturated: %d/%d tasks", activeTasks, maxConcurrency)
 	} else {
-		claimedTasks, err = h.claimTasksBatch(req.ProcessorID, batchSize, timeoutMs)
-		fairDistributionInfo = "Not used"
+		if maxConcurrency > 0 && batchSize > maxConcurrency-activeTasks {
+			batchSize = maxConcurrency - activeTasks
+		}
+
+		if useFairDistribution {
+			claimedTasks, fairDistributionInfo, err = h.claimTasksWithFairDistribution(r.Context(), req.ProcessorID, batchSize, processorLoad, timeoutMs, maxConcurrency)
+		} else {
+			claimedTasks, err = h.claimTasksBatch(r.Context(), req.ProcessorID, batchSize, timeoutMs, maxConcurrency)
+			fairDistributionInfo = "Not used"
+		}
 	}
 
 	if err != nil {
 		utils.SendError(w, http.StatusInternalServerError, "Failed to claim tasks")
 		return
 	}
+	linkTaskTraces(r.Context(), claimedTasks)
 
 	// Логируем кому отправлены задачи
 	for _, task := range claimedTasks {
 		if task != nil && task.ID != "" && task.ProcessorID != nil {
-			log.Printf("[CLAIM] Task %s от пользователя %s отправлена процессору %s", task.ID, task.UserID, *task.ProcessorID)
+			slog.InfoContext(r.Context(), "Task claimed", logging.TaskID(task.ID), logging.UserID(task.UserID), logging.ProcessorID(*task.ProcessorID))
+			metrics.TaskClaims.Inc()
+			if task.ProcessingStartedAt != nil && task.CreatedAt > 0 {
+				metrics.TaskQueueWait.Observe(float64(*task.ProcessingStartedAt-task.CreatedAt) / 1000)
+			}
 		}
 	}
 
@@ -281,168 +367,33 @@ func (h *InternalHandlers) ClaimTasks(w http.ResponseWriter, r *http.Request) {
 		response["fair_distribution_info"] = fairDistributionInfo
 	}
 
-	utils.SendJSON(w, http.StatusOK, response)
-}
-
-func (h *InternalHandlers) claimTasksBatch(processorID string, batchSize int, timeoutMs int64) ([]*database.Task, error) {
-	// Get pending tasks
-	tasks, err := h.db.GetPendingTasks(batchSize)
-	if err != nil {
-		return nil, err
-	}
-
-	if len(tasks) == 0 {
-		return []*database.Task{}, nil
+	if maxConcurrency > 0 {
+		response["max_concurrency"] = maxConcurrency
+		response["available_slots"] = max(0, maxConcurrency-activeTasks-len(claimedTasks))
 	}
 
-	// Claim tasks by updating status
-	claimedTasks := make([]*database.Task, 0)
-	now := time.Now().UnixMilli()
-	timeoutAt := now + timeoutMs
-
-	for _, task := range tasks {
-		// Update task to processing with retry logic
-		err := retryOnBusy(3, func() error {
-			query := `
-				UPDATE tasks 
-				SET status = 'processing', 
-					processor_id = ?, 
-					processing_started_at = ?, 
-					heartbeat_at = ?,
-					timeout_at = ?,
-					updated_at = ?
-				WHERE id = ? AND status = 'pending'
-			`
-
-			result, err := h.db.QueuedExecWithWriteLock(query, processorID, now, now, timeoutAt, now, task.ID)
-			if err != nil {
-				return err
-			}
-
-			if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
-				return fmt.Errorf("task not updated")
-			}
-
-			return nil
-		})
-
-		if err != nil {
-			continue // Skip this task if update failed
-		}
-
-		// Update task object
-		task.Status = "processing"
-		task.ProcessorID = &processorID
-		task.ProcessingStartedAt = &now
-		task.HeartbeatAt = &now
-		task.TimeoutAt = &timeoutAt
-		task.UpdatedAt = now
-
-		claimedTasks = append(claimedTasks, task)
-	}
+	utils.SendJSON(w, http.StatusOK, response)
+}
 
-	return claimedTasks, nil
+// claimTasksBatch claims up to batchSize pending tasks. If maxConcurrency > 0 the
+// processor never holds more than maxConcurrency processing tasks.
+func (h *InternalHandlers) claimTasksBatch(ctx context.Context, processorID string, batchSize int, timeoutMs int64, maxConcurrency int) ([]*database.Task, error) {
+	return h.store.ClaimTasks(ctx, processorID, batchSize, timeoutMs, maxConcurrency)
 }
 
 // claimTasksWithFairDistribution implements advanced fair distribution logic
-func (h *InternalHandlers) claimTasksWithFairDistribution(processorID string, batchSize int, processorLoad float64, timeoutMs int64) ([]*database.Task, string, error) {
+func (h *InternalHandlers) claimTasksWithFairDistribution(ctx context.Context, processorID string, batchSize int, processorLoad float64, timeoutMs int64, maxConcurrency int) ([]*database.Task, string, error) {
 	// Adjust batch size based on processor load (higher load = fewer tasks)
 	adjustedBatchSize := int(math.Max(1, math.Ceil(float64(batchSize)*(1.0-processorLoad*0.5))))
 
-	now := time.Now().UnixMilli()
-	timeoutAt := now + timeoutMs
-
-	// First, select pending tasks with priority
-	selectQuery := `
-		SELECT id, user_id, product_data, priority, retry_count, 
-		       estimated_duration, ollama_params, created_at
-		FROM tasks 
-		WHERE status = 'pending'
-		ORDER BY priority DESC, created_at ASC
-		LIMIT ?
-	`
-
-	rows, err := h.db.Query(selectQuery, adjustedBatchSize)
+	claimedTasks, err := h.store.ClaimTasks(ctx, processorID, adjustedBatchSize, timeoutMs, maxConcurrency)
 	if err != nil {
 		return nil, "", err
 	}
-	defer rows.Close()
-
-	var selectedTasks []*database.Task
-	for rows.Next() {
-		task := &database.Task{}
-		var ollamaParamsJSON sql.NullString
-
-		err := rows.Scan(
-			&task.ID, &task.UserID, &task.ProductData, &task.Priority,
-			&task.RetryCount, &task.EstimatedDuration, &ollamaParamsJSON, &task.CreatedAt,
-		)
-		if err != nil {
-			continue
-		}
-
-		if ollamaParamsJSON.Valid && ollamaParamsJSON.String != "" {
-			task.OllamaParams = &ollamaParamsJSON.String
-		}
-
-		selectedTasks = append(selectedTasks, task)
-	}
 
-	if len(selectedTasks) == 0 {
+	if len(claimedTasks) == 0 {
 		fairInfo := fmt.Sprintf("Load: %.1f, Adjusted batch size: %d, No tasks available", processorLoad, adjustedBatchSize)
-		return []*database.Task{}, fairInfo, nil
-	}
-
-	// Atomic update of selected tasks using transaction
-	tx, err := h.db.Begin()
-	if err != nil {
-		return nil, "", err
-	}
-	defer tx.Rollback()
-
-	claimedTasks := make([]*database.Task, 0)
-	placeholders := make([]string, len(selectedTasks))
-	taskIDs := make([]interface{}, len(selectedTasks))
-
-	for i, task := range selectedTasks {
-		placeholders[i] = "?"
-		taskIDs[i] = task.ID
-	}
-
-	updateQuery := fmt.Sprintf(`
-		UPDATE tasks 
-		SET status = 'processing',
-		    processor_id = ?,
-		    processing_started_at = ?,
-		    heartbeat_at = ?,
-		    timeout_at = ?,
-		    updated_at = ?
-		WHERE id IN (%s) AND status = 'pending'
-	`, strings.Join(placeholders, ","))
-
-	args := append([]interface{}{processorID, now, now, timeoutAt, now}, taskIDs...)
-	result, err := tx.Exec(updateQuery, args...)
-	if err != nil {
-		return nil, "", err
-	}
-
-	if err = tx.Commit(); err != nil {
-		return nil, "", err
-	}
-
-	rowsAffected, _ := result.RowsAffected()
-
-	// Update task objects for claimed tasks
-	for i, task := range selectedTasks {
-		if int64(i) < rowsAffected {
-			task.Status = "processing"
-			task.ProcessorID = &processorID
-			task.ProcessingStartedAt = &now
-			task.HeartbeatAt = &now
-			task.TimeoutAt = &timeoutAt
-			task.UpdatedAt = now
-			claimedTasks = append(claimedTasks, task)
-		}
+		return claimedTasks, fairInfo, nil
 	}
 
 	fairInfo := fmt.Sprintf("Load: %.1f, Adjusted batch size: %d, Claimed: %d", processorLoad, adjustedBatchSize, len(claimedTasks))
@@ -464,59 +415,40 @@ func (h *InternalHandlers) Heartbeat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !bindProcessorID(w, r, &req.ProcessorID) {
+		return
+	}
+
 	if req.ProcessorID == "" || req.TaskID == "" {
 		utils.SendError(w, http.StatusBadRequest, "taskId and processor_id are required")
 		return
 	}
 
-	now := time.Now().UnixMilli()
-
 	// Update processor metrics if provided
 	if req.CPUUsage != nil || req.MemoryUsage != nil || req.QueueSize != nil {
-		activeTasksCount := 1 // Since we have one task in heartbeat
-		query := `
-			INSERT OR REPLACE INTO processor_metrics 
-			(processor_id, cpu_usage, memory_usage, queue_size, active_tasks, last_updated, created_at)
-			VALUES (?, 
-			        COALESCE(?, (SELECT cpu_usage FROM processor_metrics WHERE processor_id = ?)), 
-			        COALESCE(?, (SELECT memory_usage FROM processor_metrics WHERE processor_id = ?)),
-			        COALESCE(?, (SELECT queue_size FROM processor_metrics WHERE processor_id = ?)),
-			        ?,
-			        ?, 
-			        COALESCE((SELECT created_at FROM processor_metrics WHERE processor_id = ?), ?))
-		`
-
-		_, err := h.db.Exec(query,
-			req.ProcessorID, req.CPUUsage, req.ProcessorID,
-			req.MemoryUsage, req.ProcessorID,
-			req.QueueSize, req.ProcessorID,
-			activeTasksCount,
-			now, req.ProcessorID, now)
-
-		if err != nil {
+		// Since we have one task in heartbeat
+		if err := h.store.UpdateProcessorMetrics(r.Context(), req.ProcessorID, req.CPUUsage, req.MemoryUsage, req.QueueSize, 1); err != nil {
 			utils.SendError(w, http.StatusInternalServerError, "Failed to update metrics")
 			return
 		}
 	}
 
 	// Update heartbeat for the task
-	query := `
-		UPDATE tasks 
-		SET heartbeat_at = ?, updated_at = ?
-		WHERE processor_id = ? AND id = ? AND status = 'processing'
-	`
-
-	result, err := h.db.Exec(query, now, now, req.ProcessorID, req.TaskID)
+	updated, err := h.store.HeartbeatTask(r.Context(), req.TaskID, req.ProcessorID)
 	if err != nil {
 		utils.SendError(w, http.StatusInternalServerError, "Failed to update heartbeat")
 		return
 	}
 
-	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+	if !updated {
 		utils.SendError(w, http.StatusNotFound, "Task not found or not owned by processor")
 		return
 	}
 
+	if err := h.store.TouchProcessor(r.Context(), req.ProcessorID, "", ""); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to update processor last_seen", logging.ProcessorID(req.ProcessorID), logging.Err(err))
+	}
+
 	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
 		"success": true,
 	})
@@ -529,6 +461,8 @@ func (h *InternalHandlers) ProcessorHeartbeat(w http.ResponseWriter, r *http.Req
 		CPUUsage    *float64 `json:"cpu_usage,omitempty"`
 		MemoryUsage *float64 `json:"memory_usage,omitempty"`
 		QueueSize   *int     `json:"queue_size,omitempty"`
+		Version     string   `json:"version,omitempty"`
+		Hostname    string   `json:"hostname,omitempty"`
 	}
 
 	if err := utils.ParseJSON(r, &req); err != nil {
@@ -536,47 +470,33 @@ func (h *InternalHandlers) ProcessorHeartbeat(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	if !bindProcessorID(w, r, &req.ProcessorID) {
+		return
+	}
+
 	if req.ProcessorID == "" {
 		utils.SendError(w, http.StatusBadRequest, "processor_id is required")
 		return
 	}
 
-	now := time.Now().UnixMilli()
-
 	// Count active tasks for this processor
-	var activeTasksCount int
-	countQuery := `SELECT COUNT(*) FROM tasks WHERE processor_id = ? AND status = 'processing'`
-	err := h.db.QueryRow(countQuery, req.ProcessorID).Scan(&activeTasksCount)
+	_, activeTasksCount, err := h.store.GetProcessorCapacity(r.Context(), req.ProcessorID)
 	if err != nil {
-		log.Printf("Error counting active tasks: %v\n", err)
+		slog.ErrorContext(r.Context(), "Failed to count active tasks", logging.ProcessorID(req.ProcessorID), logging.Err(err))
 		activeTasksCount = 0
 	}
 
 	// Update processor metrics
-	query := `
-		INSERT OR REPLACE INTO processor_metrics 
-		(processor_id, cpu_usage, memory_usage, queue_size, active_tasks, last_updated, created_at)
-		VALUES (?, 
-		        COALESCE(?, (SELECT cpu_usage FROM processor_metrics WHERE processor_id = ?)), 
-		        COALESCE(?, (SELECT memory_usage FROM processor_metrics WHERE processor_id = ?)),
-		        COALESCE(?, (SELECT queue_size FROM processor_metrics WHERE processor_id = ?)),
-		        ?,
-		        ?, 
-		        COALESCE((SELECT created_at FROM processor_metrics WHERE processor_id = ?), ?))
-	`
-
-	_, err = h.db.Exec(query,
-		req.ProcessorID, req.CPUUsage, req.ProcessorID,
-		req.MemoryUsage, req.ProcessorID,
-		req.QueueSize, req.ProcessorID,
-		activeTasksCount,
-		now, req.ProcessorID, now)
-
-	if err != nil {
+	if err := h.store.UpdateProcessorMetrics(r.Context(), req.ProcessorID, req.CPUUsage, req.MemoryUsage, req.QueueSize, activeTasksCount); err != nil {
 		utils.SendError(w, http.StatusInternalServerError, "Failed to update processor metrics")
 		return
 	}
 
+	if err := h.store.TouchProcessor(r.Context(), req.ProcessorID, req.Version, req.Hostname); err != nil {
+		utils.SendError(w, http.StatusInternalServerError, "Failed to update processor registry")
+		return
+	}
+
 	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
 		"success": true,
 	})
@@ -590,6 +510,11 @@ func (h *InternalHandlers) CompleteTasks(w http.ResponseWriter, r *http.Request)
 		Status       string  `json:"status"`
 		Result       *string `json:"result,omitempty"`
 		ErrorMessage *string `json:"error_message,omitempty"`
+
+		// Расход токенов LLM (опционально)
+		Model            string `json:"model,omitempty"`
+		PromptTokens     *int64 `json:"prompt_tokens,omitempty"`
+		CompletionTokens *int64 `json:"completion_tokens,omitempty"`
 	}
 
 	if err := utils.ParseJSON(r, &req); err != nil {
@@ -602,28 +527,66 @@ func (h *InternalHandlers) CompleteTasks(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if (req.PromptTokens != nil && *req.PromptTokens < 0) || (req.CompletionTokens != nil && *req.CompletionTokens < 0) {
+		utils.SendError(w, http.StatusBadRequest, "prompt_tokens and completion_tokens must not be negative")
+		return
+	}
+
 	if req.Status != "completed" && req.Status != "failed" {
 		utils.SendError(w, http.StatusBadRequest, "status must be 'completed' or 'failed'")
 		return
 	}
 
+	// Процессор с собственным токеном может завершать только свои задачи
+	if tokenProcessorID, ok := auth.ProcessorIDFromContext(r.Context()); ok {
+		processorID := ""
+		if req.ProcessorID != nil {
+			processorID = *req.ProcessorID
+		}
+		if !bindProcessorID(w, r, &processorID) {
+			return
+		}
+
+		task, err := h.store.GetTask(r.Context(), req.TaskID)
+		if err != nil {
+			utils.SendError(w, http.StatusNotFound, "Task not found")
+			return
+		}
+		if task.ProcessorID == nil || *task.ProcessorID != tokenProcessorID {
+			utils.SendError(w, http.StatusForbidden, "Task is not assigned to this processor")
+			return
+		}
+	}
+
 	// Use the proper UpdateTaskStatus function which has retry logic
-	err := h.db.UpdateTaskStatus(req.TaskID, req.Status, req.Result, req.ErrorMessage)
+	err := h.store.UpdateTaskStatus(r.Context(), req.TaskID, req.Status, req.Result, req.ErrorMessage)
 
 	if err != nil {
-		log.Printf("[COMPLETE ERROR] Failed to update task %s: %v\n", req.TaskID, err)
+		slog.ErrorContext(r.Context(), "Failed to complete task", logging.TaskID(req.TaskID), logging.Err(err))
 		utils.SendError(w, http.StatusInternalServerError, "Failed to complete task")
 		return
 	}
 
 	// Verify task was actually updated (optional additional check)
-	task, taskErr := h.db.GetTask(req.TaskID)
+	task, taskErr := h.store.GetTask(r.Context(), req.TaskID)
 	if taskErr != nil || task.Status != req.Status {
-		log.Printf("[COMPLETE ERROR] Task %s not found or not updated: %v\n", req.TaskID, taskErr)
+		slog.ErrorContext(r.Context(), "Completed task not found or not updated", logging.TaskID(req.TaskID), logging.Err(taskErr))
 		utils.SendError(w, http.StatusNotFound, "Task not found or not updated")
 		return
 	}
 
+	if req.PromptTokens != nil || req.CompletionTokens != nil {
+		recordTaskUsage(r.Context(), h.store, task, req.Model, req.PromptTokens, req.CompletionTokens)
+	}
+
+	if req.Status == database.TaskStatusFailed {
+		metrics.TaskFailures.Inc(metrics.SourceProcessor)
+	}
+	if task.ProcessingStartedAt != nil && task.CompletedAt != nil {
+		metrics.TaskProcessing.Observe(float64(*task.CompletedAt-*task.ProcessingStartedAt)/1000, req.Status)
+	}
+	finishTaskSpan(r.Context(), task)
+
 	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
 		"success": true,
 	})
@@ -636,9 +599,9 @@ func (h *InternalHandlers) Cleanup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	stats, cleaned, err := h.performCleanup()
+	stats, cleaned, err := h.performCleanup(r.Context())
 	if err != nil {
-		log.Printf("[CLEANUP ERROR] %v\n", err)
+		slog.ErrorContext(r.Context(), "Cleanup failed", logging.Err(err))
 		utils.SendError(w, http.StatusInternalServerError, "Cleanup failed")
 		return
 	}
@@ -659,9 +622,9 @@ func (h *InternalHandlers) CleanupStats(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	stats, err := h.getCleanupStats()
+	stats, err := h.getCleanupStats(r.Context())
 	if err != nil {
-		log.Printf("[CLEANUP STATS ERROR] %v\n", err)
+		slog.ErrorContext(r.Context(), "Failed to get cleanup stats", logging.Err(err))
 		utils.SendError(w, http.StatusInternalServerError, "Failed to get cleanup stats")
 		return
 	}
@@ -672,87 +635,76 @@ func (h *InternalHandlers) CleanupStats(w http.ResponseWriter, r *http.Request)
 	})
 }
 
-func (h *InternalHandlers) performCleanup() (map[string]interface{}, map[string]interface{}, error) {
+func (h *InternalHandlers) performCleanup(ctx context.Context) (map[string]interface{}, map[string]interface{}, error) {
 	now := time.Now().UnixMilli()
 	sevenDaysAgo := now - (7 * 24 * 60 * 60 * 1000) // 7 days ago
 	fiveMinutesAgo := now - (5 * 60 * 1000)         // 5 minutes ago
 
 	// Get current stats before cleanup
-	stats, err := h.getCleanupStats()
+	stats, err := h.getCleanupStats(ctx)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	// 1. Clean old completed/failed tasks (older than 7 days)
-	cleanTasksQuery := `
-		DELETE FROM tasks 
-		WHERE (status = 'completed' OR status = 'failed') 
-		AND completed_at < ?
-	`
-	taskResult, err := h.db.Exec(cleanTasksQuery, sevenDaysAgo)
-	var cleanedTasks int64 = 0
-	if err == nil {
-		cleanedTasks, _ = taskResult.RowsAffected()
+	// 1. Clean (or archive) completed/failed tasks past the retention of their status
+	archiving := h.cleanup.Archive && h.archiver != nil
+	retention := h.retention(now)
+	var cleanedTasks int64
+	for _, status := range []string{database.TaskStatusCompleted, database.TaskStatusFailed} {
+		var n int64
+		if archiving {
+			n, err = h.archiver.Archive(ctx, status, retention.Before(status))
+		} else {
+			n, err = h.store.DeleteFinishedTasks(ctx, status, retention.Before(status))
+		}
+		cleanedTasks += n
+		if err != nil {
+			slog.Error("Failed to clean up finished tasks", "status", status, "archive", archiving, logging.Err(err))
+		}
 	}
 
 	// 2. Requeue timed out tasks (processing but no heartbeat for 5+ minutes)
-	timedoutQuery := `
-		SELECT id, processor_id, retry_count, max_retries FROM tasks 
-		WHERE status = 'processing' AND (heartbeat_at < ? OR heartbeat_at IS NULL)
-	`
-	rows, err := h.db.Query(timedoutQuery, fiveMinutesAgo)
+	timedout, err := h.store.GetTimedOutTasks(ctx, fiveMinutesAgo)
+	if err != nil {
+		slog.Error("Failed to get timed out tasks", logging.Err(err))
+	}
+
 	var requeuedTasks, failedTasks int64
-	if err == nil {
-		defer rows.Close()
-		for rows.Next() {
-			var id, processorID string
-			var retryCount, maxRetries int
-			if err := rows.Scan(&id, &processorID, &retryCount, &maxRetries); err != nil {
-				continue
-			}
-			if retryCount+1 < maxRetries {
-				log.Printf("[CLEANUP DEBUG] RequeueTask params: id=%s processorID=%s\n", id, processorID)
-				err := h.db.RequeueTask(id, processorID, func() *string { s := "manager: heartbeat timeout"; return &s }())
-				if err == nil {
-					requeuedTasks++
-					log.Printf("[CLEANUP] Task %s requeued (timeout, retry %d/%d)\n", id, retryCount+1, maxRetries)
-				} else {
-					log.Printf("[CLEANUP ERROR] RequeueTask failed: %v\n", err)
-				}
-			} else {
-				failQuery := `
-					UPDATE tasks SET status = 'failed', error_message = ?, completed_at = ?, updated_at = ?
-					WHERE id = ? AND status = 'processing'
-				`
-				_, err := h.db.Exec(failQuery, "Task failed: heartbeat timeout, max retries reached", now, now, id)
-				if err == nil {
-					failedTasks++
-					log.Printf("[CLEANUP] Task %s failed (timeout, max retries)\n", id)
-				}
-			}
+	for _, t := range timedout {
+		processorID := ""
+		if t.ProcessorID != nil {
+			processorID = *t.ProcessorID
+		}
+		outcome, err := recoverOrphanedTask(ctx, h.store, sseManagerInstance, t.ID, processorID, t.RetryCount, t.MaxRetries,
+			"manager: heartbeat timeout",
+			"Task failed: heartbeat timeout, max retries reached",
+		)
+		if err != nil {
+			slog.Error("Failed to recover timed out task", logging.TaskID(t.ID), logging.Err(err))
+			continue
+		}
+		switch outcome {
+		case database.TaskEventRequeued:
+			requeuedTasks++
+		case database.TaskEventFailed:
+			failedTasks++
 		}
 	}
 
 	// 3. Clean old rate limit records (older than 7 days)
-	rateLimitQuery := `
-		DELETE FROM rate_limits 
-		WHERE last_request < ?
-	`
-	rateLimitResult, err := h.db.Exec(rateLimitQuery, sevenDaysAgo)
-	var cleanedRateLimits int64 = 0
-	if err == nil {
-		cleanedRateLimits, _ = rateLimitResult.RowsAffected()
+	cleanedRateLimits, err := h.store.PruneRateLimits(ctx, sevenDaysAgo)
+	if err != nil {
+		slog.Error("Failed to prune rate limits", logging.Err(err))
 	}
 
 	// 4. Clean old processor metrics (older than 7 days)
-	metricsQuery := `
-		DELETE FROM processor_metrics 
-		WHERE last_updated < ?
-	`
-	_, _ = h.db.Exec(metricsQuery, sevenDaysAgo)
+	if _, err := h.store.PruneProcessorMetrics(ctx, sevenDaysAgo); err != nil {
+		slog.Error("Failed to prune processor metrics", logging.Err(err))
+	}
 
 	cleaned := map[string]interface{}{
 		"tasks":      cleanedTasks,
+		"archived":   archiving,
 		"timedout":   requeuedTasks,
 		"failed":     failedTasks,
 		"rateLimits": cleanedRateLimits,
@@ -761,45 +713,30 @@ func (h *InternalHandlers) performCleanup() (map[string]interface{}, map[string]
 	return stats, cleaned, nil
 }
 
-func (h *InternalHandlers) getCleanupStats() (map[string]interface{}, error) {
+func (h *InternalHandlers) getCleanupStats(ctx context.Context) (map[string]interface{}, error) {
 	now := time.Now().UnixMilli()
-	sevenDaysAgo := now - (7 * 24 * 60 * 60 * 1000)
 	fiveMinutesAgo := now - (5 * 60 * 1000)
 
 	// Get task statistics
-	taskStatsQuery := `
-		SELECT 
-			COUNT(*) as total_tasks,
-			COALESCE(SUM(CASE WHEN status = 'pending' THEN 1 ELSE 0 END), 0) as pending_tasks,
-			COALESCE(SUM(CASE WHEN status = 'processing' THEN 1 ELSE 0 END), 0) as processing_tasks,
-			COALESCE(SUM(CASE WHEN status = 'completed' THEN 1 ELSE 0 END), 0) as completed_tasks,
-			COALESCE(SUM(CASE WHEN status = 'failed' THEN 1 ELSE 0 END), 0) as failed_tasks,
-			COALESCE(SUM(CASE WHEN status IN ('completed', 'failed') AND completed_at < ? THEN 1 ELSE 0 END), 0) as tasks_older_than_7_days,
-			COALESCE(SUM(CASE WHEN status = 'processing' AND heartbeat_at < ? THEN 1 ELSE 0 END), 0) as timedout_tasks
-		FROM tasks
-	`
-
-	var totalTasks, pendingTasks, processingTasks, completedTasks, failedTasks, oldTasks, timedoutTasks int64
-	err := h.db.QueryRow(taskStatsQuery, sevenDaysAgo, fiveMinutesAgo).Scan(
-		&totalTasks, &pendingTasks, &processingTasks, &completedTasks, &failedTasks, &oldTasks, &timedoutTasks,
-	)
+	taskStats, err := h.store.GetTaskStats(ctx, h.retention(now), fiveMinutesAgo)
 	if err != nil {
 		return nil, err
 	}
 
 	// Get rate limit records count
-	rateLimitQuery := `SELECT COUNT(*) FROM rate_limits`
-	var rateLimitRecords int64
-	h.db.QueryRow(rateLimitQuery).Scan(&rateLimitRecords)
+	rateLimitRecords, err := h.store.CountRateLimitRecords(ctx)
+	if err != nil {
+		slog.Error("Failed to count rate limit records", logging.Err(err))
+	}
 
 	stats := map[string]interface{}{
-		"totalTasks":          totalTasks,
-		"pendingTasks":        pendingTasks,
-		"processingTasks":     processingTasks,
-		"completedTasks":      completedTasks,
-		"failedTasks":         failedTasks,
-		"tasksOlderThan7Days": oldTasks,
-		"timedoutTasks":       timedoutTasks,
+		"totalTasks":          taskStats.Total,
+		"pendingTasks":        taskStats.Pending,
+		"processingTasks":     taskStats.Processing,
+		"completedTasks":      taskStats.Completed,
+		"failedTasks":         taskStats.Failed,
+		"tasksOlderThan7Days": taskStats.Expired,
+		"timedoutTasks":       taskStats.TimedOut,
 		"rateLimitRecords":    rateLimitRecords,
 	}
 
@@ -824,6 +761,10 @@ func (h *InternalHandlers) WorkSteal(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !bindProcessorID(w, r, &req.ProcessorID) {
+		return
+	}
+
 	if req.ProcessorID == "" {
 		utils.SendError(w, http.StatusBadRequest, "processor_id is required")
 		return
@@ -839,11 +780,31 @@ func (h *InternalHandlers) WorkSteal(w http.ResponseWriter, r *http.Request) {
 		timeoutMs = *req.TimeoutMs
 	}
 
-	stolenTasks, err := h.stealTasksFromOverloadedProcessors(req.ProcessorID, maxStealCount, timeoutMs)
+	// Процессор не может забрать больше задач, чем позволяет его max_concurrency
+	maxConcurrency, activeTasks, err := h.store.GetProcessorCapacity(r.Context(), req.ProcessorID)
+	if err != nil {
+		utils.SendError(w, http.StatusInternalServerError, "Failed to get processor capacity")
+		return
+	}
+	if maxConcurrency > 0 && maxStealCount > maxConcurrency-activeTasks {
+		maxStealCount = maxConcurrency - activeTasks
+	}
+	if maxStealCount <= 0 {
+		utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+			"success":      true,
+			"stolen_tasks": []*database.Task{},
+			"stolen_count": 0,
+		})
+		return
+	}
+
+	stolenTasks, err := h.stealTasksFromOverloadedProcessors(r.Context(), req.ProcessorID, maxStealCount, timeoutMs)
 	if err != nil {
 		utils.SendError(w, http.StatusInternalServerError, "Failed to steal tasks")
 		return
 	}
+	metrics.TaskSteals.Add(float64(len(stolenTasks)))
+	linkTaskTraces(r.Context(), stolenTasks)
 
 	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
 		"success":      true,
@@ -852,104 +813,10 @@ func (h *InternalHandlers) WorkSteal(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-// stealTasksFromOverloadedProcessors implements work-stealing mechanism
-func (h *InternalHandlers) stealTasksFromOverloadedProcessors(stealerProcessorID string, maxStealCount int, timeoutMs int64) ([]*database.Task, error) {
-	now := time.Now().UnixMilli()
-	timeoutAt := now + timeoutMs
-
-	// Find stealable tasks from overloaded processors
-	selectQuery := `
-		WITH processor_loads AS (
-			SELECT 
-				processor_id,
-				COUNT(*) as active_tasks
-			FROM tasks 
-			WHERE status = 'processing' 
-			AND processor_id IS NOT NULL
-			GROUP BY processor_id
-			HAVING active_tasks > 5
-		)
-		SELECT 
-			t.id,
-			t.user_id,
-			t.product_data,
-			t.priority,
-			t.retry_count,
-			t.estimated_duration,
-			t.ollama_params
-		FROM tasks t
-		JOIN processor_loads pl ON t.processor_id = pl.processor_id
-		WHERE 
-			t.status = 'processing'
-			AND t.heartbeat_at < ? 
-			AND t.processor_id != ?
-		ORDER BY pl.active_tasks DESC, t.priority DESC
-		LIMIT ?
-	`
-
-	rows, err := h.db.Query(selectQuery, now-60000, stealerProcessorID, maxStealCount)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var stealableTasks []*database.Task
-	for rows.Next() {
-		task := &database.Task{}
-		var ollamaParamsJSON sql.NullString
-
-		err := rows.Scan(
-			&task.ID, &task.UserID, &task.ProductData, &task.Priority,
-			&task.RetryCount, &task.EstimatedDuration, &ollamaParamsJSON,
-		)
-		if err != nil {
-			continue
-		}
-
-		if ollamaParamsJSON.Valid && ollamaParamsJSON.String != "" {
-			task.OllamaParams = &ollamaParamsJSON.String
-		}
-
-		stealableTasks = append(stealableTasks, task)
-	}
-
-	if len(stealableTasks) == 0 {
-		return []*database.Task{}, nil
-	}
-
-	// Update the selected tasks to new processor
-	placeholders := make([]string, len(stealableTasks))
-	taskIDs := make([]interface{}, len(stealableTasks))
-
-	for i, task := range stealableTasks {
-		placeholders[i] = "?"
-		taskIDs[i] = task.ID
-	}
-
-	updateQuery := fmt.Sprintf(`
-		UPDATE tasks 
-		SET processor_id = ?,
-		    heartbeat_at = ?,
-		    timeout_at = ?,
-		    updated_at = ?
-		WHERE id IN (%s)
-	`, strings.Join(placeholders, ","))
-
-	args := append([]interface{}{stealerProcessorID, now, timeoutAt, now}, taskIDs...)
-	_, err = h.db.Exec(updateQuery, args...)
-	if err != nil {
-		return nil, err
-	}
-
-	// Update task objects
-	for _, task := range stealableTasks {
-		task.ProcessorID = &stealerProcessorID
-		task.HeartbeatAt = &now
-		task.TimeoutAt = &timeoutAt
-		task.UpdatedAt = now
-	}
-
-	return stealableTasks, nil
+// stealTasksFromOverloadedProcessors implements work-stealing mechanism: tasks of
+// overloaded processors without a heartbeat for a minute move to the stealer
+func (h *InternalHandlers) stealTasksFromOverloadedProcessors(ctx context.Context, stealerProcessorID string, maxStealCount int, timeoutMs int64) ([]*database.Task, error) {
+	return h.store.StealTasks(ctx, stealerProcessorID, maxStealCount, time.Now().UnixMilli()-60000, timeoutMs)
 }
 
 // GET /api/internal/metrics - Get processor metrics
@@ -959,7 +826,7 @@ func (h *InternalHandlers) ProcessorMetrics(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	metrics, err := h.getProcessorLoadMetrics()
+	metrics, err := h.getProcessorLoadMetrics(r.Context())
 	if err != nil {
 		utils.SendError(w, http.StatusInternalServerError, "Failed to get processor metrics")
 		return
@@ -978,7 +845,7 @@ func (h *InternalHandlers) EstimatedTime(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	estimatedTime, err := calculateEstimatedWaitTime(h.db)
+	estimatedTime, err := calculateEstimatedWaitTime(r.Context(), h.store)
 	if err != nil {
 		utils.SendError(w, http.StatusInternalServerError, "Failed to calculate estimated time")
 		return
@@ -991,56 +858,34 @@ func (h *InternalHandlers) EstimatedTime(w http.ResponseWriter, r *http.Request)
 }
 
 // getProcessorLoadMetrics returns metrics for intelligent task distribution
-func (h *InternalHandlers) getProcessorLoadMetrics() ([]map[string]interface{}, error) {
-	query := `
-		SELECT 
-			pm.processor_id,
-			pm.cpu_usage,
-			pm.memory_usage,
-			pm.queue_size,
-			pm.last_updated,
-			COUNT(t.id) as active_tasks,
-			COALESCE(AVG((julianday('now') - julianday(t.processing_started_at / 86400000, 'unixepoch')) * 86400), 0) as avg_processing_time
-		FROM processor_metrics pm
-		LEFT JOIN tasks t ON pm.processor_id = t.processor_id AND t.status = 'processing'
-		WHERE pm.last_updated > ? - 300000
-		GROUP BY pm.processor_id
-		ORDER BY 
-			(pm.cpu_usage * 0.3 + pm.memory_usage * 0.3 + COUNT(t.id) * 0.4) ASC
-	`
-
+func (h *InternalHandlers) getProcessorLoadMetrics(ctx context.Context) ([]map[string]interface{}, error) {
 	now := time.Now().UnixMilli()
-	rows, err := h.db.Query(query, now)
+	loads, err := h.store.GetProcessorLoads(ctx, now-300000)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	metrics := make([]map[string]interface{}, 0)
-	for rows.Next() {
-		var processorID string
-		var cpuUsage, memoryUsage sql.NullFloat64
-		var queueSize sql.NullInt64
-		var lastUpdated int64
-		var activeTasks int
-		var avgProcessingTime float64
-
-		err := rows.Scan(
-			&processorID, &cpuUsage, &memoryUsage, &queueSize,
-			&lastUpdated, &activeTasks, &avgProcessingTime,
-		)
-		if err != nil {
-			continue
-		}
 
+	metrics := make([]map[string]interface{}, 0, len(loads))
+	for _, load := range loads {
 		metric := map[string]interface{}{
-			"processor_id":        processorID,
-			"cpu_usage":           cpuUsage.Float64,
-			"memory_usage":        memoryUsage.Float64,
-			"queue_size":          queueSize.Int64,
-			"last_updated":        lastUpdated,
-			"active_tasks":        activeTasks,
-			"avg_processing_time": avgProcessingTime,
+			"processor_id":        load.ID,
+			"version":             load.Version,
+			"hostname":            load.Hostname,
+			"state":               load.State,
+			"max_concurrency":     load.MaxConcurrency,
+			"cpu_usage":           load.CPUUsage,
+			"memory_usage":        load.MemoryUsage,
+			"queue_size":          load.QueueSize,
+			"last_updated":        load.LastSeen,
+			"active_tasks":        load.ActiveTasks,
+			"avg_processing_time": load.AvgProcessingTime,
+			"saturation":          nil,
+			"available_slots":     nil,
+		}
+		// Для процессоров без лимита saturation и available_slots не определены
+		if load.MaxConcurrency > 0 {
+			metric["saturation"] = float64(load.ActiveTasks) / float64(load.MaxConcurrency)
+			metric["available_slots"] = max(0, load.MaxConcurrency-load.ActiveTasks)
 		}
 		metrics = append(metrics, metric)
 	}
@@ -1059,17 +904,29 @@ func (h *InternalHandlers) RequeueTask(w http.ResponseWriter, r *http.Request) {
 		utils.SendError(w, http.StatusBadRequest, "Invalid JSON")
 		return
 	}
+
+	if !bindProcessorID(w, r, &req.ProcessorID) {
+		return
+	}
 	if req.TaskID == "" || req.ProcessorID == "" {
 		utils.SendError(w, http.StatusBadRequest, "taskId and processor_id are required")
 		return
 	}
-	err := h.db.RequeueTask(req.TaskID, req.ProcessorID, &req.Reason)
+	requeued, err := h.store.RequeueTask(r.Context(), req.TaskID, req.ProcessorID, &req.Reason)
 	if err != nil {
 		utils.SendError(w, http.StatusInternalServerError, "Failed to requeue task")
 		return
 	}
 
-	log.Printf("Task %s requeued by processor %s with reason: %v\n", req.TaskID, req.ProcessorID, req.Reason)
+	slog.InfoContext(r.Context(), "Task requeue requested", logging.TaskID(req.TaskID), logging.ProcessorID(req.ProcessorID), "reason", req.Reason, "requeued", requeued)
+
+	if requeued {
+		metrics.TaskRequeues.Inc(metrics.SourceProcessor)
+		if err := h.store.LogTaskEvent(r.Context(), req.TaskID, database.TaskEventRequeued, req.ProcessorID, req.Reason); err != nil {
+			slog.ErrorContext(r.Context(), "Failed to log task event", logging.TaskID(req.TaskID), logging.Err(err))
+		}
+		notifyTaskRequeued(r.Context(), h.store, sseManagerInstance, req.TaskID, req.Reason)
+	}
 
 	utils.SendJSON(w, http.StatusOK, map[string]interface{}{"success": true})
 }
@@ -1088,7 +945,7 @@ func (h *InternalHandlers) GetRatingStats(w http.ResponseWriter, r *http.Request
 
 	if userID != "" {
 		// Get stats for specific user
-		userRatedTasks, err := h.db.GetUserRatedTasks(userID, nil, 100, 0)
+		userRatedTasks, err := h.store.GetUserRatedTasks(r.Context(), userID, nil, 100, 0)
 		if err != nil {
 			utils.SendError(w, http.StatusInternalServerError, "Failed to get user rated tasks")
 			return
@@ -1118,9 +975,9 @@ func (h *InternalHandlers) GetRatingStats(w http.ResponseWriter, r *http.Request
 		}
 	} else {
 		// Get global stats
-		stats, err := h.db.GetTasksRatingStats(nil)
+		stats, err := h.store.GetTasksRatingStats(r.Context(), nil)
 		if err != nil {
-			log.Printf("Failed to get global rating stats: %v", err)
+			slog.ErrorContext(r.Context(), "Failed to get global rating stats", logging.Err(err))
 			utils.SendError(w, http.StatusInternalServerError, "Failed to get global rating stats")
 			return
 		}
@@ -1153,9 +1010,9 @@ func (h *InternalHandlers) GetRatingAnalytics(w http.ResponseWriter, r *http.Req
 	}
 
 	// Get global stats
-	stats, err := h.db.GetTasksRatingStats(nil)
+	stats, err := h.store.GetTasksRatingStats(r.Context(), nil)
 	if err != nil {
-		log.Printf("Failed to get global rating stats: %v", err)
+		slog.ErrorContext(r.Context(), "Failed to get global rating stats", logging.Err(err))
 		utils.SendError(w, http.StatusInternalServerError, "Failed to get global rating stats")
 		return
 	}
@@ -1173,18 +1030,13 @@ func (h *InternalHandlers) GetRatingAnalytics(w http.ResponseWriter, r *http.Req
 		qualityScore = float64(upvotes-downvotes) / float64(totalRated) * 100
 	}
 
-	// Get total tasks for coverage calculation
-	allTasks, err := h.db.GetAllTasks(nil, 1000, 0)
-	if err != nil {
-		log.Printf("Failed to get all tasks: %v", err)
-		allTasks = []*database.Task{}
-	}
-
+	// Get completed tasks for coverage calculation
 	completedTasks := 0
-	for _, task := range allTasks {
-		if task.Status == "completed" {
-			completedTasks++
-		}
+	completed, err := h.store.ListTasks(r.Context(), database.TaskFilter{Statuses: []string{database.TaskStatusCompleted}}, nil, 0)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to count completed tasks", logging.Err(err))
+	} else {
+		completedTasks = completed.Total
 	}
 
 	var ratingCoverage float64
@@ -1193,22 +1045,22 @@ func (h *InternalHandlers) GetRatingAnalytics(w http.ResponseWriter, r *http.Req
 	}
 
 	// Get time-based analytics
-	dailyStats, err := h.db.GetRatingStatsByPeriod("day", 7)
+	dailyStats, err := h.store.GetRatingStatsByPeriod(r.Context(), "day", 7)
 	if err != nil {
-		log.Printf("Failed to get daily rating stats: %v", err)
+		slog.ErrorContext(r.Context(), "Failed to get daily rating stats", logging.Err(err))
 		dailyStats = []map[string]interface{}{}
 	}
 
-	hourlyStats, err := h.db.GetRatingStatsByPeriod("hour", 24)
+	hourlyStats, err := h.store.GetRatingStatsByPeriod(r.Context(), "hour", 24)
 	if err != nil {
-		log.Printf("Failed to get hourly rating stats: %v", err)
+		slog.ErrorContext(r.Context(), "Failed to get hourly rating stats", logging.Err(err))
 		hourlyStats = []map[string]interface{}{}
 	}
 
 	// Get recent ratings
-	recentRatedTasks, err := h.db.GetRecentRatedTasks(10)
+	recentRatedTasks, err := h.store.GetRecentRatedTasks(r.Context(), 10)
 	if err != nil {
-		log.Printf("Failed to get recent rated tasks: %v", err)
+		slog.ErrorContext(r.Context(), "Failed to get recent rated tasks", logging.Err(err))
 		recentRatedTasks = []*database.Task{}
 	}
 
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ad/go-llm-manager/internal/auth"
+	"github.com/ad/go-llm-manager/internal/config"
+	"github.com/ad/go-llm-manager/internal/database"
+)
+
+// Полный цикл задачи через обработчики поверх MemoryStore, без SQLite
+func TestHandlersWithMemoryStore(t *testing.T) {
+	store := database.NewMemoryStore()
+	jwtAuth := auth.NewJWTAuth("test")
+	cfg := &config.Config{RateLimit: config.RateLimitConfig{MaxRequests: 1, WindowMs: 60000}}
+	public := NewPublicHandlers(store, jwtAuth, cfg)
+	internal := NewInternalHandlers(store, jwtAuth)
+
+	post := func(handler http.HandlerFunc, path, token string, body interface{}) *httptest.ResponseRecorder {
+		t.Helper()
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(b))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+
+	rr := post(internal.GenerateToken, "/api/internal/generate-token", "", map[string]interface{}{
+		"user_id":       "u-1",
+		"product_data":  `{"title":"Смартфон Galaxy"}`,
+		"ollama_params": map[string]string{"model": "llama3"},
+	})
+	var minted struct {
+		Token string `json:"token"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &minted)
+	if rr.Code != http.StatusOK || minted.Token == "" {
+		t.Fatalf("generate token failed: %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr = post(public.CreateTask, "/api/create", minted.Token, nil)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create failed: %d %s", rr.Code, rr.Body.String())
+	}
+	var created struct {
+		TaskID string `json:"taskId"`
+		Token  string `json:"token"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &created)
+
+	rr = post(internal.ClaimTasks, "/api/internal/claim", "", map[string]interface{}{"processor_id": "p-1", "batch_size": 1})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("claim failed: %d %s", rr.Code, rr.Body.String())
+	}
+	rr = post(internal.CompleteTasks, "/api/internal/complete", "", map[string]interface{}{
+		"taskId": created.TaskID, "status": "completed", "result": "Отличный выбор",
+		"prompt_tokens": 12, "completion_tokens": 8,
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("complete failed: %d %s", rr.Code, rr.Body.String())
+	}
+
+	// Квота в 1 запрос учтена лимитером в MemoryStore
+	if rr := post(public.CreateTask, "/api/create", minted.Token, nil); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("expected 429 after the quota is used, got %d", rr.Code)
+	}
+
+	if rr := post(public.VoteTask, "/api/tasks/vote", created.Token, map[string]string{"vote_type": "upvote"}); rr.Code != http.StatusOK {
+		t.Fatalf("vote failed: %d %s", rr.Code, rr.Body.String())
+	}
+	if stats, _ := store.GetTasksRatingStats(t.Context(), nil); stats["upvote"] != 1 {
+		t.Errorf("expected the upvote stored, got %v", stats)
+	}
+
+	rr = httptest.NewRecorder()
+	internal.TokenUsage(rr, httptest.NewRequest(http.MethodGet, "/api/internal/token-usage?user_id=u-1", nil))
+	var usage struct {
+		Total database.UserTokenUsage `json:"total"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &usage)
+	if usage.Total.TotalTokens != 20 || usage.Total.TaskCount != 1 {
+		t.Errorf("unexpected token usage: %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	internal.SearchTasks(rr, httptest.NewRequest(http.MethodGet, "/api/internal/search?q=galaxy", nil))
+	var page database.TaskSearchPage
+	json.Unmarshal(rr.Body.Bytes(), &page)
+	if rr.Code != http.StatusOK || page.Total != 1 || page.Tasks[0].ID != created.TaskID || page.Tasks[0].UserRating == nil {
+		t.Errorf("unexpected search result: %d %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ad/go-llm-manager/internal/database"
+	"github.com/ad/go-llm-manager/internal/logging"
+	"github.com/ad/go-llm-manager/internal/metrics"
+	"github.com/ad/go-llm-manager/internal/sse"
+	"github.com/ad/go-llm-manager/internal/utils"
+)
+
+// Gauges are refreshed from the database on every scrape; concurrent scrapes must not interleave
+var metricsScrapeMu sync.Mutex
+
+// GET /metrics - Prometheus metrics in text exposition format
+func (h *InternalHandlers) PrometheusMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.SendError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	metricsScrapeMu.Lock()
+	defer metricsScrapeMu.Unlock()
+
+	collectGauges(r.Context(), h.store, sseManagerInstance)
+
+	w.Header().Set("Content-Type", metrics.ContentType)
+	if err := metrics.Default.Write(w); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to write metrics", logging.Err(err))
+	}
+}
+
+// collectGauges snapshots queue depth, processor load and SSE connections.
+// On database errors the previous values are kept.
+func collectGauges(ctx context.Context, store database.Store, manager *sse.Manager) {
+	if counts, err := store.CountTasksByStatusAndModel(ctx); err != nil {
+		slog.Error("Failed to count tasks for metrics", logging.Err(err))
+	} else {
+		metrics.Tasks.Reset()
+		// Пустые статусы экспортируются нулями, чтобы ряды не пропадали
+		for _, status := range []string{database.TaskStatusPending, database.TaskStatusProcessing, database.TaskStatusCompleted, database.TaskStatusFailed} {
+			metrics.Tasks.Set(0, status, "")
+		}
+		for _, c := range counts {
+			metrics.Tasks.Set(float64(c.Count), c.Status, c.Model)
+		}
+	}
+
+	// Как и /api/internal/metrics: процессоры, выходившие на связь за последние 5 минут
+	if loads, err := store.GetProcessorLoads(ctx, time.Now().Add(-5*time.Minute).UnixMilli()); err != nil {
+		slog.Error("Failed to get processor loads for metrics", logging.Err(err))
+	} else {
+		metrics.ProcessorActiveTasks.Reset()
+		metrics.ProcessorCPUUsage.Reset()
+		metrics.ProcessorMemoryUsage.Reset()
+		for _, load := range loads {
+			metrics.ProcessorActiveTasks.Set(float64(load.ActiveTasks), load.ID)
+			metrics.ProcessorCPUUsage.Set(load.CPUUsage, load.ID)
+			metrics.ProcessorMemoryUsage.Set(load.MemoryUsage, load.ID)
+		}
+	}
+
+	if manager != nil {
+		for clientType, n := range manager.ClientCounts() {
+			metrics.SSEClients.Set(float64(n), clientType)
+		}
+	}
+}
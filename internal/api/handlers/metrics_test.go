@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ad/go-llm-manager/internal/auth"
+	"github.com/ad/go-llm-manager/internal/database"
+	"github.com/ad/go-llm-manager/internal/metrics"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	db := database.NewTestDB(t)
+	h := NewInternalHandlers(db, auth.NewJWTAuth("test"))
+
+	model := "llama3"
+	for _, id := range []string{"m-1", "m-2"} {
+		task := &database.Task{ID: id, UserID: "u-" + id, ProductData: "p", Status: "pending"}
+		task.SetOllamaParams(&database.OllamaParams{Model: &model})
+		if err := db.CreateTask(t.Context(), task); err != nil {
+			t.Fatalf("create failed: %v", err)
+		}
+	}
+
+	claims := metrics.TaskClaims.Value()
+	queueWaits := metrics.TaskQueueWait.Count()
+	failures := metrics.TaskFailures.Value(metrics.SourceProcessor)
+
+	body, _ := json.Marshal(map[string]interface{}{"processor_id": "proc-m", "batch_size": 1})
+	rr := httptest.NewRecorder()
+	h.ClaimTasks(rr, httptest.NewRequest(http.MethodPost, "/api/internal/claim", bytes.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("claim failed: %d %s", rr.Code, rr.Body.String())
+	}
+
+	body, _ = json.Marshal(map[string]interface{}{"taskId": "m-1", "status": "failed", "error_message": "boom"})
+	rr = httptest.NewRecorder()
+	h.CompleteTasks(rr, httptest.NewRequest(http.MethodPost, "/api/internal/complete", bytes.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("complete failed: %d %s", rr.Code, rr.Body.String())
+	}
+
+	if metrics.TaskClaims.Value() != claims+1 || metrics.TaskQueueWait.Count() != queueWaits+1 {
+		t.Errorf("claim not counted")
+	}
+	if metrics.TaskFailures.Value(metrics.SourceProcessor) != failures+1 {
+		t.Errorf("failure not counted")
+	}
+
+	rr = httptest.NewRecorder()
+	h.PrometheusMetrics(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != metrics.ContentType {
+		t.Fatalf("unexpected response: %d %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+
+	out := rr.Body.String()
+	for _, line := range []string{
+		`llm_manager_tasks{status="failed",model="llama3"} 1`,
+		`llm_manager_tasks{status="pending",model="llama3"} 1`,
+		`llm_manager_tasks{status="processing",model=""} 0`,
+		`# TYPE llm_manager_task_processing_seconds histogram`,
+		`# TYPE llm_manager_db_queue_wait_seconds histogram`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, out)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ad/go-llm-manager/internal/config"
+	"github.com/ad/go-llm-manager/internal/database"
+	"github.com/ad/go-llm-manager/internal/logging"
+	"github.com/ad/go-llm-manager/internal/metrics"
+	"github.com/ad/go-llm-manager/internal/sse"
+)
+
+// ProcessorMonitor marks processors offline when their task-stream connection drops
+// or their heartbeats go stale, and immediately requeues the tasks they were holding
+type ProcessorMonitor struct {
+	store            database.Store
+	manager          *sse.Manager
+	heartbeatTimeout time.Duration
+	disconnectGrace  time.Duration
+	interval         time.Duration
+
+	lastRun  atomic.Int64 // unix ms последнего прохода, для readiness
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	// Таймеры отложенных отключений и их уже запущенные обработчики, которые ждет Stop
+	mu      sync.Mutex
+	pending map[*time.Timer]struct{}
+	running sync.WaitGroup
+}
+
+func NewProcessorMonitor(store database.Store, manager *sse.Manager, cfg config.ProcessorConfig) *ProcessorMonitor {
+	return &ProcessorMonitor{
+		store:            store,
+		manager:          manager,
+		heartbeatTimeout: cfg.HeartbeatTimeout,
+		disconnectGrace:  cfg.DisconnectGrace,
+		interval:         cfg.MonitorInterval,
+		stop:             make(chan struct{}),
+		pending:          make(map[*time.Timer]struct{}),
+	}
+}
+
+// Start runs the periodic stale heartbeat check in background
+func (m *ProcessorMonitor) Start() {
+	if m.interval <= 0 {
+		return
+	}
+	m.lastRun.Store(time.Now().UnixMilli())
+
+	go func() {
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				m.checkStaleProcessors(context.Background())
+				m.lastRun.Store(time.Now().UnixMilli())
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop halts the monitor, cancels pending disconnect checks and waits for the
+// running ones. Must be called before server shutdown so that dropped
+// task-stream connections are not treated as crashed processors.
+func (m *ProcessorMonitor) Stop() {
+	m.mu.Lock()
+	m.stopOnce.Do(func() { close(m.stop) })
+	for timer := range m.pending {
+		timer.Stop()
+	}
+	clear(m.pending)
+	m.mu.Unlock()
+
+	m.running.Wait()
+}
+
+// CheckAlive returns an error if the periodic check is stopped or has not run for
+// three intervals. A monitor with a disabled interval is always alive.
+func (m *ProcessorMonitor) CheckAlive() error {
+	if m.interval <= 0 {
+		return nil
+	}
+	if m.stopped() {
+		return errors.New("processor monitor stopped")
+	}
+
+	lastRun := m.lastRun.Load()
+	if lastRun == 0 {
+		return errors.New("processor monitor not started")
+	}
+	if since := time.Since(time.UnixMilli(lastRun)); since > 3*m.interval {
+		return fmt.Errorf("processor monitor last ran %s ago", since.Round(time.Second))
+	}
+	return nil
+}
+
+func (m *ProcessorMonitor) stopped() bool {
+	select {
+	case <-m.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// ProcessorDisconnected is called when a processor task-stream connection closes.
+// If the processor does not reconnect within the grace period it is marked offline.
+func (m *ProcessorMonitor) ProcessorDisconnected(processorID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stopped() {
+		return
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(m.disconnectGrace, func() {
+		m.mu.Lock()
+		if _, ok := m.pending[timer]; !ok {
+			// Stop уже отменил проверку
+			m.mu.Unlock()
+			return
+		}
+		delete(m.pending, timer)
+		m.running.Add(1)
+		m.mu.Unlock()
+		defer m.running.Done()
+
+		if m.manager.ProcessorConnected(processorID) {
+			return
+		}
+		m.markOffline(context.Background(), processorID, "task-stream disconnected")
+	})
+	m.pending[timer] = struct{}{}
+}
+
+func (m *ProcessorMonitor) checkStaleProcessors(ctx context.Context) {
+	// Процессоры с открытым task-stream считаются живыми
+	for _, processorID := range m.manager.ConnectedProcessors() {
+		if err := m.store.TouchProcessor(ctx, processorID, "", ""); err != nil {
+			slog.Error("Failed to touch connected processor", logging.ProcessorID(processorID), logging.Err(err))
+		}
+	}
+
+	before := time.Now().Add(-m.heartbeatTimeout).UnixMilli()
+	processors, err := m.store.GetStaleProcessors(ctx, before)
+	if err != nil {
+		slog.Error("Failed to get stale processors", logging.Err(err))
+		return
+	}
+
+	for _, p := range processors {
+		m.markOffline(ctx, p.ID, "heartbeat timeout")
+	}
+}
+
+// markOffline switches the processor to offline and recovers its processing tasks
+func (m *ProcessorMonitor) markOffline(ctx context.Context, processorID, reason string) {
+	changed, err := m.store.MarkProcessorOffline(ctx, processorID)
+	if err != nil {
+		slog.Error("Failed to mark processor offline", logging.ProcessorID(processorID), logging.Err(err))
+		return
+	}
+	if changed {
+		slog.Warn("Processor marked offline", logging.ProcessorID(processorID), "reason", reason)
+	}
+
+	tasks, err := m.store.GetProcessingTasksByProcessor(ctx, processorID)
+	if err != nil {
+		slog.Error("Failed to get processor tasks", logging.ProcessorID(processorID), logging.Err(err))
+		return
+	}
+
+	for _, task := range tasks {
+		_, err := recoverOrphanedTask(ctx, m.store, m.manager, task.ID, processorID, task.RetryCount, task.MaxRetries,
+			fmt.Sprintf("manager: processor offline (%s)", reason),
+			fmt.Sprintf("Task failed: processor offline (%s), max retries reached", reason),
+		)
+		if err != nil {
+			slog.Error("Failed to recover task of offline processor", logging.TaskID(task.ID), logging.ProcessorID(processorID), logging.Err(err))
+		}
+	}
+}
+
+// recoverOrphanedTask returns a task abandoned by its processor to the queue while it
+// has retries left, otherwise fails it. The transition is logged as a task event and
+// pushed to SSE clients of manager, which may be nil. Returns the event type, or ""
+// if the task was no longer processing on that processor.
+func recoverOrphanedTask(ctx context.Context, store database.TaskStore, manager *sse.Manager, taskID, processorID string, retryCount, maxRetries int, requeueReason, failMessage string) (string, error) {
+	if retryCount+1 < maxRetries {
+		requeued, err := store.RequeueTask(ctx, taskID, processorID, &requeueReason)
+		if err != nil || !requeued {
+			return "", err
+		}
+
+		slog.Warn("Task requeued", logging.TaskID(taskID), logging.ProcessorID(processorID), "retry", retryCount+1, "max_retries", maxRetries, "reason", requeueReason)
+		metrics.TaskRequeues.Inc(metrics.SourceManager)
+		if err := store.LogTaskEvent(ctx, taskID, database.TaskEventRequeued, processorID, requeueReason); err != nil {
+			slog.Error("Failed to log task event", logging.TaskID(taskID), logging.Err(err))
+		}
+		notifyTaskRequeued(ctx, store, manager, taskID, requeueReason)
+		return database.TaskEventRequeued, nil
+	}
+
+	failed, err := store.FailProcessingTask(ctx, taskID, processorID, failMessage)
+	if err != nil || !failed {
+		return "", err
+	}
+
+	slog.Warn("Task failed, no retries left", logging.TaskID(taskID), logging.ProcessorID(processorID), "retry", retryCount+1, "max_retries", maxRetries, "reason", failMessage)
+	metrics.TaskFailures.Inc(metrics.SourceManager)
+	if err := store.LogTaskEvent(ctx, taskID, database.TaskEventFailed, processorID, failMessage); err != nil {
+		slog.Error("Failed to log task event", logging.TaskID(taskID), logging.Err(err))
+	}
+	if task, err := store.GetTask(ctx, taskID); err == nil && task != nil {
+		finishTaskSpan(context.Background(), task)
+	}
+	if manager != nil {
+		manager.BroadcastToTask(taskID, sse.SSEEvent{
+			Type: sse.EventTaskFailed,
+			Data: map[string]interface{}{
+				"taskId": taskID,
+				"status": database.TaskStatusFailed,
+				"error":  failMessage,
+			},
+			Timestamp: time.Now().UnixMilli(),
+		})
+	}
+	return database.TaskEventFailed, nil
+}
+
+// notifyTaskRequeued tells users watching the task that it is back in the queue
+// and offers it to connected processors again
+func notifyTaskRequeued(ctx context.Context, store database.TaskStore, manager *sse.Manager, taskID, reason string) {
+	if manager == nil {
+		return
+	}
+
+	manager.BroadcastToTask(taskID, sse.SSEEvent{
+		Type: sse.EventTaskStatus,
+		Data: map[string]interface{}{
+			"taskId":   taskID,
+			"status":   database.TaskStatusPending,
+			"requeued": true,
+			"reason":   reason,
+		},
+		Timestamp: time.Now().UnixMilli(),
+	})
+
+	task, err := store.GetTask(ctx, taskID)
+	if err != nil {
+		slog.Error("Failed to get requeued task for broadcast", logging.TaskID(taskID), logging.Err(err))
+		return
+	}
+	manager.BroadcastPendingTaskToProcessors(task)
+}
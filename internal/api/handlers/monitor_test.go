@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ad/go-llm-manager/internal/config"
+	"github.com/ad/go-llm-manager/internal/database"
+	"github.com/ad/go-llm-manager/internal/sse"
+)
+
+func createProcessingTask(t *testing.T, db *database.DB, id, processorID string, retryCount, maxRetries int) {
+	t.Helper()
+	if err := db.CreateTask(t.Context(), &database.Task{ID: id, UserID: "u-" + id, ProductData: "p", Status: "pending"}); err != nil {
+		t.Fatalf("failed to insert %s: %v", id, err)
+	}
+	now := time.Now().UnixMilli()
+	_, err := db.Exec(`UPDATE tasks SET status='processing', processor_id=?, heartbeat_at=?, retry_count=?, max_retries=?, processing_started_at=? WHERE id=?`,
+		processorID, now, retryCount, maxRetries, now, id)
+	if err != nil {
+		t.Fatalf("failed to update %s: %v", id, err)
+	}
+}
+
+// claimTask creates a task and claims it for the processor through the store
+func claimTask(t *testing.T, store database.Store, id, processorID string) {
+	t.Helper()
+	if err := store.CreateTask(t.Context(), &database.Task{ID: id, UserID: "u-" + id, ProductData: "p", Status: "pending", MaxRetries: 3}); err != nil {
+		t.Fatalf("failed to create %s: %v", id, err)
+	}
+	if claimed, err := store.ClaimTasks(t.Context(), processorID, 1, 60000, 0); err != nil || len(claimed) != 1 {
+		t.Fatalf("failed to claim %s: %v", id, err)
+	}
+}
+
+func TestProcessorMonitor_StaleHeartbeat(t *testing.T) {
+	db := database.NewTestDB(t)
+	manager := sse.NewManager()
+	m := NewProcessorMonitor(db, manager, config.ProcessorConfig{HeartbeatTimeout: 90 * time.Second})
+
+	if err := db.TouchProcessor(t.Context(), "proc-dead", "", ""); err != nil {
+		t.Fatalf("touch failed: %v", err)
+	}
+	if err := db.TouchProcessor(t.Context(), "proc-alive", "", ""); err != nil {
+		t.Fatalf("touch failed: %v", err)
+	}
+	old := time.Now().Add(-2 * time.Minute).UnixMilli()
+	if _, err := db.Exec(`UPDATE processors SET last_seen = ?`, old); err != nil {
+		t.Fatalf("failed to age processors: %v", err)
+	}
+
+	// proc-alive держит открытый task-stream, поэтому не должен уйти в offline
+	client := sse.NewClient("c1", "proc-alive", "", httptest.NewRecorder(), nil)
+	manager.AddClient(client)
+	defer client.Close()
+
+	createProcessingTask(t, db, "t-requeue", "proc-dead", 0, 3)
+	createProcessingTask(t, db, "t-fail", "proc-dead", 2, 3)
+	createProcessingTask(t, db, "t-alive", "proc-alive", 0, 3)
+
+	m.checkStaleProcessors(t.Context())
+
+	dead, _ := db.GetProcessor(t.Context(), "proc-dead")
+	if dead == nil || dead.State != database.ProcessorStateOffline {
+		t.Fatalf("expected proc-dead offline, got %+v", dead)
+	}
+	alive, _ := db.GetProcessor(t.Context(), "proc-alive")
+	if alive == nil || alive.State != database.ProcessorStateOnline || alive.LastSeen <= old {
+		t.Fatalf("expected proc-alive online and touched, got %+v", alive)
+	}
+
+	requeued, _ := db.GetTask(t.Context(), "t-requeue")
+	if requeued.Status != database.TaskStatusPending || requeued.RetryCount != 1 || requeued.ProcessorID != nil {
+		t.Errorf("expected t-requeue pending with retry 1, got %+v", requeued)
+	}
+	failed, _ := db.GetTask(t.Context(), "t-fail")
+	if failed.Status != database.TaskStatusFailed || failed.ErrorMessage == nil {
+		t.Errorf("expected t-fail failed, got %+v", failed)
+	}
+	untouched, _ := db.GetTask(t.Context(), "t-alive")
+	if untouched.Status != database.TaskStatusProcessing {
+		t.Errorf("expected t-alive still processing, got %s", untouched.Status)
+	}
+
+	events, err := db.GetTaskEvents(t.Context(), "t-requeue")
+	if err != nil || len(events) != 1 || events[0].EventType != database.TaskEventRequeued {
+		t.Fatalf("expected one requeued event, got %+v (err=%v)", events, err)
+	}
+	if events[0].ProcessorID == nil || *events[0].ProcessorID != "proc-dead" {
+		t.Errorf("expected event processor proc-dead, got %+v", events[0])
+	}
+	events, _ = db.GetTaskEvents(t.Context(), "t-fail")
+	if len(events) != 1 || events[0].EventType != database.TaskEventFailed {
+		t.Errorf("expected one failed event, got %+v", events)
+	}
+}
+
+func TestProcessorMonitor_DisconnectRequeuesAndNotifies(t *testing.T) {
+	store := database.NewMemoryStore()
+	manager := sse.NewManager()
+
+	m := NewProcessorMonitor(store, manager, config.ProcessorConfig{
+		HeartbeatTimeout: 90 * time.Second,
+		DisconnectGrace:  20 * time.Millisecond,
+	})
+	defer m.Stop()
+
+	if err := store.TouchProcessor(t.Context(), "proc-1", "", ""); err != nil {
+		t.Fatalf("touch failed: %v", err)
+	}
+	claimTask(t, store, "t-1", "proc-1")
+
+	// Пользователь следит за задачей через result-polling
+	watcher := sse.NewClient("w1", "u-t-1", "t-1", httptest.NewRecorder(), nil)
+	manager.AddClient(watcher)
+	defer watcher.Close()
+
+	m.ProcessorDisconnected("proc-1")
+
+	select {
+	case event := <-watcher.Events:
+		if event.Type != sse.EventTaskStatus || event.Data["requeued"] != true || event.Data["status"] != database.TaskStatusPending {
+			t.Fatalf("unexpected event: %+v", event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for requeue notification")
+	}
+
+	p, _ := store.GetProcessor(t.Context(), "proc-1")
+	if p == nil || p.State != database.ProcessorStateOffline {
+		t.Errorf("expected proc-1 offline, got %+v", p)
+	}
+	task, _ := store.GetTask(t.Context(), "t-1")
+	if task.Status != database.TaskStatusPending {
+		t.Errorf("expected t-1 pending, got %s", task.Status)
+	}
+}
+
+func TestProcessorMonitor_ReconnectWithinGrace(t *testing.T) {
+	store := database.NewMemoryStore()
+	manager := sse.NewManager()
+	m := NewProcessorMonitor(store, manager, config.ProcessorConfig{
+		HeartbeatTimeout: 90 * time.Second,
+		DisconnectGrace:  20 * time.Millisecond,
+	})
+	defer m.Stop()
+
+	if err := store.TouchProcessor(t.Context(), "proc-1", "", ""); err != nil {
+		t.Fatalf("touch failed: %v", err)
+	}
+	claimTask(t, store, "t-1", "proc-1")
+
+	m.ProcessorDisconnected("proc-1")
+	client := sse.NewClient("c1", "proc-1", "", httptest.NewRecorder(), nil)
+	manager.AddClient(client)
+	defer client.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	p, _ := store.GetProcessor(t.Context(), "proc-1")
+	if p == nil || p.State != database.ProcessorStateOnline {
+		t.Errorf("expected proc-1 to stay online, got %+v", p)
+	}
+	task, _ := store.GetTask(t.Context(), "t-1")
+	if task.Status != database.TaskStatusProcessing {
+		t.Errorf("expected t-1 still processing, got %s", task.Status)
+	}
+}
+
+func TestProcessorMonitor_StopCancelsPendingDisconnect(t *testing.T) {
+	store := database.NewMemoryStore()
+	m := NewProcessorMonitor(store, sse.NewManager(), config.ProcessorConfig{
+		HeartbeatTimeout: 90 * time.Second,
+		DisconnectGrace:  20 * time.Millisecond,
+	})
+
+	if err := store.TouchProcessor(t.Context(), "proc-1", "", ""); err != nil {
+		t.Fatalf("touch failed: %v", err)
+	}
+	claimTask(t, store, "t-1", "proc-1")
+
+	m.ProcessorDisconnected("proc-1")
+	m.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	p, _ := store.GetProcessor(t.Context(), "proc-1")
+	if p == nil || p.State != database.ProcessorStateOnline {
+		t.Errorf("expected proc-1 to stay online after Stop, got %+v", p)
+	}
+	task, _ := store.GetTask(t.Context(), "t-1")
+	if task.Status != database.TaskStatusProcessing {
+		t.Errorf("expected t-1 still processing after Stop, got %s", task.Status)
+	}
+}
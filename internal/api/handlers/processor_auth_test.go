@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ad/go-llm-manager/internal/auth"
+	"github.com/ad/go-llm-manager/internal/database"
+)
+
+func TestProcessorTokenBindsIdentity(t *testing.T) {
+	db := database.NewTestDB(t)
+	h := NewInternalHandlers(db, auth.NewJWTAuth("test"))
+
+	if err := db.CreateTask(t.Context(), &database.Task{ID: "t-1", UserID: "u-1", ProductData: "p", Status: "pending"}); err != nil {
+		t.Fatalf("create task failed: %v", err)
+	}
+	createProcessingTask(t, db, "t-other", "proc-b", 0, 3)
+
+	post := func(handler http.HandlerFunc, processorID string, body map[string]interface{}) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data))
+		req = req.WithContext(auth.WithProcessorID(req.Context(), processorID))
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+
+	// Попытка выдать себя за другой процессор
+	rr := post(h.ClaimTasks, "proc-a", map[string]interface{}{"processor_id": "proc-b"})
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for impersonation, got %d", rr.Code)
+	}
+
+	// processor_id берётся из токена
+	rr = post(h.ClaimTasks, "proc-a", map[string]interface{}{"batch_size": 1})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	task, _ := db.GetTask(t.Context(), "t-1")
+	if task.ProcessorID == nil || *task.ProcessorID != "proc-a" {
+		t.Fatalf("expected t-1 claimed by proc-a, got %+v", task.ProcessorID)
+	}
+
+	// Нельзя завершить чужую задачу
+	rr = post(h.CompleteTasks, "proc-a", map[string]interface{}{"taskId": "t-other", "status": "completed", "result": "x"})
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for foreign task, got %d", rr.Code)
+	}
+	rr = post(h.CompleteTasks, "proc-a", map[string]interface{}{"taskId": "t-1", "status": "completed", "result": "ok"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 for own task, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = post(h.RequeueTask, "proc-a", map[string]interface{}{"taskId": "t-other", "processor_id": "proc-b"})
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for requeue as another processor, got %d", rr.Code)
+	}
+}
+
+func TestRevokeProcessorTokens(t *testing.T) {
+	db := database.NewTestDB(t)
+	jwtAuth := newTestJWTAuth(t, db)
+	h := NewInternalHandlers(db, jwtAuth)
+	processorAuth := auth.NewProcessorAuth(jwtAuth)
+
+	token, err := jwtAuth.GenerateToken(&database.JWTPayload{Subject: "proc-1", ProcessorID: "proc-1"}, 3600)
+	if err != nil {
+		t.Fatalf("generate token failed: %v", err)
+	}
+	if id, err := processorAuth.Authenticate(token); err != nil || id != "proc-1" {
+		t.Fatalf("expected valid token for proc-1, got %q, %v", id, err)
+	}
+
+	userToken, _ := jwtAuth.GenerateToken(&database.JWTPayload{Subject: "u-1", UserID: "u-1"}, 3600)
+	if _, err := processorAuth.Authenticate(userToken); !errors.Is(err, auth.ErrNotProcessorToken) {
+		t.Fatalf("expected ErrNotProcessorToken for user token, got %v", err)
+	}
+
+	// Отзыв действует на токены прошлых секунд
+	waitNextSecond()
+	body, _ := json.Marshal(map[string]string{"processor_id": "proc-1"})
+	rr := httptest.NewRecorder()
+	h.RevokeProcessorTokens(rr, httptest.NewRequest(http.MethodPost, "/api/internal/processors/revoke-tokens", bytes.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	if _, err := processorAuth.Authenticate(token); !errors.Is(err, auth.ErrTokenRevoked) {
+		t.Fatalf("expected ErrTokenRevoked, got %v", err)
+	}
+
+	// Токен, перевыпущенный сразу после отзыва, действителен
+	fresh, _ := jwtAuth.GenerateToken(&database.JWTPayload{Subject: "proc-1", ProcessorID: "proc-1"}, 3600)
+	if _, err := processorAuth.Authenticate(fresh); err != nil {
+		t.Fatalf("expected fresh token to be valid, got %v", err)
+	}
+}
+
+// waitNextSecond sleeps until the next wall-clock second, so that tokens minted
+// before it fall under a revocation cutoff taken after it
+func waitNextSecond() {
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+}
+
+func newTestJWTAuth(t *testing.T, db *database.DB) *auth.JWTAuth {
+	t.Helper()
+	jwtAuth := auth.NewJWTAuth("test")
+	denylist, err := auth.NewDenylist(t.Context(), db)
+	if err != nil {
+		t.Fatalf("failed to load denylist: %v", err)
+	}
+	jwtAuth.SetDenylist(denylist)
+	return jwtAuth
+}
+
+func TestRevokeTokens(t *testing.T) {
+	db := database.NewTestDB(t)
+	jwtAuth := newTestJWTAuth(t, db)
+	h := NewInternalHandlers(db, jwtAuth)
+
+	revoke := func(body map[string]interface{}) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		rr := httptest.NewRecorder()
+		h.RevokeTokens(rr, httptest.NewRequest(http.MethodPost, "/api/internal/tokens/revoke", bytes.NewReader(data)))
+		return rr
+	}
+
+	leaked, _ := jwtAuth.GenerateToken(&database.JWTPayload{Subject: "u-1", UserID: "u-1"}, 3600)
+	other := &database.JWTPayload{Subject: "u-1", UserID: "u-1"}
+	otherToken, _ := jwtAuth.GenerateToken(other, 3600)
+	userToken, _ := jwtAuth.GenerateToken(&database.JWTPayload{Subject: "u-2", UserID: "u-2"}, 3600)
+
+	if rr := revoke(map[string]interface{}{"jti": "x", "user_id": "u-1"}); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for several targets, got %d", rr.Code)
+	}
+
+	// Отзыв по самому токену и по jti
+	if rr := revoke(map[string]interface{}{"token": leaked}); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if _, err := jwtAuth.ExtractPayloadFromToken(leaked); !errors.Is(err, auth.ErrTokenRevoked) {
+		t.Fatalf("expected leaked token revoked, got %v", err)
+	}
+	if _, err := jwtAuth.ExtractPayloadFromToken(otherToken); err != nil {
+		t.Fatalf("expected other token of the same user to stay valid, got %v", err)
+	}
+	if rr := revoke(map[string]interface{}{"jti": other.ID}); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/get?token="+otherToken, nil)
+	if _, err := jwtAuth.ExtractPayload(req); !errors.Is(err, auth.ErrTokenRevoked) {
+		t.Fatalf("expected token revoked by jti, got %v", err)
+	}
+
+	// Отзыв всех токенов пользователя и перевыпуск
+	waitNextSecond()
+	if rr := revoke(map[string]interface{}{"user_id": "u-2"}); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if _, err := jwtAuth.ExtractPayloadFromToken(userToken); !errors.Is(err, auth.ErrTokenRevoked) {
+		t.Fatalf("expected user token revoked, got %v", err)
+	}
+	reissued, _ := jwtAuth.GenerateToken(&database.JWTPayload{Subject: "u-2", UserID: "u-2"}, 3600)
+	if _, err := jwtAuth.ExtractPayloadFromToken(reissued); err != nil {
+		t.Fatalf("expected token reissued right after revoke to be valid, got %v", err)
+	}
+
+	// Денилист переживает перезагрузку, записи истёкших токенов удаляются
+	reloaded, err := auth.NewDenylist(t.Context(), db)
+	if err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	if err := reloaded.Check(other); !errors.Is(err, auth.ErrTokenRevoked) {
+		t.Fatalf("expected revocation to persist, got %v", err)
+	}
+	if err := reloaded.RevokeToken(t.Context(), "expired", time.Now().Add(-time.Second).UnixMilli()); err != nil {
+		t.Fatalf("revoke failed: %v", err)
+	}
+	if deleted, err := reloaded.Prune(t.Context()); err != nil || deleted != 1 {
+		t.Fatalf("expected 1 pruned entry, got %d (err=%v)", deleted, err)
+	}
+}
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/ad/go-llm-manager/internal/database"
+	"github.com/ad/go-llm-manager/internal/logging"
+	"github.com/ad/go-llm-manager/internal/utils"
+)
+
+// /api/internal/processors - Processor registry CRUD
+func (h *InternalHandlers) Processors(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.getProcessors(w, r)
+	case http.MethodPost:
+		h.registerProcessor(w, r)
+	case http.MethodPut, http.MethodPatch:
+		h.updateProcessor(w, r)
+	case http.MethodDelete:
+		h.deleteProcessor(w, r)
+	default:
+		utils.SendError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// GET /api/internal/processors?state=online - List processors
+// GET /api/internal/processors?id=proc-1 - Get single processor
+func (h *InternalHandlers) getProcessors(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	if id := query.Get("id"); id != "" {
+		processor, err := h.store.GetProcessor(r.Context(), id)
+		if err != nil {
+			utils.SendError(w, http.StatusInternalServerError, "Failed to get processor")
+			return
+		}
+		if processor == nil {
+			utils.SendError(w, http.StatusNotFound, "Processor not found")
+			return
+		}
+
+		utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+			"success":   true,
+			"processor": processor,
+		})
+		return
+	}
+
+	state := query.Get("state")
+	if state != "" && !database.IsValidProcessorState(state) {
+		utils.SendError(w, http.StatusBadRequest, "state must be 'online', 'draining' or 'offline'")
+		return
+	}
+
+	processors, err := h.store.ListProcessors(r.Context(), state)
+	if err != nil {
+		utils.SendError(w, http.StatusInternalServerError, "Failed to list processors")
+		return
+	}
+
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+		"success":    true,
+		"processors": processors,
+	})
+}
+
+// POST /api/internal/processors - Register processor (or replace its attributes)
+func (h *InternalHandlers) registerProcessor(w http.ResponseWriter, r *http.Request) {
+	var req database.Processor
+	if err := utils.ParseJSON(r, &req); err != nil {
+		utils.SendError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	if req.ID == "" {
+		utils.SendError(w, http.StatusBadRequest, "id is required")
+		return
+	}
+	if req.State != "" && !database.IsValidProcessorState(req.State) {
+		utils.SendError(w, http.StatusBadRequest, "state must be 'online', 'draining' or 'offline'")
+		return
+	}
+	if req.MaxConcurrency < 0 {
+		utils.SendError(w, http.StatusBadRequest, "max_concurrency must be >= 0")
+		return
+	}
+
+	if err := h.store.UpsertProcessor(r.Context(), &req); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to register processor", logging.ProcessorID(req.ID), logging.Err(err))
+		utils.SendError(w, http.StatusInternalServerError, "Failed to register processor")
+		return
+	}
+
+	processor, err := h.store.GetProcessor(r.Context(), req.ID)
+	if err != nil || processor == nil {
+		utils.SendError(w, http.StatusInternalServerError, "Failed to get processor")
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Processor registered", logging.ProcessorID(processor.ID),
+		"version", processor.Version, "hostname", processor.Hostname, "max_concurrency", processor.MaxConcurrency)
+
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+		"success":   true,
+		"processor": processor,
+	})
+}
+
+// PUT /api/internal/processors - Partially update processor (e.g. state: "draining")
+func (h *InternalHandlers) updateProcessor(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID string `json:"id"`
+		database.ProcessorUpdate
+	}
+	if err := utils.ParseJSON(r, &req); err != nil {
+		utils.SendError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	if req.ID == "" {
+		utils.SendError(w, http.StatusBadRequest, "id is required")
+		return
+	}
+	if req.State != nil && !database.IsValidProcessorState(*req.State) {
+		utils.SendError(w, http.StatusBadRequest, "state must be 'online', 'draining' or 'offline'")
+		return
+	}
+	if req.MaxConcurrency != nil && *req.MaxConcurrency < 0 {
+		utils.SendError(w, http.StatusBadRequest, "max_concurrency must be >= 0")
+		return
+	}
+
+	if err := h.store.UpdateProcessor(r.Context(), req.ID, &req.ProcessorUpdate); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			utils.SendError(w, http.StatusNotFound, "Processor not found")
+			return
+		}
+		utils.SendError(w, http.StatusInternalServerError, "Failed to update processor")
+		return
+	}
+
+	processor, err := h.store.GetProcessor(r.Context(), req.ID)
+	if err != nil || processor == nil {
+		utils.SendError(w, http.StatusInternalServerError, "Failed to get processor")
+		return
+	}
+
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+		"success":   true,
+		"processor": processor,
+	})
+}
+
+// DELETE /api/internal/processors?id=proc-1 - Remove processor from registry
+func (h *InternalHandlers) deleteProcessor(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		utils.SendError(w, http.StatusBadRequest, "id is required")
+		return
+	}
+
+	deleted, err := h.store.DeleteProcessor(r.Context(), id)
+	if err != nil {
+		utils.SendError(w, http.StatusInternalServerError, "Failed to delete processor")
+		return
+	}
+	if !deleted {
+		utils.SendError(w, http.StatusNotFound, "Processor not found")
+		return
+	}
+
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{"success": true})
+}
+
+// POST /api/internal/processors/revoke-tokens - Revoke all tokens issued to a processor
+func (h *InternalHandlers) RevokeProcessorTokens(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.SendError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req struct {
+		ProcessorID string `json:"processor_id"`
+	}
+	if err := utils.ParseJSON(r, &req); err != nil {
+		utils.SendError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	if req.ProcessorID == "" {
+		utils.SendError(w, http.StatusBadRequest, "processor_id is required")
+		return
+	}
+
+	denylist := h.jwtAuth.Denylist()
+	if denylist == nil {
+		utils.SendError(w, http.StatusServiceUnavailable, "Token revocation is not enabled")
+		return
+	}
+
+	notBefore, disconnected, err := revokeProcessorTokens(r.Context(), denylist, req.ProcessorID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to revoke processor tokens", logging.ProcessorID(req.ProcessorID), logging.Err(err))
+		utils.SendError(w, http.StatusInternalServerError, "Failed to revoke tokens")
+		return
+	}
+
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+		"success":      true,
+		"processor_id": req.ProcessorID,
+		"not_before":   notBefore,
+		"disconnected": disconnected,
+	})
+}
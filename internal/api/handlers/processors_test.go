@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ad/go-llm-manager/internal/auth"
+	"github.com/ad/go-llm-manager/internal/database"
+)
+
+func TestProcessorsCRUD(t *testing.T) {
+	db := database.NewTestDB(t)
+	h := NewInternalHandlers(db, auth.NewJWTAuth("test"))
+
+	do := func(method, target string, body interface{}) *httptest.ResponseRecorder {
+		var reader *bytes.Reader
+		if body != nil {
+			b, _ := json.Marshal(body)
+			reader = bytes.NewReader(b)
+		} else {
+			reader = bytes.NewReader(nil)
+		}
+		req := httptest.NewRequest(method, target, reader)
+		rr := httptest.NewRecorder()
+		h.Processors(rr, req)
+		return rr
+	}
+
+	// Регистрация
+	rr := do(http.MethodPost, "/api/internal/processors", map[string]interface{}{
+		"id":               "proc-1",
+		"version":          "1.2.3",
+		"hostname":         "gpu-host-1",
+		"labels":           map[string]string{"gpu": "a100"},
+		"supported_models": []string{"llama3", "qwen"},
+		"max_concurrency":  4,
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("register: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var resp struct {
+		Success   bool                `json:"success"`
+		Processor *database.Processor `json:"processor"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	p := resp.Processor
+	if p == nil || p.State != database.ProcessorStateOnline || p.MaxConcurrency != 4 ||
+		p.Labels["gpu"] != "a100" || len(p.SupportedModels) != 2 || p.FirstSeen == 0 {
+		t.Fatalf("unexpected processor after register: %+v", p)
+	}
+
+	// Частичное обновление: перевод в draining
+	rr = do(http.MethodPut, "/api/internal/processors", map[string]interface{}{
+		"id":    "proc-1",
+		"state": "draining",
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("update: expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Heartbeat не должен снимать draining
+	if err := db.TouchProcessor(t.Context(), "proc-1", "1.2.4", ""); err != nil {
+		t.Fatalf("touch failed: %v", err)
+	}
+	got, err := db.GetProcessor(t.Context(), "proc-1")
+	if err != nil || got == nil {
+		t.Fatalf("get processor failed: %v", err)
+	}
+	if got.State != database.ProcessorStateDraining {
+		t.Errorf("expected state draining after heartbeat, got %s", got.State)
+	}
+	if got.Version != "1.2.4" || got.Hostname != "gpu-host-1" {
+		t.Errorf("expected version updated and hostname kept, got %+v", got)
+	}
+
+	// Невалидное состояние
+	rr = do(http.MethodPut, "/api/internal/processors", map[string]interface{}{
+		"id":    "proc-1",
+		"state": "sleeping",
+	})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for invalid state, got %d", rr.Code)
+	}
+
+	// Неизвестный процессор
+	rr = do(http.MethodPut, "/api/internal/processors", map[string]interface{}{
+		"id":    "missing",
+		"state": "offline",
+	})
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown processor, got %d", rr.Code)
+	}
+
+	// Список с фильтром
+	rr = do(http.MethodGet, "/api/internal/processors?state=draining", nil)
+	var list struct {
+		Processors []*database.Processor `json:"processors"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&list); err != nil {
+		t.Fatalf("failed to decode list: %v", err)
+	}
+	if len(list.Processors) != 1 || list.Processors[0].ID != "proc-1" {
+		t.Errorf("expected one draining processor, got %+v", list.Processors)
+	}
+
+	// Удаление
+	rr = do(http.MethodDelete, "/api/internal/processors?id=proc-1", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("delete: expected 200, got %d", rr.Code)
+	}
+	rr = do(http.MethodGet, "/api/internal/processors?id=proc-1", nil)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 after delete, got %d", rr.Code)
+	}
+}
+
+func TestProcessorHeartbeatRegistersProcessor(t *testing.T) {
+	db := database.NewTestDB(t)
+	h := NewInternalHandlers(db, auth.NewJWTAuth("test"))
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"processor_id": "proc-hb",
+		"cpu_usage":    12.5,
+		"version":      "0.9.0",
+		"hostname":     "worker-7",
+	})
+	req := httptest.NewRequest(http.MethodPost, "/api/internal/processor-heartbeat", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	h.ProcessorHeartbeat(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	p, err := db.GetProcessor(t.Context(), "proc-hb")
+	if err != nil || p == nil {
+		t.Fatalf("processor was not registered by heartbeat: %v", err)
+	}
+	if p.State != database.ProcessorStateOnline || p.Version != "0.9.0" || p.Hostname != "worker-7" {
+		t.Errorf("unexpected processor: %+v", p)
+	}
+
+	// Метрики теперь строятся по реестру процессоров
+	metrics, err := h.getProcessorLoadMetrics(t.Context())
+	if err != nil {
+		t.Fatalf("getProcessorLoadMetrics failed: %v", err)
+	}
+	if len(metrics) != 1 || metrics[0]["processor_id"] != "proc-hb" || metrics[0]["cpu_usage"] != 12.5 {
+		t.Errorf("unexpected metrics: %+v", metrics)
+	}
+
+	// Offline процессоры не участвуют в оценке нагрузки
+	offline := database.ProcessorStateOffline
+	if err := db.UpdateProcessor(t.Context(), "proc-hb", &database.ProcessorUpdate{State: &offline}); err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	metrics, err = h.getProcessorLoadMetrics(t.Context())
+	if err != nil {
+		t.Fatalf("getProcessorLoadMetrics failed: %v", err)
+	}
+	if len(metrics) != 0 {
+		t.Errorf("expected no metrics for offline processor, got %+v", metrics)
+	}
+}
+
+func TestClaimTasksRespectsMaxConcurrency(t *testing.T) {
+	db := database.NewTestDB(t)
+	h := NewInternalHandlers(db, auth.NewJWTAuth("test"))
+
+	if err := db.UpsertProcessor(t.Context(), &database.Processor{ID: "proc-cap", MaxConcurrency: 3}); err != nil {
+		t.Fatalf("register failed: %v", err)
+	}
+	for i := 0; i < 6; i++ {
+		task := &database.Task{ID: fmt.Sprintf("cap-%d", i), UserID: fmt.Sprintf("u-%d", i), ProductData: "p", Status: "pending"}
+		if err := db.CreateTask(t.Context(), task); err != nil {
+			t.Fatalf("create task failed: %v", err)
+		}
+	}
+	// Одна задача уже в работе у процессора
+	createProcessingTask(t, db, "cap-busy", "proc-cap", 0, 3)
+
+	claim := func(fair bool) map[string]interface{} {
+		body, _ := json.Marshal(map[string]interface{}{
+			"processor_id":          "proc-cap",
+			"batch_size":            5,
+			"use_fair_distribution": fair,
+		})
+		req := httptest.NewRequest(http.MethodPost, "/api/internal/claim", bytes.NewReader(body))
+		rr := httptest.NewRecorder()
+		h.ClaimTasks(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("claim: expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var resp map[string]interface{}
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		return resp
+	}
+
+	resp := claim(false)
+	if resp["claimed_count"] != float64(2) || resp["available_slots"] != float64(0) {
+		t.Fatalf("expected 2 claimed and 0 slots left, got %+v", resp)
+	}
+
+	resp = claim(true)
+	if resp["claimed_count"] != float64(0) {
+		t.Fatalf("expected saturated processor to claim nothing, got %+v", resp)
+	}
+
+	metrics, err := h.getProcessorLoadMetrics(t.Context())
+	if err != nil || len(metrics) != 1 {
+		t.Fatalf("unexpected metrics: %+v (err=%v)", metrics, err)
+	}
+	if metrics[0]["saturation"] != 1.0 || metrics[0]["available_slots"] != 0 {
+		t.Errorf("expected full saturation, got %+v", metrics[0])
+	}
+
+	// Процессор без лимита не ограничен
+	resp = func() map[string]interface{} {
+		body, _ := json.Marshal(map[string]interface{}{"processor_id": "proc-free", "batch_size": 5})
+		req := httptest.NewRequest(http.MethodPost, "/api/internal/claim", bytes.NewReader(body))
+		rr := httptest.NewRecorder()
+		h.ClaimTasks(rr, req)
+		var resp map[string]interface{}
+		_ = json.NewDecoder(rr.Body).Decode(&resp)
+		return resp
+	}()
+	if resp["claimed_count"] != float64(4) {
+		t.Errorf("expected unlimited processor to claim remaining 4 tasks, got %+v", resp)
+	}
+	if _, ok := resp["available_slots"]; ok {
+		t.Errorf("expected no available_slots for unlimited processor, got %+v", resp)
+	}
+}
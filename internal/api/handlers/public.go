@@ -1,31 +1,41 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"log/slog"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/ad/go-llm-manager/internal/auth"
 	"github.com/ad/go-llm-manager/internal/config"
 	"github.com/ad/go-llm-manager/internal/database"
+	"github.com/ad/go-llm-manager/internal/logging"
+	"github.com/ad/go-llm-manager/internal/ratelimit"
 	"github.com/ad/go-llm-manager/internal/sse"
+	"github.com/ad/go-llm-manager/internal/tracing"
 	"github.com/ad/go-llm-manager/internal/utils"
 
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type PublicHandlers struct {
-	db      *database.DB
+	store   database.Store
 	jwtAuth *auth.JWTAuth
 	config  *config.Config
+	limiter *ratelimit.Limiter
 }
 
-func NewPublicHandlers(db *database.DB, jwtAuth *auth.JWTAuth, cfg *config.Config) *PublicHandlers {
+func NewPublicHandlers(store database.Store, jwtAuth *auth.JWTAuth, cfg *config.Config) *PublicHandlers {
 	return &PublicHandlers{
-		db:      db,
+		store:   store,
 		jwtAuth: jwtAuth,
 		config:  cfg,
+		limiter: ratelimit.NewLimiter(store),
 	}
 }
 
@@ -57,6 +67,56 @@ func (h *PublicHandlers) Health(w http.ResponseWriter, r *http.Request) {
 	h.HealthCheck(w, r)
 }
 
+// GET /.well-known/jwks.json - Public keys for verifying issued JWTs (HS256 keys are not published)
+func (h *PublicHandlers) JWKS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.SendError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	utils.SendJSON(w, http.StatusOK, h.jwtAuth.JWKS())
+}
+
+// Лимит тела /api/create, если MAX_PRODUCT_DATA_BYTES не задан
+const defaultMaxProductDataBytes = 1 << 20
+
+func (h *PublicHandlers) maxProductDataBytes() int64 {
+	if h.config != nil && h.config.Server.MaxProductDataBytes > 0 {
+		return h.config.Server.MaxProductDataBytes
+	}
+	return defaultMaxProductDataBytes
+}
+
+// rateLimitPolicy returns the configured task quota with the token rate_limit claim applied
+func (h *PublicHandlers) rateLimitPolicy(payload *database.JWTPayload) ratelimit.Policy {
+	policy := ratelimit.DefaultPolicy
+	if h.config != nil {
+		if h.config.RateLimit.Algorithm != "" {
+			policy.Algorithm = h.config.RateLimit.Algorithm
+		}
+		if h.config.RateLimit.MaxRequests > 0 {
+			policy.Limit = h.config.RateLimit.MaxRequests
+		}
+		if h.config.RateLimit.WindowMs > 0 {
+			policy.Window = time.Duration(h.config.RateLimit.WindowMs) * time.Millisecond
+		}
+	}
+	return policy.Override(payload.RateLimit)
+}
+
+// setRateLimitHeaders reports the quota state; Retry-After is set only when it is exhausted
+func setRateLimitHeaders(w http.ResponseWriter, status ratelimit.Status) {
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(status.Limit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(status.Remaining))
+	w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(int64(math.Ceil(float64(status.Reset.UnixMilli())/1000)), 10))
+	if status.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(status.RetryAfter.Seconds()))))
+	} else {
+		w.Header().Del("Retry-After")
+	}
+}
+
 // POST /api/create - Create new task (JWT auth required)
 func (h *PublicHandlers) CreateTask(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -81,33 +141,67 @@ func (h *PublicHandlers) CreateTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if payload.ProductData == "" {
-		utils.SendError(w, http.StatusBadRequest, "Missing product_data in request body or JWT token")
-		return
+	// product_data либо в токене, либо в теле запроса, привязанном к токену хешем
+	productData := payload.ProductData
+	if payload.ProductDataSHA256 != "" {
+		var body struct {
+			ProductData string `json:"product_data"`
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, h.maxProductDataBytes())
+		if err := utils.ParseJSON(r, &body); err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				utils.SendError(w, http.StatusRequestEntityTooLarge, "Request body too large")
+				return
+			}
+			utils.SendError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		if body.ProductData == "" {
+			utils.SendError(w, http.StatusBadRequest, "Missing product_data in request body")
+			return
+		}
+		if !auth.VerifyProductDataHash(body.ProductData, payload.ProductDataSHA256) {
+			utils.SendError(w, http.StatusForbidden, "product_data does not match token hash")
+			return
+		}
+		productData = body.ProductData
 	}
 
-	// Check rate limit - use custom limits from JWT payload if provided
-	windowMs := int64(86400000) // 24h default
-	maxRequests := 100          // 100 requests default
-
-	if payload.RateLimit != nil {
-		windowMs = payload.RateLimit.WindowMs
-		maxRequests = payload.RateLimit.MaxRequests
+	if productData == "" {
+		utils.SendError(w, http.StatusBadRequest, "Missing product_data in request body or JWT token")
+		return
 	}
 
-	rateLimit, err := h.db.CheckRateLimit(userID, windowMs, maxRequests)
+	// Квота списывается только за реально созданные задачи: здесь лишь проверка
+	policy := h.rateLimitPolicy(payload)
+	rateStatus, err := h.limiter.Peek(r.Context(), userID, policy)
 	if err != nil {
-		log.Printf("Failed to check rate limit for user %s (window: %d ms, max: %d): %v", userID, windowMs, maxRequests, err)
+		slog.ErrorContext(r.Context(), "Failed to check rate limit", logging.UserID(userID), "algorithm", policy.Algorithm, "window", policy.Window, "limit", policy.Limit, logging.Err(err))
 		utils.SendError(w, http.StatusInternalServerError, "Failed to check rate limit")
 		return
 	}
+	setRateLimitHeaders(w, rateStatus)
 
-	if rateLimit.RequestCount > maxRequests {
-		log.Printf("Rate limit exceeded for user %s: %d requests in %d ms", userID, rateLimit.RequestCount, windowMs)
+	if !rateStatus.Allowed {
+		slog.WarnContext(r.Context(), "Rate limit exceeded", logging.UserID(userID), "limit", policy.Limit, "window", policy.Window, "algorithm", policy.Algorithm)
 		utils.SendError(w, http.StatusTooManyRequests, "Rate limit exceeded")
 		return
 	}
 
+	// Лимит токенов LLM за окно: новая задача не создаётся, пока расход не опустится ниже лимита
+	usedTokens, tokenLimit, err := h.tokenUsage(r.Context(), userID, payload)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to check token usage", logging.UserID(userID), logging.Err(err))
+		utils.SendError(w, http.StatusInternalServerError, "Failed to check token quota")
+		return
+	}
+	if tokenLimit != nil && usedTokens >= tokenLimit.MaxTokens {
+		slog.WarnContext(r.Context(), "Token quota exceeded", logging.UserID(userID), "used_tokens", usedTokens, "max_tokens", tokenLimit.MaxTokens, "window_ms", tokenLimit.WindowMs)
+		utils.SendError(w, http.StatusTooManyRequests, "Token quota exceeded")
+		return
+	}
+
 	taskID := uuid.New().String()
 	priority := 0
 	if payload.Priority != nil {
@@ -118,7 +212,7 @@ func (h *PublicHandlers) CreateTask(w http.ResponseWriter, r *http.Request) {
 	task := &database.Task{
 		ID:          taskID,
 		UserID:      userID,
-		ProductData: payload.ProductData,
+		ProductData: productData,
 		Status:      "pending",
 		Priority:    priority,
 		MaxRetries:  3,
@@ -132,8 +226,14 @@ func (h *PublicHandlers) CreateTask(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	if err := h.db.CreateTask(task); err != nil {
-		if err.Error() == "user already has an active task" {
+	// Трейс запроса сохраняется в задаче: процессоры получают его при claim и продолжают
+	trace.SpanFromContext(r.Context()).SetAttributes(attrTaskID.String(taskID))
+	if traceParent := tracing.TraceParent(r.Context()); traceParent != "" {
+		task.TraceParent = &traceParent
+	}
+
+	if err := h.store.CreateTask(r.Context(), task); err != nil {
+		if errors.Is(err, database.ErrActiveTask) {
 			utils.SendError(w, http.StatusConflict, "User already has an active task. Please wait for the current task to complete.")
 			return
 		}
@@ -141,13 +241,19 @@ func (h *PublicHandlers) CreateTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if charged, err := h.limiter.Charge(context.WithoutCancel(r.Context()), userID, policy); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to charge rate limit", logging.UserID(userID), logging.Err(err))
+	} else {
+		setRateLimitHeaders(w, charged)
+	}
+
 	// Оповещение процессоров через SSE о новой задаче
 	if sseManagerInstance != nil {
 		sseManagerInstance.BroadcastPendingTaskToProcessors(task)
 	}
 
 	// Calculate estimated wait time (human-readable format like TypeScript)
-	estimatedTime, err := calculateEstimatedWaitTime(h.db)
+	estimatedTime, err := calculateEstimatedWaitTime(r.Context(), h.store)
 	if err != nil {
 		utils.SendError(w, http.StatusInternalServerError, "Failed to calculate estimated time")
 		return
@@ -207,7 +313,7 @@ func (h *PublicHandlers) GetResult(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get task
-	task, err := h.db.GetTask(payload.TaskID)
+	task, err := h.store.GetTask(r.Context(), payload.TaskID)
 	if err != nil {
 		utils.SendError(w, http.StatusNotFound, "Task not found")
 		return
@@ -267,33 +373,55 @@ func (h *PublicHandlers) GetUserData(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get user's latest task
-	latestTask, err := h.db.GetUserLatestTask(userID)
+	latestTask, err := h.store.GetUserLatestTask(r.Context(), userID)
 	if err != nil {
 		utils.SendError(w, http.StatusInternalServerError, "Failed to get latest task")
 		return
 	}
 
 	// Get user's rate limits
-	rateLimit, err := h.db.GetUserRateLimit(userID)
+	policy := h.rateLimitPolicy(payload)
+	rateStatus, err := h.limiter.Peek(r.Context(), userID, policy)
 	if err != nil {
 		utils.SendError(w, http.StatusInternalServerError, "Failed to get rate limits")
 		return
 	}
+	periodStart := rateStatus.Reset.Add(-policy.Window)
+
+	usedTokens, tokenLimit, err := h.tokenUsage(r.Context(), userID, payload)
+	if err != nil {
+		utils.SendError(w, http.StatusInternalServerError, "Failed to get token usage")
+		return
+	}
+	tokenUsage, err := h.store.GetUserTokenUsage(r.Context(), userID)
+	if err != nil {
+		utils.SendError(w, http.StatusInternalServerError, "Failed to get token usage")
+		return
+	}
+	var maxTokens int64 // 0 = без ограничения
+	tokenWindowMs := int64(defaultTokenLimitWindowMs)
+	if tokenLimit != nil {
+		maxTokens, tokenWindowMs = tokenLimit.MaxTokens, tokenLimit.WindowMs
+	}
 
 	// Prepare response
 	data := map[string]interface{}{
 		"success": true,
 		"user_id": userID,
 		"rate_limit": map[string]interface{}{
-			"request_count": rateLimit.RequestCount,
-			"request_limit": h.config.RateLimit.MaxRequests,
-			"window_start":  rateLimit.WindowStart,
-			"last_request":  rateLimit.LastRequest,
-			"period_start":  time.Unix(0, rateLimit.WindowStart*int64(time.Millisecond)).Format(time.RFC3339),
-			"period_end":    time.Unix(0, (rateLimit.WindowStart+h.config.RateLimit.WindowMs)*int64(time.Millisecond)).Format(time.RFC3339),
-			// "token_count":   0,     // TODO: implement token counting
-			// "token_limit":   10000, // TODO: get from config
+			"algorithm":       policy.Algorithm,
+			"request_count":   rateStatus.Limit - rateStatus.Remaining,
+			"request_limit":   rateStatus.Limit,
+			"remaining":       rateStatus.Remaining,
+			"window_start":    periodStart.UnixMilli(),
+			"last_request":    rateStatus.LastRequest,
+			"period_start":    periodStart.Format(time.RFC3339),
+			"period_end":      rateStatus.Reset.Format(time.RFC3339),
+			"token_count":     usedTokens,
+			"token_limit":     maxTokens,
+			"token_window_ms": tokenWindowMs,
 		},
+		"token_usage": tokenUsage,
 	}
 
 	// Add latest task if exists
@@ -343,7 +471,7 @@ func (h *PublicHandlers) GetUserData(w http.ResponseWriter, r *http.Request) {
 
 			resultToken, err := h.jwtAuth.GenerateToken(resultPayload, 3600) // 1 hour
 			if err != nil {
-				log.Printf("Failed to generate result token for task %s: %v", latestTask.ID, err)
+				slog.ErrorContext(r.Context(), "Failed to generate result token", logging.TaskID(latestTask.ID), logging.Err(err))
 			} else {
 				taskData["token"] = resultToken
 			}
@@ -394,7 +522,7 @@ func (h *PublicHandlers) VoteTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Printf("VoteTask: userID=%s, taskID=%s, voteType=%s", userID, taskID, req.VoteType)
+	slog.DebugContext(r.Context(), "Vote received", logging.UserID(userID), logging.TaskID(taskID), "vote", req.VoteType)
 
 	// Validate vote value
 	if req.VoteType != "upvote" && req.VoteType != "downvote" && req.VoteType != "" {
@@ -403,7 +531,7 @@ func (h *PublicHandlers) VoteTask(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get task to verify ownership and status
-	task, err := h.db.GetTask(taskID)
+	task, err := h.store.GetTask(r.Context(), taskID)
 	if err != nil {
 		utils.SendError(w, http.StatusNotFound, "Task not found")
 		return
@@ -438,14 +566,14 @@ func (h *PublicHandlers) VoteTask(w http.ResponseWriter, r *http.Request) {
 		newRating = nil
 	}
 
-	err = h.db.UpdateTaskRating(taskID, userID, newRating)
+	err = h.store.UpdateTaskRating(r.Context(), taskID, userID, newRating)
 	if err != nil {
-		log.Printf("Failed to update task rating: %v", err)
+		slog.ErrorContext(r.Context(), "Failed to update task rating", logging.TaskID(taskID), logging.Err(err))
 		utils.SendError(w, http.StatusInternalServerError, "Failed to update rating")
 		return
 	}
 
-	log.Printf("Successfully updated task rating: taskID=%s, newRating=%v", taskID, newRating)
+	slog.InfoContext(r.Context(), "Task rating updated", logging.TaskID(taskID), "vote", req.VoteType, "removed", newRating == nil)
 
 	// Return response
 	response := database.VoteResponse{
@@ -34,7 +34,7 @@ func TestRequeueTask_Integration(t *testing.T) {
 		Priority:    0,
 		MaxRetries:  3,
 	}
-	err := db.CreateTask(task)
+	err := db.CreateTask(t.Context(), task)
 	if err != nil {
 		t.Fatalf("failed to create task: %v", err)
 	}
@@ -45,7 +45,7 @@ func TestRequeueTask_Integration(t *testing.T) {
 	}
 
 	// Проверить, что задача создана корректно
-	task0, err := db.GetTask("task-1")
+	task0, err := db.GetTask(t.Context(), "task-1")
 	if err != nil {
 		t.Fatalf("failed to get task after create: %v", err)
 	}
@@ -71,7 +71,7 @@ func TestRequeueTask_Integration(t *testing.T) {
 	}
 
 	// Проверить, что задача теперь pending
-	task2, err := db.GetTask("task-1")
+	task2, err := db.GetTask(t.Context(), "task-1")
 	if err != nil {
 		t.Fatalf("failed to get task: %v", err)
 	}
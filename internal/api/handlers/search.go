@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/ad/go-llm-manager/internal/database"
+	"github.com/ad/go-llm-manager/internal/logging"
+	"github.com/ad/go-llm-manager/internal/utils"
+)
+
+// GET /api/internal/search?q=... - Full-text search over task prompts, results and errors,
+// with the filters and cursor pagination of /api/internal/all-tasks
+func (h *InternalHandlers) SearchTasks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.SendError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	q := query.Get("q")
+	if q == "" {
+		utils.SendError(w, http.StatusBadRequest, "q is required")
+		return
+	}
+
+	limit := 20
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 100 {
+			utils.SendError(w, http.StatusBadRequest, "limit must be between 1 and 100")
+			return
+		}
+		limit = n
+	}
+
+	filter, err := parseTaskFilter(query)
+	if err != nil {
+		utils.SendError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var after *database.TaskCursor
+	if c := query.Get("cursor"); c != "" {
+		if after, err = database.ParseTaskCursor(c); err != nil {
+			utils.SendError(w, http.StatusBadRequest, "Invalid cursor")
+			return
+		}
+	}
+
+	page, err := h.store.SearchTasks(r.Context(), q, filter, after, limit)
+	if errors.Is(err, database.ErrInvalidSearchQuery) {
+		utils.SendError(w, http.StatusBadRequest, "q must contain a letter or digit")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to search tasks", logging.Err(err))
+		utils.SendError(w, http.StatusInternalServerError, "Failed to search tasks")
+		return
+	}
+
+	utils.SendJSON(w, http.StatusOK, page)
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ad/go-llm-manager/internal/auth"
+	"github.com/ad/go-llm-manager/internal/database"
+)
+
+func TestSearchTasks(t *testing.T) {
+	db := database.NewTestDB(t)
+	h := NewInternalHandlers(db, auth.NewJWTAuth("test"))
+
+	for _, id := range []string{"a", "b"} {
+		if err := db.CreateTask(t.Context(), &database.Task{ID: id, UserID: id, ProductData: `{"q":"weather"}`, Status: database.TaskStatusPending}); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+	failure := "model llama3 not found"
+	if err := db.UpdateTaskStatus(t.Context(), "b", database.TaskStatusFailed, nil, &failure); err != nil {
+		t.Fatalf("fail: %v", err)
+	}
+
+	search := func(query string) (*database.TaskSearchPage, int) {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		h.SearchTasks(rr, httptest.NewRequest(http.MethodGet, "/api/internal/search?"+query, nil))
+		var page database.TaskSearchPage
+		json.Unmarshal(rr.Body.Bytes(), &page)
+		return &page, rr.Code
+	}
+
+	page, code := search("q=weather&status=failed")
+	if code != http.StatusOK || page.Total != 1 || page.Tasks[0].ID != "b" {
+		t.Fatalf("filtered search: code %d, %+v", code, page)
+	}
+	page, _ = search("q=LLAMA3+not")
+	if page.Total != 1 || page.Tasks[0].Snippets["error_message"] != "model <mark>llama3</mark> <mark>not</mark> found" {
+		t.Errorf("error search: %+v", page.Tasks[0])
+	}
+
+	for _, query := range []string{"", "q=%2A%2A", "q=x&limit=500", "q=x&rating=bad", "q=x&cursor=%21"} {
+		if _, code := search(query); code != http.StatusBadRequest {
+			t.Errorf("%q: code %d, want 400", query, code)
+		}
+	}
+}
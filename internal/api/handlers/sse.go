@@ -1,8 +1,10 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"strings"
@@ -10,20 +12,22 @@ import (
 
 	"github.com/ad/go-llm-manager/internal/auth"
 	"github.com/ad/go-llm-manager/internal/database"
+	"github.com/ad/go-llm-manager/internal/logging"
 	"github.com/ad/go-llm-manager/internal/sse"
 	"github.com/ad/go-llm-manager/internal/utils"
 	"github.com/google/uuid"
 )
 
 type SSEHandlers struct {
-	db      *database.DB
+	store   database.Store
 	jwtAuth *auth.JWTAuth
 	manager *sse.Manager
+	monitor *ProcessorMonitor
 }
 
-func NewSSEHandlers(db *database.DB, jwtAuth *auth.JWTAuth) *SSEHandlers {
+func NewSSEHandlers(store database.Store, jwtAuth *auth.JWTAuth) *SSEHandlers {
 	return &SSEHandlers{
-		db:      db,
+		store:   store,
 		jwtAuth: jwtAuth,
 		manager: sse.NewManager(),
 	}
@@ -63,7 +67,7 @@ func (h *SSEHandlers) ResultPolling(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Проверка существования задачи
-	task, err := h.db.GetTask(taskID)
+	task, err := h.store.GetTask(r.Context(), taskID)
 	if err != nil {
 		utils.SendError(w, http.StatusNotFound, "Task not found")
 		return
@@ -119,7 +123,7 @@ func (h *SSEHandlers) ResultPolling(w http.ResponseWriter, r *http.Request) {
 	taskDone := make(chan bool, 1)
 
 	// Запуск polling в отдельной goroutine
-	go h.pollTask(client, taskID, userID, pollInterval, maxDuration, taskDone)
+	go h.pollTask(r.Context(), client, taskID, userID, pollInterval, maxDuration, taskDone)
 
 	// Запуск heartbeat в отдельной goroutine
 	go h.sendHeartbeats(client, heartbeatInterval, maxDuration, taskDone)
@@ -128,7 +132,7 @@ func (h *SSEHandlers) ResultPolling(w http.ResponseWriter, r *http.Request) {
 	client.Run()
 }
 
-func (h *SSEHandlers) pollTask(client *sse.Client, taskID, userID string, pollInterval, maxDuration int, taskDone chan bool) {
+func (h *SSEHandlers) pollTask(ctx context.Context, client *sse.Client, taskID, userID string, pollInterval, maxDuration int, taskDone chan bool) {
 	startTime := time.Now()
 	ticker := time.NewTicker(time.Duration(pollInterval) * time.Millisecond)
 	defer ticker.Stop()
@@ -166,7 +170,7 @@ func (h *SSEHandlers) pollTask(client *sse.Client, taskID, userID string, pollIn
 			}
 
 			// Получение задачи
-			task, err := h.db.GetTask(taskID)
+			task, err := h.store.GetTask(ctx, taskID)
 			if err != nil {
 				select {
 				case client.Events <- sse.SSEEvent{
@@ -406,8 +410,8 @@ func formatTimePtr(t *int64) interface{} {
 	return time.Unix(0, *t*int64(time.Millisecond)).Format(time.RFC3339)
 }
 
-func (h *SSEHandlers) checkPendingTasks(client *sse.Client) {
-	tasks, err := h.db.GetPendingTasks(10)
+func (h *SSEHandlers) checkPendingTasks(ctx context.Context, client *sse.Client) {
+	tasks, err := h.store.GetPendingTasks(ctx, 10)
 	if err != nil {
 		// log.Printf("checkPendingTasks: error fetching tasks: %v", err)
 		return
@@ -513,11 +517,19 @@ func (h *SSEHandlers) TaskStream(w http.ResponseWriter, r *http.Request) {
 	}
 
 	processorID := r.URL.Query().Get("processor_id")
+	if !bindProcessorID(w, r, &processorID) {
+		return
+	}
 	if processorID == "" {
 		utils.SendError(w, http.StatusBadRequest, "Missing processor_id parameter")
 		return
 	}
 
+	// Регистрируем процессор (или обновляем last_seen) при подключении
+	if err := h.store.TouchProcessor(r.Context(), processorID, r.URL.Query().Get("version"), r.URL.Query().Get("hostname")); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to register processor on task-stream connect", logging.ProcessorID(processorID), logging.Err(err))
+	}
+
 	// Парсинг опций - делаем heartbeat более частым
 	heartbeat := h.parseIntParam(r.URL.Query().Get("heartbeat"), 15000, 5000, 20000)
 	maxDuration := h.parseIntParam(r.URL.Query().Get("maxDuration"), 3600000, 60000, 7200000)
@@ -571,13 +583,23 @@ func (h *SSEHandlers) TaskStream(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Проверка существующих pending задач
-	go h.checkPendingTasks(client)
+	go h.checkPendingTasks(r.Context(), client)
 
 	// Запуск heartbeat для процессора
 	go h.sendProcessorHeartbeats(client, processorID, heartbeat, maxDuration)
 
 	// Запуск клиента (блокирующий)
 	client.Run()
+
+	// Соединение закрыто: если процессор не переподключится, монитор пометит его offline
+	if h.monitor != nil {
+		h.monitor.ProcessorDisconnected(processorID)
+	}
+}
+
+// SetProcessorMonitor включает отслеживание разрывов task-stream соединений
+func (h *SSEHandlers) SetProcessorMonitor(m *ProcessorMonitor) {
+	h.monitor = m
 }
 
 // Экспортируем менеджер для интеграции с public.go
@@ -47,7 +47,8 @@ func setupTestDB(t *testing.T) *database.DB {
 		ollama_params TEXT,
 		estimated_duration INTEGER DEFAULT 300000, -- 5 minutes default
 		actual_duration INTEGER,
-		rating TEXT CHECK (rating IN ('upvote', 'downvote', NULL))
+		rating TEXT CHECK (rating IN ('upvote', 'downvote', NULL)),
+		trace_parent TEXT
 	);
 	
 	CREATE TABLE rate_limits (
@@ -57,6 +58,76 @@ func setupTestDB(t *testing.T) *database.DB {
 		last_request INTEGER NOT NULL
 	);
 
+	CREATE TABLE rate_limit_state (
+		user_id TEXT NOT NULL,
+		algorithm TEXT NOT NULL,
+		window_start INTEGER NOT NULL DEFAULT 0,
+		count INTEGER NOT NULL DEFAULT 0,
+		prev_count INTEGER NOT NULL DEFAULT 0,
+		tokens REAL NOT NULL DEFAULT 0,
+		updated_at INTEGER NOT NULL,
+		PRIMARY KEY (user_id, algorithm)
+	);
+
+	CREATE TABLE rate_limit_log (
+		user_id TEXT NOT NULL,
+		created_at INTEGER NOT NULL
+	);
+
+	CREATE TABLE task_usage (
+		task_id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		model TEXT NOT NULL DEFAULT '',
+		prompt_tokens INTEGER NOT NULL DEFAULT 0,
+		completion_tokens INTEGER NOT NULL DEFAULT 0,
+		total_tokens INTEGER NOT NULL DEFAULT 0,
+		created_at INTEGER NOT NULL
+	);
+
+	CREATE TABLE user_token_usage (
+		user_id TEXT PRIMARY KEY,
+		prompt_tokens INTEGER NOT NULL DEFAULT 0,
+		completion_tokens INTEGER NOT NULL DEFAULT 0,
+		total_tokens INTEGER NOT NULL DEFAULT 0,
+		task_count INTEGER NOT NULL DEFAULT 0,
+		updated_at INTEGER NOT NULL
+	);
+
+	CREATE TABLE daily_token_usage (
+		user_id TEXT NOT NULL,
+		day TEXT NOT NULL,
+		model TEXT NOT NULL DEFAULT '',
+		prompt_tokens INTEGER NOT NULL DEFAULT 0,
+		completion_tokens INTEGER NOT NULL DEFAULT 0,
+		total_tokens INTEGER NOT NULL DEFAULT 0,
+		task_count INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (user_id, day, model)
+	);
+
+	CREATE TABLE user_token_limits (
+		user_id TEXT PRIMARY KEY,
+		max_tokens INTEGER NOT NULL,
+		window_ms INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL
+	);
+
+	CREATE TABLE usage_ledger (
+		task_id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		model TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		started_at INTEGER,
+		completed_at INTEGER NOT NULL,
+		queue_wait_ms INTEGER,
+		processing_ms INTEGER,
+		retry_count INTEGER NOT NULL DEFAULT 0,
+		prompt_tokens INTEGER,
+		completion_tokens INTEGER,
+		total_tokens INTEGER,
+		recorded_at INTEGER NOT NULL
+	);
+
 	CREATE TABLE processor_metrics (
 		processor_id TEXT PRIMARY KEY,
 		cpu_usage REAL NOT NULL DEFAULT 0.0,
@@ -65,6 +136,18 @@ func setupTestDB(t *testing.T) *database.DB {
 		active_tasks INTEGER NOT NULL DEFAULT 0,
 		last_updated INTEGER NOT NULL,
 		created_at INTEGER NOT NULL DEFAULT (unixepoch() * 1000)
+	);
+
+	CREATE TABLE processors (
+		id TEXT PRIMARY KEY,
+		version TEXT NOT NULL DEFAULT '',
+		hostname TEXT NOT NULL DEFAULT '',
+		labels TEXT NOT NULL DEFAULT '{}',
+		supported_models TEXT NOT NULL DEFAULT '[]',
+		max_concurrency INTEGER NOT NULL DEFAULT 0,
+		first_seen INTEGER NOT NULL,
+		last_seen INTEGER NOT NULL,
+		state TEXT NOT NULL DEFAULT 'online' CHECK (state IN ('online', 'draining', 'offline'))
 	);`)
 	if err != nil {
 		t.Fatalf("failed to create initial db tables: %v", err)
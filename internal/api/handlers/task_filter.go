@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/ad/go-llm-manager/internal/database"
+)
+
+// parseTaskFilter reads the task filter of /api/internal/all-tasks and
+// /api/internal/search from query parameters. The error message is meant for the client.
+func parseTaskFilter(query url.Values) (database.TaskFilter, error) {
+	filter := database.TaskFilter{
+		UserID:      query.Get("user_id"),
+		ProcessorID: query.Get("processor_id"),
+		Model:       query.Get("model"),
+	}
+
+	for _, status := range strings.Split(query.Get("status"), ",") {
+		status = strings.TrimSpace(status)
+		if status == "" {
+			continue
+		}
+		switch status {
+		case database.TaskStatusPending, database.TaskStatusProcessing, database.TaskStatusCompleted, database.TaskStatusFailed:
+			filter.Statuses = append(filter.Statuses, status)
+		default:
+			return filter, errors.New("status must be a list of 'pending', 'processing', 'completed', 'failed'")
+		}
+	}
+
+	switch rating := query.Get("rating"); rating {
+	case "", "upvote", "downvote", database.RatingNone:
+		filter.Rating = rating
+	default:
+		return filter, errors.New("rating must be 'upvote', 'downvote' or 'none'")
+	}
+
+	for name, dst := range map[string]**int{"priority_min": &filter.PriorityMin, "priority_max": &filter.PriorityMax} {
+		if v := query.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return filter, errors.New(name + " must be an integer")
+			}
+			*dst = &n
+		}
+	}
+
+	for name, dst := range map[string]*int64{
+		"created_from":   &filter.CreatedFrom,
+		"created_to":     &filter.CreatedTo,
+		"completed_from": &filter.CompletedFrom,
+		"completed_to":   &filter.CompletedTo,
+	} {
+		if v := query.Get(name); v != "" {
+			ms, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || ms <= 0 {
+				return filter, errors.New(name + " must be a unix timestamp in milliseconds")
+			}
+			*dst = ms
+		}
+	}
+
+	if v := query.Get("has_error"); v != "" {
+		hasError, err := strconv.ParseBool(v)
+		if err != nil {
+			return filter, errors.New("has_error must be 'true' or 'false'")
+		}
+		filter.HasError = &hasError
+	}
+
+	return filter, nil
+}
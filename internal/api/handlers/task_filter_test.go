@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ad/go-llm-manager/internal/auth"
+	"github.com/ad/go-llm-manager/internal/database"
+)
+
+func TestGetAllTasksFiltersAndPages(t *testing.T) {
+	db := database.NewTestDB(t)
+	h := NewInternalHandlers(db, auth.NewJWTAuth("test"))
+
+	for i := range 5 {
+		task := &database.Task{ID: fmt.Sprintf("t-%d", i), UserID: fmt.Sprintf("u-%d", i), ProductData: "{}", Status: database.TaskStatusPending, Priority: i}
+		if err := db.CreateTask(t.Context(), task); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+	if _, err := db.Exec(`UPDATE tasks SET created_at = 1000 + priority`); err != nil {
+		t.Fatalf("set created_at: %v", err)
+	}
+	if _, err := db.Exec(`UPDATE tasks SET status = 'failed', error_message = 'boom', completed_at = 5000 WHERE id IN ('t-1', 't-3')`); err != nil {
+		t.Fatalf("fail tasks: %v", err)
+	}
+
+	list := func(query string) (*database.TaskPage, int) {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		h.GetAllTasks(rr, httptest.NewRequest(http.MethodGet, "/api/internal/all-tasks?"+query, nil))
+		var page database.TaskPage
+		if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+			t.Fatalf("%s: decode response: %v", query, err)
+		}
+		return &page, rr.Code
+	}
+
+	page, code := list("status=failed&has_error=true&priority_min=2")
+	if code != http.StatusOK || page.Total != 1 || len(page.Tasks) != 1 || page.Tasks[0].ID != "t-3" {
+		t.Fatalf("filtered list: code %d, %+v", code, page)
+	}
+
+	// Новая задача между страницами не сдвигает следующую
+	page, _ = list("limit=2")
+	if page.Total != 5 || len(page.Tasks) != 2 || page.Tasks[0].ID != "t-4" || page.NextCursor == "" {
+		t.Fatalf("first page: %+v", page)
+	}
+	if err := db.CreateTask(t.Context(), &database.Task{ID: "t-new", UserID: "u-new", ProductData: "{}", Status: database.TaskStatusPending}); err != nil {
+		t.Fatalf("create t-new: %v", err)
+	}
+	page, _ = list("limit=2&cursor=" + page.NextCursor)
+	if len(page.Tasks) != 2 || page.Tasks[0].ID != "t-2" || page.Tasks[1].ID != "t-1" {
+		t.Fatalf("second page: %+v", page)
+	}
+	page, _ = list("limit=2&cursor=" + page.NextCursor)
+	if len(page.Tasks) != 1 || page.Tasks[0].ID != "t-0" || page.NextCursor != "" {
+		t.Fatalf("last page: %+v", page)
+	}
+
+	// Устаревший offset игнорируется, а не ломает старых клиентов
+	page, code = list("limit=2&offset=10")
+	if code != http.StatusOK || len(page.Tasks) != 2 || page.Tasks[0].ID != "t-new" {
+		t.Fatalf("offset: code %d, %+v", code, page)
+	}
+
+	for _, query := range []string{"status=done", "rating=meh", "priority_min=x", "created_from=yesterday", "has_error=maybe", "cursor=%21"} {
+		if _, code := list(query); code != http.StatusBadRequest {
+			t.Errorf("%s: code %d, want 400", query, code)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ad/go-llm-manager/internal/auth"
+	"github.com/ad/go-llm-manager/internal/database"
+	"github.com/ad/go-llm-manager/internal/logging"
+	"github.com/ad/go-llm-manager/internal/utils"
+)
+
+// Срок хранения записи в denylist, если exp отзываемого токена неизвестен
+const defaultRevokedTokenTTL = 365 * 24 * time.Hour
+
+// POST /api/internal/tokens/revoke - Revoke a single token (jti or token) or all tokens
+// of a user or processor issued up to now
+func (h *InternalHandlers) RevokeTokens(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.SendError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req struct {
+		JTI         string `json:"jti,omitempty"`
+		ExpiresAt   *int64 `json:"expires_at,omitempty"` // unix seconds, как exp в токене
+		Token       string `json:"token,omitempty"`
+		UserID      string `json:"user_id,omitempty"`
+		ProcessorID string `json:"processor_id,omitempty"`
+	}
+	if err := utils.ParseJSON(r, &req); err != nil {
+		utils.SendError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	targets := 0
+	for _, v := range []string{req.JTI, req.Token, req.UserID, req.ProcessorID} {
+		if v != "" {
+			targets++
+		}
+	}
+	if targets != 1 {
+		utils.SendError(w, http.StatusBadRequest, "Exactly one of jti, token, user_id or processor_id is required")
+		return
+	}
+
+	denylist := h.jwtAuth.Denylist()
+	if denylist == nil {
+		utils.SendError(w, http.StatusServiceUnavailable, "Token revocation is not enabled")
+		return
+	}
+
+	switch {
+	case req.UserID != "":
+		notBefore, err := denylist.RevokeSubject(r.Context(), database.TokenSubjectUser, req.UserID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to revoke user tokens", logging.UserID(req.UserID), logging.Err(err))
+			utils.SendError(w, http.StatusInternalServerError, "Failed to revoke tokens")
+			return
+		}
+
+		slog.InfoContext(r.Context(), "User tokens revoked", logging.UserID(req.UserID))
+		utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+			"success":    true,
+			"user_id":    req.UserID,
+			"not_before": notBefore,
+		})
+
+	case req.ProcessorID != "":
+		notBefore, disconnected, err := revokeProcessorTokens(r.Context(), denylist, req.ProcessorID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to revoke processor tokens", logging.ProcessorID(req.ProcessorID), logging.Err(err))
+			utils.SendError(w, http.StatusInternalServerError, "Failed to revoke tokens")
+			return
+		}
+
+		utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+			"success":      true,
+			"processor_id": req.ProcessorID,
+			"not_before":   notBefore,
+			"disconnected": disconnected,
+		})
+
+	default:
+		jti := req.JTI
+		expiresAt := time.Now().Add(defaultRevokedTokenTTL).UnixMilli()
+		if req.ExpiresAt != nil {
+			expiresAt = *req.ExpiresAt * 1000
+		}
+
+		if req.Token != "" {
+			payload, err := h.jwtAuth.VerifyToken(req.Token)
+			if err != nil {
+				utils.SendError(w, http.StatusBadRequest, "Invalid or expired token")
+				return
+			}
+			if payload.ID == "" {
+				utils.SendError(w, http.StatusBadRequest, "Token has no jti, revoke by user_id or processor_id")
+				return
+			}
+			jti = payload.ID
+			expiresAt = payload.ExpiresAt * 1000
+		}
+
+		if err := denylist.RevokeToken(r.Context(), jti, expiresAt); err != nil {
+			slog.ErrorContext(r.Context(), "Failed to revoke token", "jti", jti, logging.Err(err))
+			utils.SendError(w, http.StatusInternalServerError, "Failed to revoke token")
+			return
+		}
+
+		slog.InfoContext(r.Context(), "Token revoked", "jti", jti)
+		utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+			"success":    true,
+			"jti":        jti,
+			"expires_at": expiresAt,
+		})
+	}
+}
+
+// revokeProcessorTokens revokes all tokens of the processor and closes its open
+// task-stream connections. Returns the cutoff and the number of closed streams.
+func revokeProcessorTokens(ctx context.Context, denylist *auth.Denylist, processorID string) (int64, int, error) {
+	notBefore, err := denylist.RevokeSubject(ctx, database.TokenSubjectProcessor, processorID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Открытые task-stream соединения с отозванным токеном закрываем сразу
+	disconnected := 0
+	if sseManagerInstance != nil {
+		disconnected = sseManagerInstance.DisconnectProcessor(processorID)
+	}
+
+	slog.Info("Processor tokens revoked", logging.ProcessorID(processorID), "disconnected_streams", disconnected)
+
+	return notBefore, disconnected, nil
+}
+
+// introspectionResponse is the RFC 7662 response. JWT claims are embedded only for active tokens.
+type introspectionResponse struct {
+	Active    bool   `json:"active"`
+	TokenType string `json:"token_type,omitempty"`
+	Revoked   bool   `json:"revoked,omitempty"`
+	*database.JWTPayload
+}
+
+// POST /api/internal/introspect - RFC 7662 token introspection (form or JSON body with `token`)
+func (h *InternalHandlers) Introspect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		utils.SendError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var token string
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		if err := r.ParseForm(); err != nil {
+			utils.SendError(w, http.StatusBadRequest, "Invalid form")
+			return
+		}
+		token = r.PostForm.Get("token")
+	} else {
+		var req struct {
+			Token string `json:"token"`
+		}
+		if err := utils.ParseJSON(r, &req); err != nil {
+			utils.SendError(w, http.StatusBadRequest, "Invalid JSON")
+			return
+		}
+		token = req.Token
+	}
+	if token == "" {
+		utils.SendError(w, http.StatusBadRequest, "token is required")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	payload, err := h.jwtAuth.VerifyToken(token)
+	if err != nil {
+		utils.SendJSON(w, http.StatusOK, introspectionResponse{Active: false})
+		return
+	}
+
+	if denylist := h.jwtAuth.Denylist(); denylist != nil {
+		if err := denylist.Check(payload); errors.Is(err, auth.ErrTokenRevoked) {
+			utils.SendJSON(w, http.StatusOK, introspectionResponse{Active: false, Revoked: true})
+			return
+		}
+	}
+
+	tokenType := database.TokenSubjectUser
+	if payload.ProcessorID != "" {
+		tokenType = database.TokenSubjectProcessor
+	}
+
+	utils.SendJSON(w, http.StatusOK, introspectionResponse{
+		Active:     true,
+		TokenType:  tokenType,
+		JWTPayload: payload,
+	})
+}
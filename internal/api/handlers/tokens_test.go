@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/ad/go-llm-manager/internal/auth"
+	"github.com/ad/go-llm-manager/internal/database"
+)
+
+func introspect(t *testing.T, h *InternalHandlers, req *http.Request) (int, map[string]interface{}) {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	h.Introspect(rr, req)
+
+	if rr.Code == http.StatusOK && rr.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("expected Cache-Control: no-store, got %q", rr.Header().Get("Cache-Control"))
+	}
+
+	var resp map[string]interface{}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	return rr.Code, resp
+}
+
+func introspectJSON(t *testing.T, h *InternalHandlers, token string) (int, map[string]interface{}) {
+	t.Helper()
+	body, _ := json.Marshal(map[string]string{"token": token})
+	req := httptest.NewRequest(http.MethodPost, "/api/internal/introspect", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	return introspect(t, h, req)
+}
+
+func TestIntrospectActiveTokens(t *testing.T) {
+	db := database.NewTestDB(t)
+	jwtAuth := newTestJWTAuth(t, db)
+	h := NewInternalHandlers(db, jwtAuth)
+
+	priority := 2
+	userPayload := &database.JWTPayload{
+		Issuer:      "llm-proxy",
+		Subject:     "u-1",
+		UserID:      "u-1",
+		ProductData: "data",
+		Priority:    &priority,
+		RateLimit:   &database.RateLimitConfig{MaxRequests: 5, WindowMs: 1000},
+	}
+	userToken, _ := jwtAuth.GenerateToken(userPayload, 3600)
+
+	code, resp := introspectJSON(t, h, userToken)
+	if code != http.StatusOK || resp["active"] != true {
+		t.Fatalf("expected active token, got %d %v", code, resp)
+	}
+	if resp["token_type"] != "user" || resp["user_id"] != "u-1" || resp["sub"] != "u-1" || resp["iss"] != "llm-proxy" {
+		t.Errorf("unexpected claims: %v", resp)
+	}
+	if resp["jti"] != userPayload.ID || resp["product_data"] != "data" || resp["priority"] != float64(2) {
+		t.Errorf("unexpected claims: %v", resp)
+	}
+	if resp["exp"] == nil || resp["iat"] == nil || resp["rate_limit"] == nil {
+		t.Errorf("expected exp, iat and rate_limit, got %v", resp)
+	}
+	if _, ok := resp["revoked"]; ok {
+		t.Errorf("active token must not be marked revoked: %v", resp)
+	}
+
+	// RFC 7662: application/x-www-form-urlencoded
+	processorToken, _ := jwtAuth.GenerateToken(&database.JWTPayload{Subject: "proc-1", ProcessorID: "proc-1"}, 3600)
+	form := url.Values{"token": {processorToken}, "token_type_hint": {"access_token"}}
+	req := httptest.NewRequest(http.MethodPost, "/api/internal/introspect", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	code, resp = introspect(t, h, req)
+	if code != http.StatusOK || resp["active"] != true || resp["token_type"] != "processor" || resp["processor_id"] != "proc-1" {
+		t.Fatalf("expected active processor token, got %d %v", code, resp)
+	}
+}
+
+func TestIntrospectInactiveTokens(t *testing.T) {
+	db := database.NewTestDB(t)
+	jwtAuth := newTestJWTAuth(t, db)
+	h := NewInternalHandlers(db, jwtAuth)
+
+	expired, _ := jwtAuth.GenerateToken(&database.JWTPayload{Subject: "u-1", UserID: "u-1"}, -10)
+	foreign, _ := auth.NewJWTAuth("other-secret").GenerateToken(&database.JWTPayload{Subject: "u-1", UserID: "u-1"}, 3600)
+
+	for name, token := range map[string]string{
+		"garbage": "not-a-jwt",
+		"expired": expired,
+		"foreign": foreign,
+	} {
+		code, resp := introspectJSON(t, h, token)
+		if code != http.StatusOK || resp["active"] != false || len(resp) != 1 {
+			t.Errorf("%s: expected only active=false, got %d %v", name, code, resp)
+		}
+	}
+
+	payload := &database.JWTPayload{Subject: "u-1", UserID: "u-1"}
+	revoked, _ := jwtAuth.GenerateToken(payload, 3600)
+	if err := jwtAuth.Denylist().RevokeToken(t.Context(), payload.ID, (payload.ExpiresAt+3600)*1000); err != nil {
+		t.Fatalf("revoke failed: %v", err)
+	}
+	code, resp := introspectJSON(t, h, revoked)
+	if code != http.StatusOK || resp["active"] != false || resp["revoked"] != true {
+		t.Fatalf("expected revoked inactive token, got %d %v", code, resp)
+	}
+	if _, ok := resp["user_id"]; ok {
+		t.Errorf("inactive token must not expose claims: %v", resp)
+	}
+}
+
+func TestIntrospectBadRequests(t *testing.T) {
+	db := database.NewTestDB(t)
+	h := NewInternalHandlers(db, newTestJWTAuth(t, db))
+
+	if code, _ := introspectJSON(t, h, ""); code != http.StatusBadRequest {
+		t.Errorf("expected 400 for missing token, got %d", code)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/internal/introspect", strings.NewReader("{"))
+	if code, _ := introspect(t, h, req); code != http.StatusBadRequest {
+		t.Errorf("expected 400 for invalid JSON, got %d", code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/internal/introspect", nil)
+	if code, _ := introspect(t, h, req); code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405 for GET, got %d", code)
+	}
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/ad/go-llm-manager/internal/database"
+	"github.com/ad/go-llm-manager/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Атрибуты спанов задач
+const (
+	attrTaskID      = attribute.Key("task.id")
+	attrTaskStatus  = attribute.Key("task.status")
+	attrProcessorID = attribute.Key("processor.id")
+	attrRetryCount  = attribute.Key("task.retry_count")
+)
+
+// linkTaskTraces links the span in ctx (claim or work-steal) to the traces of the tasks
+func linkTaskTraces(ctx context.Context, tasks []*database.Task) {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+
+	for _, task := range tasks {
+		if task.TraceParent == nil {
+			continue
+		}
+		taskCtx := tracing.ContextWithTraceParent(context.Background(), *task.TraceParent)
+		if sc := trace.SpanContextFromContext(taskCtx); sc.IsValid() {
+			span.AddLink(trace.Link{SpanContext: sc, Attributes: []attribute.KeyValue{attrTaskID.String(task.ID)}})
+		}
+	}
+}
+
+// finishTaskSpan records the whole life of a finished task, from created_at to
+// completed_at, as a span in the trace started by the create request. Claim is
+// an event on it; the request that finished the task (ctx) is linked.
+func finishTaskSpan(ctx context.Context, task *database.Task) {
+	if task.TraceParent == nil || task.CompletedAt == nil {
+		return
+	}
+	parent := tracing.ContextWithTraceParent(context.Background(), *task.TraceParent)
+	if !trace.SpanContextFromContext(parent).IsValid() {
+		return
+	}
+
+	attrs := []attribute.KeyValue{
+		attrTaskID.String(task.ID),
+		attrTaskStatus.String(task.Status),
+		attrRetryCount.Int(task.RetryCount),
+	}
+	if task.ProcessorID != nil {
+		attrs = append(attrs, attrProcessorID.String(*task.ProcessorID))
+	}
+
+	opts := []trace.SpanStartOption{
+		trace.WithTimestamp(time.UnixMilli(task.CreatedAt)),
+		trace.WithAttributes(attrs...),
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		opts = append(opts, trace.WithLinks(trace.Link{SpanContext: sc}))
+	}
+
+	_, span := tracing.Tracer().Start(parent, "task", opts...)
+	if task.ProcessingStartedAt != nil {
+		span.AddEvent("claimed", trace.WithTimestamp(time.UnixMilli(*task.ProcessingStartedAt)))
+	}
+	if task.Status == database.TaskStatusFailed {
+		message := ""
+		if task.ErrorMessage != nil {
+			message = *task.ErrorMessage
+		}
+		span.SetStatus(codes.Error, message)
+	}
+	span.End(trace.WithTimestamp(time.UnixMilli(*task.CompletedAt)))
+}
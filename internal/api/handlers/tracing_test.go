@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ad/go-llm-manager/internal/auth"
+	"github.com/ad/go-llm-manager/internal/config"
+	"github.com/ad/go-llm-manager/internal/database"
+	"github.com/ad/go-llm-manager/internal/tracing"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTaskTraceFromCreateToComplete(t *testing.T) {
+	exporter := tracing.NewTestExporter(t)
+
+	db := database.NewTestDB(t)
+	jwtAuth := auth.NewJWTAuth("test")
+	public := NewPublicHandlers(db, jwtAuth, &config.Config{})
+	internal := NewInternalHandlers(db, jwtAuth)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/create", public.CreateTask)
+	mux.HandleFunc("/api/internal/claim", internal.ClaimTasks)
+	mux.HandleFunc("/api/internal/complete", internal.CompleteTasks)
+	handler := tracing.Middleware(mux)
+
+	do := func(path, body string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code >= 300 {
+			t.Fatalf("%s failed: %d %s", path, rr.Code, rr.Body.String())
+		}
+		return rr
+	}
+
+	// Клиент присылает свой traceparent: задача продолжает его трейс
+	const clientTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	token, _ := jwtAuth.GenerateToken(&database.JWTPayload{Subject: "u-1", UserID: "u-1", ProductData: "p"}, 3600)
+	rr := do("/api/create", "", http.Header{
+		"Authorization": {"Bearer " + token},
+		"Traceparent":   {"00-" + clientTraceID + "-00f067aa0ba902b7-01"},
+	})
+	var created struct {
+		TaskID string `json:"taskId"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &created)
+
+	stored, _ := db.GetTask(t.Context(), created.TaskID)
+	if stored.TraceParent == nil || !strings.Contains(*stored.TraceParent, clientTraceID) {
+		t.Fatalf("expected the create trace on the task, got %v", stored.TraceParent)
+	}
+
+	rr = do("/api/internal/claim", `{"processor_id":"proc-1"}`, nil)
+	var claimed struct {
+		Tasks []struct {
+			ID          string `json:"id"`
+			TraceParent string `json:"traceparent"`
+		} `json:"tasks"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &claimed)
+	if len(claimed.Tasks) != 1 || claimed.Tasks[0].TraceParent != *stored.TraceParent {
+		t.Fatalf("expected traceparent in the claim payload, got %+v", claimed.Tasks)
+	}
+
+	// Процессор продолжает трейс задачи при завершении
+	body, _ := json.Marshal(map[string]string{"taskId": created.TaskID, "status": "failed", "error_message": "boom"})
+	do("/api/internal/complete", string(body), http.Header{"Traceparent": {claimed.Tasks[0].TraceParent}})
+
+	spans := exporter.GetSpans()
+	byName := func(name string) *tracetest.SpanStub {
+		for i := range spans {
+			if spans[i].Name == name {
+				return &spans[i]
+			}
+		}
+		t.Fatalf("span %q not recorded", name)
+		return nil
+	}
+
+	create := byName("POST /api/create")
+	claim := byName("POST /api/internal/claim")
+	complete := byName("POST /api/internal/complete")
+	task := byName("task")
+
+	if create.SpanContext.TraceID().String() != clientTraceID || complete.SpanContext.TraceID().String() != clientTraceID {
+		t.Errorf("create and complete should continue the client trace")
+	}
+	if len(claim.Links) != 1 || claim.Links[0].SpanContext.TraceID().String() != clientTraceID {
+		t.Errorf("expected claim span linked to the task trace, got %+v", claim.Links)
+	}
+	if task.Parent.SpanID() != create.SpanContext.SpanID() || task.Status.Code != codes.Error {
+		t.Errorf("unexpected task span: parent %s status %+v", task.Parent.SpanID(), task.Status)
+	}
+	if len(task.Events) != 1 || task.Events[0].Name != "claimed" || task.EndTime.Before(task.StartTime) {
+		t.Errorf("unexpected task span events: %+v", task.Events)
+	}
+
+	// Запросы к БД — дочерние спаны HTTP-запросов
+	var dbSpans int
+	for _, span := range spans {
+		if strings.HasPrefix(span.Name, "db ") {
+			dbSpans++
+			if traceID := span.SpanContext.TraceID(); traceID.String() != clientTraceID && traceID != claim.SpanContext.TraceID() {
+				t.Errorf("db span %q is outside the request traces", span.Name)
+			}
+		}
+	}
+	if dbSpans == 0 {
+		t.Error("expected db spans")
+	}
+}
+
+func TestUntracedRequestsStoreNoTraceParent(t *testing.T) {
+	db := database.NewTestDB(t)
+	jwtAuth := auth.NewJWTAuth("test")
+	public := NewPublicHandlers(db, jwtAuth, &config.Config{})
+
+	token, _ := jwtAuth.GenerateToken(&database.JWTPayload{Subject: "u-1", UserID: "u-1", ProductData: "p"}, 3600)
+	req := httptest.NewRequest(http.MethodPost, "/api/create", bytes.NewReader(nil))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	public.CreateTask(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create failed: %d %s", rr.Code, rr.Body.String())
+	}
+
+	var created struct {
+		TaskID string `json:"taskId"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &created)
+	if task, _ := db.GetTask(t.Context(), created.TaskID); task.TraceParent != nil {
+		t.Errorf("expected no traceparent without tracing, got %q", *task.TraceParent)
+	}
+}
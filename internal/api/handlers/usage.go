@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ad/go-llm-manager/internal/database"
+	"github.com/ad/go-llm-manager/internal/logging"
+	"github.com/ad/go-llm-manager/internal/utils"
+)
+
+// Окно лимита токенов, если в токене или лимите пользователя оно не задано
+const defaultTokenLimitWindowMs = 86400000
+
+// recordTaskUsage stores the token usage reported on completion. The model falls
+// back to ollama_params.model of the task. Errors are logged: the task is already completed.
+func recordTaskUsage(ctx context.Context, store database.UsageStore, task *database.Task, model string, promptTokens, completionTokens *int64) {
+	if model == "" {
+		if params, err := task.GetOllamaParams(); err == nil && params != nil && params.Model != nil {
+			model = *params.Model
+		}
+	}
+
+	usage := &database.TaskUsage{
+		TaskID: task.ID,
+		UserID: task.UserID,
+		Model:  model,
+	}
+	if promptTokens != nil {
+		usage.PromptTokens = *promptTokens
+	}
+	if completionTokens != nil {
+		usage.CompletionTokens = *completionTokens
+	}
+
+	recorded, err := store.RecordTaskUsage(ctx, usage)
+	if err != nil {
+		slog.Error("Failed to record task usage", logging.TaskID(task.ID), logging.Err(err))
+		return
+	}
+	if !recorded {
+		slog.Info("Task usage already recorded, ignoring", logging.TaskID(task.ID))
+	}
+}
+
+// tokenLimit returns the token quota of the user: the JWT token_limit claim, then the
+// per-user limit, then TOKEN_LIMIT. Returns nil when the user is not limited.
+func (h *PublicHandlers) tokenLimit(ctx context.Context, userID string, payload *database.JWTPayload) (*database.TokenLimitConfig, error) {
+	var limit database.TokenLimitConfig
+	if h.config != nil {
+		limit = database.TokenLimitConfig{
+			MaxTokens: h.config.RateLimit.TokenLimit,
+			WindowMs:  h.config.RateLimit.TokenLimitWindowMs,
+		}
+	}
+
+	userLimit, err := h.store.GetUserTokenLimit(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if userLimit != nil {
+		limit = database.TokenLimitConfig{MaxTokens: userLimit.MaxTokens, WindowMs: userLimit.WindowMs}
+	}
+
+	if claim := payload.TokenLimit; claim != nil {
+		if claim.MaxTokens > 0 {
+			limit.MaxTokens = claim.MaxTokens
+		}
+		if claim.WindowMs > 0 {
+			limit.WindowMs = claim.WindowMs
+		}
+	}
+
+	if limit.MaxTokens <= 0 {
+		return nil, nil
+	}
+	if limit.WindowMs <= 0 {
+		limit.WindowMs = defaultTokenLimitWindowMs
+	}
+	return &limit, nil
+}
+
+// tokenUsage returns the user's tokens in the quota window together with the quota (nil if unlimited)
+func (h *PublicHandlers) tokenUsage(ctx context.Context, userID string, payload *database.JWTPayload) (int64, *database.TokenLimitConfig, error) {
+	limit, err := h.tokenLimit(ctx, userID, payload)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	windowMs := int64(defaultTokenLimitWindowMs)
+	if limit != nil {
+		windowMs = limit.WindowMs
+	}
+
+	used, err := h.store.SumUserTokens(ctx, userID, time.Now().UnixMilli()-windowMs)
+	return used, limit, err
+}
+
+// GET /api/internal/token-usage?user_id=...&days=30 - Token usage of a user: totals, per day and model, quota
+func (h *InternalHandlers) TokenUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.SendError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		utils.SendError(w, http.StatusBadRequest, "user_id is required")
+		return
+	}
+
+	days := 30
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 366 {
+			utils.SendError(w, http.StatusBadRequest, "days must be between 1 and 366")
+			return
+		}
+		days = n
+	}
+
+	total, err := h.store.GetUserTokenUsage(r.Context(), userID)
+	if err != nil {
+		utils.SendError(w, http.StatusInternalServerError, "Failed to get token usage")
+		return
+	}
+
+	fromDay := time.Now().UTC().AddDate(0, 0, -(days - 1)).Format(time.DateOnly)
+	daily, err := h.store.ListDailyTokenUsage(r.Context(), userID, fromDay)
+	if err != nil {
+		utils.SendError(w, http.StatusInternalServerError, "Failed to get token usage")
+		return
+	}
+	if daily == nil {
+		daily = []*database.DailyTokenUsage{}
+	}
+
+	limit, err := h.store.GetUserTokenLimit(r.Context(), userID)
+	if err != nil {
+		utils.SendError(w, http.StatusInternalServerError, "Failed to get token limit")
+		return
+	}
+
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"user_id": userID,
+		"total":   total,
+		"daily":   daily,
+		"limit":   limit,
+	})
+}
+
+// /api/internal/token-limits - Per-user token quotas
+func (h *InternalHandlers) TokenLimits(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.listTokenLimits(w, r)
+	case http.MethodPut:
+		h.setTokenLimit(w, r)
+	case http.MethodDelete:
+		h.deleteTokenLimit(w, r)
+	default:
+		utils.SendError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// GET /api/internal/token-limits - List per-user token quotas
+func (h *InternalHandlers) listTokenLimits(w http.ResponseWriter, r *http.Request) {
+	limits, err := h.store.ListUserTokenLimits(r.Context())
+	if err != nil {
+		utils.SendError(w, http.StatusInternalServerError, "Failed to list token limits")
+		return
+	}
+	if limits == nil {
+		limits = []*database.UserTokenLimit{}
+	}
+
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"limits":  limits,
+	})
+}
+
+// PUT /api/internal/token-limits - Set the token quota of a user
+func (h *InternalHandlers) setTokenLimit(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		UserID    string `json:"user_id"`
+		MaxTokens int64  `json:"max_tokens"`
+		WindowMs  int64  `json:"window_ms"`
+	}
+	if err := utils.ParseJSON(r, &req); err != nil {
+		utils.SendError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	if req.UserID == "" {
+		utils.SendError(w, http.StatusBadRequest, "user_id is required")
+		return
+	}
+	if req.MaxTokens <= 0 {
+		utils.SendError(w, http.StatusBadRequest, "max_tokens must be > 0")
+		return
+	}
+	if req.WindowMs < 0 {
+		utils.SendError(w, http.StatusBadRequest, "window_ms must not be negative")
+		return
+	}
+	if req.WindowMs == 0 {
+		req.WindowMs = defaultTokenLimitWindowMs
+	}
+
+	limit := &database.UserTokenLimit{UserID: req.UserID, MaxTokens: req.MaxTokens, WindowMs: req.WindowMs}
+	if err := h.store.SetUserTokenLimit(r.Context(), limit); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to set token limit", logging.UserID(req.UserID), logging.Err(err))
+		utils.SendError(w, http.StatusInternalServerError, "Failed to set token limit")
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Token limit set", logging.UserID(req.UserID), "max_tokens", req.MaxTokens, "window_ms", req.WindowMs)
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"limit":   limit,
+	})
+}
+
+// DELETE /api/internal/token-limits?user_id=... - Remove the token quota of a user
+func (h *InternalHandlers) deleteTokenLimit(w http.ResponseWriter, r *http.Request) {
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		utils.SendError(w, http.StatusBadRequest, "user_id is required")
+		return
+	}
+
+	deleted, err := h.store.DeleteUserTokenLimit(r.Context(), userID)
+	if err != nil {
+		utils.SendError(w, http.StatusInternalServerError, "Failed to delete token limit")
+		return
+	}
+	if !deleted {
+		utils.SendError(w, http.StatusNotFound, "Token limit not found")
+		return
+	}
+
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"user_id": userID,
+	})
+}
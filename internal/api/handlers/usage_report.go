@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ad/go-llm-manager/internal/database"
+	"github.com/ad/go-llm-manager/internal/logging"
+	"github.com/ad/go-llm-manager/internal/utils"
+)
+
+// Отчёт по умолчанию: помесячно за последние 30 дней по пользователям и моделям
+const defaultUsageReportDays = 30
+
+var defaultUsageReportGroups = []string{database.UsageGroupUser, database.UsageGroupModel}
+
+var usageReportCSVHeader = []string{
+	"period", "user_id", "model", "status", "tasks", "completed", "failed", "failure_rate",
+	"processing_ms", "avg_processing_ms", "avg_queue_wait_ms",
+	"tasks_with_tokens", "prompt_tokens", "completion_tokens", "total_tokens",
+}
+
+// GET /api/internal/usage-report?period=month&from=YYYY-MM-DD&to=YYYY-MM-DD&user_id=...&group_by=user,model,status&format=json|csv
+// Usage of finished tasks aggregated by period (UTC) and the requested dimensions
+func (h *InternalHandlers) UsageReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		utils.SendError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+
+	period := query.Get("period")
+	if period == "" {
+		period = database.PeriodMonth
+	}
+	if period != database.PeriodDay && period != database.PeriodWeek && period != database.PeriodMonth {
+		utils.SendError(w, http.StatusBadRequest, "period must be 'day', 'week' or 'month'")
+		return
+	}
+
+	format := query.Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		utils.SendError(w, http.StatusBadRequest, "format must be 'json' or 'csv'")
+		return
+	}
+
+	// to включительно: отчёт за день to заканчивается в полночь следующего дня
+	to := time.Now().UTC().Truncate(24 * time.Hour)
+	if v := query.Get("to"); v != "" {
+		day, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			utils.SendError(w, http.StatusBadRequest, "to must be a date in YYYY-MM-DD format")
+			return
+		}
+		to = day
+	}
+	from := to.AddDate(0, 0, -(defaultUsageReportDays - 1))
+	if v := query.Get("from"); v != "" {
+		day, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			utils.SendError(w, http.StatusBadRequest, "from must be a date in YYYY-MM-DD format")
+			return
+		}
+		from = day
+	}
+	if from.After(to) {
+		utils.SendError(w, http.StatusBadRequest, "from must not be after to")
+		return
+	}
+
+	groupBy := defaultUsageReportGroups
+	if query.Has("group_by") {
+		groupBy = nil
+		for _, g := range strings.Split(query.Get("group_by"), ",") {
+			g = strings.TrimSpace(g)
+			if g == "" {
+				continue
+			}
+			if g != database.UsageGroupUser && g != database.UsageGroupModel && g != database.UsageGroupStatus {
+				utils.SendError(w, http.StatusBadRequest, "group_by must be a list of 'user', 'model', 'status'")
+				return
+			}
+			groupBy = append(groupBy, g)
+		}
+	}
+
+	filter := database.UsageReportFilter{
+		Period:  period,
+		From:    from.UnixMilli(),
+		To:      to.AddDate(0, 0, 1).UnixMilli(),
+		UserID:  query.Get("user_id"),
+		GroupBy: groupBy,
+	}
+
+	report, err := h.store.GetUsageReport(r.Context(), filter)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to build usage report", logging.Err(err))
+		utils.SendError(w, http.StatusInternalServerError, "Failed to build usage report")
+		return
+	}
+	if report == nil {
+		report = []*database.UsageReportRow{}
+	}
+
+	if format == "csv" {
+		writeUsageReportCSV(w, report, from, to)
+		return
+	}
+
+	utils.SendJSON(w, http.StatusOK, map[string]interface{}{
+		"success":  true,
+		"period":   period,
+		"from":     from.Format(time.DateOnly),
+		"to":       to.Format(time.DateOnly),
+		"group_by": groupBy,
+		"rows":     report,
+	})
+}
+
+// writeUsageReportCSV sends the report as a CSV attachment
+func writeUsageReportCSV(w http.ResponseWriter, report []*database.UsageReportRow, from, to time.Time) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="usage-%s-%s.csv"`,
+		from.Format(time.DateOnly), to.Format(time.DateOnly)))
+	w.WriteHeader(http.StatusOK)
+
+	cw := csv.NewWriter(w)
+	cw.Write(usageReportCSVHeader)
+	for _, row := range report {
+		cw.Write([]string{
+			row.Period, row.UserID, row.Model, row.Status,
+			strconv.FormatInt(row.Tasks, 10),
+			strconv.FormatInt(row.Completed, 10),
+			strconv.FormatInt(row.Failed, 10),
+			strconv.FormatFloat(row.FailureRate, 'f', 4, 64),
+			strconv.FormatInt(row.ProcessingMs, 10),
+			strconv.FormatFloat(row.AvgProcessingMs, 'f', 1, 64),
+			strconv.FormatFloat(row.AvgQueueWaitMs, 'f', 1, 64),
+			strconv.FormatInt(row.TasksWithTokens, 10),
+			strconv.FormatInt(row.PromptTokens, 10),
+			strconv.FormatInt(row.CompletionTokens, 10),
+			strconv.FormatInt(row.TotalTokens, 10),
+		})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		slog.Error("Failed to write usage report CSV", logging.Err(err))
+	}
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ad/go-llm-manager/internal/auth"
+	"github.com/ad/go-llm-manager/internal/database"
+)
+
+func TestUsageReportAggregatesLedger(t *testing.T) {
+	db := database.NewTestDB(t)
+	h := NewInternalHandlers(db, auth.NewJWTAuth("test"))
+
+	createProcessingTask(t, db, "a", "proc-1", 0, 3)
+	completeWithUsage(t, h, "a", map[string]interface{}{"model": "llama3", "prompt_tokens": 100, "completion_tokens": 20})
+
+	// Та же модель и пользователь, но задача упала без токенов
+	createProcessingTask(t, db, "b", "proc-1", 1, 3)
+	db.Exec(`UPDATE tasks SET user_id = 'u-a', ollama_params = '{"model":"llama3"}' WHERE id = 'b'`)
+	if ok, err := db.FailProcessingTask(t.Context(), "b", "proc-1", "boom"); err != nil || !ok {
+		t.Fatalf("fail task: %v %v", ok, err)
+	}
+
+	// Задачи удалены очисткой, учёт остаётся
+	if _, err := db.Exec(`DELETE FROM tasks`); err != nil {
+		t.Fatalf("delete tasks: %v", err)
+	}
+
+	entry, err := db.GetUsageLedgerEntry(t.Context(), "b")
+	if err != nil || entry == nil || entry.Status != "failed" || entry.Model != "llama3" || entry.RetryCount != 1 || entry.TotalTokens != nil {
+		t.Fatalf("unexpected ledger entry: %+v %v", entry, err)
+	}
+
+	rr := httptest.NewRecorder()
+	h.UsageReport(rr, httptest.NewRequest(http.MethodGet, "/api/internal/usage-report?period=day", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("report failed: %d %s", rr.Code, rr.Body.String())
+	}
+
+	var resp struct {
+		Rows []database.UsageReportRow `json:"rows"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if len(resp.Rows) != 1 {
+		t.Fatalf("expected one row, got %+v", resp.Rows)
+	}
+	row := resp.Rows[0]
+	today := time.Now().UTC().Format(time.DateOnly)
+	if row.Period != today || row.UserID != "u-a" || row.Model != "llama3" || row.Status != "" {
+		t.Errorf("unexpected group: %+v", row)
+	}
+	if row.Tasks != 2 || row.Completed != 1 || row.Failed != 1 || row.FailureRate != 0.5 {
+		t.Errorf("unexpected counts: %+v", row)
+	}
+	if row.TasksWithTokens != 1 || row.TotalTokens != 120 || row.PromptTokens != 100 {
+		t.Errorf("unexpected tokens: %+v", row)
+	}
+
+	rr = httptest.NewRecorder()
+	h.UsageReport(rr, httptest.NewRequest(http.MethodGet, "/api/internal/usage-report?group_by=status&format=csv", nil))
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid csv: %v", err)
+	}
+	if len(records) != 3 || records[0][0] != "period" {
+		t.Fatalf("unexpected csv: %v", records)
+	}
+	// Месяц, без пользователя и модели, строки по статусам в алфавитном порядке
+	if records[1][0] != today[:7] || records[1][1] != "" || records[1][3] != "completed" || records[2][3] != "failed" {
+		t.Errorf("unexpected csv rows: %v", records[1:])
+	}
+}
+
+func TestUsageReportValidation(t *testing.T) {
+	db := database.NewTestDB(t)
+	h := NewInternalHandlers(db, auth.NewJWTAuth("test"))
+
+	for _, query := range []string{
+		"period=hour",
+		"format=xml",
+		"from=2026-13-01",
+		"from=2026-02-01&to=2026-01-01",
+		"group_by=processor",
+	} {
+		rr := httptest.NewRecorder()
+		h.UsageReport(rr, httptest.NewRequest(http.MethodGet, "/api/internal/usage-report?"+query, nil))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/ad/go-llm-manager/internal/auth"
+	"github.com/ad/go-llm-manager/internal/config"
+	"github.com/ad/go-llm-manager/internal/database"
+)
+
+func completeWithUsage(t *testing.T, h *InternalHandlers, taskID string, body map[string]interface{}) {
+	t.Helper()
+	body["taskId"] = taskID
+	body["status"] = "completed"
+	b, _ := json.Marshal(body)
+	rr := httptest.NewRecorder()
+	h.CompleteTasks(rr, httptest.NewRequest(http.MethodPost, "/api/internal/complete", bytes.NewReader(b)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("complete failed: %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestCompleteRecordsTokenUsage(t *testing.T) {
+	db := database.NewTestDB(t)
+	h := NewInternalHandlers(db, auth.NewJWTAuth("test"))
+
+	model := "llama3"
+	task := &database.Task{ID: "t-1", UserID: "u-1", ProductData: "p", Status: "pending"}
+	task.SetOllamaParams(&database.OllamaParams{Model: &model})
+	if err := db.CreateTask(t.Context(), task); err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+
+	usage := map[string]interface{}{"prompt_tokens": 120, "completion_tokens": 30}
+	completeWithUsage(t, h, "t-1", usage)
+	// Повторное завершение не удваивает расход
+	completeWithUsage(t, h, "t-1", map[string]interface{}{"prompt_tokens": 120, "completion_tokens": 30})
+
+	got, _ := db.GetTaskUsage(t.Context(), "t-1")
+	if got == nil || got.Model != "llama3" || got.TotalTokens != 150 || got.UserID != "u-1" {
+		t.Fatalf("unexpected task usage: %+v", got)
+	}
+
+	rr := httptest.NewRecorder()
+	h.TokenUsage(rr, httptest.NewRequest(http.MethodGet, "/api/internal/token-usage?user_id=u-1", nil))
+	var resp struct {
+		Total database.UserTokenUsage    `json:"total"`
+		Daily []database.DailyTokenUsage `json:"daily"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &resp)
+	if resp.Total.TotalTokens != 150 || resp.Total.TaskCount != 1 || resp.Total.PromptTokens != 120 {
+		t.Errorf("unexpected totals: %+v", resp.Total)
+	}
+	if len(resp.Daily) != 1 || resp.Daily[0].Model != "llama3" || resp.Daily[0].CompletionTokens != 30 {
+		t.Errorf("unexpected daily usage: %+v", resp.Daily)
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{"taskId": "t-1", "status": "completed", "prompt_tokens": -1})
+	rr = httptest.NewRecorder()
+	h.CompleteTasks(rr, httptest.NewRequest(http.MethodPost, "/api/internal/complete", bytes.NewReader(body)))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for negative tokens, got %d", rr.Code)
+	}
+}
+
+func TestCreateTaskEnforcesTokenQuota(t *testing.T) {
+	db := database.NewTestDB(t)
+	jwtAuth := auth.NewJWTAuth("test")
+	cfg := &config.Config{RateLimit: config.RateLimitConfig{TokenLimit: 1000, TokenLimitWindowMs: 3600000}}
+	public := NewPublicHandlers(db, jwtAuth, cfg)
+	internal := NewInternalHandlers(db, jwtAuth)
+
+	tokenFor := func(tokenLimit *database.TokenLimitConfig) string {
+		token, _ := jwtAuth.GenerateToken(&database.JWTPayload{
+			Subject: "q-user", UserID: "q-user", ProductData: "p", TokenLimit: tokenLimit,
+		}, 3600)
+		return token
+	}
+	create := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/create", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		public.CreateTask(rr, req)
+		return rr
+	}
+	getData := func(token string) map[string]interface{} {
+		rr := httptest.NewRecorder()
+		public.GetUserData(rr, httptest.NewRequest(http.MethodGet, "/api/get?token="+url.QueryEscape(token), nil))
+		var resp map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &resp)
+		return resp["rate_limit"].(map[string]interface{})
+	}
+
+	token := tokenFor(nil)
+	rr := create(token)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var created struct {
+		TaskID string `json:"taskId"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &created)
+	completeWithUsage(t, internal, created.TaskID, map[string]interface{}{"prompt_tokens": 900, "completion_tokens": 100})
+
+	if rr := create(token); rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 when the token quota is used up, got %d", rr.Code)
+	}
+	if rl := getData(token); rl["token_count"] != float64(1000) || rl["token_limit"] != float64(1000) {
+		t.Errorf("unexpected token usage in /api/get: %v", rl)
+	}
+
+	// Лимит в JWT важнее конфигурации
+	if rr := create(tokenFor(&database.TokenLimitConfig{MaxTokens: 5000})); rr.Code != http.StatusCreated {
+		t.Fatalf("expected JWT token_limit to raise the quota, got %d", rr.Code)
+	}
+	db.Exec(`UPDATE tasks SET status = 'completed' WHERE user_id = 'q-user'`)
+
+	// Индивидуальный лимит пользователя важнее конфигурации
+	body, _ := json.Marshal(map[string]interface{}{"user_id": "q-user", "max_tokens": 2000})
+	rr = httptest.NewRecorder()
+	internal.TokenLimits(rr, httptest.NewRequest(http.MethodPut, "/api/internal/token-limits", bytes.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("set limit failed: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := create(token); rr.Code != http.StatusCreated {
+		t.Fatalf("expected per-user limit to apply, got %d", rr.Code)
+	}
+	if rl := getData(token); rl["token_limit"] != float64(2000) || rl["token_window_ms"] != float64(defaultTokenLimitWindowMs) {
+		t.Errorf("unexpected token limit in /api/get: %v", rl)
+	}
+
+	rr = httptest.NewRecorder()
+	internal.TokenLimits(rr, httptest.NewRequest(http.MethodDelete, "/api/internal/token-limits?user_id=q-user", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("delete limit failed: %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	internal.TokenLimits(rr, httptest.NewRequest(http.MethodDelete, "/api/internal/token-limits?user_id=q-user", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for missing limit, got %d", rr.Code)
+	}
+}
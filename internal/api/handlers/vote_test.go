@@ -26,7 +26,7 @@ func TestVoteTask(t *testing.T) {
 	defer db.Close()
 
 	// Run migrations
-	if err := db.RunMigrations(); err != nil {
+	if err := db.RunMigrations(t.Context()); err != nil {
 		t.Fatalf("Failed to run migrations: %v", err)
 	}
 
@@ -62,7 +62,7 @@ func TestVoteTask(t *testing.T) {
 	}
 
 	// Insert task into database
-	err = db.CreateTask(task)
+	err = db.CreateTask(t.Context(), task)
 	if err != nil {
 		t.Fatalf("Failed to create test task: %v", err)
 	}
@@ -113,7 +113,7 @@ func TestVoteTask(t *testing.T) {
 		}
 
 		// Verify in database
-		updatedTask, err := db.GetTask(taskID)
+		updatedTask, err := db.GetTask(t.Context(), taskID)
 		if err != nil {
 			t.Fatalf("Failed to get updated task: %v", err)
 		}
@@ -166,7 +166,7 @@ func TestVoteTask(t *testing.T) {
 		}
 
 		// Verify in database
-		updatedTask, err := db.GetTask(taskID)
+		updatedTask, err := db.GetTask(t.Context(), taskID)
 		if err != nil {
 			t.Fatalf("Failed to get updated task: %v", err)
 		}
@@ -234,7 +234,7 @@ func TestVoteTask(t *testing.T) {
 			UpdatedAt:   1234567890,
 		}
 
-		err = db.CreateTask(pendingTask)
+		err = db.CreateTask(t.Context(), pendingTask)
 		if err != nil {
 			t.Fatalf("Failed to create pending task: %v", err)
 		}
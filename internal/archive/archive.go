@@ -0,0 +1,301 @@
+// Package archive moves expired finished tasks, with their ratings and events, out
+// of the database into daily gzip-compressed JSONL files and imports them back.
+//
+// A file tasks-YYYY-MM-DD.jsonl.gz holds the tasks completed on that UTC day, one
+// database.ArchivedTask per line. Later runs append to the file as new gzip members,
+// which gzip, zcat and Go's gzip.Reader read as one stream.
+package archive
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ad/go-llm-manager/internal/database"
+	"github.com/ad/go-llm-manager/internal/metrics"
+)
+
+const (
+	filePrefix = "tasks-"
+	fileExt    = ".jsonl.gz"
+
+	// Задач за один запрос к БД и за одну транзакцию удаления
+	batchSize = 500
+)
+
+// ErrInvalidName is returned by Import for names that are not archive files
+var ErrInvalidName = errors.New("invalid archive name")
+
+// File is a daily archive file
+type File struct {
+	Name       string `json:"name"`
+	Day        string `json:"day"` // YYYY-MM-DD, UTC
+	Size       int64  `json:"size"`
+	ModifiedAt int64  `json:"modified_at"` // unix ms
+}
+
+// ImportResult counts the tasks of an imported archive
+type ImportResult struct {
+	Imported int `json:"imported"` // добавлены или обновлены
+	Skipped  int `json:"skipped"`  // уже есть в БД
+}
+
+// Archiver writes expired tasks to the archive directory before deleting them
+type Archiver struct {
+	db  *database.DB
+	dir string
+
+	mu sync.Mutex // один запуск за раз: файлы дня перезаписываются целиком
+}
+
+func NewArchiver(db *database.DB, dir string) *Archiver {
+	return &Archiver{db: db, dir: dir}
+}
+
+// Archive moves the tasks of a finished status completed before `before` (unix ms)
+// to the archive. The file of a day is written and synced before its tasks are
+// deleted, so a failure leaves them in the database; the next run archives them
+// again, and Import skips the duplicates. Returns the number of deleted tasks.
+func (a *Archiver) Archive(ctx context.Context, status string, before int64) (int64, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var archived int64
+	var day *dayFile
+	var pending []*database.Task
+	defer func() {
+		if day != nil {
+			day.abort()
+		}
+	}()
+
+	// Файл дня готов: удаляем его задачи
+	flush := func() error {
+		if day == nil {
+			return nil
+		}
+		err := day.commit()
+		day = nil
+		if err != nil {
+			return err
+		}
+		for len(pending) > 0 {
+			n := min(batchSize, len(pending))
+			deleted, err := a.db.DeleteArchivedTasks(ctx, pending[:n])
+			archived += deleted
+			metrics.TasksArchived.Add(float64(deleted), status)
+			if err != nil {
+				return err
+			}
+			pending = pending[n:]
+		}
+		return nil
+	}
+
+	var afterCompletedAt int64
+	var afterID string
+	for {
+		batch, err := a.db.ListExpiredTasks(ctx, status, before, afterCompletedAt, afterID, batchSize)
+		if err != nil {
+			return archived, err
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		for _, t := range batch {
+			d := dayOf(*t.Task.CompletedAt)
+			if day != nil && day.day != d {
+				if err := flush(); err != nil {
+					return archived, err
+				}
+			}
+			if day == nil {
+				if day, err = openDay(a.dir, d); err != nil {
+					return archived, err
+				}
+			}
+			if err := day.enc.Encode(t); err != nil {
+				return archived, err
+			}
+			pending = append(pending, t.Task)
+		}
+
+		last := batch[len(batch)-1].Task
+		afterCompletedAt, afterID = *last.CompletedAt, last.ID
+	}
+
+	return archived, flush()
+}
+
+// List returns the archive files, newest day first
+func (a *Archiver) List() ([]*File, error) {
+	entries, err := os.ReadDir(a.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*File
+	for _, entry := range entries {
+		day, ok := parseName(entry.Name())
+		if !ok || entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, &File{
+			Name:       entry.Name(),
+			Day:        day,
+			Size:       info.Size(),
+			ModifiedAt: info.ModTime().UnixMilli(),
+		})
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Day > files[j].Day })
+	return files, nil
+}
+
+// Import restores the tasks of an archive file given by name. Tasks already in the
+// database are skipped unless the archived copy is newer. On error the tasks read
+// so far stay imported.
+func (a *Archiver) Import(ctx context.Context, name string) (*ImportResult, error) {
+	if _, ok := parseName(name); !ok || filepath.Base(name) != name {
+		return nil, ErrInvalidName
+	}
+
+	f, err := os.Open(filepath.Join(a.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	result := &ImportResult{}
+	dec := json.NewDecoder(zr)
+	for line := 1; ; line++ {
+		var task database.ArchivedTask
+		if err := dec.Decode(&task); err == io.EOF {
+			return result, nil
+		} else if err != nil {
+			return result, fmt.Errorf("%s: record %d: %w", name, line, err)
+		}
+		if task.Task == nil || task.Task.ID == "" {
+			return result, fmt.Errorf("%s: record %d: no task", name, line)
+		}
+
+		changed, err := a.db.ImportArchivedTask(ctx, &task)
+		if err != nil {
+			return result, fmt.Errorf("%s: task %s: %w", name, task.Task.ID, err)
+		}
+		if changed {
+			result.Imported++
+		} else {
+			result.Skipped++
+		}
+	}
+}
+
+func dayOf(ms int64) string {
+	return time.UnixMilli(ms).UTC().Format(time.DateOnly)
+}
+
+// parseName returns the day of an archive file name; ok is false for other files
+func parseName(name string) (string, bool) {
+	day, found := strings.CutPrefix(name, filePrefix)
+	if !found {
+		return "", false
+	}
+	day, found = strings.CutSuffix(day, fileExt)
+	if !found {
+		return "", false
+	}
+	if _, err := time.Parse(time.DateOnly, day); err != nil {
+		return "", false
+	}
+	return day, true
+}
+
+// dayFile is the archive of a day being written: a temporary copy of the existing
+// file with a new gzip member appended, renamed over the file on commit
+type dayFile struct {
+	day  string
+	path string
+	tmp  *os.File
+	zw   *gzip.Writer
+	enc  *json.Encoder
+}
+
+func openDay(dir, day string) (*dayFile, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+
+	path := filepath.Join(dir, filePrefix+day+fileExt)
+	tmp, err := os.CreateTemp(dir, filePrefix+day+"-*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	d := &dayFile{day: day, path: path, tmp: tmp}
+
+	existing, err := os.Open(path)
+	switch {
+	case err == nil:
+		_, err = io.Copy(tmp, existing)
+		existing.Close()
+		if err != nil {
+			d.abort()
+			return nil, err
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		d.abort()
+		return nil, err
+	}
+
+	d.zw = gzip.NewWriter(tmp)
+	d.enc = json.NewEncoder(d.zw)
+	return d, nil
+}
+
+func (d *dayFile) commit() error {
+	if err := d.zw.Close(); err != nil {
+		d.abort()
+		return err
+	}
+	if err := d.tmp.Sync(); err != nil {
+		d.abort()
+		return err
+	}
+	if err := d.tmp.Close(); err != nil {
+		os.Remove(d.tmp.Name())
+		return err
+	}
+	if err := os.Rename(d.tmp.Name(), d.path); err != nil {
+		os.Remove(d.tmp.Name())
+		return err
+	}
+	return nil
+}
+
+func (d *dayFile) abort() {
+	d.tmp.Close()
+	os.Remove(d.tmp.Name())
+}
@@ -0,0 +1,193 @@
+package archive
+
+import (
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ad/go-llm-manager/internal/database"
+)
+
+// finishedTask creates a task and marks it finished at completedAt
+func finishedTask(t *testing.T, db *database.DB, id, status string, completedAt time.Time) {
+	t.Helper()
+	task := &database.Task{ID: id, UserID: "user-" + id, ProductData: `{"q":"` + id + `"}`, Status: database.TaskStatusPending, MaxRetries: 3}
+	if err := db.CreateTask(t.Context(), task); err != nil {
+		t.Fatalf("CreateTask(%s): %v", id, err)
+	}
+	ms := completedAt.UnixMilli()
+	_, err := db.Exec(`UPDATE tasks SET status = ?, result = ?, completed_at = ?, updated_at = ? WHERE id = ?`, status, "result "+id, ms, ms, id)
+	if err != nil {
+		t.Fatalf("finish %s: %v", id, err)
+	}
+	if err := db.LogTaskEvent(t.Context(), id, database.TaskEventRequeued, "proc-1", "heartbeat timeout"); err != nil {
+		t.Fatalf("LogTaskEvent(%s): %v", id, err)
+	}
+}
+
+func readArchive(t *testing.T, path string) []database.ArchivedTask {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tasks []database.ArchivedTask
+	dec := json.NewDecoder(zr)
+	for {
+		var task database.ArchivedTask
+		if err := dec.Decode(&task); err == io.EOF {
+			return tasks
+		} else if err != nil {
+			t.Fatalf("decode %s: %v", path, err)
+		}
+		tasks = append(tasks, task)
+	}
+}
+
+func TestArchiveAndImport(t *testing.T) {
+	ctx := t.Context()
+	db := database.NewTestDB(t)
+	a := NewArchiver(db, t.TempDir())
+
+	day1 := time.Date(2026, 10, 1, 10, 0, 0, 0, time.UTC)
+	day2 := time.Date(2026, 10, 2, 23, 59, 0, 0, time.UTC)
+	finishedTask(t, db, "a", database.TaskStatusCompleted, day1)
+	finishedTask(t, db, "b", database.TaskStatusCompleted, day2)
+	finishedTask(t, db, "c", database.TaskStatusCompleted, day2.Add(2*time.Minute)) // уже 3 октября
+	finishedTask(t, db, "failed", database.TaskStatusFailed, day1)
+	if err := db.UpdateTaskRating(ctx, "a", "user-a", ptr("upvote")); err != nil {
+		t.Fatalf("UpdateTaskRating: %v", err)
+	}
+
+	archived, err := a.Archive(ctx, database.TaskStatusCompleted, day2.Add(time.Minute).UnixMilli())
+	if err != nil {
+		t.Fatalf("Archive: %v", err)
+	}
+	if archived != 2 {
+		t.Fatalf("archived %d tasks, want 2", archived)
+	}
+
+	for id, want := range map[string]bool{"a": false, "b": false, "c": true, "failed": true} {
+		_, err := db.GetTask(ctx, id)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			t.Fatalf("GetTask(%s): %v", id, err)
+		}
+		if got := err == nil; got != want {
+			t.Errorf("task %s in database = %v, want %v", id, got, want)
+		}
+	}
+
+	files, err := a.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(files) != 2 || files[0].Name != "tasks-2026-10-02.jsonl.gz" || files[1].Day != "2026-10-01" {
+		t.Fatalf("List = %+v, want archives of 2026-10-02 and 2026-10-01", files)
+	}
+
+	records := readArchive(t, filepath.Join(a.dir, files[1].Name))
+	if len(records) != 1 {
+		t.Fatalf("2026-10-01 archive has %d records, want 1", len(records))
+	}
+	rec := records[0]
+	if rec.Task.ID != "a" || rec.Task.UserRating == nil || *rec.Task.UserRating != "upvote" || rec.Task.Result == nil || len(rec.Events) != 1 {
+		t.Errorf("unexpected archived task %+v with events %v", rec.Task, rec.Events)
+	}
+
+	// Импорт возвращает задачу вместе с оценкой и событиями, повторный ничего не меняет
+	result, err := a.Import(ctx, files[1].Name)
+	if err != nil || result.Imported != 1 || result.Skipped != 0 {
+		t.Fatalf("Import = %+v, %v; want 1 imported", result, err)
+	}
+	task, err := db.GetTask(ctx, "a")
+	if err != nil {
+		t.Fatalf("GetTask after import: %v", err)
+	}
+	if task.UserRating == nil || *task.UserRating != "upvote" || *task.CompletedAt != day1.UnixMilli() {
+		t.Errorf("imported task %+v differs from archived", task)
+	}
+	events, err := db.GetTaskEvents(ctx, "a")
+	if err != nil || len(events) != 1 || events[0].EventType != database.TaskEventRequeued {
+		t.Errorf("imported events = %v (%v), want the archived event", events, err)
+	}
+
+	result, err = a.Import(ctx, files[1].Name)
+	if err != nil || result.Imported != 0 || result.Skipped != 1 {
+		t.Fatalf("second Import = %+v, %v; want 1 skipped", result, err)
+	}
+}
+
+func TestArchiveAppendsToDayFile(t *testing.T) {
+	ctx := t.Context()
+	db := database.NewTestDB(t)
+	a := NewArchiver(db, t.TempDir())
+
+	day := time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC)
+	finishedTask(t, db, "first", database.TaskStatusFailed, day)
+	if _, err := a.Archive(ctx, database.TaskStatusFailed, day.Add(time.Hour).UnixMilli()); err != nil {
+		t.Fatalf("Archive: %v", err)
+	}
+	finishedTask(t, db, "second", database.TaskStatusFailed, day.Add(2*time.Hour))
+	if _, err := a.Archive(ctx, database.TaskStatusFailed, day.Add(3*time.Hour).UnixMilli()); err != nil {
+		t.Fatalf("second Archive: %v", err)
+	}
+
+	records := readArchive(t, filepath.Join(a.dir, "tasks-2026-10-01.jsonl.gz"))
+	if len(records) != 2 || records[0].Task.ID != "first" || records[1].Task.ID != "second" {
+		t.Fatalf("day archive has %d records, want first and second", len(records))
+	}
+
+	entries, _ := os.ReadDir(a.dir)
+	if len(entries) != 1 {
+		t.Errorf("archive directory has %d files, want only the day archive", len(entries))
+	}
+}
+
+func TestImportRejectsInvalidNames(t *testing.T) {
+	a := NewArchiver(database.NewTestDB(t), t.TempDir())
+	for _, name := range []string{"", "../tasks-2026-10-01.jsonl.gz", "tasks-2026-13-01.jsonl.gz", "backup.db"} {
+		if _, err := a.Import(t.Context(), name); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("Import(%q) error = %v, want ErrInvalidName", name, err)
+		}
+	}
+	if _, err := a.Import(t.Context(), "tasks-2026-10-01.jsonl.gz"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Import of a missing archive error = %v, want ErrNotExist", err)
+	}
+}
+
+func TestDeleteArchivedTasksKeepsChangedTasks(t *testing.T) {
+	ctx := t.Context()
+	db := database.NewTestDB(t)
+	finishedTask(t, db, "rated", database.TaskStatusCompleted, time.Now().Add(-time.Hour))
+
+	expired, err := db.ListExpiredTasks(ctx, database.TaskStatusCompleted, time.Now().UnixMilli(), 0, "", batchSize)
+	if err != nil || len(expired) != 1 {
+		t.Fatalf("ListExpiredTasks = %d tasks, %v; want 1", len(expired), err)
+	}
+
+	// Оценка после записи в архив: задача остается до следующего запуска
+	if err := db.UpdateTaskRating(ctx, "rated", "user-rated", ptr("downvote")); err != nil {
+		t.Fatalf("UpdateTaskRating: %v", err)
+	}
+	deleted, err := db.DeleteArchivedTasks(ctx, []*database.Task{expired[0].Task})
+	if err != nil || deleted != 0 {
+		t.Fatalf("DeleteArchivedTasks = %d, %v; want the changed task kept", deleted, err)
+	}
+	if _, err := db.GetTask(ctx, "rated"); err != nil {
+		t.Fatalf("changed task deleted: %v", err)
+	}
+}
+
+func ptr(s string) *string { return &s }
@@ -1,42 +1,84 @@
 package auth
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"log/slog"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/ad/go-llm-manager/internal/database"
+	"github.com/ad/go-llm-manager/internal/logging"
 )
 
 var (
-	ErrMissingAPIKey = errors.New("missing API key")
-	ErrInvalidAPIKey = errors.New("invalid API key")
+	ErrMissingAPIKey     = errors.New("missing API key")
+	ErrInvalidAPIKey     = errors.New("invalid API key")
+	ErrInsufficientScope = errors.New("API key lacks required scope")
+)
+
+// API key scopes
+const (
+	ScopeProcessor  = "processor"
+	ScopeAdminRead  = "admin-read"
+	ScopeAdminWrite = "admin-write"
+	ScopeTokenMint  = "token-mint"
+	ScopeIntrospect = "introspect"
+	ScopeMetrics    = "metrics"
 )
 
+// AllScopes lists every known scope (granted to the bootstrap INTERNAL_API_KEY)
+var AllScopes = []string{ScopeProcessor, ScopeAdminRead, ScopeAdminWrite, ScopeTokenMint, ScopeIntrospect, ScopeMetrics}
+
+// IsValidScope reports whether scope is a known API key scope
+func IsValidScope(scope string) bool {
+	for _, s := range AllScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// last_used_at пишется не чаще раза в минуту на ключ
+const apiKeyTouchInterval = time.Minute
+
 type APIKeyManager struct {
 	internalAPIKey string
+	store          database.APIKeyStore
+
+	mu        sync.Mutex
+	lastTouch map[string]time.Time
 }
 
-func NewAPIKeyManager(key string) *APIKeyManager {
+// NewAPIKeyManager creates a manager with the bootstrap key (all scopes) and, if store
+// is not nil, the keys stored in it
+func NewAPIKeyManager(key string, store database.APIKeyStore) *APIKeyManager {
 	return &APIKeyManager{
 		internalAPIKey: key,
+		store:          store,
+		lastTouch:      make(map[string]time.Time),
 	}
 }
 
-func (a *APIKeyManager) ValidateAPIKey(authHeader string) error {
+func (a *APIKeyManager) ValidateAPIKey(ctx context.Context, authHeader string) error {
 	if authHeader == "" {
 		return ErrMissingAPIKey
 	}
 
-	// Extract Bearer token
-	parts := strings.SplitN(authHeader, " ", 2)
-	if len(parts) != 2 || parts[0] != "Bearer" {
-		return ErrInvalidAPIKey
-	}
-
-	apiKey := parts[1]
-	if apiKey != a.internalAPIKey {
+	apiKey := a.ExtractAPIKey(authHeader)
+	if apiKey == "" {
 		return ErrInvalidAPIKey
 	}
 
-	return nil
+	_, err := a.Authenticate(ctx, apiKey)
+	return err
 }
 
 func (a *APIKeyManager) ExtractAPIKey(authHeader string) string {
@@ -47,7 +89,83 @@ func (a *APIKeyManager) ExtractAPIKey(authHeader string) string {
 	return ""
 }
 
-// ValidateKey validates API key directly
-func (a *APIKeyManager) ValidateKey(key string) bool {
-	return key != "" && key == a.internalAPIKey
+// ValidateKey validates API key directly (any scope)
+func (a *APIKeyManager) ValidateKey(ctx context.Context, key string) bool {
+	_, err := a.Authenticate(ctx, key)
+	return err == nil
+}
+
+// HasScope reports whether the key is valid and grants scope
+func (a *APIKeyManager) HasScope(ctx context.Context, key, scope string) bool {
+	scopes, err := a.Authenticate(ctx, key)
+	if err != nil {
+		return false
+	}
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticate verifies the key and returns its scopes
+func (a *APIKeyManager) Authenticate(ctx context.Context, key string) ([]string, error) {
+	if key == "" {
+		return nil, ErrMissingAPIKey
+	}
+
+	if a.internalAPIKey != "" && subtle.ConstantTimeCompare([]byte(key), []byte(a.internalAPIKey)) == 1 {
+		return AllScopes, nil
+	}
+
+	if a.store == nil {
+		return nil, ErrInvalidAPIKey
+	}
+
+	keyHash := HashAPIKey(key)
+	stored, err := a.store.GetAPIKeyByHash(ctx, keyHash)
+	if err != nil {
+		slog.Error("Failed to look up API key", logging.Err(err))
+		return nil, ErrInvalidAPIKey
+	}
+	if stored == nil || subtle.ConstantTimeCompare([]byte(stored.KeyHash), []byte(keyHash)) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+	if !stored.IsActive(time.Now().UnixMilli()) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	a.touch(ctx, stored.ID)
+
+	return stored.Scopes, nil
+}
+
+func (a *APIKeyManager) touch(ctx context.Context, id string) {
+	a.mu.Lock()
+	if time.Since(a.lastTouch[id]) < apiKeyTouchInterval {
+		a.mu.Unlock()
+		return
+	}
+	a.lastTouch[id] = time.Now()
+	a.mu.Unlock()
+
+	if err := a.store.TouchAPIKey(ctx, id); err != nil {
+		slog.Error("Failed to update last_used_at of API key", "key_id", id, logging.Err(err))
+	}
+}
+
+// GenerateAPIKey returns a new random plaintext API key
+func GenerateAPIKey() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return "llmk_" + base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+// HashAPIKey returns the hex SHA-256 hash under which the key is stored
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
 }
@@ -0,0 +1,18 @@
+package auth
+
+import "context"
+
+type contextKey string
+
+const processorIDKey contextKey = "processor_id"
+
+// WithProcessorID binds an authenticated processor identity to the request context
+func WithProcessorID(ctx context.Context, processorID string) context.Context {
+	return context.WithValue(ctx, processorIDKey, processorID)
+}
+
+// ProcessorIDFromContext returns the processor identity bound by the auth middleware
+func ProcessorIDFromContext(ctx context.Context) (string, bool) {
+	processorID, ok := ctx.Value(processorIDKey).(string)
+	return processorID, ok && processorID != ""
+}
@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/ad/go-llm-manager/internal/database"
+	"github.com/ad/go-llm-manager/internal/logging"
+)
+
+// How often the denylist is reloaded from the database and pruned
+const denylistRefreshInterval = time.Minute
+
+// Denylist is an in-memory cache of revoked token IDs (jti) and per-subject
+// "issued before" cutoffs. Revocations made through it are applied to the cache
+// immediately; the cache is also reloaded periodically.
+type Denylist struct {
+	store database.RevocationStore
+
+	mu      sync.RWMutex
+	revoked map[string]int64            // jti -> expires_at (unix ms)
+	cutoffs map[string]map[string]int64 // subject type -> subject ID -> not_before (unix ms, целые секунды)
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewDenylist creates a denylist and loads it from the store
+func NewDenylist(ctx context.Context, store database.RevocationStore) (*Denylist, error) {
+	d := &Denylist{
+		store: store,
+		stop:  make(chan struct{}),
+	}
+	if err := d.Reload(ctx); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Start periodically prunes expired entries and reloads the cache
+func (d *Denylist) Start() {
+	go func() {
+		ticker := time.NewTicker(denylistRefreshInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := d.Prune(context.Background()); err != nil {
+					slog.Error("Failed to prune revoked tokens", logging.Err(err))
+				}
+				if err := d.Reload(context.Background()); err != nil {
+					slog.Error("Failed to reload token denylist", logging.Err(err))
+				}
+			case <-d.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop halts the background refresh
+func (d *Denylist) Stop() {
+	d.stopOnce.Do(func() { close(d.stop) })
+}
+
+// Reload replaces the cache with the current database state
+func (d *Denylist) Reload(ctx context.Context) error {
+	revoked, err := d.store.ListRevokedTokenIDs(ctx, time.Now().UnixMilli())
+	if err != nil {
+		return err
+	}
+	cutoffs, err := d.store.ListTokenCutoffs(ctx)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	d.revoked = revoked
+	d.cutoffs = cutoffs
+	d.mu.Unlock()
+
+	return nil
+}
+
+// Prune removes entries of tokens that have already expired
+func (d *Denylist) Prune(ctx context.Context) (int64, error) {
+	now := time.Now().UnixMilli()
+
+	deleted, err := d.store.PruneRevokedTokens(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+
+	d.mu.Lock()
+	for jti, expiresAt := range d.revoked {
+		if expiresAt <= now {
+			delete(d.revoked, jti)
+		}
+	}
+	d.mu.Unlock()
+
+	return deleted, nil
+}
+
+// RevokeToken denylists a single token until its expiry (unix ms)
+func (d *Denylist) RevokeToken(ctx context.Context, jti string, expiresAt int64) error {
+	if err := d.store.RevokeTokenID(ctx, jti, expiresAt); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	if expiresAt > d.revoked[jti] {
+		d.revoked[jti] = expiresAt
+	}
+	d.mu.Unlock()
+
+	return nil
+}
+
+// RevokeSubject invalidates all tokens of the subject issued before the current
+// second. Returns the cutoff (unix ms, whole seconds).
+func (d *Denylist) RevokeSubject(ctx context.Context, subjectType, subjectID string) (int64, error) {
+	notBefore, err := d.store.RevokeTokensBefore(ctx, subjectType, subjectID)
+	if err != nil {
+		return 0, err
+	}
+
+	d.mu.Lock()
+	if d.cutoffs[subjectType] == nil {
+		d.cutoffs[subjectType] = make(map[string]int64)
+	}
+	d.cutoffs[subjectType][subjectID] = notBefore
+	d.mu.Unlock()
+
+	return notBefore, nil
+}
+
+// Check returns ErrTokenRevoked if the token is denylisted by jti or was issued
+// before a cutoff of its user or processor. iat has one-second precision, so
+// tokens issued in the second of the cutoff stay valid: a token reissued right
+// after "revoke all" must work, and one minted just before the revoke in that
+// same second is accepted too.
+func (d *Denylist) Check(payload *database.JWTPayload) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if payload.ID != "" {
+		if _, revoked := d.revoked[payload.ID]; revoked {
+			return ErrTokenRevoked
+		}
+	}
+
+	// Сравниваем в секундах: iat точнее не бывает
+	if payload.UserID != "" {
+		if cutoff, ok := d.cutoffs[database.TokenSubjectUser][payload.UserID]; ok && payload.IssuedAt < cutoff/1000 {
+			return ErrTokenRevoked
+		}
+	}
+	if payload.ProcessorID != "" {
+		if cutoff, ok := d.cutoffs[database.TokenSubjectProcessor][payload.ProcessorID]; ok && payload.IssuedAt < cutoff/1000 {
+			return ErrTokenRevoked
+		}
+	}
+
+	return nil
+}
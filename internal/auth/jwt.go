@@ -15,23 +15,40 @@ import (
 )
 
 type JWTManager struct {
-	secretKey []byte
+	keyring *Keyring
 }
 
 func NewJWTManager(secret string) *JWTManager {
+	return NewJWTManagerWithKeyring(NewHMACKeyring(secret))
+}
+
+// NewJWTManagerWithKeyring creates a manager that signs with the active key of the keyring
+func NewJWTManagerWithKeyring(keyring *Keyring) *JWTManager {
 	return &JWTManager{
-		secretKey: []byte(secret),
+		keyring: keyring,
 	}
 }
 
+// JWKS returns the public verification keys of the keyring
+func (j *JWTManager) JWKS() JSONWebKeySet {
+	return j.keyring.JWKS()
+}
+
+// GenerateToken signs a token for the payload. A token ID (jti) is generated and
+// stored in payload.ID unless the caller set one.
 func (j *JWTManager) GenerateToken(payload *database.JWTPayload, expiresIn int) (string, error) {
 	now := time.Now()
 
+	if payload.ID == "" {
+		payload.ID = uuid.New().String()
+	}
+
 	claims := jwt.MapClaims{
 		"iss":     payload.Issuer,
 		"sub":     payload.Subject,
 		"exp":     now.Add(time.Duration(expiresIn) * time.Second).Unix(),
 		"iat":     now.Unix(),
+		"jti":     payload.ID,
 		"user_id": payload.UserID,
 	}
 
@@ -47,6 +64,9 @@ func (j *JWTManager) GenerateToken(payload *database.JWTPayload, expiresIn int)
 	if payload.ProductData != "" {
 		claims["product_data"] = payload.ProductData
 	}
+	if payload.ProductDataSHA256 != "" {
+		claims["product_data_sha256"] = payload.ProductDataSHA256
+	}
 	if payload.Priority != nil {
 		claims["priority"] = *payload.Priority
 	}
@@ -59,18 +79,25 @@ func (j *JWTManager) GenerateToken(payload *database.JWTPayload, expiresIn int)
 	if payload.RateLimit != nil {
 		claims["rate_limit"] = payload.RateLimit
 	}
+	if payload.TokenLimit != nil {
+		claims["token_limit"] = payload.TokenLimit
+	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(j.secretKey)
+	return j.keyring.sign(claims)
 }
 
 func (j *JWTManager) VerifyToken(tokenString string) (*database.JWTPayload, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return j.secretKey, nil
-	})
+	claims, err := j.parseClaims(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	return payloadFromClaims(claims), nil
+}
+
+// parseClaims verifies the token signature and expiry and returns its claims
+func (j *JWTManager) parseClaims(tokenString string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, j.keyring.keyfunc)
 
 	if err != nil {
 		return nil, err
@@ -82,27 +109,42 @@ func (j *JWTManager) VerifyToken(tokenString string) (*database.JWTPayload, erro
 
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
-		return nil, fmt.Errorf("invalid claims")
+		return nil, fmt.Errorf("invalid token claims")
 	}
 
+	return claims, nil
+}
+
+// payloadFromClaims maps JWT claims to JWTPayload (field names match TypeScript)
+func payloadFromClaims(claims jwt.MapClaims) *database.JWTPayload {
 	payload := &database.JWTPayload{}
 
-	// Extract required fields
-	if iss, ok := claims["iss"].(string); ok {
-		payload.Issuer = iss
+	// Extract user_id and sub (TypeScript uses both)
+	if userID, ok := claims["user_id"].(string); ok {
+		payload.UserID = userID
 	}
-	if aud, ok := claims["aud"].(string); ok {
-		payload.Audience = aud
+	if subject, ok := claims["sub"].(string); ok {
+		payload.Subject = subject
+		// If user_id is empty, use sub as fallback (matching TypeScript logic)
+		if payload.UserID == "" {
+			payload.UserID = subject
+		}
 	}
-	if sub, ok := claims["sub"].(string); ok {
-		payload.Subject = sub
+	if issuer, ok := claims["iss"].(string); ok {
+		payload.Issuer = issuer
 	}
-	if userID, ok := claims["user_id"].(string); ok {
-		payload.UserID = userID
+	if audience, ok := claims["aud"].(string); ok {
+		payload.Audience = audience
 	}
 	if exp, ok := claims["exp"].(float64); ok {
 		payload.ExpiresAt = int64(exp)
 	}
+	if iat, ok := claims["iat"].(float64); ok {
+		payload.IssuedAt = int64(iat)
+	}
+	if jti, ok := claims["jti"].(string); ok {
+		payload.ID = jti
+	}
 
 	// Extract optional fields
 	if taskID, ok := claims["taskId"].(string); ok {
@@ -111,9 +153,12 @@ func (j *JWTManager) VerifyToken(tokenString string) (*database.JWTPayload, erro
 	if productData, ok := claims["product_data"].(string); ok {
 		payload.ProductData = productData
 	}
+	if productDataSHA256, ok := claims["product_data_sha256"].(string); ok {
+		payload.ProductDataSHA256 = productDataSHA256
+	}
 	if priority, ok := claims["priority"].(float64); ok {
-		priorityInt := int(priority)
-		payload.Priority = &priorityInt
+		p := int(priority)
+		payload.Priority = &p
 	}
 	if processorID, ok := claims["processor_id"].(string); ok {
 		payload.ProcessorID = processorID
@@ -178,12 +223,29 @@ func (j *JWTManager) VerifyToken(tokenString string) (*database.JWTPayload, erro
 			if windowMs, ok := rateLimitMap["window_ms"].(float64); ok {
 				rateLimit.WindowMs = int64(windowMs)
 			}
+			if algorithm, ok := rateLimitMap["algorithm"].(string); ok {
+				rateLimit.Algorithm = algorithm
+			}
 
 			payload.RateLimit = rateLimit
 		}
 	}
 
-	return payload, nil
+	// Handle token_limit
+	if tokenLimitMap, ok := claims["token_limit"].(map[string]interface{}); ok {
+		tokenLimit := &database.TokenLimitConfig{}
+
+		if maxTokens, ok := tokenLimitMap["max_tokens"].(float64); ok {
+			tokenLimit.MaxTokens = int64(maxTokens)
+		}
+		if windowMs, ok := tokenLimitMap["window_ms"].(float64); ok {
+			tokenLimit.WindowMs = int64(windowMs)
+		}
+
+		payload.TokenLimit = tokenLimit
+	}
+
+	return payload
 }
 
 func GenerateTaskID() string {
@@ -198,6 +260,7 @@ func GenerateRandomKey(length int) string {
 
 type JWTAuth struct {
 	*JWTManager
+	denylist *Denylist
 }
 
 func NewJWTAuth(secret string) *JWTAuth {
@@ -206,6 +269,38 @@ func NewJWTAuth(secret string) *JWTAuth {
 	}
 }
 
+func NewJWTAuthWithKeyring(keyring *Keyring) *JWTAuth {
+	return &JWTAuth{
+		JWTManager: NewJWTManagerWithKeyring(keyring),
+	}
+}
+
+// SetDenylist enables revocation checks for extracted tokens
+func (j *JWTAuth) SetDenylist(denylist *Denylist) {
+	j.denylist = denylist
+}
+
+// Denylist returns the revocation denylist, or nil if revocation is not enabled
+func (j *JWTAuth) Denylist() *Denylist {
+	return j.denylist
+}
+
+// verifyClaims parses the token and rejects revoked tokens
+func (j *JWTAuth) verifyClaims(tokenString string) (jwt.MapClaims, error) {
+	claims, err := j.parseClaims(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	if j.denylist != nil {
+		if err := j.denylist.Check(payloadFromClaims(claims)); err != nil {
+			return nil, err
+		}
+	}
+
+	return claims, nil
+}
+
 // ExtractUserID extracts user ID from JWT token in request
 func (j *JWTAuth) ExtractUserID(r *http.Request) (string, error) {
 	tokenString := j.extractTokenFromRequest(r)
@@ -213,21 +308,13 @@ func (j *JWTAuth) ExtractUserID(r *http.Request) (string, error) {
 		return "", fmt.Errorf("no token found")
 	}
 
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return j.secretKey, nil
-	})
-
+	claims, err := j.verifyClaims(tokenString)
 	if err != nil {
 		return "", err
 	}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		if userID, exists := claims["user_id"].(string); exists {
-			return userID, nil
-		}
+	if userID, exists := claims["user_id"].(string); exists {
+		return userID, nil
 	}
 
 	return "", fmt.Errorf("invalid token claims")
@@ -240,124 +327,12 @@ func (j *JWTAuth) ExtractPayload(r *http.Request) (*database.JWTPayload, error)
 		return nil, fmt.Errorf("no token found")
 	}
 
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return j.secretKey, nil
-	})
-
+	claims, err := j.verifyClaims(tokenString)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse token: %w", err)
 	}
 
-	if !token.Valid {
-		return nil, fmt.Errorf("invalid token")
-	}
-
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return nil, fmt.Errorf("invalid token claims")
-	}
-
-	payload := &database.JWTPayload{}
-
-	// Extract user_id and sub (TypeScript uses both)
-	if userID, ok := claims["user_id"].(string); ok {
-		payload.UserID = userID
-	}
-	if subject, ok := claims["sub"].(string); ok {
-		payload.Subject = subject
-		// If user_id is empty, use sub as fallback (matching TypeScript logic)
-		if payload.UserID == "" {
-			payload.UserID = subject
-		}
-	}
-	if issuer, ok := claims["iss"].(string); ok {
-		payload.Issuer = issuer
-	}
-	if audience, ok := claims["aud"].(string); ok {
-		payload.Audience = audience
-	}
-	if taskID, ok := claims["taskId"].(string); ok {
-		payload.TaskID = taskID
-	}
-	if productData, ok := claims["product_data"].(string); ok {
-		payload.ProductData = productData
-	}
-	if priority, ok := claims["priority"].(float64); ok {
-		p := int(priority)
-		payload.Priority = &p
-	}
-	if processorID, ok := claims["processor_id"].(string); ok {
-		payload.ProcessorID = processorID
-	}
-
-	// Handle ollama_params (similar to VerifyToken)
-	if ollamaParamsRaw, ok := claims["ollama_params"]; ok {
-		if ollamaParamsMap, ok := ollamaParamsRaw.(map[string]interface{}); ok {
-			ollamaParams := &database.OllamaParams{}
-
-			if model, ok := ollamaParamsMap["model"].(string); ok {
-				ollamaParams.Model = &model
-			}
-			if prompt, ok := ollamaParamsMap["prompt"].(string); ok {
-				ollamaParams.Prompt = &prompt
-			}
-			if temp, ok := ollamaParamsMap["temperature"].(float64); ok {
-				ollamaParams.Temperature = &temp
-			}
-			if maxTokens, ok := ollamaParamsMap["max_tokens"].(float64); ok {
-				maxTokensInt := int(maxTokens)
-				ollamaParams.MaxTokens = &maxTokensInt
-			}
-			if topP, ok := ollamaParamsMap["top_p"].(float64); ok {
-				ollamaParams.TopP = &topP
-			}
-			if topK, ok := ollamaParamsMap["top_k"].(float64); ok {
-				topKInt := int(topK)
-				ollamaParams.TopK = &topKInt
-			}
-			if repeatPenalty, ok := ollamaParamsMap["repeat_penalty"].(float64); ok {
-				ollamaParams.RepeatPenalty = &repeatPenalty
-			}
-			if seed, ok := ollamaParamsMap["seed"].(float64); ok {
-				seedInt := int(seed)
-				ollamaParams.Seed = &seedInt
-			}
-			if stopRaw, ok := ollamaParamsMap["stop"]; ok {
-				if stopSlice, ok := stopRaw.([]interface{}); ok {
-					stop := make([]string, len(stopSlice))
-					for i, v := range stopSlice {
-						if s, ok := v.(string); ok {
-							stop[i] = s
-						}
-					}
-					ollamaParams.Stop = stop
-				}
-			}
-
-			payload.OllamaParams = ollamaParams
-		}
-	}
-
-	// Handle rate_limit (similar to VerifyToken)
-	if rateLimitRaw, ok := claims["rate_limit"]; ok {
-		if rateLimitMap, ok := rateLimitRaw.(map[string]interface{}); ok {
-			rateLimit := &database.RateLimitConfig{}
-
-			if maxRequests, ok := rateLimitMap["max_requests"].(float64); ok {
-				rateLimit.MaxRequests = int(maxRequests)
-			}
-			if windowMs, ok := rateLimitMap["window_ms"].(float64); ok {
-				rateLimit.WindowMs = int64(windowMs)
-			}
-
-			payload.RateLimit = rateLimit
-		}
-	}
-
-	return payload, nil
+	return payloadFromClaims(claims), nil
 }
 
 // ExtractPayloadFromToken extracts JWT payload from token string
@@ -366,124 +341,12 @@ func (j *JWTAuth) ExtractPayloadFromToken(tokenString string) (*database.JWTPayl
 		return nil, fmt.Errorf("empty token")
 	}
 
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return j.secretKey, nil
-	})
-
+	claims, err := j.verifyClaims(tokenString)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse token: %w", err)
 	}
 
-	if !token.Valid {
-		return nil, fmt.Errorf("invalid token")
-	}
-
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return nil, fmt.Errorf("invalid token claims")
-	}
-
-	payload := &database.JWTPayload{}
-
-	// Extract user_id and sub (TypeScript uses both)
-	if userID, ok := claims["user_id"].(string); ok {
-		payload.UserID = userID
-	}
-	if subject, ok := claims["sub"].(string); ok {
-		payload.Subject = subject
-		// If user_id is empty, use sub as fallback (matching TypeScript logic)
-		if payload.UserID == "" {
-			payload.UserID = subject
-		}
-	}
-	if issuer, ok := claims["iss"].(string); ok {
-		payload.Issuer = issuer
-	}
-	if audience, ok := claims["aud"].(string); ok {
-		payload.Audience = audience
-	}
-	if taskID, ok := claims["taskId"].(string); ok {
-		payload.TaskID = taskID
-	}
-	if productData, ok := claims["product_data"].(string); ok {
-		payload.ProductData = productData
-	}
-	if priority, ok := claims["priority"].(float64); ok {
-		p := int(priority)
-		payload.Priority = &p
-	}
-	if processorID, ok := claims["processor_id"].(string); ok {
-		payload.ProcessorID = processorID
-	}
-
-	// Handle ollama_params (reusing существующей логики)
-	if ollamaParamsRaw, ok := claims["ollama_params"]; ok {
-		if ollamaParamsMap, ok := ollamaParamsRaw.(map[string]interface{}); ok {
-			ollamaParams := &database.OllamaParams{}
-
-			if model, ok := ollamaParamsMap["model"].(string); ok {
-				ollamaParams.Model = &model
-			}
-			if prompt, ok := ollamaParamsMap["prompt"].(string); ok {
-				ollamaParams.Prompt = &prompt
-			}
-			if temp, ok := ollamaParamsMap["temperature"].(float64); ok {
-				ollamaParams.Temperature = &temp
-			}
-			if maxTokens, ok := ollamaParamsMap["max_tokens"].(float64); ok {
-				maxTokensInt := int(maxTokens)
-				ollamaParams.MaxTokens = &maxTokensInt
-			}
-			if topP, ok := ollamaParamsMap["top_p"].(float64); ok {
-				ollamaParams.TopP = &topP
-			}
-			if topK, ok := ollamaParamsMap["top_k"].(float64); ok {
-				topKInt := int(topK)
-				ollamaParams.TopK = &topKInt
-			}
-			if repeatPenalty, ok := ollamaParamsMap["repeat_penalty"].(float64); ok {
-				ollamaParams.RepeatPenalty = &repeatPenalty
-			}
-			if seed, ok := ollamaParamsMap["seed"].(float64); ok {
-				seedInt := int(seed)
-				ollamaParams.Seed = &seedInt
-			}
-			if stopRaw, ok := ollamaParamsMap["stop"]; ok {
-				if stopSlice, ok := stopRaw.([]interface{}); ok {
-					stop := make([]string, len(stopSlice))
-					for i, v := range stopSlice {
-						if s, ok := v.(string); ok {
-							stop[i] = s
-						}
-					}
-					ollamaParams.Stop = stop
-				}
-			}
-
-			payload.OllamaParams = ollamaParams
-		}
-	}
-
-	// Handle rate_limit
-	if rateLimitRaw, ok := claims["rate_limit"]; ok {
-		if rateLimitMap, ok := rateLimitRaw.(map[string]interface{}); ok {
-			rateLimit := &database.RateLimitConfig{}
-
-			if maxRequests, ok := rateLimitMap["max_requests"].(float64); ok {
-				rateLimit.MaxRequests = int(maxRequests)
-			}
-			if windowMs, ok := rateLimitMap["window_ms"].(float64); ok {
-				rateLimit.WindowMs = int64(windowMs)
-			}
-
-			payload.RateLimit = rateLimit
-		}
-	}
-
-	return payload, nil
+	return payloadFromClaims(claims), nil
 }
 
 func (j *JWTAuth) extractTokenFromRequest(r *http.Request) string {
@@ -0,0 +1,323 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Supported signing algorithms
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// DefaultKeyID is the kid of the key built from JWT_SECRET when no keyring file is configured
+const DefaultKeyID = "default"
+
+// KeyConfig describes one key of the keyring file. PEM keys can be given inline
+// or as a file path; a key without a private part can only verify tokens.
+type KeyConfig struct {
+	KID            string     `json:"kid"`
+	Alg            string     `json:"alg"`
+	Secret         string     `json:"secret,omitempty"`
+	PrivateKey     string     `json:"private_key,omitempty"`
+	PrivateKeyFile string     `json:"private_key_file,omitempty"`
+	PublicKey      string     `json:"public_key,omitempty"`
+	PublicKeyFile  string     `json:"public_key_file,omitempty"`
+	RetiredAt      *time.Time `json:"retired_at,omitempty"`
+}
+
+// KeyringFile is the JSON format of JWT_KEYRING_FILE
+type KeyringFile struct {
+	ActiveKID string      `json:"active_kid"`
+	Keys      []KeyConfig `json:"keys"`
+}
+
+// SigningKey is a parsed keyring entry
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	RetiredAt *time.Time
+
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// Keyring holds the active signing key and the keys still accepted for verification.
+// Retired keys verify tokens for gracePeriod after retired_at.
+type Keyring struct {
+	keys        []*SigningKey
+	byID        map[string]*SigningKey
+	active      *SigningKey
+	gracePeriod time.Duration
+}
+
+// NewHMACKeyring returns a keyring with a single HS256 key
+func NewHMACKeyring(secret string) *Keyring {
+	key := &SigningKey{
+		ID:        DefaultKeyID,
+		Algorithm: AlgHS256,
+		method:    jwt.SigningMethodHS256,
+		signKey:   []byte(secret),
+		verifyKey: []byte(secret),
+	}
+	return &Keyring{
+		keys:   []*SigningKey{key},
+		byID:   map[string]*SigningKey{key.ID: key},
+		active: key,
+	}
+}
+
+// LoadKeyringFile reads a keyring from a JSON file
+func LoadKeyringFile(path string, gracePeriod time.Duration) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyring file: %w", err)
+	}
+
+	var file KeyringFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid keyring file: %w", err)
+	}
+
+	return NewKeyring(file, gracePeriod)
+}
+
+// NewKeyring parses the keyring. The active key must exist, have a private part
+// and not be retired.
+func NewKeyring(file KeyringFile, gracePeriod time.Duration) (*Keyring, error) {
+	k := &Keyring{
+		byID:        make(map[string]*SigningKey),
+		gracePeriod: gracePeriod,
+	}
+
+	for _, cfg := range file.Keys {
+		key, err := NewSigningKey(cfg)
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := k.byID[key.ID]; exists {
+			return nil, fmt.Errorf("duplicate key id %q", key.ID)
+		}
+		k.keys = append(k.keys, key)
+		k.byID[key.ID] = key
+	}
+
+	active, ok := k.byID[file.ActiveKID]
+	if !ok {
+		return nil, fmt.Errorf("active key %q not found in keyring", file.ActiveKID)
+	}
+	if active.signKey == nil {
+		return nil, fmt.Errorf("active key %q has no private key", active.ID)
+	}
+	if active.RetiredAt != nil {
+		return nil, fmt.Errorf("active key %q is retired", active.ID)
+	}
+	k.active = active
+
+	return k, nil
+}
+
+// NewSigningKey parses a single keyring entry
+func NewSigningKey(cfg KeyConfig) (*SigningKey, error) {
+	if cfg.KID == "" {
+		return nil, fmt.Errorf("key id is required")
+	}
+
+	key := &SigningKey{ID: cfg.KID, Algorithm: cfg.Alg, RetiredAt: cfg.RetiredAt}
+
+	privatePEM, err := readPEM(cfg.PrivateKey, cfg.PrivateKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("key %q: %w", cfg.KID, err)
+	}
+	publicPEM, err := readPEM(cfg.PublicKey, cfg.PublicKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("key %q: %w", cfg.KID, err)
+	}
+
+	switch cfg.Alg {
+	case AlgHS256:
+		if cfg.Secret == "" {
+			return nil, fmt.Errorf("key %q: secret is required for %s", cfg.KID, cfg.Alg)
+		}
+		key.method = jwt.SigningMethodHS256
+		key.signKey = []byte(cfg.Secret)
+		key.verifyKey = []byte(cfg.Secret)
+
+	case AlgRS256:
+		key.method = jwt.SigningMethodRS256
+		if privatePEM != nil {
+			private, err := jwt.ParseRSAPrivateKeyFromPEM(privatePEM)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %w", cfg.KID, err)
+			}
+			key.signKey = private
+			key.verifyKey = &private.PublicKey
+		} else if publicPEM != nil {
+			public, err := jwt.ParseRSAPublicKeyFromPEM(publicPEM)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %w", cfg.KID, err)
+			}
+			key.verifyKey = public
+		} else {
+			return nil, fmt.Errorf("key %q: private or public key is required for %s", cfg.KID, cfg.Alg)
+		}
+
+	case AlgEdDSA:
+		key.method = jwt.SigningMethodEdDSA
+		if privatePEM != nil {
+			private, err := jwt.ParseEdPrivateKeyFromPEM(privatePEM)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %w", cfg.KID, err)
+			}
+			edPrivate, ok := private.(ed25519.PrivateKey)
+			if !ok {
+				return nil, fmt.Errorf("key %q: not an Ed25519 private key", cfg.KID)
+			}
+			key.signKey = edPrivate
+			key.verifyKey = edPrivate.Public()
+		} else if publicPEM != nil {
+			public, err := jwt.ParseEdPublicKeyFromPEM(publicPEM)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %w", cfg.KID, err)
+			}
+			key.verifyKey = public
+		} else {
+			return nil, fmt.Errorf("key %q: private or public key is required for %s", cfg.KID, cfg.Alg)
+		}
+
+	default:
+		return nil, fmt.Errorf("key %q: unsupported algorithm %q", cfg.KID, cfg.Alg)
+	}
+
+	return key, nil
+}
+
+func readPEM(inline, path string) ([]byte, error) {
+	if inline != "" {
+		return []byte(inline), nil
+	}
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return data, nil
+}
+
+// ActiveKeyID returns the kid new tokens are signed with
+func (k *Keyring) ActiveKeyID() string {
+	return k.active.ID
+}
+
+// usable reports whether the key still verifies tokens at now
+func (k *Keyring) usable(key *SigningKey, now time.Time) bool {
+	return key.RetiredAt == nil || now.Before(key.RetiredAt.Add(k.gracePeriod))
+}
+
+// sign signs the claims with the active key and sets the kid header
+func (k *Keyring) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.active.method, claims)
+	token.Header["kid"] = k.active.ID
+	return token.SignedString(k.active.signKey)
+}
+
+// keyfunc selects the verification key by kid. Tokens without kid (issued before
+// the keyring was introduced) are checked against the HS256 keys.
+func (k *Keyring) keyfunc(token *jwt.Token) (interface{}, error) {
+	now := time.Now()
+
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		set := jwt.VerificationKeySet{}
+		for _, key := range k.keys {
+			if key.Algorithm == AlgHS256 && k.usable(key, now) {
+				set.Keys = append(set.Keys, key.verifyKey)
+			}
+		}
+		if len(set.Keys) == 0 {
+			return nil, fmt.Errorf("token has no kid")
+		}
+		return set, nil
+	}
+
+	key, ok := k.byID[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	if !k.usable(key, now) {
+		return nil, fmt.Errorf("signing key %q is retired", kid)
+	}
+
+	return key.verifyKey, nil
+}
+
+// JSONWebKey is a public key in JWK format (RFC 7517)
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JSONWebKeySet is the /.well-known/jwks.json document
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// JWKS returns the public keys that still verify tokens. HS256 keys are never published.
+func (k *Keyring) JWKS() JSONWebKeySet {
+	now := time.Now()
+	set := JSONWebKeySet{Keys: make([]JSONWebKey, 0)}
+
+	for _, key := range k.keys {
+		if !k.usable(key, now) {
+			continue
+		}
+
+		switch public := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JSONWebKey{
+				Kty: "RSA",
+				Kid: key.ID,
+				Use: "sig",
+				Alg: key.Algorithm,
+				N:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JSONWebKey{
+				Kty: "OKP",
+				Kid: key.ID,
+				Use: "sig",
+				Alg: key.Algorithm,
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+
+	return set
+}
@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/ad/go-llm-manager/internal/database"
+)
+
+func pemPrivateKey(t *testing.T, key interface{}) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
+
+func mustKeyring(t *testing.T, file KeyringFile, grace time.Duration) *Keyring {
+	t.Helper()
+	k, err := NewKeyring(file, grace)
+	if err != nil {
+		t.Fatalf("failed to build keyring: %v", err)
+	}
+	return k
+}
+
+func TestKeyringAsymmetricAlgorithms(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	for _, cfg := range []KeyConfig{
+		{KID: "rsa-1", Alg: AlgRS256, PrivateKey: pemPrivateKey(t, rsaKey)},
+		{KID: "ed-1", Alg: AlgEdDSA, PrivateKey: pemPrivateKey(t, edKey)},
+	} {
+		t.Run(cfg.Alg, func(t *testing.T) {
+			m := NewJWTManagerWithKeyring(mustKeyring(t, KeyringFile{ActiveKID: cfg.KID, Keys: []KeyConfig{cfg}}, 0))
+
+			token, err := m.GenerateToken(&database.JWTPayload{UserID: "u-1"}, 60)
+			if err != nil {
+				t.Fatalf("sign failed: %v", err)
+			}
+			payload, err := m.VerifyToken(token)
+			if err != nil || payload.UserID != "u-1" {
+				t.Fatalf("verify failed: %+v, %v", payload, err)
+			}
+
+			jwks := m.JWKS()
+			if len(jwks.Keys) != 1 || jwks.Keys[0].Kid != cfg.KID || jwks.Keys[0].Alg != cfg.Alg {
+				t.Fatalf("unexpected jwks: %+v", jwks)
+			}
+		})
+	}
+}
+
+func TestKeyringRotationGracePeriod(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	newKey := KeyConfig{KID: "ed-2", Alg: AlgEdDSA, PrivateKey: pemPrivateKey(t, edKey)}
+
+	// Токены, выпущенные старым HS256-ключом: с kid и без kid (до появления keyring)
+	legacy := NewJWTManager("old-secret")
+	oldToken, _ := legacy.GenerateToken(&database.JWTPayload{UserID: "u-1"}, 3600)
+	noKidToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": "u-1",
+		"exp":     time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte("old-secret"))
+
+	retiredRecently := time.Now().Add(-30 * time.Minute)
+	m := NewJWTManagerWithKeyring(mustKeyring(t, KeyringFile{
+		ActiveKID: "ed-2",
+		Keys: []KeyConfig{
+			newKey,
+			{KID: DefaultKeyID, Alg: AlgHS256, Secret: "old-secret", RetiredAt: &retiredRecently},
+		},
+	}, time.Hour))
+
+	for _, token := range []string{oldToken, noKidToken} {
+		if _, err := m.VerifyToken(token); err != nil {
+			t.Fatalf("expected retired key to verify within grace period: %v", err)
+		}
+	}
+	if len(m.JWKS().Keys) != 1 {
+		t.Fatalf("expected HS256 key not to be published, got %+v", m.JWKS())
+	}
+
+	retiredLongAgo := time.Now().Add(-2 * time.Hour)
+	m = NewJWTManagerWithKeyring(mustKeyring(t, KeyringFile{
+		ActiveKID: "ed-2",
+		Keys: []KeyConfig{
+			newKey,
+			{KID: DefaultKeyID, Alg: AlgHS256, Secret: "old-secret", RetiredAt: &retiredLongAgo},
+		},
+	}, time.Hour))
+
+	for _, token := range []string{oldToken, noKidToken} {
+		if _, err := m.VerifyToken(token); err == nil {
+			t.Fatal("expected retired key to be rejected after grace period")
+		}
+	}
+
+	newToken, _ := m.GenerateToken(&database.JWTPayload{UserID: "u-2"}, 60)
+	if _, err := m.VerifyToken(newToken); err != nil {
+		t.Fatalf("expected active key to verify: %v", err)
+	}
+	if _, err := legacy.VerifyToken(newToken); err == nil {
+		t.Fatal("expected token of another key to be rejected")
+	}
+}
+
+func TestKeyringRejectsInvalidConfig(t *testing.T) {
+	retired := time.Now()
+	cases := map[string]KeyringFile{
+		"missing active": {ActiveKID: "x", Keys: []KeyConfig{{KID: "a", Alg: AlgHS256, Secret: "s"}}},
+		"retired active": {ActiveKID: "a", Keys: []KeyConfig{{KID: "a", Alg: AlgHS256, Secret: "s", RetiredAt: &retired}}},
+		"unknown alg":    {ActiveKID: "a", Keys: []KeyConfig{{KID: "a", Alg: "none"}}},
+		"duplicate kid":  {ActiveKID: "a", Keys: []KeyConfig{{KID: "a", Alg: AlgHS256, Secret: "s"}, {KID: "a", Alg: AlgHS256, Secret: "t"}}},
+	}
+	for name, file := range cases {
+		if _, err := NewKeyring(file, 0); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"errors"
+)
+
+var (
+	ErrNotProcessorToken = errors.New("token is not a processor token")
+	ErrTokenRevoked      = errors.New("token has been revoked")
+)
+
+// ProcessorAuth validates per-processor JWTs minted by /api/internal/generate-token.
+// Revoked tokens are rejected by the denylist of jwtAuth.
+type ProcessorAuth struct {
+	jwtAuth *JWTAuth
+}
+
+func NewProcessorAuth(jwtAuth *JWTAuth) *ProcessorAuth {
+	return &ProcessorAuth{
+		jwtAuth: jwtAuth,
+	}
+}
+
+// Authenticate returns the processor ID bound to the token
+func (p *ProcessorAuth) Authenticate(tokenString string) (string, error) {
+	payload, err := p.jwtAuth.ExtractPayloadFromToken(tokenString)
+	if err != nil {
+		return "", err
+	}
+
+	if payload.ProcessorID == "" {
+		return "", ErrNotProcessorToken
+	}
+
+	return payload.ProcessorID, nil
+}
@@ -0,0 +1,29 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+)
+
+// HashProductData returns the hex SHA-256 of product_data used in the product_data_sha256 claim
+func HashProductData(productData string) string {
+	sum := sha256.Sum256([]byte(productData))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsValidProductDataHash reports whether hash is a hex-encoded SHA-256
+func IsValidProductDataHash(hash string) bool {
+	decoded, err := hex.DecodeString(hash)
+	return err == nil && len(decoded) == sha256.Size
+}
+
+// VerifyProductDataHash compares product_data with the hash from the token in constant time
+func VerifyProductDataHash(productData, hash string) bool {
+	expected, err := hex.DecodeString(hash)
+	if err != nil || len(expected) != sha256.Size {
+		return false
+	}
+	sum := sha256.Sum256([]byte(productData))
+	return subtle.ConstantTimeCompare(sum[:], expected) == 1
+}
@@ -0,0 +1,461 @@
+// Package backup takes consistent snapshots of the SQLite database with VACUUM INTO,
+// keeps them according to a daily/weekly retention policy and restores them.
+//
+// A snapshot is a file backup-<UTC time>.db, or .db.gz when compressed, next to a
+// <file>.sha256 file in sha256sum format, so snapshots can also be verified with
+// `sha256sum -c` after copying them elsewhere.
+package backup
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ad/go-llm-manager/internal/config"
+	"github.com/ad/go-llm-manager/internal/database"
+	"github.com/ad/go-llm-manager/internal/logging"
+	"github.com/ad/go-llm-manager/internal/metrics"
+)
+
+const (
+	filePrefix     = "backup-"
+	timeLayout     = "20060102T150405.000Z"
+	dbExt          = ".db"
+	gzipExt        = ".gz"
+	checksumExt    = ".sha256"
+	tempExt        = ".tmp"
+	preRestoreName = ".pre-restore-"
+)
+
+// ErrBusy is returned by Create while another snapshot is being written
+var ErrBusy = errors.New("backup already in progress")
+
+// Snapshot is a backup file in the backup directory
+type Snapshot struct {
+	Name       string `json:"name"`
+	Path       string `json:"-"`
+	Size       int64  `json:"size"`
+	SHA256     string `json:"sha256,omitempty"` // пусто, если файла .sha256 нет
+	Compressed bool   `json:"compressed"`
+	CreatedAt  int64  `json:"created_at"` // unix ms
+}
+
+// Manager writes snapshots of the database into the backup directory on request
+// and on a schedule
+type Manager struct {
+	db  *database.DB
+	cfg config.BackupConfig
+	now func() time.Time
+
+	mu sync.Mutex // один снимок за раз
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewManager(db *database.DB, cfg config.BackupConfig) *Manager {
+	return &Manager{
+		db:   db,
+		cfg:  cfg,
+		now:  time.Now,
+		stop: make(chan struct{}),
+	}
+}
+
+// Start takes a snapshot every BACKUP_INTERVAL; it does nothing if the interval is 0
+func (m *Manager) Start() {
+	if m.cfg.Interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(m.cfg.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				snapshot, err := m.Create(context.Background())
+				if err != nil {
+					slog.Error("Scheduled backup failed", logging.Err(err))
+					continue
+				}
+				slog.Info("Created database backup", "name", snapshot.Name, "size", snapshot.Size)
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop halts the schedule and waits for a snapshot in progress
+func (m *Manager) Stop() {
+	m.stopOnce.Do(func() { close(m.stop) })
+	m.mu.Lock()
+	defer m.mu.Unlock()
+}
+
+// Create writes a snapshot of the database and then applies the retention policy.
+// It returns ErrBusy if another snapshot is being written.
+func (m *Manager) Create(ctx context.Context) (*Snapshot, error) {
+	if !m.mu.TryLock() {
+		return nil, ErrBusy
+	}
+	defer m.mu.Unlock()
+
+	start := time.Now()
+	snapshot, err := m.create(ctx)
+	if err != nil {
+		metrics.BackupFailures.Inc()
+		return nil, err
+	}
+	metrics.BackupDuration.Observe(time.Since(start).Seconds())
+	metrics.BackupLastSuccess.Set(float64(snapshot.CreatedAt) / 1000)
+
+	// Снимок уже записан: ошибка очистки старых не делает его неудачным
+	if _, err := m.prune(); err != nil {
+		slog.Error("Failed to prune old backups", logging.Err(err))
+	}
+	return snapshot, nil
+}
+
+func (m *Manager) create(ctx context.Context) (*Snapshot, error) {
+	if err := os.MkdirAll(m.cfg.Dir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	createdAt := m.now().UTC()
+	name := filePrefix + createdAt.Format(timeLayout) + dbExt
+	if m.cfg.Compress {
+		name += gzipExt
+	}
+	path := filepath.Join(m.cfg.Dir, name)
+
+	// VACUUM INTO пишет во временный файл: неполный снимок не попадет в список
+	raw := filepath.Join(m.cfg.Dir, filePrefix+createdAt.Format(timeLayout)+dbExt+tempExt)
+	if err := m.db.VacuumInto(ctx, raw); err != nil {
+		os.Remove(raw)
+		return nil, fmt.Errorf("failed to snapshot database: %w", err)
+	}
+	defer os.Remove(raw)
+
+	data := raw
+	if m.cfg.Compress {
+		data = path + tempExt
+		defer os.Remove(data)
+		if err := compressFile(raw, data); err != nil {
+			return nil, fmt.Errorf("failed to compress snapshot: %w", err)
+		}
+	}
+
+	sum, size, err := checksumFile(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeChecksum(path, sum); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(data, path); err != nil {
+		os.Remove(path + checksumExt)
+		return nil, err
+	}
+
+	return &Snapshot{
+		Name:       name,
+		Path:       path,
+		Size:       size,
+		SHA256:     sum,
+		Compressed: m.cfg.Compress,
+		CreatedAt:  createdAt.UnixMilli(),
+	}, nil
+}
+
+// List returns the snapshots in the backup directory, newest first
+func (m *Manager) List() ([]*Snapshot, error) {
+	return List(m.cfg.Dir)
+}
+
+// List returns the snapshots in dir, newest first
+func List(dir string) ([]*Snapshot, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []*Snapshot
+	for _, entry := range entries {
+		createdAt, compressed, ok := parseName(entry.Name())
+		if !ok || entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		sum, err := readChecksum(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		snapshots = append(snapshots, &Snapshot{
+			Name:       entry.Name(),
+			Path:       path,
+			Size:       info.Size(),
+			SHA256:     sum,
+			Compressed: compressed,
+			CreatedAt:  createdAt.UnixMilli(),
+		})
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].CreatedAt > snapshots[j].CreatedAt })
+	return snapshots, nil
+}
+
+// parseName returns the time of a snapshot file name; ok is false for other files
+func parseName(name string) (createdAt time.Time, compressed bool, ok bool) {
+	rest, found := strings.CutPrefix(name, filePrefix)
+	if !found {
+		return time.Time{}, false, false
+	}
+	rest, compressed = strings.CutSuffix(rest, gzipExt)
+	rest, found = strings.CutSuffix(rest, dbExt)
+	if !found {
+		return time.Time{}, false, false
+	}
+	createdAt, err := time.Parse(timeLayout, rest)
+	if err != nil {
+		return time.Time{}, false, false
+	}
+	return createdAt, compressed, true
+}
+
+// prune removes snapshots not kept by the retention policy
+func (m *Manager) prune() ([]*Snapshot, error) {
+	snapshots, err := m.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []*Snapshot
+	for _, s := range expired(snapshots, m.cfg.KeepDaily, m.cfg.KeepWeekly) {
+		if err := os.Remove(s.Path); err != nil {
+			return removed, err
+		}
+		os.Remove(s.Path + checksumExt)
+		removed = append(removed, s)
+		slog.Info("Removed old database backup", "name", s.Name)
+	}
+	return removed, nil
+}
+
+// expired returns the snapshots (sorted newest first) that the retention policy
+// drops: it keeps the newest snapshot of each of the keepDaily most recent days and
+// of each of the keepWeekly most recent ISO weeks (UTC), and always the newest one.
+// With both limits at 0 nothing is dropped.
+func expired(snapshots []*Snapshot, keepDaily, keepWeekly int) []*Snapshot {
+	if keepDaily <= 0 && keepWeekly <= 0 {
+		return nil
+	}
+
+	days := map[string]bool{}
+	weeks := map[string]bool{}
+	var drop []*Snapshot
+	for i, s := range snapshots {
+		t := time.UnixMilli(s.CreatedAt).UTC()
+		day := t.Format(time.DateOnly)
+		year, w := t.ISOWeek()
+		week := fmt.Sprintf("%d-W%02d", year, w)
+
+		keep := i == 0
+		if !days[day] && len(days) < keepDaily {
+			days[day] = true
+			keep = true
+		}
+		if !weeks[week] && len(weeks) < keepWeekly {
+			weeks[week] = true
+			keep = true
+		}
+		if !keep {
+			drop = append(drop, s)
+		}
+	}
+	return drop
+}
+
+func compressFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func checksumFile(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+// writeChecksum writes <path>.sha256 in the format of sha256sum
+func writeChecksum(path, sum string) error {
+	line := fmt.Sprintf("%s  %s\n", sum, filepath.Base(path))
+	tmp := path + checksumExt + tempExt
+	if err := os.WriteFile(tmp, []byte(line), 0640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path+checksumExt)
+}
+
+func readChecksum(path string) (string, error) {
+	data, err := os.ReadFile(path + checksumExt)
+	if err != nil {
+		return "", err
+	}
+	sum, _, _ := strings.Cut(strings.TrimSpace(string(data)), " ")
+	if len(sum) != sha256.Size*2 {
+		return "", fmt.Errorf("malformed checksum file %s", path+checksumExt)
+	}
+	return sum, nil
+}
+
+// Restore replaces the database at dbPath with the snapshot at path. The server
+// must be stopped. The snapshot is checked against its .sha256 file, unpacked next
+// to the database and must pass PRAGMA integrity_check before the files are
+// swapped; the current database is kept as <dbPath>.pre-restore-<time>, whose path
+// is returned ("" if there was no database).
+func Restore(ctx context.Context, path, dbPath string) (string, error) {
+	sum, err := readChecksum(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		slog.Warn("Backup has no checksum file, skipping checksum verification", "path", path)
+	case err != nil:
+		return "", err
+	}
+
+	// Распаковываем рядом с БД, чтобы rename был атомарным
+	tmp := dbPath + ".restore" + tempExt
+	os.Remove(tmp)
+	defer os.Remove(tmp)
+	actual, err := unpack(path, tmp, strings.HasSuffix(path, gzipExt))
+	if err != nil {
+		return "", fmt.Errorf("failed to unpack backup: %w", err)
+	}
+	if sum != "" && actual != sum {
+		return "", fmt.Errorf("checksum mismatch: %s has %s, expected %s", filepath.Base(path), actual, sum)
+	}
+	if err := database.CheckIntegrity(ctx, tmp); err != nil {
+		return "", err
+	}
+
+	preRestore := ""
+	if _, err := os.Stat(dbPath); err == nil {
+		// WAL переносим в файл БД, чтобы сохраненная копия была полной
+		if err := database.Checkpoint(ctx, dbPath); err != nil {
+			return "", fmt.Errorf("failed to checkpoint current database: %w", err)
+		}
+		preRestore = dbPath + preRestoreName + time.Now().UTC().Format("20060102T150405Z")
+		if err := os.Rename(dbPath, preRestore); err != nil {
+			return "", err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	// Остатки WAL старой БД нельзя применять к восстановленной
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(dbPath + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return preRestore, err
+		}
+	}
+
+	if err := os.Rename(tmp, dbPath); err != nil {
+		return preRestore, err
+	}
+	return preRestore, nil
+}
+
+// unpack copies the backup to dst, decompressing it if needed, and returns the
+// SHA-256 of the backup file as stored
+func unpack(src, dst string, compressed bool) (string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	h := sha256.New()
+	tee := io.TeeReader(in, h)
+	r := tee
+	if compressed {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return "", err
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0640)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return "", err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		return "", err
+	}
+
+	// gzip может не дочитать хвост файла
+	if _, err := io.Copy(io.Discard, tee); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
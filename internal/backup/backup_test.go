@@ -0,0 +1,217 @@
+package backup
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ad/go-llm-manager/internal/config"
+	"github.com/ad/go-llm-manager/internal/database"
+)
+
+func newTestManager(t *testing.T, compress bool) (*Manager, *database.DB, string) {
+	t.Helper()
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "llm-proxy.db")
+	db, err := database.NewSQLiteDB(dbPath)
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.RunMigrations(t.Context()); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	m := NewManager(db, config.BackupConfig{Dir: filepath.Join(dir, "backups"), Compress: compress})
+	return m, db, dbPath
+}
+
+func createTask(t *testing.T, db *database.DB, id string) {
+	t.Helper()
+	task := &database.Task{ID: id, UserID: "user-" + id, ProductData: "{}", Status: database.TaskStatusPending}
+	if err := db.CreateTask(t.Context(), task); err != nil {
+		t.Fatalf("CreateTask(%s): %v", id, err)
+	}
+}
+
+func TestCreateAndRestore(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		t.Run(map[bool]string{false: "plain", true: "gzip"}[compress], func(t *testing.T) {
+			m, db, dbPath := newTestManager(t, compress)
+			createTask(t, db, "before")
+
+			snapshot, err := m.Create(t.Context())
+			if err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+			if snapshot.Compressed != compress || strings.HasSuffix(snapshot.Name, ".gz") != compress {
+				t.Errorf("snapshot %s compressed = %v, want %v", snapshot.Name, snapshot.Compressed, compress)
+			}
+
+			snapshots, err := m.List()
+			if err != nil {
+				t.Fatalf("List: %v", err)
+			}
+			if len(snapshots) != 1 || snapshots[0].Name != snapshot.Name || snapshots[0].SHA256 != snapshot.SHA256 {
+				t.Fatalf("List = %+v, want the created snapshot", snapshots)
+			}
+
+			// Задача после снимка пропадет при восстановлении
+			createTask(t, db, "after")
+			db.Close()
+
+			preRestore, err := Restore(t.Context(), snapshot.Path, dbPath)
+			if err != nil {
+				t.Fatalf("Restore: %v", err)
+			}
+			if _, err := os.Stat(preRestore); err != nil {
+				t.Errorf("previous database not kept: %v", err)
+			}
+
+			restored, err := database.NewSQLiteDB(dbPath)
+			if err != nil {
+				t.Fatalf("failed to open restored db: %v", err)
+			}
+			defer restored.Close()
+			for id, want := range map[string]bool{"before": true, "after": false} {
+				_, err := restored.GetTask(t.Context(), id)
+				if err != nil && !errors.Is(err, sql.ErrNoRows) {
+					t.Fatalf("GetTask(%s): %v", id, err)
+				}
+				if got := err == nil; got != want {
+					t.Errorf("task %s restored = %v, want %v", id, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRestoreRejectsDamagedSnapshot(t *testing.T) {
+	m, _, dbPath := newTestManager(t, false)
+	snapshot, err := m.Create(t.Context())
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	data, err := os.ReadFile(snapshot.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)/2] ^= 0xff
+
+	t.Run("checksum", func(t *testing.T) {
+		if err := os.WriteFile(snapshot.Path, data, 0640); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Restore(t.Context(), snapshot.Path, dbPath); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+			t.Fatalf("Restore error = %v, want checksum mismatch", err)
+		}
+	})
+
+	t.Run("integrity", func(t *testing.T) {
+		// Без .sha256 повреждение должна найти integrity_check
+		os.Remove(snapshot.Path + checksumExt)
+		corrupt := make([]byte, len(data))
+		copy(corrupt, data)
+		for i := 4096; i < len(corrupt); i++ {
+			corrupt[i] = 0xff
+		}
+		if err := os.WriteFile(snapshot.Path, corrupt, 0640); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Restore(t.Context(), snapshot.Path, dbPath); err == nil {
+			t.Fatal("Restore of a corrupt snapshot succeeded")
+		}
+	})
+
+	// Текущая БД не тронута
+	if err := database.CheckIntegrity(t.Context(), dbPath); err != nil {
+		t.Fatalf("database changed by failed restore: %v", err)
+	}
+	if _, err := os.Stat(dbPath + ".restore" + tempExt); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+}
+
+func TestCreateAppliesRetention(t *testing.T) {
+	m, _, _ := newTestManager(t, false)
+	m.cfg.KeepDaily = 2
+
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	for _, at := range []time.Time{now.AddDate(0, 0, -2), now.AddDate(0, 0, -1), now.Add(-time.Hour), now} {
+		m.now = func() time.Time { return at }
+		if _, err := m.Create(t.Context()); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+	}
+
+	snapshots, err := m.List()
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	var got []int64
+	for _, s := range snapshots {
+		got = append(got, s.CreatedAt)
+	}
+	want := []int64{now.UnixMilli(), now.AddDate(0, 0, -1).UnixMilli()}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("kept snapshots = %v, want %v", got, want)
+	}
+
+	entries, _ := os.ReadDir(m.cfg.Dir)
+	if len(entries) != 2*len(want) {
+		t.Errorf("backup directory has %d files, want %d snapshots with checksums", len(entries), len(want))
+	}
+}
+
+func TestExpired(t *testing.T) {
+	// Снимки каждые 12 часов за 30 дней, от новых к старым; 2026-10-18 - воскресенье
+	now := time.Date(2026, 10, 18, 23, 0, 0, 0, time.UTC)
+	var snapshots []*Snapshot
+	for i := range 60 {
+		snapshots = append(snapshots, &Snapshot{CreatedAt: now.Add(-time.Duration(i) * 12 * time.Hour).UnixMilli()})
+	}
+
+	kept := func(keepDaily, keepWeekly int) []string {
+		drop := map[*Snapshot]bool{}
+		for _, s := range expired(snapshots, keepDaily, keepWeekly) {
+			drop[s] = true
+		}
+		var days []string
+		for _, s := range snapshots {
+			if !drop[s] {
+				days = append(days, time.UnixMilli(s.CreatedAt).UTC().Format("01-02T15"))
+			}
+		}
+		return days
+	}
+
+	tests := []struct {
+		name          string
+		daily, weekly int
+		want          []string
+	}{
+		{"disabled", 0, 0, nil},
+		{"daily", 3, 0, []string{"10-18T23", "10-17T23", "10-16T23"}},
+		{"weekly", 0, 2, []string{"10-18T23", "10-11T23"}},
+		{"both", 2, 3, []string{"10-18T23", "10-17T23", "10-11T23", "10-04T23"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := kept(tt.daily, tt.weekly)
+			if tt.want == nil {
+				if len(got) != len(snapshots) {
+					t.Fatalf("kept %d snapshots, want all %d", len(got), len(snapshots))
+				}
+				return
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("kept %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
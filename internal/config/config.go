@@ -3,9 +3,8 @@ package config
 import (
 	"encoding/json"
 	"flag"
-	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"os"
 	"strconv"
 	"time"
@@ -22,65 +21,205 @@ type Config struct {
 	RateLimit RateLimitConfig `json:"RATE_LIMIT"`
 	Cleanup   CleanupConfig   `json:"CLEANUP"`
 	SSE       SSEConfig       `json:"SSE"`
+	Processor ProcessorConfig `json:"PROCESSOR"`
+	Tracing   TracingConfig   `json:"TRACING"`
+	Logging   LoggingConfig   `json:"LOGGING"`
+	Health    HealthConfig    `json:"HEALTH"`
+	Backup    BackupConfig    `json:"BACKUP"`
 }
 
 type ServerConfig struct {
-	Host string `json:"HOST"`
-	Port string `json:"PORT"`
+	Host                string `json:"HOST"`
+	Port                string `json:"PORT"`
+	MaxProductDataBytes int64  `json:"MAX_PRODUCT_DATA_BYTES"`
 }
 
 type DatabaseConfig struct {
 	Path           string `json:"DB_PATH"`
 	MigrationsPath string `json:"MIGRATIONS_PATH"`
+
+	// Дедлайны запросов к БД по классам операций
+	ReadTimeout        time.Duration `json:"DB_READ_TIMEOUT"`
+	WriteTimeout       time.Duration `json:"DB_WRITE_TIMEOUT"`
+	MaintenanceTimeout time.Duration `json:"DB_MAINTENANCE_TIMEOUT"` // миграции и очистка
 }
 
 type AuthConfig struct {
-	JWTSecret      string `json:"JWT_SECRET"`
-	InternalAPIKey string `json:"INTERNAL_API_KEY"`
+	JWTSecret             string        `json:"JWT_SECRET" secret:"true"`
+	JWTKeyringFile        string        `json:"JWT_KEYRING_FILE"`
+	JWTKeyGracePeriod     time.Duration `json:"JWT_KEY_GRACE_PERIOD"`
+	InternalAPIKey        string        `json:"INTERNAL_API_KEY" secret:"true"`
+	RequireProcessorToken bool          `json:"REQUIRE_PROCESSOR_TOKEN"`
 }
 
 type RateLimitConfig struct {
-	WindowMs    int64 `json:"RATE_LIMIT_WINDOW"`
-	MaxRequests int   `json:"RATE_LIMIT_MAX_REQUESTS"`
+	WindowMs    int64  `json:"RATE_LIMIT_WINDOW"`
+	MaxRequests int    `json:"RATE_LIMIT_MAX_REQUESTS"`
+	Algorithm   string `json:"RATE_LIMIT_ALGORITHM"` // fixed_window, sliding_log, sliding_window, token_bucket
+
+	TokenLimit         int64 `json:"TOKEN_LIMIT"` // токенов LLM на пользователя за окно, 0 = без ограничения
+	TokenLimitWindowMs int64 `json:"TOKEN_LIMIT_WINDOW"`
+
+	IntrospectRate  float64 `json:"INTROSPECT_RATE_LIMIT"` // requests per second per API key
+	IntrospectBurst int     `json:"INTROSPECT_RATE_BURST"`
+
+	// Лимиты маршрутов по IP клиента и субъекту JWT: "/path=rate:burst,..." (rate в запросах в секунду)
+	RouteLimits    string `json:"ROUTE_RATE_LIMITS"`
+	TrustedProxies string `json:"TRUSTED_PROXIES"` // CIDR через запятую; только им доверяем X-Forwarded-For/X-Real-IP
 }
 
 type CleanupConfig struct {
 	Enabled        bool `json:"CLEANUP_ENABLED"`
 	DaysToKeep     int  `json:"CLEANUP_DAYS"`
 	TimeoutMinutes int  `json:"TASK_TIMEOUT_MINUTES"`
+
+	// Срок хранения завершенных задач по статусу, 0 = CLEANUP_DAYS
+	CompletedDays int `json:"CLEANUP_COMPLETED_DAYS"`
+	FailedDays    int `json:"CLEANUP_FAILED_DAYS"`
+
+	Archive    bool   `json:"CLEANUP_ARCHIVE"` // перед удалением выгружать задачи в ARCHIVE_DIR
+	ArchiveDir string `json:"ARCHIVE_DIR"`
+}
+
+// RetentionDays returns how many days finished tasks of the status are kept
+func (c CleanupConfig) RetentionDays(status string) int {
+	days := c.CompletedDays
+	if status == "failed" {
+		days = c.FailedDays
+	}
+	if days <= 0 {
+		return c.DaysToKeep
+	}
+	return days
 }
 
 type SSEConfig struct {
 	HeartbeatInterval time.Duration `json:"HEARTBEAT_INTERVAL"`
 	ClientTimeout     time.Duration `json:"CLIENT_TIMEOUT"`
+
+	MaxConnectionsPerUser int `json:"SSE_MAX_CONNECTIONS_PER_USER"` // 0 = без ограничения
+	MaxConnectionsPerIP   int `json:"SSE_MAX_CONNECTIONS_PER_IP"`
+}
+
+type ProcessorConfig struct {
+	HeartbeatTimeout time.Duration `json:"PROCESSOR_HEARTBEAT_TIMEOUT"`
+	DisconnectGrace  time.Duration `json:"PROCESSOR_DISCONNECT_GRACE"`
+	MonitorInterval  time.Duration `json:"PROCESSOR_MONITOR_INTERVAL"`
+}
+
+type TracingConfig struct {
+	Exporter     string  `json:"TRACING_EXPORTER"`      // none, stdout, otlp
+	OTLPEndpoint string  `json:"TRACING_OTLP_ENDPOINT"` // host:port OTLP/HTTP коллектора; пусто = OTEL_EXPORTER_OTLP_ENDPOINT или localhost:4318
+	OTLPInsecure bool    `json:"TRACING_OTLP_INSECURE"`
+	SampleRatio  float64 `json:"TRACING_SAMPLE_RATIO"` // доля новых трейсов, 0..1
+	ServiceName  string  `json:"TRACING_SERVICE_NAME"`
+}
+
+type HealthConfig struct {
+	ReadyMaxWALBytes   int64         `json:"READY_MAX_WAL_BYTES"`  // 0 = не проверять размер WAL
+	ReadyMinProcessors int           `json:"READY_MIN_PROCESSORS"` // 0 = готовность не зависит от процессоров
+	ReadyCheckTimeout  time.Duration `json:"READY_CHECK_TIMEOUT"`
+	DrainDelay         time.Duration `json:"SHUTDOWN_DRAIN_DELAY"` // сколько /readyz отвечает 503 до server.Shutdown
+}
+
+type BackupConfig struct {
+	Dir        string        `json:"BACKUP_DIR"`
+	Interval   time.Duration `json:"BACKUP_INTERVAL"`    // 0 = только по запросу
+	KeepDaily  int           `json:"BACKUP_KEEP_DAILY"`  // последний снимок каждого из N дней
+	KeepWeekly int           `json:"BACKUP_KEEP_WEEKLY"` // последний снимок каждой из N недель
+	Compress   bool          `json:"BACKUP_COMPRESS"`    // gzip
+}
+
+type LoggingConfig struct {
+	Level  string `json:"LOG_LEVEL"`  // debug, info, warn, error
+	Format string `json:"LOG_FORMAT"` // json, text
 }
 
+// DefaultRouteLimits protects public endpoints per client IP and JWT subject
+const DefaultRouteLimits = "/api/create=1:10,/api/result=5:30,/api/get=2:20,/api/tasks/vote=1:10,/api/result-polling=0.5:10"
+
 func Load(args []string) *Config {
 	config := &Config{
 		Server: ServerConfig{
-			Host: getEnv("HOST", "0.0.0.0"),
-			Port: getEnv("PORT", "8080"),
+			Host:                getEnv("HOST", "0.0.0.0"),
+			Port:                getEnv("PORT", "8080"),
+			MaxProductDataBytes: getEnvInt64("MAX_PRODUCT_DATA_BYTES", 1<<20),
 		},
 		Database: DatabaseConfig{
 			Path:           getEnv("DB_PATH", "./data/llm-proxy.db"),
-			MigrationsPath: getEnv("MIGRATIONS_PATH", "./migrations"),
+			MigrationsPath: getEnv("MIGRATIONS_PATH", ""),
+
+			ReadTimeout:        getEnvDuration("DB_READ_TIMEOUT", 10*time.Second),
+			WriteTimeout:       getEnvDuration("DB_WRITE_TIMEOUT", 30*time.Second),
+			MaintenanceTimeout: getEnvDuration("DB_MAINTENANCE_TIMEOUT", 10*time.Minute),
 		},
 		Auth: AuthConfig{
-			JWTSecret:      getEnv("JWT_SECRET", "dev-secret-key"),
-			InternalAPIKey: getEnv("INTERNAL_API_KEY", "dev-internal-key"),
+			JWTSecret:             getEnv("JWT_SECRET", "dev-secret-key"),
+			JWTKeyringFile:        getEnv("JWT_KEYRING_FILE", ""),
+			JWTKeyGracePeriod:     getEnvDuration("JWT_KEY_GRACE_PERIOD", 24*time.Hour),
+			InternalAPIKey:        getEnv("INTERNAL_API_KEY", "dev-internal-key"),
+			RequireProcessorToken: getEnvBool("REQUIRE_PROCESSOR_TOKEN", true),
 		},
 		RateLimit: RateLimitConfig{
 			WindowMs:    getEnvInt64("RATE_LIMIT_WINDOW", 86400000), // 24 hours
 			MaxRequests: getEnvInt("RATE_LIMIT_MAX_REQUESTS", 100),
+			Algorithm:   getEnv("RATE_LIMIT_ALGORITHM", "fixed_window"),
+
+			TokenLimit:         getEnvInt64("TOKEN_LIMIT", 0),
+			TokenLimitWindowMs: getEnvInt64("TOKEN_LIMIT_WINDOW", 86400000), // 24 hours
+
+			IntrospectRate:  getEnvFloat("INTROSPECT_RATE_LIMIT", 50),
+			IntrospectBurst: getEnvInt("INTROSPECT_RATE_BURST", 100),
+
+			RouteLimits:    getEnv("ROUTE_RATE_LIMITS", DefaultRouteLimits),
+			TrustedProxies: getEnv("TRUSTED_PROXIES", ""),
 		},
 		Cleanup: CleanupConfig{
 			Enabled:        getEnvBool("CLEANUP_ENABLED", true),
 			DaysToKeep:     getEnvInt("CLEANUP_DAYS", 7),
 			TimeoutMinutes: getEnvInt("TASK_TIMEOUT_MINUTES", 30),
+
+			CompletedDays: getEnvInt("CLEANUP_COMPLETED_DAYS", 0),
+			FailedDays:    getEnvInt("CLEANUP_FAILED_DAYS", 0),
+
+			Archive:    getEnvBool("CLEANUP_ARCHIVE", false),
+			ArchiveDir: getEnv("ARCHIVE_DIR", "./data/archive"),
 		},
 		SSE: SSEConfig{
 			HeartbeatInterval: getEnvDuration("SSE_HEARTBEAT_INTERVAL", 30*time.Second),
 			ClientTimeout:     getEnvDuration("SSE_CLIENT_TIMEOUT", 5*time.Minute),
+
+			MaxConnectionsPerUser: getEnvInt("SSE_MAX_CONNECTIONS_PER_USER", 3),
+			MaxConnectionsPerIP:   getEnvInt("SSE_MAX_CONNECTIONS_PER_IP", 20),
+		},
+		Processor: ProcessorConfig{
+			HeartbeatTimeout: getEnvDuration("PROCESSOR_HEARTBEAT_TIMEOUT", 90*time.Second),
+			DisconnectGrace:  getEnvDuration("PROCESSOR_DISCONNECT_GRACE", 10*time.Second),
+			MonitorInterval:  getEnvDuration("PROCESSOR_MONITOR_INTERVAL", 15*time.Second),
+		},
+		Tracing: TracingConfig{
+			Exporter:     getEnv("TRACING_EXPORTER", "none"),
+			OTLPEndpoint: getEnv("TRACING_OTLP_ENDPOINT", ""),
+			OTLPInsecure: getEnvBool("TRACING_OTLP_INSECURE", false),
+			SampleRatio:  getEnvFloat("TRACING_SAMPLE_RATIO", 1),
+			ServiceName:  getEnv("TRACING_SERVICE_NAME", "go-llm-manager"),
+		},
+		Health: HealthConfig{
+			ReadyMaxWALBytes:   getEnvInt64("READY_MAX_WAL_BYTES", 256<<20),
+			ReadyMinProcessors: getEnvInt("READY_MIN_PROCESSORS", 0),
+			ReadyCheckTimeout:  getEnvDuration("READY_CHECK_TIMEOUT", 2*time.Second),
+			DrainDelay:         getEnvDuration("SHUTDOWN_DRAIN_DELAY", 5*time.Second),
+		},
+		Backup: BackupConfig{
+			Dir:        getEnv("BACKUP_DIR", "./data/backups"),
+			Interval:   getEnvDuration("BACKUP_INTERVAL", 24*time.Hour),
+			KeepDaily:  getEnvInt("BACKUP_KEEP_DAILY", 7),
+			KeepWeekly: getEnvInt("BACKUP_KEEP_WEEKLY", 4),
+			Compress:   getEnvBool("BACKUP_COMPRESS", true),
+		},
+		Logging: LoggingConfig{
+			Level:  getEnv("LOG_LEVEL", "info"),
+			Format: getEnv("LOG_FORMAT", "json"),
 		},
 	}
 
@@ -93,7 +232,7 @@ func Load(args []string) *Config {
 			if err = json.Unmarshal(byteValue, &config); err == nil {
 				initFromFile = true
 			} else {
-				fmt.Printf("error on unmarshal config from file %s\n", err.Error())
+				slog.Error("Failed to unmarshal config file", "file", ConfigFileName, "error", err)
 			}
 		}
 	}
@@ -103,17 +242,56 @@ func Load(args []string) *Config {
 
 		flags.StringVar(&config.Server.Host, "host", lookupEnvOrString("HOST", config.Server.Host), "HOST")
 		flags.StringVar(&config.Server.Port, "port", lookupEnvOrString("PORT", config.Server.Port), "PORT")
+		flags.Int64Var(&config.Server.MaxProductDataBytes, "maxProductDataBytes", lookupEnvOrInt64("MAX_PRODUCT_DATA_BYTES", config.Server.MaxProductDataBytes), "MAX_PRODUCT_DATA_BYTES")
 		flags.StringVar(&config.Database.Path, "dbPath", lookupEnvOrString("DB_PATH", config.Database.Path), "DB_PATH")
 		flags.StringVar(&config.Database.MigrationsPath, "dbMigrationsPath", lookupEnvOrString("DB_MIGRATIONS_PATH", config.Database.MigrationsPath), "DB_MIGRATIONS_PATH")
+		flags.DurationVar(&config.Database.ReadTimeout, "dbReadTimeout", lookupEnvOrDuration("DB_READ_TIMEOUT", config.Database.ReadTimeout), "DB_READ_TIMEOUT")
+		flags.DurationVar(&config.Database.WriteTimeout, "dbWriteTimeout", lookupEnvOrDuration("DB_WRITE_TIMEOUT", config.Database.WriteTimeout), "DB_WRITE_TIMEOUT")
+		flags.DurationVar(&config.Database.MaintenanceTimeout, "dbMaintenanceTimeout", lookupEnvOrDuration("DB_MAINTENANCE_TIMEOUT", config.Database.MaintenanceTimeout), "DB_MAINTENANCE_TIMEOUT")
 		flags.StringVar(&config.Auth.JWTSecret, "jwtSecret", lookupEnvOrString("JWT_SECRET", config.Auth.JWTSecret), "JWT_SECRET")
+		flags.StringVar(&config.Auth.JWTKeyringFile, "jwtKeyringFile", lookupEnvOrString("JWT_KEYRING_FILE", config.Auth.JWTKeyringFile), "JWT_KEYRING_FILE")
+		flags.DurationVar(&config.Auth.JWTKeyGracePeriod, "jwtKeyGracePeriod", lookupEnvOrDuration("JWT_KEY_GRACE_PERIOD", config.Auth.JWTKeyGracePeriod), "JWT_KEY_GRACE_PERIOD")
 		flags.StringVar(&config.Auth.InternalAPIKey, "internalAPIKey", lookupEnvOrString("INTERNAL_API_KEY", config.Auth.InternalAPIKey), "INTERNAL_API_KEY")
+		flags.BoolVar(&config.Auth.RequireProcessorToken, "requireProcessorToken", lookupEnvOrBool("REQUIRE_PROCESSOR_TOKEN", config.Auth.RequireProcessorToken), "REQUIRE_PROCESSOR_TOKEN")
 		flags.Int64Var(&config.RateLimit.WindowMs, "rateLimitWindow", lookupEnvOrInt64("RATE_LIMIT_WINDOW", config.RateLimit.WindowMs), "RATE_LIMIT_WINDOW")
 		flags.IntVar(&config.RateLimit.MaxRequests, "rateLimitMaxRequests", lookupEnvOrInt("RATE_LIMIT_MAX_REQUESTS", config.RateLimit.MaxRequests), "RATE_LIMIT_MAX_REQUESTS")
+		flags.StringVar(&config.RateLimit.Algorithm, "rateLimitAlgorithm", lookupEnvOrString("RATE_LIMIT_ALGORITHM", config.RateLimit.Algorithm), "RATE_LIMIT_ALGORITHM")
+		flags.Int64Var(&config.RateLimit.TokenLimit, "tokenLimit", lookupEnvOrInt64("TOKEN_LIMIT", config.RateLimit.TokenLimit), "TOKEN_LIMIT")
+		flags.Int64Var(&config.RateLimit.TokenLimitWindowMs, "tokenLimitWindow", lookupEnvOrInt64("TOKEN_LIMIT_WINDOW", config.RateLimit.TokenLimitWindowMs), "TOKEN_LIMIT_WINDOW")
+		flags.Float64Var(&config.RateLimit.IntrospectRate, "introspectRateLimit", lookupEnvOrFloat("INTROSPECT_RATE_LIMIT", config.RateLimit.IntrospectRate), "INTROSPECT_RATE_LIMIT")
+		flags.IntVar(&config.RateLimit.IntrospectBurst, "introspectRateBurst", lookupEnvOrInt("INTROSPECT_RATE_BURST", config.RateLimit.IntrospectBurst), "INTROSPECT_RATE_BURST")
+		flags.StringVar(&config.RateLimit.RouteLimits, "routeRateLimits", lookupEnvOrString("ROUTE_RATE_LIMITS", config.RateLimit.RouteLimits), "ROUTE_RATE_LIMITS")
+		flags.StringVar(&config.RateLimit.TrustedProxies, "trustedProxies", lookupEnvOrString("TRUSTED_PROXIES", config.RateLimit.TrustedProxies), "TRUSTED_PROXIES")
 		flags.BoolVar(&config.Cleanup.Enabled, "cleanupEnabled", lookupEnvOrBool("CLEANUP_ENABLED", config.Cleanup.Enabled), "CLEANUP_ENABLED")
 		flags.IntVar(&config.Cleanup.DaysToKeep, "cleanupDays", lookupEnvOrInt("CLEANUP_DAYS", config.Cleanup.DaysToKeep), "CLEANUP_DAYS")
 		flags.IntVar(&config.Cleanup.TimeoutMinutes, "taskTimeoutMinutes", lookupEnvOrInt("TASK_TIMEOUT_MINUTES", config.Cleanup.TimeoutMinutes), "TASK_TIMEOUT_MINUTES")
+		flags.IntVar(&config.Cleanup.CompletedDays, "cleanupCompletedDays", lookupEnvOrInt("CLEANUP_COMPLETED_DAYS", config.Cleanup.CompletedDays), "CLEANUP_COMPLETED_DAYS")
+		flags.IntVar(&config.Cleanup.FailedDays, "cleanupFailedDays", lookupEnvOrInt("CLEANUP_FAILED_DAYS", config.Cleanup.FailedDays), "CLEANUP_FAILED_DAYS")
+		flags.BoolVar(&config.Cleanup.Archive, "cleanupArchive", lookupEnvOrBool("CLEANUP_ARCHIVE", config.Cleanup.Archive), "CLEANUP_ARCHIVE")
+		flags.StringVar(&config.Cleanup.ArchiveDir, "archiveDir", lookupEnvOrString("ARCHIVE_DIR", config.Cleanup.ArchiveDir), "ARCHIVE_DIR")
 		flags.DurationVar(&config.SSE.HeartbeatInterval, "sseHeartbeatInterval", lookupEnvOrDuration("SSE_HEARTBEAT_INTERVAL", config.SSE.HeartbeatInterval), "SSE_HEARTBEAT_INTERVAL")
 		flags.DurationVar(&config.SSE.ClientTimeout, "sseClientTimeout", lookupEnvOrDuration("SSE_CLIENT_TIMEOUT", config.SSE.ClientTimeout), "SSE_CLIENT_TIMEOUT")
+		flags.IntVar(&config.SSE.MaxConnectionsPerUser, "sseMaxConnectionsPerUser", lookupEnvOrInt("SSE_MAX_CONNECTIONS_PER_USER", config.SSE.MaxConnectionsPerUser), "SSE_MAX_CONNECTIONS_PER_USER")
+		flags.IntVar(&config.SSE.MaxConnectionsPerIP, "sseMaxConnectionsPerIP", lookupEnvOrInt("SSE_MAX_CONNECTIONS_PER_IP", config.SSE.MaxConnectionsPerIP), "SSE_MAX_CONNECTIONS_PER_IP")
+		flags.DurationVar(&config.Processor.HeartbeatTimeout, "processorHeartbeatTimeout", lookupEnvOrDuration("PROCESSOR_HEARTBEAT_TIMEOUT", config.Processor.HeartbeatTimeout), "PROCESSOR_HEARTBEAT_TIMEOUT")
+		flags.DurationVar(&config.Processor.DisconnectGrace, "processorDisconnectGrace", lookupEnvOrDuration("PROCESSOR_DISCONNECT_GRACE", config.Processor.DisconnectGrace), "PROCESSOR_DISCONNECT_GRACE")
+		flags.DurationVar(&config.Processor.MonitorInterval, "processorMonitorInterval", lookupEnvOrDuration("PROCESSOR_MONITOR_INTERVAL", config.Processor.MonitorInterval), "PROCESSOR_MONITOR_INTERVAL")
+		flags.StringVar(&config.Tracing.Exporter, "tracingExporter", lookupEnvOrString("TRACING_EXPORTER", config.Tracing.Exporter), "TRACING_EXPORTER")
+		flags.StringVar(&config.Tracing.OTLPEndpoint, "tracingOTLPEndpoint", lookupEnvOrString("TRACING_OTLP_ENDPOINT", config.Tracing.OTLPEndpoint), "TRACING_OTLP_ENDPOINT")
+		flags.BoolVar(&config.Tracing.OTLPInsecure, "tracingOTLPInsecure", lookupEnvOrBool("TRACING_OTLP_INSECURE", config.Tracing.OTLPInsecure), "TRACING_OTLP_INSECURE")
+		flags.Float64Var(&config.Tracing.SampleRatio, "tracingSampleRatio", lookupEnvOrFloat("TRACING_SAMPLE_RATIO", config.Tracing.SampleRatio), "TRACING_SAMPLE_RATIO")
+		flags.StringVar(&config.Tracing.ServiceName, "tracingServiceName", lookupEnvOrString("TRACING_SERVICE_NAME", config.Tracing.ServiceName), "TRACING_SERVICE_NAME")
+		flags.Int64Var(&config.Health.ReadyMaxWALBytes, "readyMaxWALBytes", lookupEnvOrInt64("READY_MAX_WAL_BYTES", config.Health.ReadyMaxWALBytes), "READY_MAX_WAL_BYTES")
+		flags.IntVar(&config.Health.ReadyMinProcessors, "readyMinProcessors", lookupEnvOrInt("READY_MIN_PROCESSORS", config.Health.ReadyMinProcessors), "READY_MIN_PROCESSORS")
+		flags.DurationVar(&config.Health.ReadyCheckTimeout, "readyCheckTimeout", lookupEnvOrDuration("READY_CHECK_TIMEOUT", config.Health.ReadyCheckTimeout), "READY_CHECK_TIMEOUT")
+		flags.DurationVar(&config.Health.DrainDelay, "shutdownDrainDelay", lookupEnvOrDuration("SHUTDOWN_DRAIN_DELAY", config.Health.DrainDelay), "SHUTDOWN_DRAIN_DELAY")
+		flags.StringVar(&config.Backup.Dir, "backupDir", lookupEnvOrString("BACKUP_DIR", config.Backup.Dir), "BACKUP_DIR")
+		flags.DurationVar(&config.Backup.Interval, "backupInterval", lookupEnvOrDuration("BACKUP_INTERVAL", config.Backup.Interval), "BACKUP_INTERVAL")
+		flags.IntVar(&config.Backup.KeepDaily, "backupKeepDaily", lookupEnvOrInt("BACKUP_KEEP_DAILY", config.Backup.KeepDaily), "BACKUP_KEEP_DAILY")
+		flags.IntVar(&config.Backup.KeepWeekly, "backupKeepWeekly", lookupEnvOrInt("BACKUP_KEEP_WEEKLY", config.Backup.KeepWeekly), "BACKUP_KEEP_WEEKLY")
+		flags.BoolVar(&config.Backup.Compress, "backupCompress", lookupEnvOrBool("BACKUP_COMPRESS", config.Backup.Compress), "BACKUP_COMPRESS")
+		flags.StringVar(&config.Logging.Level, "logLevel", lookupEnvOrString("LOG_LEVEL", config.Logging.Level), "LOG_LEVEL")
+		flags.StringVar(&config.Logging.Format, "logFormat", lookupEnvOrString("LOG_FORMAT", config.Logging.Format), "LOG_FORMAT")
 
 		// flags.BoolVar(&config.Debug, "debug", lookupEnvOrBool("DEBUG", config.Debug), "Debug")
 
@@ -122,8 +300,6 @@ func Load(args []string) *Config {
 		}
 	}
 
-	log.Printf("Loaded configuration: %+v\n", config)
-
 	return config
 }
 
@@ -152,6 +328,15 @@ func getEnvInt64(key string, defaultValue int64) int64 {
 	return defaultValue
 }
 
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+	return defaultValue
+}
+
 func getEnvBool(key string, defaultValue bool) bool {
 	if value := os.Getenv(key); value != "" {
 		if b, err := strconv.ParseBool(value); err == nil {
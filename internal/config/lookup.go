@@ -34,6 +34,16 @@ func lookupEnvOrInt64(key string, defaultVal int64) int64 {
 	return defaultVal
 }
 
+func lookupEnvOrFloat(key string, defaultVal float64) float64 {
+	if val, ok := os.LookupEnv(key); ok {
+		if x, err := strconv.ParseFloat(val, 64); err == nil {
+			return x
+		}
+	}
+
+	return defaultVal
+}
+
 func lookupEnvOrBool(key string, defaultVal bool) bool {
 	if val, ok := os.LookupEnv(key); ok {
 		if x, err := strconv.ParseBool(val); err == nil {
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"log/slog"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const redacted = "[REDACTED]"
+
+// LogValue implements slog.LogValuer: the configuration is logged as groups keyed
+// by JSON names, fields tagged secret:"true" are redacted unless empty.
+func (c *Config) LogValue() slog.Value {
+	return structLogValue(reflect.ValueOf(*c))
+}
+
+func structLogValue(v reflect.Value) slog.Value {
+	t := v.Type()
+	attrs := make([]slog.Attr, 0, t.NumField())
+	for i := range t.NumField() {
+		field := t.Field(i)
+		key, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if key == "" {
+			key = field.Name
+		}
+
+		value := v.Field(i)
+		switch {
+		case value.Kind() == reflect.Struct:
+			attrs = append(attrs, slog.Attr{Key: key, Value: structLogValue(value)})
+		case field.Tag.Get("secret") == "true" && !value.IsZero():
+			attrs = append(attrs, slog.String(key, redacted))
+		case value.Type() == reflect.TypeFor[time.Duration]():
+			attrs = append(attrs, slog.String(key, value.Interface().(time.Duration).String()))
+		default:
+			attrs = append(attrs, slog.Any(key, value.Interface()))
+		}
+	}
+	return slog.GroupValue(attrs...)
+}
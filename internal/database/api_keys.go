@@ -0,0 +1,142 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// API key operations
+
+const apiKeyColumns = `id, name, key_hash, key_prefix, scopes, created_at, expires_at, last_used_at, revoked_at`
+
+func scanAPIKey(row rowScanner) (*APIKey, error) {
+	var k APIKey
+	var scopesJSON string
+	var expiresAt, lastUsedAt, revokedAt sql.NullInt64
+
+	err := row.Scan(
+		&k.ID, &k.Name, &k.KeyHash, &k.KeyPrefix, &scopesJSON,
+		&k.CreatedAt, &expiresAt, &lastUsedAt, &revokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if scopesJSON != "" {
+		if err := json.Unmarshal([]byte(scopesJSON), &k.Scopes); err != nil {
+			return nil, fmt.Errorf("invalid scopes for api key %s: %w", k.ID, err)
+		}
+	}
+	if expiresAt.Valid {
+		k.ExpiresAt = &expiresAt.Int64
+	}
+	if lastUsedAt.Valid {
+		k.LastUsedAt = &lastUsedAt.Int64
+	}
+	if revokedAt.Valid {
+		k.RevokedAt = &revokedAt.Int64
+	}
+
+	return &k, nil
+}
+
+// CreateAPIKey stores a new API key (ID, hash and prefix must be set by the caller)
+func (db *DB) CreateAPIKey(ctx context.Context, k *APIKey) error {
+	if k.Scopes == nil {
+		k.Scopes = []string{}
+	}
+	scopesJSON, err := json.Marshal(k.Scopes)
+	if err != nil {
+		return err
+	}
+	if k.CreatedAt == 0 {
+		k.CreatedAt = time.Now().UnixMilli()
+	}
+
+	query := `
+		INSERT INTO api_keys (id, name, key_hash, key_prefix, scopes, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err = db.QueuedExec(ctx, query,
+		k.ID, k.Name, k.KeyHash, k.KeyPrefix, string(scopesJSON), k.CreatedAt, k.ExpiresAt,
+	)
+	return err
+}
+
+// GetAPIKey returns an API key by ID or nil if it does not exist
+func (db *DB) GetAPIKey(ctx context.Context, id string) (*APIKey, error) {
+	return db.getAPIKeyBy(ctx, "id", id)
+}
+
+// GetAPIKeyByHash returns an API key by its hash or nil if it does not exist
+func (db *DB) GetAPIKeyByHash(ctx context.Context, keyHash string) (*APIKey, error) {
+	return db.getAPIKeyBy(ctx, "key_hash", keyHash)
+}
+
+func (db *DB) getAPIKeyBy(ctx context.Context, column, value string) (*APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE ` + column + ` = ?`
+
+	k, err := scanAPIKey(db.QueuedQueryRow(ctx, query, value))
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// ListAPIKeys returns all API keys, newest first
+func (db *DB) ListAPIKeys(ctx context.Context) ([]*APIKey, error) {
+	rows, err := db.QueuedQuery(ctx, `SELECT `+apiKeyColumns+` FROM api_keys ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make([]*APIKey, 0)
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey revokes a key immediately. Returns false if the key is unknown or already revoked.
+func (db *DB) RevokeAPIKey(ctx context.Context, id string) (bool, error) {
+	result, err := db.QueuedExec(ctx,
+		`UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL`,
+		time.Now().UnixMilli(), id,
+	)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected > 0, nil
+}
+
+// ExpireAPIKey shortens the key validity to expiresAt (used for rotation grace periods).
+// A key that already expires earlier keeps its expiry.
+func (db *DB) ExpireAPIKey(ctx context.Context, id string, expiresAt int64) error {
+	query := `
+		UPDATE api_keys SET expires_at = ?
+		WHERE id = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)
+	`
+	_, err := db.QueuedExec(ctx, query, expiresAt, id, expiresAt)
+	return err
+}
+
+// TouchAPIKey records the last usage time of a key
+func (db *DB) TouchAPIKey(ctx context.Context, id string) error {
+	_, err := db.QueuedExec(ctx, `UPDATE api_keys SET last_used_at = ? WHERE id = ?`, time.Now().UnixMilli(), id)
+	return err
+}
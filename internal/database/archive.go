@@ -0,0 +1,193 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+)
+
+// ArchivedTask is a finished task with its lifecycle log, as written to the archive
+type ArchivedTask struct {
+	Task   *Task        `json:"task"`
+	Events []*TaskEvent `json:"events,omitempty"`
+}
+
+// ListExpiredTasks returns up to limit tasks of the status finished before `before`
+// (unix ms), ordered by completed_at and id, starting after the task (afterCompletedAt,
+// afterID). Each task comes with its events.
+func (db *DB) ListExpiredTasks(ctx context.Context, status string, before, afterCompletedAt int64, afterID string, limit int) ([]*ArchivedTask, error) {
+	query := `
+		SELECT id, user_id, product_data, status, result, error_message,
+			   created_at, updated_at, completed_at, priority, retry_count,
+			   max_retries, processor_id, processing_started_at, heartbeat_at,
+			   timeout_at, ollama_params, estimated_duration, actual_duration, rating,
+			   trace_parent
+		FROM tasks
+		WHERE status = ? AND completed_at < ?
+		AND (completed_at > ? OR (completed_at = ? AND id > ?))
+		ORDER BY completed_at ASC, id ASC
+		LIMIT ?
+	`
+
+	rows, err := db.QueuedQuery(ctx, query, status, before, afterCompletedAt, afterCompletedAt, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var archived []*ArchivedTask
+	byID := make(map[string]*ArchivedTask)
+	for rows.Next() {
+		var traceParent sql.NullString
+		task, err := scanTask(withColumns{rows, []interface{}{&traceParent}})
+		if err != nil {
+			return nil, err
+		}
+		if traceParent.Valid {
+			task.TraceParent = &traceParent.String
+		}
+		a := &ArchivedTask{Task: task}
+		archived = append(archived, a)
+		byID[task.ID] = a
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(archived) == 0 {
+		return nil, nil
+	}
+
+	placeholders, args := inList(len(archived), func(i int) interface{} { return archived[i].Task.ID })
+	events, err := db.QueuedQuery(ctx, `
+		SELECT id, task_id, event_type, processor_id, message, created_at
+		FROM task_events
+		WHERE task_id IN (`+placeholders+`)
+		ORDER BY created_at ASC, id ASC
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer events.Close()
+
+	for events.Next() {
+		var event TaskEvent
+		var processorID, message sql.NullString
+		if err := events.Scan(&event.ID, &event.TaskID, &event.EventType, &processorID, &message, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		if processorID.Valid {
+			event.ProcessorID = &processorID.String
+		}
+		if message.Valid {
+			event.Message = &message.String
+		}
+		byID[event.TaskID].Events = append(byID[event.TaskID].Events, &event)
+	}
+
+	return archived, events.Err()
+}
+
+// DeleteArchivedTasks deletes archived tasks and their events. A task changed since
+// it was read (its updated_at differs, e.g. it was rated) is kept, so the change is
+// archived on the next run. Returns the number of deleted tasks.
+func (db *DB) DeleteArchivedTasks(ctx context.Context, tasks []*Task) (int64, error) {
+	ctx, cancel := db.maintenance(ctx)
+	defer cancel()
+
+	var deleted int64
+	err := db.QueuedTransaction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		deleted = 0
+		for _, task := range tasks {
+			result, err := tx.ExecContext(ctx, `DELETE FROM tasks WHERE id = ? AND updated_at = ?`, task.ID, task.UpdatedAt)
+			if err != nil {
+				return err
+			}
+			if n, _ := result.RowsAffected(); n == 0 {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, `DELETE FROM task_events WHERE task_id = ?`, task.ID); err != nil {
+				return err
+			}
+			deleted++
+		}
+		return nil
+	})
+	return deleted, err
+}
+
+// ImportArchivedTask restores an archived task with its events. A task that already
+// exists is only updated if the archived copy is newer (a rating given after an
+// earlier archive run). Returns false if nothing changed.
+func (db *DB) ImportArchivedTask(ctx context.Context, archived *ArchivedTask) (bool, error) {
+	task := archived.Task
+
+	var changed bool
+	err := db.QueuedTransaction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		changed = false
+
+		var updatedAt int64
+		err := tx.QueryRowContext(ctx, `SELECT updated_at FROM tasks WHERE id = ?`, task.ID).Scan(&updatedAt)
+		if err == nil {
+			if task.UpdatedAt <= updatedAt {
+				return nil
+			}
+			_, err = tx.ExecContext(ctx, `UPDATE tasks SET rating = ?, updated_at = ? WHERE id = ?`, task.UserRating, task.UpdatedAt, task.ID)
+			changed = err == nil
+			return err
+		}
+		if err != sql.ErrNoRows {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO tasks (
+				id, user_id, product_data, status, result, error_message,
+				created_at, updated_at, completed_at, priority, retry_count,
+				max_retries, processor_id, processing_started_at, heartbeat_at,
+				timeout_at, ollama_params, estimated_duration, actual_duration, rating,
+				trace_parent
+			) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`,
+			task.ID, task.UserID, task.ProductData, task.Status, task.Result, task.ErrorMessage,
+			task.CreatedAt, task.UpdatedAt, task.CompletedAt, task.Priority, task.RetryCount,
+			task.MaxRetries, task.ProcessorID, task.ProcessingStartedAt, task.HeartbeatAt,
+			task.TimeoutAt, task.OllamaParams, task.EstimatedDuration, task.ActualDuration, task.UserRating,
+			task.TraceParent,
+		)
+		if err != nil {
+			return err
+		}
+
+		for _, event := range archived.Events {
+			_, err := tx.ExecContext(ctx, `
+				INSERT INTO task_events (task_id, event_type, processor_id, message, created_at)
+				VALUES (?, ?, ?, ?, ?)
+			`, task.ID, event.EventType, event.ProcessorID, event.Message, event.CreatedAt)
+			if err != nil {
+				return err
+			}
+		}
+		changed = true
+		return nil
+	})
+	return changed, err
+}
+
+// withColumns scans the columns of scanTask followed by extra columns
+type withColumns struct {
+	rows  rowScanner
+	extra []interface{}
+}
+
+func (s withColumns) Scan(dest ...interface{}) error {
+	return s.rows.Scan(append(dest, s.extra...)...)
+}
+
+// inList returns "?, ?, ..." for n values and the values
+func inList(n int, value func(i int) interface{}) (string, []interface{}) {
+	args := make([]interface{}, n)
+	for i := range args {
+		args[i] = value(i)
+	}
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", "), args
+}
@@ -0,0 +1,97 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ErrInUse is returned by Checkpoint when another connection blocks the checkpoint
+var ErrInUse = errors.New("database is in use by another connection")
+
+// VacuumInto writes a consistent snapshot of the database to path, which must not
+// exist. The snapshot is taken on a separate connection: in WAL mode it is a read
+// transaction, so the writer keeps committing while the file is written.
+func (db *DB) VacuumInto(ctx context.Context, path string) error {
+	ctx, cancel := db.maintenance(ctx)
+	defer cancel()
+
+	span := startSpan(ctx, "VACUUM INTO")
+	defer span.End()
+
+	// Читатели открыты с query_only, а VACUUM INTO для SQLite - запись
+	conn := db.DB
+	if !isMemory(db.path) {
+		var err error
+		conn, err = openPool(db.path, 1)
+		if err != nil {
+			recordSpanError(span, err)
+			return err
+		}
+		defer conn.Close()
+	}
+
+	_, err := conn.ExecContext(ctx, `VACUUM INTO ?`, path)
+	recordSpanError(span, err)
+	return err
+}
+
+// CheckIntegrity runs PRAGMA integrity_check on the database file at path without
+// modifying it. It returns an error listing the problems found, if any.
+func CheckIntegrity(ctx context.Context, path string) error {
+	// openPool создал бы пустую БД на месте отсутствующего файла
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+
+	conn, err := openPool(path, 1, "_pragma=query_only(1)")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	rows, err := conn.QueryContext(ctx, `PRAGMA integrity_check`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var msg string
+		if err := rows.Scan(&msg); err != nil {
+			return err
+		}
+		if msg != "ok" {
+			problems = append(problems, msg)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("integrity check failed: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Checkpoint moves the contents of the WAL into the database file and truncates
+// the WAL, so the file can be copied or moved on its own
+func Checkpoint(ctx context.Context, path string) error {
+	conn, err := openPool(path, 1)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var busy, logFrames, checkpointed int
+	if err := conn.QueryRowContext(ctx, `PRAGMA wal_checkpoint(TRUNCATE)`).Scan(&busy, &logFrames, &checkpointed); err != nil {
+		return err
+	}
+	if busy != 0 {
+		return ErrInUse
+	}
+	return nil
+}
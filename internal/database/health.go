@@ -0,0 +1,52 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+)
+
+// GetSchemaVersion returns the latest migration recorded in schema_migrations
+func (db *DB) GetSchemaVersion(ctx context.Context) (int, error) {
+	var version int
+	err := db.QueuedQueryRow(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	return version, err
+}
+
+// CheckWritable starts a write transaction, which fails if the database file or
+// its directory is read-only or the write lock can't be taken within busy_timeout
+func (db *DB) CheckWritable(ctx context.Context) error {
+	return db.QueuedTransaction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `UPDATE tasks SET id = id WHERE 0`)
+		return err
+	})
+}
+
+// WALSize returns the size of the -wal file of the main database, 0 if there is none
+func (db *DB) WALSize(ctx context.Context) (int64, error) {
+	var seq int
+	var name, file string
+	if err := db.QueuedQueryRow(ctx, `PRAGMA database_list`).Scan(&seq, &name, &file); err != nil {
+		return 0, err
+	}
+	if file == "" {
+		return 0, nil // in-memory database
+	}
+
+	info, err := os.Stat(file + "-wal")
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// CountOnlineProcessors returns the number of processors in the online state
+func (db *DB) CountOnlineProcessors(ctx context.Context) (int, error) {
+	var count int
+	err := db.QueuedQueryRow(ctx, `SELECT COUNT(*) FROM processors WHERE state = ?`, ProcessorStateOnline).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,269 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// legacySchemaVersion is the last migration covered by legacySchema
+const legacySchemaVersion = 11
+
+// legacySchema is the inline schema that RunMigrations executed before versioned
+// migrations. It is only used to bring such databases up to legacySchemaVersion
+// before their migrations are recorded in schema_migrations.
+const legacySchema = `
+	-- Основная таблица задач
+	CREATE TABLE IF NOT EXISTS tasks (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		product_data TEXT NOT NULL,
+		status TEXT NOT NULL CHECK (status IN ('pending', 'processing', 'completed', 'failed')),
+		result TEXT,
+		error_message TEXT,
+		created_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL,
+		completed_at INTEGER,
+		priority INTEGER DEFAULT 0,
+		retry_count INTEGER DEFAULT 0,
+		max_retries INTEGER DEFAULT 3,
+		processor_id TEXT,
+		processing_started_at INTEGER,
+		heartbeat_at INTEGER,
+		timeout_at INTEGER,
+		ollama_params TEXT,
+		estimated_duration INTEGER DEFAULT 300000,
+		actual_duration INTEGER,
+		rating TEXT CHECK (rating IN ('upvote', 'downvote', NULL)),
+		trace_parent TEXT -- W3C traceparent запроса, создавшего задачу
+	);
+
+	-- Rate limiting
+	CREATE TABLE IF NOT EXISTS rate_limits (
+		user_id TEXT PRIMARY KEY,
+		request_count INTEGER NOT NULL DEFAULT 0,
+		window_start INTEGER NOT NULL,
+		last_request INTEGER NOT NULL
+	);
+
+	-- Состояние алгоритмов rate limit (fixed_window, sliding_window, token_bucket)
+	CREATE TABLE IF NOT EXISTS rate_limit_state (
+		user_id TEXT NOT NULL,
+		algorithm TEXT NOT NULL,
+		window_start INTEGER NOT NULL DEFAULT 0,
+		count INTEGER NOT NULL DEFAULT 0,
+		prev_count INTEGER NOT NULL DEFAULT 0,
+		tokens REAL NOT NULL DEFAULT 0,
+		updated_at INTEGER NOT NULL,
+		PRIMARY KEY (user_id, algorithm)
+	);
+
+	-- Журнал запросов для sliding_log
+	CREATE TABLE IF NOT EXISTS rate_limit_log (
+		user_id TEXT NOT NULL,
+		created_at INTEGER NOT NULL
+	);
+
+	-- Расход токенов LLM по задачам
+	CREATE TABLE IF NOT EXISTS task_usage (
+		task_id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		model TEXT NOT NULL DEFAULT '',
+		prompt_tokens INTEGER NOT NULL DEFAULT 0,
+		completion_tokens INTEGER NOT NULL DEFAULT 0,
+		total_tokens INTEGER NOT NULL DEFAULT 0,
+		created_at INTEGER NOT NULL
+	);
+
+	-- Суммарный расход токенов пользователя
+	CREATE TABLE IF NOT EXISTS user_token_usage (
+		user_id TEXT PRIMARY KEY,
+		prompt_tokens INTEGER NOT NULL DEFAULT 0,
+		completion_tokens INTEGER NOT NULL DEFAULT 0,
+		total_tokens INTEGER NOT NULL DEFAULT 0,
+		task_count INTEGER NOT NULL DEFAULT 0,
+		updated_at INTEGER NOT NULL
+	);
+
+	-- Расход токенов по дням (UTC) и моделям
+	CREATE TABLE IF NOT EXISTS daily_token_usage (
+		user_id TEXT NOT NULL,
+		day TEXT NOT NULL,
+		model TEXT NOT NULL DEFAULT '',
+		prompt_tokens INTEGER NOT NULL DEFAULT 0,
+		completion_tokens INTEGER NOT NULL DEFAULT 0,
+		total_tokens INTEGER NOT NULL DEFAULT 0,
+		task_count INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (user_id, day, model)
+	);
+
+	-- Индивидуальные лимиты токенов пользователей
+	CREATE TABLE IF NOT EXISTS user_token_limits (
+		user_id TEXT PRIMARY KEY,
+		max_tokens INTEGER NOT NULL,
+		window_ms INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL
+	);
+
+	-- Учёт завершённых задач для отчётов; не очищается вместе с задачами
+	CREATE TABLE IF NOT EXISTS usage_ledger (
+		task_id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL,
+		model TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		started_at INTEGER,
+		completed_at INTEGER NOT NULL,
+		queue_wait_ms INTEGER,
+		processing_ms INTEGER,
+		retry_count INTEGER NOT NULL DEFAULT 0,
+		prompt_tokens INTEGER,
+		completion_tokens INTEGER,
+		total_tokens INTEGER,
+		recorded_at INTEGER NOT NULL
+	);
+
+	-- Метрики процессоров
+	CREATE TABLE IF NOT EXISTS processor_metrics (
+		processor_id TEXT PRIMARY KEY,
+		cpu_usage REAL NOT NULL DEFAULT 0.0,
+		memory_usage REAL NOT NULL DEFAULT 0.0,
+		queue_size INTEGER NOT NULL DEFAULT 0,
+		active_tasks INTEGER NOT NULL DEFAULT 0,
+		last_updated INTEGER NOT NULL,
+		created_at INTEGER NOT NULL DEFAULT (unixepoch() * 1000)
+	);
+
+	-- Реестр процессоров
+	CREATE TABLE IF NOT EXISTS processors (
+		id TEXT PRIMARY KEY,
+		version TEXT NOT NULL DEFAULT '',
+		hostname TEXT NOT NULL DEFAULT '',
+		labels TEXT NOT NULL DEFAULT '{}',
+		supported_models TEXT NOT NULL DEFAULT '[]',
+		max_concurrency INTEGER NOT NULL DEFAULT 0,
+		first_seen INTEGER NOT NULL,
+		last_seen INTEGER NOT NULL,
+		state TEXT NOT NULL DEFAULT 'online' CHECK (state IN ('online', 'draining', 'offline'))
+	);
+
+	-- Журнал событий задач
+	CREATE TABLE IF NOT EXISTS task_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		task_id TEXT NOT NULL,
+		event_type TEXT NOT NULL,
+		processor_id TEXT,
+		message TEXT,
+		created_at INTEGER NOT NULL
+	);
+
+	-- Отзыв токенов по субъекту
+	CREATE TABLE IF NOT EXISTS token_cutoffs (
+		subject_type TEXT NOT NULL,
+		subject_id TEXT NOT NULL,
+		not_before INTEGER NOT NULL,
+		PRIMARY KEY (subject_type, subject_id)
+	);
+
+	-- Отозванные токены (denylist по jti)
+	CREATE TABLE IF NOT EXISTS revoked_tokens (
+		jti TEXT PRIMARY KEY,
+		expires_at INTEGER NOT NULL,
+		revoked_at INTEGER NOT NULL
+	);
+
+	-- Ключи внутреннего API
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		key_hash TEXT NOT NULL UNIQUE,
+		key_prefix TEXT NOT NULL,
+		scopes TEXT NOT NULL DEFAULT '[]',
+		created_at INTEGER NOT NULL,
+		expires_at INTEGER,
+		last_used_at INTEGER,
+		revoked_at INTEGER
+	);
+
+	-- Индексы для производительности
+	CREATE INDEX IF NOT EXISTS idx_tasks_status ON tasks(status);
+	CREATE INDEX IF NOT EXISTS idx_tasks_user_id ON tasks(user_id);
+	CREATE INDEX IF NOT EXISTS idx_tasks_processor_id ON tasks(processor_id);
+	CREATE INDEX IF NOT EXISTS idx_tasks_created_at ON tasks(created_at);
+	CREATE INDEX IF NOT EXISTS idx_tasks_timeout_at ON tasks(timeout_at);
+	CREATE INDEX IF NOT EXISTS idx_tasks_rating ON tasks(rating);
+	CREATE INDEX IF NOT EXISTS idx_rate_limits_window_start ON rate_limits(window_start);
+	CREATE INDEX IF NOT EXISTS idx_rate_limit_state_updated_at ON rate_limit_state(updated_at);
+	CREATE INDEX IF NOT EXISTS idx_rate_limit_log_user_created ON rate_limit_log(user_id, created_at);
+	CREATE INDEX IF NOT EXISTS idx_task_usage_user_created ON task_usage(user_id, created_at);
+	CREATE INDEX IF NOT EXISTS idx_daily_token_usage_day ON daily_token_usage(day);
+	CREATE INDEX IF NOT EXISTS idx_usage_ledger_completed_at ON usage_ledger(completed_at);
+	CREATE INDEX IF NOT EXISTS idx_usage_ledger_user_completed ON usage_ledger(user_id, completed_at);
+	CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expires_at ON revoked_tokens(expires_at);
+	CREATE INDEX IF NOT EXISTS idx_processor_metrics_last_updated ON processor_metrics(last_updated);
+	CREATE INDEX IF NOT EXISTS idx_processors_state_last_seen ON processors(state, last_seen);
+	CREATE INDEX IF NOT EXISTS idx_task_events_task_id ON task_events(task_id, created_at);
+	`
+
+// adoptLegacySchema records migrations up to legacySchemaVersion as applied for a
+// database created by the inline schema, after completing it the way the inline
+// schema did: missing tables, columns and indexes are added, the usage ledger is
+// backfilled.
+func adoptLegacySchema(ctx context.Context, tx *sql.Tx, all []Migration) error {
+	// CREATE TABLE IF NOT EXISTS не добавляет новые колонки в существующую таблицу
+	if err := addColumnIfMissing(ctx, tx, "tasks", "rating", "TEXT CHECK (rating IN ('upvote', 'downvote', NULL))"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(ctx, tx, "tasks", "trace_parent", "TEXT"); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, legacySchema); err != nil {
+		return fmt.Errorf("legacy schema: %w", err)
+	}
+
+	// Завершённые до появления usage_ledger задачи попадают в него один раз
+	now := time.Now().UnixMilli()
+	if _, err := tx.ExecContext(ctx, usageLedgerBackfill, now); err != nil {
+		return err
+	}
+
+	for _, m := range all {
+		if m.Version > legacySchemaVersion {
+			break
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO schema_migrations (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)`,
+			m.Version, m.Name, m.Checksum, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addColumnIfMissing adds a column to a table created by an older version
+func addColumnIfMissing(ctx context.Context, tx *sql.Tx, table, column, definition string) error {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, typ string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &defaultValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = tx.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
@@ -0,0 +1,789 @@
+package database
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Store kept in memory, for tests that don't need SQLite.
+// It behaves like DB as checked by the store contract tests. Returned values
+// are copies.
+type MemoryStore struct {
+	mu         sync.Mutex
+	tasks      map[string]*memoryTask
+	seq        int64 // порядок вставки, разрешает равные created_at
+	events     []*TaskEvent
+	processors map[string]*Processor
+	metrics    map[string]*ProcessorMetrics
+
+	taskUsage   map[string]*TaskUsage
+	userUsage   map[string]*UserTokenUsage
+	dailyUsage  map[dailyUsageKey]*DailyTokenUsage
+	ledger      map[string]*UsageLedgerEntry
+	tokenLimits map[string]*UserTokenLimit
+
+	apiKeys       map[string]*APIKey
+	revokedTokens map[string]int64
+	tokenCutoffs  map[string]map[string]int64
+
+	rateLimits   map[rateLimitKey]*RateLimitState
+	rateLimitLog map[string][]int64
+}
+
+type memoryTask struct {
+	Task
+	seq int64
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		tasks:         make(map[string]*memoryTask),
+		processors:    make(map[string]*Processor),
+		metrics:       make(map[string]*ProcessorMetrics),
+		taskUsage:     make(map[string]*TaskUsage),
+		userUsage:     make(map[string]*UserTokenUsage),
+		dailyUsage:    make(map[dailyUsageKey]*DailyTokenUsage),
+		ledger:        make(map[string]*UsageLedgerEntry),
+		tokenLimits:   make(map[string]*UserTokenLimit),
+		apiKeys:       make(map[string]*APIKey),
+		revokedTokens: make(map[string]int64),
+		tokenCutoffs:  make(map[string]map[string]int64),
+		rateLimits:    make(map[rateLimitKey]*RateLimitState),
+		rateLimitLog:  make(map[string][]int64),
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func cloneTask(t *memoryTask) *Task {
+	task := t.Task
+	return &task
+}
+
+func cloneProcessor(p *Processor) *Processor {
+	c := *p
+	c.Labels = maps.Clone(p.Labels)
+	c.SupportedModels = slices.Clone(p.SupportedModels)
+	return &c
+}
+
+// sortedTasks returns the tasks matching filter ordered by compare, ties by insertion order
+func (s *MemoryStore) sortedTasks(filter func(*memoryTask) bool, compare func(a, b *memoryTask) int) []*memoryTask {
+	var result []*memoryTask
+	for _, t := range s.tasks {
+		if filter(t) {
+			result = append(result, t)
+		}
+	}
+	slices.SortFunc(result, func(a, b *memoryTask) int {
+		if c := compare(a, b); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.seq, b.seq)
+	})
+	return result
+}
+
+func byPriority(a, b *memoryTask) int {
+	if c := cmp.Compare(b.Priority, a.Priority); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.CreatedAt, b.CreatedAt)
+}
+
+func byCreatedDesc(a, b *memoryTask) int {
+	if c := cmp.Compare(b.CreatedAt, a.CreatedAt); c != 0 {
+		return c
+	}
+	return cmp.Compare(b.seq, a.seq)
+}
+
+func page(tasks []*memoryTask, limit, offset int) []*Task {
+	result := make([]*Task, 0)
+	for i := offset; i < len(tasks) && len(result) < limit; i++ {
+		result = append(result, cloneTask(tasks[i]))
+	}
+	return result
+}
+
+// modelOf returns ollama_params.model of the task, "" if not set
+func modelOf(t *Task) string {
+	if t.OllamaParams == nil {
+		return ""
+	}
+	var params struct {
+		Model string `json:"model"`
+	}
+	if json.Unmarshal([]byte(*t.OllamaParams), &params) != nil {
+		return ""
+	}
+	return params.Model
+}
+
+func isFinished(status string) bool {
+	return status == TaskStatusCompleted || status == TaskStatusFailed
+}
+
+func (s *MemoryStore) activeTasks(processorID string) int {
+	var n int
+	for _, t := range s.tasks {
+		if t.Status == TaskStatusProcessing && t.ProcessorID != nil && *t.ProcessorID == processorID {
+			n++
+		}
+	}
+	return n
+}
+
+// Tasks
+
+func (s *MemoryStore) CreateTask(_ context.Context, task *Task) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.tasks {
+		if t.UserID == task.UserID && (t.Status == TaskStatusPending || t.Status == TaskStatusProcessing) {
+			return ErrActiveTask
+		}
+	}
+	if _, ok := s.tasks[task.ID]; ok {
+		return fmt.Errorf("task %s already exists", task.ID)
+	}
+
+	now := time.Now().UnixMilli()
+	s.seq++
+	stored := &memoryTask{
+		Task: Task{
+			ID:                task.ID,
+			UserID:            task.UserID,
+			ProductData:       task.ProductData,
+			Status:            task.Status,
+			CreatedAt:         now,
+			UpdatedAt:         now,
+			Priority:          task.Priority,
+			MaxRetries:        task.MaxRetries,
+			EstimatedDuration: task.EstimatedDuration,
+			TraceParent:       task.TraceParent,
+		},
+		seq: s.seq,
+	}
+	if task.OllamaParams != nil && *task.OllamaParams != "" {
+		stored.OllamaParams = ptr(*task.OllamaParams)
+	}
+	s.tasks[task.ID] = stored
+	return nil
+}
+
+func (s *MemoryStore) GetTask(_ context.Context, id string) (*Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tasks[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return cloneTask(t), nil
+}
+
+func (s *MemoryStore) GetUserLatestTask(_ context.Context, userID string) (*Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tasks := s.sortedTasks(func(t *memoryTask) bool { return t.UserID == userID }, byCreatedDesc)
+	if len(tasks) == 0 {
+		return nil, nil
+	}
+	return cloneTask(tasks[0]), nil
+}
+
+func (s *MemoryStore) GetPendingTasks(_ context.Context, limit int) ([]*Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tasks := s.sortedTasks(func(t *memoryTask) bool { return t.Status == TaskStatusPending }, byPriority)
+	return page(tasks, limit, 0), nil
+}
+
+func (s *MemoryStore) ListTasks(_ context.Context, filter TaskFilter, after *TaskCursor, limit int) (*TaskPage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tasks := s.sortedTasks(func(t *memoryTask) bool { return filter.match(&t.Task) }, func(a, b *memoryTask) int {
+		if c := cmp.Compare(b.CreatedAt, a.CreatedAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.ID, a.ID)
+	})
+
+	result := &TaskPage{Tasks: []*Task{}, Total: len(tasks)}
+	if limit <= 0 {
+		return result, nil
+	}
+	for _, t := range tasks {
+		if after != nil && !afterCursor(&t.Task, after) {
+			continue
+		}
+		if len(result.Tasks) == limit {
+			last := result.Tasks[limit-1]
+			result.NextCursor = (&TaskCursor{CreatedAt: last.CreatedAt, ID: last.ID}).String()
+			break
+		}
+		result.Tasks = append(result.Tasks, cloneTask(t))
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) GetProcessingTasksByProcessor(_ context.Context, processorID string) ([]*Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tasks := s.sortedTasks(func(t *memoryTask) bool {
+		return t.Status == TaskStatusProcessing && t.ProcessorID != nil && *t.ProcessorID == processorID
+	}, func(a, b *memoryTask) int {
+		return cmp.Compare(*a.ProcessingStartedAt, *b.ProcessingStartedAt)
+	})
+	return page(tasks, len(tasks), 0), nil
+}
+
+func (s *MemoryStore) GetTimedOutTasks(_ context.Context, heartbeatBefore int64) ([]*Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tasks := s.sortedTasks(func(t *memoryTask) bool {
+		return t.Status == TaskStatusProcessing && (t.HeartbeatAt == nil || *t.HeartbeatAt < heartbeatBefore)
+	}, func(a, b *memoryTask) int { return 0 })
+	return page(tasks, len(tasks), 0), nil
+}
+
+func (s *MemoryStore) CountTasksByStatusAndModel(_ context.Context) ([]*TaskCount, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	type key struct{ status, model string }
+	counts := make(map[key]int)
+	for _, t := range s.tasks {
+		counts[key{t.Status, modelOf(&t.Task)}]++
+	}
+
+	result := make([]*TaskCount, 0, len(counts))
+	for k, n := range counts {
+		result = append(result, &TaskCount{Status: k.status, Model: k.model, Count: n})
+	}
+	return result, nil
+}
+
+func (s *MemoryStore) GetTaskStats(_ context.Context, retention Retention, heartbeatBefore int64) (*TaskStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var stats TaskStats
+	for _, t := range s.tasks {
+		stats.Total++
+		switch t.Status {
+		case TaskStatusPending:
+			stats.Pending++
+		case TaskStatusProcessing:
+			stats.Processing++
+			if t.HeartbeatAt != nil && *t.HeartbeatAt < heartbeatBefore {
+				stats.TimedOut++
+			}
+		case TaskStatusCompleted:
+			stats.Completed++
+		case TaskStatusFailed:
+			stats.Failed++
+		}
+		if isFinished(t.Status) && t.CompletedAt != nil && *t.CompletedAt < retention.Before(t.Status) {
+			stats.Expired++
+		}
+	}
+	return &stats, nil
+}
+
+func (s *MemoryStore) GetQueueStats(_ context.Context, completedSince int64) (*QueueStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var stats QueueStats
+	var total int64
+	for _, t := range s.tasks {
+		switch {
+		case t.Status == TaskStatusPending:
+			stats.Pending++
+		case t.Status == TaskStatusCompleted && t.CompletedAt != nil && t.ProcessingStartedAt != nil && *t.CompletedAt > completedSince:
+			stats.Completed++
+			total += *t.CompletedAt - *t.ProcessingStartedAt
+		}
+	}
+	if stats.Completed > 0 {
+		stats.AvgProcessingMs = float64(total) / float64(stats.Completed)
+	}
+	return &stats, nil
+}
+
+func (s *MemoryStore) ClaimTasks(_ context.Context, processorID string, limit int, timeoutMs int64, maxConcurrency int) ([]*Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if maxConcurrency > 0 {
+		limit = min(limit, maxConcurrency-s.activeTasks(processorID))
+	}
+	claimed := make([]*Task, 0)
+	if limit <= 0 {
+		return claimed, nil
+	}
+
+	now := time.Now().UnixMilli()
+	timeoutAt := now + timeoutMs
+	tasks := s.sortedTasks(func(t *memoryTask) bool { return t.Status == TaskStatusPending }, byPriority)
+	for _, t := range tasks[:min(limit, len(tasks))] {
+		t.Status = TaskStatusProcessing
+		t.ProcessorID = ptr(processorID)
+		t.ProcessingStartedAt = ptr(now)
+		t.HeartbeatAt = ptr(now)
+		t.TimeoutAt = ptr(timeoutAt)
+		t.UpdatedAt = now
+		claimed = append(claimed, cloneTask(t))
+	}
+	return claimed, nil
+}
+
+func (s *MemoryStore) HeartbeatTask(_ context.Context, taskID, processorID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tasks[taskID]
+	if !ok || t.Status != TaskStatusProcessing || t.ProcessorID == nil || *t.ProcessorID != processorID {
+		return false, nil
+	}
+	now := time.Now().UnixMilli()
+	t.HeartbeatAt = ptr(now)
+	t.UpdatedAt = now
+	return true, nil
+}
+
+func (s *MemoryStore) StealTasks(_ context.Context, processorID string, limit int, heartbeatBefore, timeoutMs int64) ([]*Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	loads := make(map[string]int)
+	for _, t := range s.tasks {
+		if t.Status == TaskStatusProcessing && t.ProcessorID != nil {
+			loads[*t.ProcessorID]++
+		}
+	}
+
+	tasks := s.sortedTasks(func(t *memoryTask) bool {
+		return t.Status == TaskStatusProcessing && t.ProcessorID != nil && *t.ProcessorID != processorID &&
+			loads[*t.ProcessorID] > stealMinActiveTasks && t.HeartbeatAt != nil && *t.HeartbeatAt < heartbeatBefore
+	}, func(a, b *memoryTask) int {
+		if c := cmp.Compare(loads[*b.ProcessorID], loads[*a.ProcessorID]); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.Priority, a.Priority)
+	})
+
+	now := time.Now().UnixMilli()
+	timeoutAt := now + timeoutMs
+	stolen := make([]*Task, 0)
+	for _, t := range tasks[:min(limit, len(tasks))] {
+		t.ProcessorID = ptr(processorID)
+		t.HeartbeatAt = ptr(now)
+		t.TimeoutAt = ptr(timeoutAt)
+		t.UpdatedAt = now
+		stolen = append(stolen, cloneTask(t))
+	}
+	return stolen, nil
+}
+
+func (s *MemoryStore) UpdateTaskStatus(_ context.Context, id, status string, result, errorMessage *string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tasks[id]
+	if !ok {
+		return nil
+	}
+	now := time.Now().UnixMilli()
+	t.Status = status
+	t.UpdatedAt = now
+	t.Result = result
+	t.ErrorMessage = errorMessage
+	if isFinished(status) {
+		t.CompletedAt = ptr(now)
+	}
+	s.recordLedger(id)
+	return nil
+}
+
+func (s *MemoryStore) RequeueTask(_ context.Context, taskID, processorID string, reason *string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tasks[taskID]
+	if !ok || t.Status != TaskStatusProcessing || t.ProcessorID == nil || *t.ProcessorID != processorID {
+		return false, nil
+	}
+	t.Status = TaskStatusPending
+	t.ProcessorID = nil
+	t.HeartbeatAt = nil
+	t.ProcessingStartedAt = nil
+	t.TimeoutAt = nil
+	t.RetryCount++
+	if reason != nil {
+		t.ErrorMessage = ptr(*reason)
+	}
+	return true, nil
+}
+
+func (s *MemoryStore) FailProcessingTask(_ context.Context, taskID, processorID, errorMessage string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tasks[taskID]
+	if !ok || t.Status != TaskStatusProcessing || t.ProcessorID == nil || *t.ProcessorID != processorID {
+		return false, nil
+	}
+	now := time.Now().UnixMilli()
+	t.Status = TaskStatusFailed
+	t.ErrorMessage = ptr(errorMessage)
+	t.CompletedAt = ptr(now)
+	t.UpdatedAt = now
+	s.recordLedger(taskID)
+	return true, nil
+}
+
+func (s *MemoryStore) UpdateTaskRating(_ context.Context, taskID, userID string, rating *string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tasks[taskID]
+	if !ok || t.UserID != userID {
+		return fmt.Errorf("task not found or not owned by user")
+	}
+	if t.Status != TaskStatusCompleted {
+		return fmt.Errorf("task must be completed to rate it")
+	}
+	if rating != nil {
+		rating = ptr(*rating)
+	}
+	t.UserRating = rating
+	t.UpdatedAt = time.Now().UnixMilli()
+	return nil
+}
+
+func (s *MemoryStore) DeleteFinishedTasks(_ context.Context, status string, before int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+	for id, t := range s.tasks {
+		if t.Status == status && t.CompletedAt != nil && *t.CompletedAt < before {
+			delete(s.tasks, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (s *MemoryStore) LogTaskEvent(_ context.Context, taskID, eventType, processorID, message string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	event := &TaskEvent{
+		ID:        int64(len(s.events) + 1),
+		TaskID:    taskID,
+		EventType: eventType,
+		CreatedAt: time.Now().UnixMilli(),
+	}
+	if processorID != "" {
+		event.ProcessorID = ptr(processorID)
+	}
+	if message != "" {
+		event.Message = ptr(message)
+	}
+	s.events = append(s.events, event)
+	return nil
+}
+
+func (s *MemoryStore) GetTaskEvents(_ context.Context, taskID string) ([]*TaskEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events := make([]*TaskEvent, 0)
+	for _, e := range s.events {
+		if e.TaskID == taskID {
+			event := *e
+			events = append(events, &event)
+		}
+	}
+	return events, nil
+}
+
+// Processors
+
+func (s *MemoryStore) TouchProcessor(_ context.Context, processorID, version, hostname string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UnixMilli()
+	p, ok := s.processors[processorID]
+	if !ok {
+		s.processors[processorID] = &Processor{
+			ID: processorID, Version: version, Hostname: hostname,
+			Labels: map[string]string{}, SupportedModels: []string{},
+			FirstSeen: now, LastSeen: now, State: ProcessorStateOnline,
+		}
+		return nil
+	}
+
+	if version != "" {
+		p.Version = version
+	}
+	if hostname != "" {
+		p.Hostname = hostname
+	}
+	p.LastSeen = now
+	if p.State != ProcessorStateDraining {
+		p.State = ProcessorStateOnline
+	}
+	return nil
+}
+
+func (s *MemoryStore) UpsertProcessor(_ context.Context, p *Processor) error {
+	if p.State == "" {
+		p.State = ProcessorStateOnline
+	}
+	if !IsValidProcessorState(p.State) {
+		return fmt.Errorf("invalid processor state: %s", p.State)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UnixMilli()
+	stored := cloneProcessor(p)
+	if stored.Labels == nil {
+		stored.Labels = map[string]string{}
+	}
+	if stored.SupportedModels == nil {
+		stored.SupportedModels = []string{}
+	}
+	stored.FirstSeen, stored.LastSeen = now, now
+	if existing, ok := s.processors[p.ID]; ok {
+		stored.FirstSeen = existing.FirstSeen
+	}
+	s.processors[p.ID] = stored
+	return nil
+}
+
+func (s *MemoryStore) UpdateProcessor(_ context.Context, processorID string, upd *ProcessorUpdate) error {
+	if upd.State != nil && !IsValidProcessorState(*upd.State) {
+		return fmt.Errorf("invalid processor state: %s", *upd.State)
+	}
+	if *upd == (ProcessorUpdate{}) {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.processors[processorID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if upd.Version != nil {
+		p.Version = *upd.Version
+	}
+	if upd.Hostname != nil {
+		p.Hostname = *upd.Hostname
+	}
+	if upd.Labels != nil {
+		p.Labels = maps.Clone(*upd.Labels)
+	}
+	if upd.SupportedModels != nil {
+		p.SupportedModels = slices.Clone(*upd.SupportedModels)
+	}
+	if upd.MaxConcurrency != nil {
+		p.MaxConcurrency = *upd.MaxConcurrency
+	}
+	if upd.State != nil {
+		p.State = *upd.State
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetProcessor(_ context.Context, processorID string) (*Processor, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.processors[processorID]
+	if !ok {
+		return nil, nil
+	}
+	return cloneProcessor(p), nil
+}
+
+// sortedProcessors returns the processors matching filter ordered by last_seen
+func (s *MemoryStore) sortedProcessors(filter func(*Processor) bool, desc bool) []*Processor {
+	result := make([]*Processor, 0)
+	for _, p := range s.processors {
+		if filter(p) {
+			result = append(result, cloneProcessor(p))
+		}
+	}
+	slices.SortFunc(result, func(a, b *Processor) int {
+		if desc {
+			a, b = b, a
+		}
+		return cmp.Or(cmp.Compare(a.LastSeen, b.LastSeen), cmp.Compare(a.ID, b.ID))
+	})
+	return result
+}
+
+func (s *MemoryStore) ListProcessors(_ context.Context, state string) ([]*Processor, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sortedProcessors(func(p *Processor) bool { return state == "" || p.State == state }, true), nil
+}
+
+func (s *MemoryStore) DeleteProcessor(_ context.Context, processorID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.processors[processorID]
+	delete(s.processors, processorID)
+	return ok, nil
+}
+
+func (s *MemoryStore) GetStaleProcessors(_ context.Context, before int64) ([]*Processor, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sortedProcessors(func(p *Processor) bool {
+		return p.State != ProcessorStateOffline && p.LastSeen < before
+	}, false), nil
+}
+
+func (s *MemoryStore) MarkProcessorOffline(_ context.Context, processorID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.processors[processorID]
+	if !ok || p.State == ProcessorStateOffline {
+		return false, nil
+	}
+	p.State = ProcessorStateOffline
+	return true, nil
+}
+
+func (s *MemoryStore) CountOnlineProcessors(_ context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int
+	for _, p := range s.processors {
+		if p.State == ProcessorStateOnline {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *MemoryStore) GetProcessorCapacity(_ context.Context, processorID string) (maxConcurrency, active int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p, ok := s.processors[processorID]; ok {
+		maxConcurrency = p.MaxConcurrency
+	}
+	return maxConcurrency, s.activeTasks(processorID), nil
+}
+
+func (s *MemoryStore) GetProcessorLoads(_ context.Context, since int64) ([]*ProcessorLoad, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UnixMilli()
+	loads := make([]*ProcessorLoad, 0)
+	for _, p := range s.processors {
+		if p.State != ProcessorStateOnline || p.LastSeen <= since {
+			continue
+		}
+
+		load := &ProcessorLoad{Processor: *cloneProcessor(p)}
+		if m, ok := s.metrics[p.ID]; ok {
+			load.CPUUsage, load.MemoryUsage, load.QueueSize = m.CPUUsage, m.MemoryUsage, m.QueueSize
+		}
+
+		var started, total int64
+		for _, t := range s.tasks {
+			if t.Status != TaskStatusProcessing || t.ProcessorID == nil || *t.ProcessorID != p.ID {
+				continue
+			}
+			load.ActiveTasks++
+			if t.ProcessingStartedAt != nil {
+				started++
+				total += now - *t.ProcessingStartedAt
+			}
+		}
+		if started > 0 {
+			load.AvgProcessingTime = float64(total) / float64(started) / 1000
+		}
+		loads = append(loads, load)
+	}
+
+	score := func(l *ProcessorLoad) float64 {
+		return l.CPUUsage*0.3 + l.MemoryUsage*0.3 + float64(l.ActiveTasks)*0.4
+	}
+	slices.SortFunc(loads, func(a, b *ProcessorLoad) int {
+		return cmp.Or(cmp.Compare(score(a), score(b)), cmp.Compare(a.ID, b.ID))
+	})
+	return loads, nil
+}
+
+func (s *MemoryStore) UpdateProcessorMetrics(_ context.Context, processorID string, cpuUsage, memoryUsage *float64, queueSize *int, activeTasks int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UnixMilli()
+	m, ok := s.metrics[processorID]
+	if !ok {
+		m = &ProcessorMetrics{ProcessorID: processorID, CreatedAt: now}
+		s.metrics[processorID] = m
+	}
+	if cpuUsage != nil {
+		m.CPUUsage = *cpuUsage
+	}
+	if memoryUsage != nil {
+		m.MemoryUsage = *memoryUsage
+	}
+	if queueSize != nil {
+		m.QueueSize = *queueSize
+	}
+	m.ActiveTasks = activeTasks
+	m.LastUpdated = now
+	return nil
+}
+
+func (s *MemoryStore) PruneProcessorMetrics(_ context.Context, before int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var pruned int64
+	for id, m := range s.metrics {
+		if m.LastUpdated < before {
+			delete(s.metrics, id)
+			pruned++
+		}
+	}
+	return pruned, nil
+}
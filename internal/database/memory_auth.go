@@ -0,0 +1,180 @@
+package database
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+)
+
+func cloneAPIKey(k *APIKey) *APIKey {
+	c := *k
+	c.Scopes = slices.Clone(k.Scopes)
+	if k.ExpiresAt != nil {
+		c.ExpiresAt = ptr(*k.ExpiresAt)
+	}
+	if k.LastUsedAt != nil {
+		c.LastUsedAt = ptr(*k.LastUsedAt)
+	}
+	if k.RevokedAt != nil {
+		c.RevokedAt = ptr(*k.RevokedAt)
+	}
+	return &c
+}
+
+// API keys
+
+func (s *MemoryStore) CreateAPIKey(_ context.Context, k *APIKey) error {
+	if k.Scopes == nil {
+		k.Scopes = []string{}
+	}
+	if k.CreatedAt == 0 {
+		k.CreatedAt = time.Now().UnixMilli()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.apiKeys {
+		if existing.ID == k.ID || existing.KeyHash == k.KeyHash {
+			return fmt.Errorf("api key %s already exists", k.ID)
+		}
+	}
+	stored := cloneAPIKey(k)
+	stored.LastUsedAt, stored.RevokedAt = nil, nil
+	s.apiKeys[k.ID] = stored
+	return nil
+}
+
+func (s *MemoryStore) GetAPIKey(_ context.Context, id string) (*APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k, ok := s.apiKeys[id]
+	if !ok {
+		return nil, nil
+	}
+	return cloneAPIKey(k), nil
+}
+
+func (s *MemoryStore) GetAPIKeyByHash(_ context.Context, keyHash string) (*APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, k := range s.apiKeys {
+		if k.KeyHash == keyHash {
+			return cloneAPIKey(k), nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *MemoryStore) ListAPIKeys(_ context.Context) ([]*APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]*APIKey, 0, len(s.apiKeys))
+	for _, k := range s.apiKeys {
+		keys = append(keys, cloneAPIKey(k))
+	}
+	slices.SortFunc(keys, func(a, b *APIKey) int {
+		return cmp.Or(cmp.Compare(b.CreatedAt, a.CreatedAt), cmp.Compare(a.ID, b.ID))
+	})
+	return keys, nil
+}
+
+func (s *MemoryStore) RevokeAPIKey(_ context.Context, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k, ok := s.apiKeys[id]
+	if !ok || k.RevokedAt != nil {
+		return false, nil
+	}
+	k.RevokedAt = ptr(time.Now().UnixMilli())
+	return true, nil
+}
+
+func (s *MemoryStore) ExpireAPIKey(_ context.Context, id string, expiresAt int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k, ok := s.apiKeys[id]
+	if ok && k.RevokedAt == nil && (k.ExpiresAt == nil || *k.ExpiresAt > expiresAt) {
+		k.ExpiresAt = ptr(expiresAt)
+	}
+	return nil
+}
+
+func (s *MemoryStore) TouchAPIKey(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if k, ok := s.apiKeys[id]; ok {
+		k.LastUsedAt = ptr(time.Now().UnixMilli())
+	}
+	return nil
+}
+
+// Token revocation
+
+func (s *MemoryStore) RevokeTokenID(_ context.Context, jti string, expiresAt int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.revokedTokens[jti] = max(s.revokedTokens[jti], expiresAt)
+	return nil
+}
+
+func (s *MemoryStore) RevokeTokensBefore(_ context.Context, subjectType, subjectID string) (int64, error) {
+	notBefore := time.Now().Truncate(time.Second).UnixMilli()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.tokenCutoffs[subjectType] == nil {
+		s.tokenCutoffs[subjectType] = make(map[string]int64)
+	}
+	s.tokenCutoffs[subjectType][subjectID] = notBefore
+	return notBefore, nil
+}
+
+func (s *MemoryStore) ListRevokedTokenIDs(_ context.Context, now int64) (map[string]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	revoked := make(map[string]int64)
+	for jti, expiresAt := range s.revokedTokens {
+		if expiresAt > now {
+			revoked[jti] = expiresAt
+		}
+	}
+	return revoked, nil
+}
+
+func (s *MemoryStore) ListTokenCutoffs(_ context.Context) (map[string]map[string]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoffs := make(map[string]map[string]int64, len(s.tokenCutoffs))
+	for subjectType, subjects := range s.tokenCutoffs {
+		cutoffs[subjectType] = maps.Clone(subjects)
+	}
+	return cutoffs, nil
+}
+
+func (s *MemoryStore) PruneRevokedTokens(_ context.Context, before int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+	for jti, expiresAt := range s.revokedTokens {
+		if expiresAt <= before {
+			delete(s.revokedTokens, jti)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
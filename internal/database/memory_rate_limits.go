@@ -0,0 +1,98 @@
+package database
+
+import (
+	"context"
+	"slices"
+)
+
+type rateLimitKey struct {
+	userID, algorithm string
+}
+
+func (s *MemoryStore) GetRateLimitState(_ context.Context, userID, algorithm string) (*RateLimitState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.rateLimits[rateLimitKey{userID, algorithm}]
+	if !ok {
+		return nil, nil
+	}
+	c := *state
+	return &c, nil
+}
+
+// UpdateRateLimitState calls update under the store lock, so update must not use the store
+func (s *MemoryStore) UpdateRateLimitState(_ context.Context, userID, algorithm string, update func(*RateLimitState) *RateLimitState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := rateLimitKey{userID, algorithm}
+	var current *RateLimitState
+	if state, ok := s.rateLimits[key]; ok {
+		c := *state
+		current = &c
+	}
+
+	stored := *update(current)
+	s.rateLimits[key] = &stored
+	return nil
+}
+
+func (s *MemoryStore) ListRateLimitLog(_ context.Context, userID string, since int64) ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.rateLimitLogSince(userID, since), nil
+}
+
+func (s *MemoryStore) AddRateLimitLog(_ context.Context, userID string, at, pruneBefore int64) ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rateLimitLog[userID] = append(s.rateLimitLog[userID], at)
+	log := s.rateLimitLogSince(userID, pruneBefore)
+	s.rateLimitLog[userID] = slices.Clone(log)
+	return log, nil
+}
+
+// rateLimitLogSince returns the user's log entries newer than since, oldest first
+func (s *MemoryStore) rateLimitLogSince(userID string, since int64) []int64 {
+	var log []int64
+	for _, at := range s.rateLimitLog[userID] {
+		if at > since {
+			log = append(log, at)
+		}
+	}
+	slices.Sort(log)
+	return log
+}
+
+func (s *MemoryStore) PruneRateLimits(_ context.Context, before int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var removed int64
+	for key, state := range s.rateLimits {
+		if state.UpdatedAt < before {
+			delete(s.rateLimits, key)
+			removed++
+		}
+	}
+	for userID, log := range s.rateLimitLog {
+		kept := slices.DeleteFunc(slices.Clone(log), func(at int64) bool { return at < before })
+		removed += int64(len(log) - len(kept))
+		if len(kept) == 0 {
+			delete(s.rateLimitLog, userID)
+		} else {
+			s.rateLimitLog[userID] = kept
+		}
+	}
+	return removed, nil
+}
+
+func (s *MemoryStore) CountRateLimitRecords(_ context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return int64(len(s.rateLimits)), nil
+}
@@ -0,0 +1,117 @@
+package database
+
+import (
+	"cmp"
+	"context"
+	"maps"
+	"slices"
+	"time"
+)
+
+func (s *MemoryStore) GetTasksRatingStats(_ context.Context, userID *string) (map[string]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := make(map[string]int)
+	for _, t := range s.tasks {
+		if t.UserRating != nil && (userID == nil || t.UserID == *userID) {
+			stats[*t.UserRating]++
+		}
+	}
+	return stats, nil
+}
+
+func (s *MemoryStore) GetUserRatedTasks(_ context.Context, userID string, rating *string, limit, offset int) ([]*Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tasks := s.sortedTasks(func(t *memoryTask) bool {
+		return t.UserID == userID && t.UserRating != nil && (rating == nil || *t.UserRating == *rating)
+	}, byCreatedDesc)
+	return page(tasks, limit, offset), nil
+}
+
+// GetRatingStatsByPeriod groups by periods of the local time zone, as DB does
+func (s *MemoryStore) GetRatingStatsByPeriod(_ context.Context, period string, count int) ([]map[string]interface{}, error) {
+	var results []map[string]interface{}
+
+	now := time.Now()
+	if _, _, err := truncatePeriod(now, period); err != nil {
+		return results, err
+	}
+	// Окно "последние count периодов", как datetime('now', '-count period')
+	var since time.Time
+	switch period {
+	case PeriodHour:
+		since = now.Add(-time.Duration(count) * time.Hour)
+	case PeriodDay:
+		since = now.AddDate(0, 0, -count)
+	case PeriodWeek:
+		since = now.AddDate(0, 0, -count*7)
+	case PeriodMonth:
+		since = now.AddDate(0, -count, 0)
+	}
+	since = since.Truncate(time.Second)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	type stats struct {
+		start                          time.Time
+		label                          string
+		upvotes, downvotes, totalRated int
+	}
+	periods := make(map[string]*stats)
+	for _, t := range s.tasks {
+		if t.Status != TaskStatusCompleted || t.CompletedAt == nil {
+			continue
+		}
+		start, label, _ := truncatePeriod(time.UnixMilli(*t.CompletedAt), period)
+		if start.Before(since) {
+			continue
+		}
+		p, ok := periods[label]
+		if !ok {
+			p = &stats{start: start, label: label}
+			periods[label] = p
+		}
+		if t.UserRating != nil {
+			p.totalRated++
+			switch *t.UserRating {
+			case "upvote":
+				p.upvotes++
+			case "downvote":
+				p.downvotes++
+			}
+		}
+	}
+
+	// Последние count периодов в порядке возрастания
+	ordered := slices.SortedFunc(maps.Values(periods), func(a, b *stats) int { return a.start.Compare(b.start) })
+	ordered = ordered[max(0, len(ordered)-count):]
+	for _, p := range ordered {
+		var qualityScore float64
+		if p.totalRated > 0 {
+			qualityScore = float64(p.upvotes-p.downvotes) / float64(p.totalRated) * 100
+		}
+
+		results = append(results, map[string]interface{}{
+			"period":        p.label,
+			"upvotes":       p.upvotes,
+			"downvotes":     p.downvotes,
+			"total_rated":   p.totalRated,
+			"quality_score": qualityScore,
+		})
+	}
+	return results, nil
+}
+
+func (s *MemoryStore) GetRecentRatedTasks(_ context.Context, limit int) ([]*Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tasks := s.sortedTasks(func(t *memoryTask) bool {
+		return t.UserRating != nil && t.Status == TaskStatusCompleted
+	}, func(a, b *memoryTask) int { return cmp.Compare(b.UpdatedAt, a.UpdatedAt) })
+	return page(tasks, limit, 0), nil
+}
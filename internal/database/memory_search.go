@@ -0,0 +1,169 @@
+package database
+
+import (
+	"cmp"
+	"context"
+	"strings"
+)
+
+// snippetTokens is the snippet length in words, as in snippet(..., 16) of DB
+const snippetTokens = 16
+
+// searchToken is a word of a searched field: its lowercased text and byte range
+type searchToken struct {
+	text       string
+	start, end int
+}
+
+// tokenize splits text into runs of letters and digits, like the FTS5 unicode61 tokenizer
+func tokenize(text string) []searchToken {
+	var tokens []searchToken
+	start := -1
+	for i, r := range text {
+		switch {
+		case isWordRune(r) && start < 0:
+			start = i
+		case !isWordRune(r) && start >= 0:
+			tokens = append(tokens, searchToken{strings.ToLower(text[start:i]), start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, searchToken{strings.ToLower(text[start:]), start, len(text)})
+	}
+	return tokens
+}
+
+// matchTerm returns the [first, last] token ranges where the phrase of the term occurs
+func matchTerm(tokens []searchToken, term searchTerm) [][2]int {
+	words := tokenize(term.text)
+	var matches [][2]int
+	for i := 0; i+len(words) <= len(tokens); i++ {
+		matched := true
+		for j, w := range words {
+			token := tokens[i+j].text
+			if j == len(words)-1 && term.prefix {
+				matched = strings.HasPrefix(token, w.text)
+			} else {
+				matched = token == w.text
+			}
+			if !matched {
+				break
+			}
+		}
+		if matched {
+			matches = append(matches, [2]int{i, i + len(words) - 1})
+		}
+	}
+	return matches
+}
+
+// snippet returns up to snippetTokens words of text around its first match,
+// with the matches wrapped in markOpen/markClose, as snippet() of FTS5 does
+func snippet(text string, tokens []searchToken, matches [][2]int) string {
+	first := matches[0][0]
+	for _, m := range matches {
+		first = min(first, m[0])
+	}
+	from := max(0, min(first, len(tokens)-snippetTokens))
+	to := min(len(tokens), from+snippetTokens)
+
+	var b strings.Builder
+	pos := 0
+	if from > 0 {
+		b.WriteString("…")
+		pos = tokens[from].start
+	}
+	end := len(text)
+	if to < len(tokens) {
+		end = tokens[to-1].end
+	}
+	for i := from; i < to; i++ {
+		for _, m := range matches {
+			if m[0] == i {
+				b.WriteString(text[pos:tokens[i].start])
+				b.WriteString(markOpen)
+				last := min(m[1], to-1)
+				b.WriteString(text[tokens[i].start:tokens[last].end])
+				b.WriteString(markClose)
+				pos = tokens[last].end
+				i = last
+				break
+			}
+		}
+	}
+	b.WriteString(text[pos:end])
+	if to < len(tokens) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+// SearchTasks matches the query like DB does, but ignores only case, not diacritics.
+// Snippets start at the first match rather than at the window FTS5 would pick.
+func (s *MemoryStore) SearchTasks(_ context.Context, query string, filter TaskFilter, after *TaskCursor, limit int) (*TaskSearchPage, error) {
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return nil, ErrInvalidSearchQuery
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snippets := make(map[string]map[string]string)
+	tasks := s.sortedTasks(func(t *memoryTask) bool {
+		if !filter.match(&t.Task) {
+			return false
+		}
+
+		fields := []*string{&t.ProductData, t.Result, t.ErrorMessage}
+		tokens := make([][]searchToken, len(fields))
+		matches := make([][][2]int, len(fields))
+		for _, term := range terms {
+			found := false
+			for i, field := range fields {
+				if field == nil {
+					continue
+				}
+				if tokens[i] == nil {
+					tokens[i] = tokenize(*field)
+				}
+				if m := matchTerm(tokens[i], term); len(m) > 0 {
+					matches[i] = append(matches[i], m...)
+					found = true
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+
+		hit := make(map[string]string)
+		for i, m := range matches {
+			if len(m) > 0 {
+				hit[searchColumns[i]] = highlight(snippet(*fields[i], tokens[i], m))
+			}
+		}
+		snippets[t.ID] = hit
+		return true
+	}, func(a, b *memoryTask) int {
+		return cmp.Or(cmp.Compare(b.CreatedAt, a.CreatedAt), cmp.Compare(b.ID, a.ID))
+	})
+
+	result := &TaskSearchPage{Tasks: []*TaskSearchHit{}, Total: len(tasks)}
+	if limit <= 0 {
+		return result, nil
+	}
+	for _, t := range tasks {
+		if after != nil && !afterCursor(&t.Task, after) {
+			continue
+		}
+		if len(result.Tasks) == limit {
+			last := result.Tasks[limit-1]
+			result.NextCursor = (&TaskCursor{CreatedAt: last.CreatedAt, ID: last.ID}).String()
+			break
+		}
+		result.Tasks = append(result.Tasks, &TaskSearchHit{Task: cloneTask(t), Snippets: snippets[t.ID]})
+	}
+	return result, nil
+}
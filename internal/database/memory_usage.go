@@ -0,0 +1,271 @@
+package database
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+)
+
+type dailyUsageKey struct {
+	userID, day, model string
+}
+
+func (s *MemoryStore) RecordTaskUsage(_ context.Context, usage *TaskUsage) (bool, error) {
+	if usage.TotalTokens == 0 {
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
+	if usage.CreatedAt == 0 {
+		usage.CreatedAt = time.Now().UnixMilli()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.taskUsage[usage.TaskID]; ok {
+		return false, nil
+	}
+	stored := *usage
+	s.taskUsage[usage.TaskID] = &stored
+
+	total, ok := s.userUsage[usage.UserID]
+	if !ok {
+		total = &UserTokenUsage{UserID: usage.UserID}
+		s.userUsage[usage.UserID] = total
+	}
+	total.PromptTokens += usage.PromptTokens
+	total.CompletionTokens += usage.CompletionTokens
+	total.TotalTokens += usage.TotalTokens
+	total.TaskCount++
+	total.UpdatedAt = usage.CreatedAt
+
+	key := dailyUsageKey{usage.UserID, usageDay(usage.CreatedAt), usage.Model}
+	daily, ok := s.dailyUsage[key]
+	if !ok {
+		daily = &DailyTokenUsage{UserID: key.userID, Day: key.day, Model: key.model}
+		s.dailyUsage[key] = daily
+	}
+	daily.PromptTokens += usage.PromptTokens
+	daily.CompletionTokens += usage.CompletionTokens
+	daily.TotalTokens += usage.TotalTokens
+	daily.TaskCount++
+
+	s.recordLedger(usage.TaskID)
+	return true, nil
+}
+
+func (s *MemoryStore) GetUserTokenUsage(_ context.Context, userID string) (*UserTokenUsage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if usage, ok := s.userUsage[userID]; ok {
+		c := *usage
+		return &c, nil
+	}
+	return &UserTokenUsage{UserID: userID}, nil
+}
+
+func (s *MemoryStore) SumUserTokens(_ context.Context, userID string, since int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var total int64
+	for _, u := range s.taskUsage {
+		if u.UserID == userID && u.CreatedAt > since {
+			total += u.TotalTokens
+		}
+	}
+	return total, nil
+}
+
+func (s *MemoryStore) ListDailyTokenUsage(_ context.Context, userID, fromDay string) ([]*DailyTokenUsage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var days []*DailyTokenUsage
+	for _, d := range s.dailyUsage {
+		if d.UserID == userID && d.Day >= fromDay {
+			c := *d
+			days = append(days, &c)
+		}
+	}
+	slices.SortFunc(days, func(a, b *DailyTokenUsage) int {
+		return cmp.Or(cmp.Compare(b.Day, a.Day), cmp.Compare(a.Model, b.Model))
+	})
+	return days, nil
+}
+
+func (s *MemoryStore) SetUserTokenLimit(_ context.Context, limit *UserTokenLimit) error {
+	limit.UpdatedAt = time.Now().UnixMilli()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := *limit
+	s.tokenLimits[limit.UserID] = &stored
+	return nil
+}
+
+func (s *MemoryStore) GetUserTokenLimit(_ context.Context, userID string) (*UserTokenLimit, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	limit, ok := s.tokenLimits[userID]
+	if !ok {
+		return nil, nil
+	}
+	c := *limit
+	return &c, nil
+}
+
+func (s *MemoryStore) ListUserTokenLimits(_ context.Context) ([]*UserTokenLimit, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var limits []*UserTokenLimit
+	for _, limit := range s.tokenLimits {
+		c := *limit
+		limits = append(limits, &c)
+	}
+	slices.SortFunc(limits, func(a, b *UserTokenLimit) int { return cmp.Compare(a.UserID, b.UserID) })
+	return limits, nil
+}
+
+func (s *MemoryStore) DeleteUserTokenLimit(_ context.Context, userID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.tokenLimits[userID]
+	delete(s.tokenLimits, userID)
+	return ok, nil
+}
+
+// recordLedger writes the ledger entry of a finished task, as recordUsageLedger does.
+// The caller holds s.mu.
+func (s *MemoryStore) recordLedger(taskID string) {
+	t, ok := s.tasks[taskID]
+	if !ok || !isFinished(t.Status) {
+		return
+	}
+
+	entry := &UsageLedgerEntry{
+		TaskID:      t.ID,
+		UserID:      t.UserID,
+		Model:       modelOf(&t.Task),
+		Status:      t.Status,
+		CreatedAt:   t.CreatedAt,
+		CompletedAt: t.UpdatedAt,
+		RetryCount:  t.RetryCount,
+	}
+	if t.CompletedAt != nil {
+		entry.CompletedAt = *t.CompletedAt
+	}
+	if t.ProcessingStartedAt != nil {
+		entry.StartedAt = ptr(*t.ProcessingStartedAt)
+		entry.QueueWaitMs = ptr(*t.ProcessingStartedAt - t.CreatedAt)
+		entry.ProcessingMs = ptr(entry.CompletedAt - *t.ProcessingStartedAt)
+	}
+	if usage, ok := s.taskUsage[taskID]; ok {
+		if usage.Model != "" {
+			entry.Model = usage.Model
+		}
+		entry.PromptTokens = ptr(usage.PromptTokens)
+		entry.CompletionTokens = ptr(usage.CompletionTokens)
+		entry.TotalTokens = ptr(usage.TotalTokens)
+	}
+	s.ledger[taskID] = entry
+}
+
+func (s *MemoryStore) GetUsageReport(_ context.Context, filter UsageReportFilter) ([]*UsageReportRow, error) {
+	if _, _, err := truncatePeriod(time.Now(), filter.Period); err != nil {
+		return nil, err
+	}
+	grouped := make(map[string]bool)
+	for _, g := range filter.GroupBy {
+		if _, ok := usageGroupColumns[g]; !ok {
+			return nil, fmt.Errorf("unsupported group: %s", g)
+		}
+		grouped[g] = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	type group struct {
+		start time.Time
+		row   UsageReportRow
+		// суммы для средних, как AVG по не-NULL значениям
+		queueWait, withProcessing, withQueueWait int64
+	}
+	groups := make(map[UsageReportRow]*group)
+
+	for _, e := range s.ledger {
+		if e.CompletedAt < filter.From || e.CompletedAt >= filter.To || (filter.UserID != "" && e.UserID != filter.UserID) {
+			continue
+		}
+
+		start, label, _ := truncatePeriod(time.UnixMilli(e.CompletedAt).UTC(), filter.Period)
+		key := UsageReportRow{Period: label}
+		if grouped[UsageGroupUser] {
+			key.UserID = e.UserID
+		}
+		if grouped[UsageGroupModel] {
+			key.Model = e.Model
+		}
+		if grouped[UsageGroupStatus] {
+			key.Status = e.Status
+		}
+		g, ok := groups[key]
+		if !ok {
+			g = &group{start: start, row: key}
+			groups[key] = g
+		}
+
+		r := &g.row
+		r.Tasks++
+		switch e.Status {
+		case TaskStatusCompleted:
+			r.Completed++
+		case TaskStatusFailed:
+			r.Failed++
+		}
+		if e.ProcessingMs != nil {
+			r.ProcessingMs += *e.ProcessingMs
+			g.withProcessing++
+		}
+		if e.QueueWaitMs != nil {
+			g.queueWait += *e.QueueWaitMs
+			g.withQueueWait++
+		}
+		if e.TotalTokens != nil {
+			r.TasksWithTokens++
+			r.PromptTokens += *e.PromptTokens
+			r.CompletionTokens += *e.CompletionTokens
+			r.TotalTokens += *e.TotalTokens
+		}
+	}
+
+	ordered := slices.SortedFunc(maps.Values(groups), func(a, b *group) int {
+		return cmp.Or(a.start.Compare(b.start),
+			cmp.Compare(a.row.UserID, b.row.UserID),
+			cmp.Compare(a.row.Model, b.row.Model),
+			cmp.Compare(a.row.Status, b.row.Status))
+	})
+	var report []*UsageReportRow
+	for _, g := range ordered {
+		r := g.row
+		if g.withProcessing > 0 {
+			r.AvgProcessingMs = float64(r.ProcessingMs) / float64(g.withProcessing)
+		}
+		if g.withQueueWait > 0 {
+			r.AvgQueueWaitMs = float64(g.queueWait) / float64(g.withQueueWait)
+		}
+		if r.Tasks > 0 {
+			r.FailureRate = float64(r.Failed) / float64(r.Tasks)
+		}
+		report = append(report, &r)
+	}
+	return report, nil
+}
@@ -0,0 +1,252 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ad/go-llm-manager/migrations"
+)
+
+// Состояния миграций в MigrationStatus
+const (
+	MigrationPending  = "pending"
+	MigrationApplied  = "applied"
+	MigrationModified = "modified" // применена, но файл с тех пор изменён
+	MigrationMissing  = "missing"  // применена, но файла нет в источнике
+)
+
+const schemaMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INTEGER PRIMARY KEY,
+	name TEXT NOT NULL,
+	checksum TEXT NOT NULL,
+	applied_at INTEGER NOT NULL
+)`
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.sql$`)
+
+// Migration is an up-migration file NNNN_name.sql
+type Migration struct {
+	Version  int    `json:"version"`
+	Name     string `json:"name"`
+	SQL      string `json:"-"`
+	Checksum string `json:"checksum"`
+}
+
+// MigrationState is a migration of the source or of schema_migrations with its status
+type MigrationState struct {
+	Migration
+	Status    string `json:"status"`
+	AppliedAt *int64 `json:"applied_at,omitempty"`
+}
+
+// MigrationSource returns the migrations of dir, or the embedded ones if dir is empty.
+// The error wraps fs.ErrNotExist if dir does not exist.
+func MigrationSource(dir string) (fs.FS, error) {
+	if dir == "" {
+		return migrations.FS, nil
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("migrations path: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("migrations path %s is not a directory", dir)
+	}
+	return os.DirFS(dir), nil
+}
+
+// LoadMigrations reads the *.sql files of source ordered by version. Other files are ignored.
+func LoadMigrations(source fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(source, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	var result []Migration
+	seen := make(map[int]string)
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		version, _ := strconv.Atoi(match[1])
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("duplicate migration version %d: %s and %s", version, other, entry.Name())
+		}
+		seen[version] = entry.Name()
+
+		data, err := fs.ReadFile(source, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(data)
+		result = append(result, Migration{
+			Version:  version,
+			Name:     match[2],
+			SQL:      string(data),
+			Checksum: hex.EncodeToString(sum[:]),
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Version < result[j].Version })
+	return result, nil
+}
+
+// LatestSchemaVersion returns the version of the last embedded migration
+func LatestSchemaVersion() int {
+	all, err := LoadMigrations(migrations.FS)
+	if err != nil || len(all) == 0 {
+		return 0
+	}
+	return all[len(all)-1].Version
+}
+
+// Migrate applies pending migrations of source in version order, each in its own
+// transaction, and returns the applied ones. A database created before versioned
+// migrations is adopted first: migrations it already has are recorded as applied.
+func (db *DB) Migrate(ctx context.Context, source fs.FS) ([]Migration, error) {
+	ctx, cancel := db.maintenance(ctx)
+	defer cancel()
+
+	all, err := LoadMigrations(source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load migrations: %w", err)
+	}
+
+	if err := db.initMigrations(ctx, all); err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+	for _, m := range all {
+		ok, err := db.applyMigration(ctx, m)
+		if err != nil {
+			return applied, fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+		if ok {
+			applied = append(applied, m)
+		}
+	}
+	return applied, nil
+}
+
+// initMigrations creates schema_migrations. If the database already has tasks but
+// no schema_migrations, it was created by the inline schema and is adopted.
+func (db *DB) initMigrations(ctx context.Context, all []Migration) error {
+	return db.QueuedTransaction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		hasMigrations, err := tableExists(ctx, tx, "schema_migrations")
+		if err != nil || hasMigrations {
+			return err
+		}
+		legacy, err := tableExists(ctx, tx, "tasks")
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, schemaMigrationsTable); err != nil {
+			return err
+		}
+		if legacy {
+			return adoptLegacySchema(ctx, tx, all)
+		}
+		return nil
+	})
+}
+
+// applyMigration runs m unless it is already recorded. The record is inserted first,
+// so a concurrent runner waiting for the write lock skips the migration.
+func (db *DB) applyMigration(ctx context.Context, m Migration) (bool, error) {
+	var applied bool
+	err := db.QueuedTransaction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO schema_migrations (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)`,
+			m.Version, m.Name, m.Checksum, time.Now().UnixMilli())
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, m.SQL); err != nil {
+			return err
+		}
+		applied = true
+		return nil
+	})
+	return applied, err
+}
+
+// MigrationStatus compares the migrations of source with schema_migrations
+func (db *DB) MigrationStatus(ctx context.Context, source fs.FS) ([]MigrationState, error) {
+	all, err := LoadMigrations(source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load migrations: %w", err)
+	}
+
+	recorded := make(map[int]MigrationState)
+	exists, err := db.schemaMigrationsExists(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		rows, err := db.QueuedQuery(ctx, `SELECT version, name, checksum, applied_at FROM schema_migrations`)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var state MigrationState
+			var appliedAt int64
+			if err := rows.Scan(&state.Version, &state.Name, &state.Checksum, &appliedAt); err != nil {
+				return nil, err
+			}
+			state.AppliedAt = &appliedAt
+			state.Status = MigrationMissing
+			recorded[state.Version] = state
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	result := make([]MigrationState, 0, len(all))
+	for _, m := range all {
+		state := MigrationState{Migration: m, Status: MigrationPending}
+		if rec, ok := recorded[m.Version]; ok {
+			delete(recorded, m.Version)
+			state.AppliedAt = rec.AppliedAt
+			state.Status = MigrationApplied
+			if rec.Checksum != m.Checksum {
+				state.Status = MigrationModified
+			}
+		}
+		result = append(result, state)
+	}
+	for _, rec := range recorded {
+		result = append(result, rec)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Version < result[j].Version })
+	return result, nil
+}
+
+func (db *DB) schemaMigrationsExists(ctx context.Context) (bool, error) {
+	var n int
+	err := db.QueuedQueryRow(ctx, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'`).Scan(&n)
+	return n > 0, err
+}
+
+func tableExists(ctx context.Context, tx *sql.Tx, name string) (bool, error) {
+	var n int
+	err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, name).Scan(&n)
+	return n > 0, err
+}
@@ -0,0 +1,149 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/ad/go-llm-manager/migrations"
+)
+
+func newEmptyDB(t *testing.T) *DB {
+	db, err := NewSQLiteDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestMigrateFreshDatabase(t *testing.T) {
+	ctx := t.Context()
+	db := newEmptyDB(t)
+
+	all, err := LoadMigrations(migrations.FS)
+	if err != nil {
+		t.Fatalf("failed to load migrations: %v", err)
+	}
+	applied, err := db.Migrate(ctx, migrations.FS)
+	if err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+	if len(applied) != len(all) {
+		t.Fatalf("expected %d applied migrations, got %d", len(all), len(applied))
+	}
+
+	version, err := db.GetSchemaVersion(ctx)
+	if err != nil || version != LatestSchemaVersion() {
+		t.Errorf("expected schema version %d, got %d (%v)", LatestSchemaVersion(), version, err)
+	}
+
+	// Повторный запуск ничего не применяет
+	applied, err = db.Migrate(ctx, migrations.FS)
+	if err != nil || len(applied) != 0 {
+		t.Errorf("expected no migrations on rerun, got %d (%v)", len(applied), err)
+	}
+
+	if err := db.CreateTask(ctx, &Task{ID: "t-1", UserID: "u-1", ProductData: "p", Status: "pending"}); err != nil {
+		t.Errorf("create task on migrated schema: %v", err)
+	}
+}
+
+func TestMigrateAdoptsLegacySchema(t *testing.T) {
+	ctx := t.Context()
+	db := newEmptyDB(t)
+
+	// База, созданная встроенной схемой до версионных миграций
+	if _, err := db.Exec(legacySchema); err != nil {
+		t.Fatalf("failed to create legacy schema: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO tasks (id, user_id, product_data, status, created_at, updated_at, completed_at)
+		VALUES ('t-1', 'u-1', 'p', 'completed', 1000, 2000, 2000)`); err != nil {
+		t.Fatalf("failed to insert task: %v", err)
+	}
+
+	applied, err := db.Migrate(ctx, migrations.FS)
+	if err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+	for _, m := range applied {
+		if m.Version <= legacySchemaVersion {
+			t.Errorf("migration %d should be recorded, not applied", m.Version)
+		}
+	}
+
+	states, err := db.MigrationStatus(ctx, migrations.FS)
+	if err != nil {
+		t.Fatalf("status failed: %v", err)
+	}
+	for _, s := range states {
+		if s.Status != MigrationApplied {
+			t.Errorf("migration %04d_%s: expected applied, got %s", s.Version, s.Name, s.Status)
+		}
+	}
+
+	var indexes int
+	db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name = 'idx_tasks_claim_pending'`).Scan(&indexes)
+	if indexes != 1 {
+		t.Error("expected indexes of the initial schema on the adopted database")
+	}
+	if entry, err := db.GetUsageLedgerEntry(ctx, "t-1"); err != nil || entry == nil {
+		t.Errorf("expected the completed task in the usage ledger, got %v (%v)", entry, err)
+	}
+}
+
+func TestMigrateRollsBackFailedMigration(t *testing.T) {
+	ctx := t.Context()
+	db := newEmptyDB(t)
+
+	source := fstest.MapFS{
+		"0001_create.sql": {Data: []byte(`CREATE TABLE items (id INTEGER PRIMARY KEY);`)},
+		"0002_broken.sql": {Data: []byte(`CREATE TABLE others (id INTEGER); INSERT INTO missing VALUES (1);`)},
+		"README.md":       {Data: []byte("not a migration")},
+	}
+
+	applied, err := db.Migrate(ctx, source)
+	if err == nil {
+		t.Fatal("expected broken migration to fail")
+	}
+	if len(applied) != 1 || applied[0].Version != 1 {
+		t.Errorf("expected only the first migration applied, got %+v", applied)
+	}
+
+	var tables int
+	db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'others'`).Scan(&tables)
+	if tables != 0 {
+		t.Error("expected the failed migration to be rolled back")
+	}
+
+	states, err := db.MigrationStatus(ctx, source)
+	if err != nil {
+		t.Fatalf("status failed: %v", err)
+	}
+	if len(states) != 2 || states[0].Status != MigrationApplied || states[1].Status != MigrationPending {
+		t.Errorf("unexpected status: %+v", states)
+	}
+
+	// Исправленный файл применяется, изменённый после применения — помечается
+	source["0002_broken.sql"] = &fstest.MapFile{Data: []byte(`CREATE TABLE others (id INTEGER);`)}
+	source["0001_create.sql"] = &fstest.MapFile{Data: []byte(`CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT);`)}
+	if _, err := db.Migrate(ctx, source); err != nil {
+		t.Fatalf("migrate after fix failed: %v", err)
+	}
+	delete(source, "0002_broken.sql")
+
+	states, _ = db.MigrationStatus(ctx, source)
+	if len(states) != 2 || states[0].Status != MigrationModified || states[1].Status != MigrationMissing {
+		t.Errorf("unexpected status: %+v", states)
+	}
+}
+
+func TestLoadMigrationsRejectsDuplicateVersions(t *testing.T) {
+	source := fstest.MapFS{
+		"0001_a.sql": {Data: []byte(`SELECT 1;`)},
+		"1_b.sql":    {Data: []byte(`SELECT 1;`)},
+	}
+	if _, err := LoadMigrations(source); err == nil {
+		t.Error("expected error for duplicate versions")
+	}
+}
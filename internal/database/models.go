@@ -25,7 +25,8 @@ type Task struct {
 	OllamaParams        *string `json:"ollama_params,omitempty" db:"ollama_params"`
 	EstimatedDuration   *int64  `json:"estimated_duration,omitempty" db:"estimated_duration"`
 	ActualDuration      *int64  `json:"actual_duration,omitempty" db:"actual_duration"`
-	UserRating          *string `json:"rating,omitempty" db:"rating"` // "upvote", "downvote" или NULL
+	UserRating          *string `json:"rating,omitempty" db:"rating"`            // "upvote", "downvote" или NULL
+	TraceParent         *string `json:"traceparent,omitempty" db:"trace_parent"` // W3C trace context создания задачи
 }
 
 type OllamaParams struct {
@@ -70,11 +71,56 @@ func (t *Task) GetOllamaParams() (*OllamaParams, error) {
 	return &params, nil
 }
 
-type RateLimit struct {
-	UserID       string `json:"user_id" db:"user_id"`
-	RequestCount int    `json:"request_count" db:"request_count"`
-	WindowStart  int64  `json:"window_start" db:"window_start"`
-	LastRequest  int64  `json:"last_request" db:"last_request"`
+// RateLimitState is the persisted counter of one rate limit algorithm for a user.
+// Fields not used by the algorithm stay zero.
+type RateLimitState struct {
+	UserID      string  `json:"user_id" db:"user_id"`
+	Algorithm   string  `json:"algorithm" db:"algorithm"`
+	WindowStart int64   `json:"window_start" db:"window_start"`
+	Count       int     `json:"count" db:"count"`
+	PrevCount   int     `json:"prev_count" db:"prev_count"` // sliding_window: счетчик предыдущего окна
+	Tokens      float64 `json:"tokens" db:"tokens"`         // token_bucket: остаток токенов
+	UpdatedAt   int64   `json:"updated_at" db:"updated_at"`
+}
+
+// TaskUsage is the LLM token usage reported by the processor for a task
+type TaskUsage struct {
+	TaskID           string `json:"task_id" db:"task_id"`
+	UserID           string `json:"user_id" db:"user_id"`
+	Model            string `json:"model" db:"model"`
+	PromptTokens     int64  `json:"prompt_tokens" db:"prompt_tokens"`
+	CompletionTokens int64  `json:"completion_tokens" db:"completion_tokens"`
+	TotalTokens      int64  `json:"total_tokens" db:"total_tokens"`
+	CreatedAt        int64  `json:"created_at" db:"created_at"`
+}
+
+// UserTokenUsage is the all-time token usage of a user
+type UserTokenUsage struct {
+	UserID           string `json:"user_id" db:"user_id"`
+	PromptTokens     int64  `json:"prompt_tokens" db:"prompt_tokens"`
+	CompletionTokens int64  `json:"completion_tokens" db:"completion_tokens"`
+	TotalTokens      int64  `json:"total_tokens" db:"total_tokens"`
+	TaskCount        int64  `json:"task_count" db:"task_count"`
+	UpdatedAt        int64  `json:"updated_at" db:"updated_at"`
+}
+
+// DailyTokenUsage is the token usage of a user per UTC day and model
+type DailyTokenUsage struct {
+	UserID           string `json:"user_id" db:"user_id"`
+	Day              string `json:"day" db:"day"` // YYYY-MM-DD
+	Model            string `json:"model" db:"model"`
+	PromptTokens     int64  `json:"prompt_tokens" db:"prompt_tokens"`
+	CompletionTokens int64  `json:"completion_tokens" db:"completion_tokens"`
+	TotalTokens      int64  `json:"total_tokens" db:"total_tokens"`
+	TaskCount        int64  `json:"task_count" db:"task_count"`
+}
+
+// UserTokenLimit is a per-user token quota set by an administrator
+type UserTokenLimit struct {
+	UserID    string `json:"user_id" db:"user_id"`
+	MaxTokens int64  `json:"max_tokens" db:"max_tokens"`
+	WindowMs  int64  `json:"window_ms" db:"window_ms"`
+	UpdatedAt int64  `json:"updated_at" db:"updated_at"`
 }
 
 type ProcessorMetrics struct {
@@ -87,6 +133,70 @@ type ProcessorMetrics struct {
 	CreatedAt   int64   `json:"created_at" db:"created_at"`
 }
 
+// Processor is a registered task processor (worker)
+type Processor struct {
+	ID              string            `json:"id" db:"id"`
+	Version         string            `json:"version,omitempty" db:"version"`
+	Hostname        string            `json:"hostname,omitempty" db:"hostname"`
+	Labels          map[string]string `json:"labels,omitempty" db:"labels"`
+	SupportedModels []string          `json:"supported_models,omitempty" db:"supported_models"`
+	MaxConcurrency  int               `json:"max_concurrency" db:"max_concurrency"` // 0 = без ограничения
+	FirstSeen       int64             `json:"first_seen" db:"first_seen"`
+	LastSeen        int64             `json:"last_seen" db:"last_seen"`
+	State           string            `json:"state" db:"state"` // "online", "draining" или "offline"
+}
+
+// ProcessorUpdate holds optional fields for a partial processor update
+type ProcessorUpdate struct {
+	Version         *string            `json:"version,omitempty"`
+	Hostname        *string            `json:"hostname,omitempty"`
+	Labels          *map[string]string `json:"labels,omitempty"`
+	SupportedModels *[]string          `json:"supported_models,omitempty"`
+	MaxConcurrency  *int               `json:"max_concurrency,omitempty"`
+	State           *string            `json:"state,omitempty"`
+}
+
+// ProcessorLoad is a registered processor joined with its latest metrics and active tasks
+type ProcessorLoad struct {
+	Processor
+	CPUUsage          float64 `json:"cpu_usage"`
+	MemoryUsage       float64 `json:"memory_usage"`
+	QueueSize         int     `json:"queue_size"`
+	ActiveTasks       int     `json:"active_tasks"`
+	AvgProcessingTime float64 `json:"avg_processing_time"` // seconds
+}
+
+// APIKey is an internal API key. Only the SHA-256 hash of the key is stored.
+type APIKey struct {
+	ID         string   `json:"id" db:"id"`
+	Name       string   `json:"name" db:"name"`
+	KeyHash    string   `json:"-" db:"key_hash"`
+	KeyPrefix  string   `json:"key_prefix" db:"key_prefix"`
+	Scopes     []string `json:"scopes" db:"scopes"`
+	CreatedAt  int64    `json:"created_at" db:"created_at"`
+	ExpiresAt  *int64   `json:"expires_at,omitempty" db:"expires_at"`
+	LastUsedAt *int64   `json:"last_used_at,omitempty" db:"last_used_at"`
+	RevokedAt  *int64   `json:"revoked_at,omitempty" db:"revoked_at"`
+}
+
+// IsActive reports whether the key is neither revoked nor expired at `now` (unix ms)
+func (k *APIKey) IsActive(now int64) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || *k.ExpiresAt > now
+}
+
+// TaskEvent is an entry in the task lifecycle log
+type TaskEvent struct {
+	ID          int64   `json:"id" db:"id"`
+	TaskID      string  `json:"task_id" db:"task_id"`
+	EventType   string  `json:"event_type" db:"event_type"`
+	ProcessorID *string `json:"processor_id,omitempty" db:"processor_id"`
+	Message     *string `json:"message,omitempty" db:"message"`
+	CreatedAt   int64   `json:"created_at" db:"created_at"`
+}
+
 // Request/Response models
 type CreateTaskRequest struct {
 	ProductData  string        `json:"product_data" binding:"required"`
@@ -119,22 +229,33 @@ type VoteResponse struct {
 }
 
 type JWTPayload struct {
-	UserID       string           `json:"user_id"`
-	TaskID       string           `json:"taskId,omitempty"`
-	ProductData  string           `json:"product_data,omitempty"`
-	Priority     *int             `json:"priority,omitempty"`
-	OllamaParams *OllamaParams    `json:"ollama_params,omitempty"`
-	ProcessorID  string           `json:"processor_id,omitempty"`
-	RateLimit    *RateLimitConfig `json:"rate_limit,omitempty"`
-	Issuer       string           `json:"iss"`
-	Audience     string           `json:"aud,omitempty"` // Optional, used in some tokens
-	Subject      string           `json:"sub"`
-	ExpiresAt    int64            `json:"exp"`
+	UserID            string            `json:"user_id"`
+	TaskID            string            `json:"taskId,omitempty"`
+	ProductData       string            `json:"product_data,omitempty"`
+	ProductDataSHA256 string            `json:"product_data_sha256,omitempty"` // product_data is sent in the /api/create body
+	Priority          *int              `json:"priority,omitempty"`
+	OllamaParams      *OllamaParams     `json:"ollama_params,omitempty"`
+	ProcessorID       string            `json:"processor_id,omitempty"`
+	RateLimit         *RateLimitConfig  `json:"rate_limit,omitempty"`
+	TokenLimit        *TokenLimitConfig `json:"token_limit,omitempty"`
+	Issuer            string            `json:"iss"`
+	Audience          string            `json:"aud,omitempty"` // Optional, used in some tokens
+	Subject           string            `json:"sub"`
+	ExpiresAt         int64             `json:"exp"`
+	IssuedAt          int64             `json:"iat,omitempty"`
+	ID                string            `json:"jti,omitempty"`
 }
 
 type RateLimitConfig struct {
-	MaxRequests int   `json:"max_requests"`
-	WindowMs    int64 `json:"window_ms"`
+	MaxRequests int    `json:"max_requests"`
+	WindowMs    int64  `json:"window_ms"`
+	Algorithm   string `json:"algorithm,omitempty"` // fixed_window, sliding_log, sliding_window или token_bucket
+}
+
+// TokenLimitConfig limits LLM tokens used by a user's tasks per window
+type TokenLimitConfig struct {
+	MaxTokens int64 `json:"max_tokens"`
+	WindowMs  int64 `json:"window_ms"`
 }
 
 // SSE Events
@@ -158,15 +279,16 @@ type APIResponse struct {
 }
 
 type GenerateTokenRequest struct {
-	UserID        string           `json:"user_id,omitempty"`
-	ProcessorID   string           `json:"processor_id,omitempty"`
-	DurationHours *int             `json:"duration_hours,omitempty"`
-	TaskID        string           `json:"taskId,omitempty"`
-	ExpiresIn     *int             `json:"expires_in,omitempty"`
-	ProductData   string           `json:"product_data,omitempty"`
-	Priority      *int             `json:"priority,omitempty"`
-	OllamaParams  *OllamaParams    `json:"ollama_params,omitempty"`
-	RateLimit     *RateLimitConfig `json:"rate_limit,omitempty"`
+	UserID        string            `json:"user_id,omitempty"`
+	ProcessorID   string            `json:"processor_id,omitempty"`
+	DurationHours *int              `json:"duration_hours,omitempty"`
+	TaskID        string            `json:"taskId,omitempty"`
+	ExpiresIn     *int              `json:"expires_in,omitempty"`
+	ProductData   string            `json:"product_data,omitempty"`
+	Priority      *int              `json:"priority,omitempty"`
+	OllamaParams  *OllamaParams     `json:"ollama_params,omitempty"`
+	RateLimit     *RateLimitConfig  `json:"rate_limit,omitempty"`
+	TokenLimit    *TokenLimitConfig `json:"token_limit,omitempty"`
 }
 
 type ClaimTasksRequest struct {
@@ -207,6 +329,28 @@ const (
 	TaskStatusFailed     = "failed"
 )
 
+// Processor state constants
+const (
+	ProcessorStateOnline   = "online"
+	ProcessorStateDraining = "draining"
+	ProcessorStateOffline  = "offline"
+)
+
+// Task event type constants
+const (
+	TaskEventRequeued = "requeued"
+	TaskEventFailed   = "failed"
+)
+
+// IsValidProcessorState reports whether state is a known processor state
+func IsValidProcessorState(state string) bool {
+	switch state {
+	case ProcessorStateOnline, ProcessorStateDraining, ProcessorStateOffline:
+		return true
+	}
+	return false
+}
+
 // SSE event types
 const (
 	SSEEventTaskStatus    = "task_status"
@@ -0,0 +1,59 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// Report periods for grouping by time
+const (
+	PeriodHour  = "hour"
+	PeriodDay   = "day"
+	PeriodWeek  = "week"
+	PeriodMonth = "month"
+)
+
+// periodStart returns an SQL expression truncating a unix ms column to the start of
+// the period (as 'YYYY-MM-DD HH:MM:SS') and the strftime format of the period label.
+// Weeks start on Monday. With localtime the server time zone is used, otherwise UTC.
+func periodStart(period, column string, localtime bool) (string, string, error) {
+	ts := column + "/1000, 'unixepoch'"
+	if localtime {
+		ts += ", 'localtime'"
+	}
+
+	switch period {
+	case PeriodHour:
+		return fmt.Sprintf("strftime('%%Y-%%m-%%d %%H:00:00', %s)", ts), "%Y-%m-%d %H", nil
+	case PeriodDay:
+		return fmt.Sprintf("datetime(%s, 'start of day')", ts), "%Y-%m-%d", nil
+	case PeriodWeek:
+		return fmt.Sprintf("datetime(%s, 'start of day', '-6 days', 'weekday 1')", ts), "%Y-%m-%d", nil
+	case PeriodMonth:
+		return fmt.Sprintf("datetime(%s, 'start of month')", ts), "%Y-%m", nil
+	}
+
+	return "", "", fmt.Errorf("unsupported period: %s", period)
+}
+
+// truncatePeriod is periodStart for MemoryStore: it returns the start of the period
+// containing t, in the location of t, and the period label.
+func truncatePeriod(t time.Time, period string) (time.Time, string, error) {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+
+	switch period {
+	case PeriodHour:
+		start := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+		return start, start.Format("2006-01-02 15"), nil
+	case PeriodDay:
+		return day, day.Format(time.DateOnly), nil
+	case PeriodWeek:
+		start := day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+		return start, start.Format(time.DateOnly), nil
+	case PeriodMonth:
+		start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+		return start, start.Format("2006-01"), nil
+	}
+
+	return time.Time{}, "", fmt.Errorf("unsupported period: %s", period)
+}
@@ -0,0 +1,380 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Processor registry operations
+
+const processorColumns = `id, version, hostname, labels, supported_models, max_concurrency, first_seen, last_seen, state`
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanProcessor(row rowScanner) (*Processor, error) {
+	var p Processor
+	var labelsJSON, modelsJSON string
+
+	err := row.Scan(
+		&p.ID, &p.Version, &p.Hostname, &labelsJSON, &modelsJSON,
+		&p.MaxConcurrency, &p.FirstSeen, &p.LastSeen, &p.State,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if labelsJSON != "" {
+		if err := json.Unmarshal([]byte(labelsJSON), &p.Labels); err != nil {
+			return nil, fmt.Errorf("invalid labels for processor %s: %w", p.ID, err)
+		}
+	}
+	if modelsJSON != "" {
+		if err := json.Unmarshal([]byte(modelsJSON), &p.SupportedModels); err != nil {
+			return nil, fmt.Errorf("invalid supported_models for processor %s: %w", p.ID, err)
+		}
+	}
+
+	return &p, nil
+}
+
+func encodeLabels(labels map[string]string) (string, error) {
+	if labels == nil {
+		return "{}", nil
+	}
+	data, err := json.Marshal(labels)
+	return string(data), err
+}
+
+func encodeModels(models []string) (string, error) {
+	if models == nil {
+		return "[]", nil
+	}
+	data, err := json.Marshal(models)
+	return string(data), err
+}
+
+// TouchProcessor records that a processor is alive (heartbeat or task-stream connect).
+// Unknown processors are registered as online; draining processors stay draining.
+func (db *DB) TouchProcessor(ctx context.Context, processorID, version, hostname string) error {
+	query := `
+		INSERT INTO processors (id, version, hostname, first_seen, last_seen, state)
+		VALUES (?, ?, ?, ?, ?, 'online')
+		ON CONFLICT(id) DO UPDATE SET
+			version = CASE WHEN excluded.version != '' THEN excluded.version ELSE processors.version END,
+			hostname = CASE WHEN excluded.hostname != '' THEN excluded.hostname ELSE processors.hostname END,
+			last_seen = excluded.last_seen,
+			state = CASE WHEN processors.state = 'draining' THEN 'draining' ELSE 'online' END
+	`
+
+	now := time.Now().UnixMilli()
+	_, err := db.QueuedExec(ctx, query, processorID, version, hostname, now, now)
+	return err
+}
+
+// UpsertProcessor registers a processor or replaces its declared attributes
+func (db *DB) UpsertProcessor(ctx context.Context, p *Processor) error {
+	if p.State == "" {
+		p.State = ProcessorStateOnline
+	}
+	if !IsValidProcessorState(p.State) {
+		return fmt.Errorf("invalid processor state: %s", p.State)
+	}
+
+	labelsJSON, err := encodeLabels(p.Labels)
+	if err != nil {
+		return err
+	}
+	modelsJSON, err := encodeModels(p.SupportedModels)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO processors (
+			id, version, hostname, labels, supported_models, max_concurrency,
+			first_seen, last_seen, state
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			version = excluded.version,
+			hostname = excluded.hostname,
+			labels = excluded.labels,
+			supported_models = excluded.supported_models,
+			max_concurrency = excluded.max_concurrency,
+			last_seen = excluded.last_seen,
+			state = excluded.state
+	`
+
+	now := time.Now().UnixMilli()
+	_, err = db.QueuedExec(ctx, query,
+		p.ID, p.Version, p.Hostname, labelsJSON, modelsJSON, p.MaxConcurrency,
+		now, now, p.State,
+	)
+	return err
+}
+
+// UpdateProcessor applies a partial update. Returns sql.ErrNoRows if the processor is unknown.
+func (db *DB) UpdateProcessor(ctx context.Context, processorID string, upd *ProcessorUpdate) error {
+	var sets []string
+	var args []interface{}
+
+	if upd.Version != nil {
+		sets = append(sets, "version = ?")
+		args = append(args, *upd.Version)
+	}
+	if upd.Hostname != nil {
+		sets = append(sets, "hostname = ?")
+		args = append(args, *upd.Hostname)
+	}
+	if upd.Labels != nil {
+		labelsJSON, err := encodeLabels(*upd.Labels)
+		if err != nil {
+			return err
+		}
+		sets = append(sets, "labels = ?")
+		args = append(args, labelsJSON)
+	}
+	if upd.SupportedModels != nil {
+		modelsJSON, err := encodeModels(*upd.SupportedModels)
+		if err != nil {
+			return err
+		}
+		sets = append(sets, "supported_models = ?")
+		args = append(args, modelsJSON)
+	}
+	if upd.MaxConcurrency != nil {
+		sets = append(sets, "max_concurrency = ?")
+		args = append(args, *upd.MaxConcurrency)
+	}
+	if upd.State != nil {
+		if !IsValidProcessorState(*upd.State) {
+			return fmt.Errorf("invalid processor state: %s", *upd.State)
+		}
+		sets = append(sets, "state = ?")
+		args = append(args, *upd.State)
+	}
+
+	if len(sets) == 0 {
+		return nil
+	}
+
+	query := fmt.Sprintf(`UPDATE processors SET %s WHERE id = ?`, strings.Join(sets, ", "))
+	args = append(args, processorID)
+
+	result, err := db.QueuedExec(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetProcessor returns a processor by ID or nil if it is not registered
+func (db *DB) GetProcessor(ctx context.Context, processorID string) (*Processor, error) {
+	query := `SELECT ` + processorColumns + ` FROM processors WHERE id = ?`
+
+	p, err := scanProcessor(db.QueuedQueryRow(ctx, query, processorID))
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// ListProcessors returns registered processors, optionally filtered by state
+func (db *DB) ListProcessors(ctx context.Context, state string) ([]*Processor, error) {
+	query := `SELECT ` + processorColumns + ` FROM processors`
+	var args []interface{}
+
+	if state != "" {
+		query += ` WHERE state = ?`
+		args = append(args, state)
+	}
+	query += ` ORDER BY last_seen DESC`
+
+	rows, err := db.QueuedQuery(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	processors := make([]*Processor, 0)
+	for rows.Next() {
+		p, err := scanProcessor(rows)
+		if err != nil {
+			return nil, err
+		}
+		processors = append(processors, p)
+	}
+
+	return processors, rows.Err()
+}
+
+// DeleteProcessor removes a processor from the registry
+func (db *DB) DeleteProcessor(ctx context.Context, processorID string) (bool, error) {
+	result, err := db.QueuedExec(ctx, `DELETE FROM processors WHERE id = ?`, processorID)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected > 0, nil
+}
+
+// GetProcessorLoads returns online processors seen after `since` with their latest
+// reported metrics and current number of processing tasks, least loaded first
+func (db *DB) GetProcessorLoads(ctx context.Context, since int64) ([]*ProcessorLoad, error) {
+	query := `
+		SELECT
+			p.id, p.version, p.hostname, p.labels, p.supported_models, p.max_concurrency,
+			p.first_seen, p.last_seen, p.state,
+			COALESCE(pm.cpu_usage, 0) as cpu_usage,
+			COALESCE(pm.memory_usage, 0) as memory_usage,
+			COALESCE(pm.queue_size, 0) as queue_size,
+			COUNT(t.id) as active_tasks,
+			COALESCE(AVG(? - t.processing_started_at), 0) / 1000.0 as avg_processing_time
+		FROM processors p
+		LEFT JOIN processor_metrics pm ON pm.processor_id = p.id
+		LEFT JOIN tasks t ON t.processor_id = p.id AND t.status = 'processing'
+		WHERE p.state = 'online' AND p.last_seen > ?
+		GROUP BY p.id
+		ORDER BY
+			(COALESCE(pm.cpu_usage, 0) * 0.3 + COALESCE(pm.memory_usage, 0) * 0.3 + COUNT(t.id) * 0.4) ASC
+	`
+
+	now := time.Now().UnixMilli()
+	rows, err := db.QueuedQuery(ctx, query, now, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	loads := make([]*ProcessorLoad, 0)
+	for rows.Next() {
+		var load ProcessorLoad
+		var labelsJSON, modelsJSON string
+
+		err := rows.Scan(
+			&load.ID, &load.Version, &load.Hostname, &labelsJSON, &modelsJSON,
+			&load.MaxConcurrency, &load.FirstSeen, &load.LastSeen, &load.State,
+			&load.CPUUsage, &load.MemoryUsage, &load.QueueSize,
+			&load.ActiveTasks, &load.AvgProcessingTime,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if labelsJSON != "" {
+			_ = json.Unmarshal([]byte(labelsJSON), &load.Labels)
+		}
+		if modelsJSON != "" {
+			_ = json.Unmarshal([]byte(modelsJSON), &load.SupportedModels)
+		}
+
+		loads = append(loads, &load)
+	}
+
+	return loads, rows.Err()
+}
+
+// GetStaleProcessors returns online and draining processors not seen since `before`
+func (db *DB) GetStaleProcessors(ctx context.Context, before int64) ([]*Processor, error) {
+	query := `
+		SELECT ` + processorColumns + `
+		FROM processors
+		WHERE state IN ('online', 'draining') AND last_seen < ?
+		ORDER BY last_seen ASC
+	`
+
+	rows, err := db.QueuedQuery(ctx, query, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	processors := make([]*Processor, 0)
+	for rows.Next() {
+		p, err := scanProcessor(rows)
+		if err != nil {
+			return nil, err
+		}
+		processors = append(processors, p)
+	}
+
+	return processors, rows.Err()
+}
+
+// MarkProcessorOffline switches a processor to offline. Returns false if it was
+// already offline or is not registered.
+func (db *DB) MarkProcessorOffline(ctx context.Context, processorID string) (bool, error) {
+	result, err := db.QueuedExec(ctx,
+		`UPDATE processors SET state = 'offline' WHERE id = ? AND state != 'offline'`,
+		processorID,
+	)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected > 0, nil
+}
+
+// GetProcessorCapacity returns the declared max concurrency of a processor and the
+// number of tasks it is currently processing. maxConcurrency is 0 for processors
+// without a limit, including unregistered ones.
+func (db *DB) GetProcessorCapacity(ctx context.Context, processorID string) (maxConcurrency, active int, err error) {
+	query := `
+		SELECT
+			COALESCE((SELECT max_concurrency FROM processors WHERE id = ?), 0),
+			(SELECT COUNT(*) FROM tasks WHERE processor_id = ? AND status = 'processing')
+	`
+	err = db.QueuedQueryRow(ctx, query, processorID, processorID).Scan(&maxConcurrency, &active)
+	return maxConcurrency, active, err
+}
+
+// UpdateProcessorMetrics stores the latest metrics reported by a processor.
+// Metrics that are nil keep their previous values (0 for a new processor).
+func (db *DB) UpdateProcessorMetrics(ctx context.Context, processorID string, cpuUsage, memoryUsage *float64, queueSize *int, activeTasks int) error {
+	query := `
+		INSERT OR REPLACE INTO processor_metrics 
+		(processor_id, cpu_usage, memory_usage, queue_size, active_tasks, last_updated, created_at)
+		VALUES (?, 
+		        COALESCE(?, (SELECT cpu_usage FROM processor_metrics WHERE processor_id = ?)), 
+		        COALESCE(?, (SELECT memory_usage FROM processor_metrics WHERE processor_id = ?)),
+		        COALESCE(?, (SELECT queue_size FROM processor_metrics WHERE processor_id = ?)),
+		        ?,
+		        ?, 
+		        COALESCE((SELECT created_at FROM processor_metrics WHERE processor_id = ?), ?))
+	`
+
+	now := time.Now().UnixMilli()
+	_, err := db.QueuedExec(ctx, query,
+		processorID, cpuUsage, processorID,
+		memoryUsage, processorID,
+		queueSize, processorID,
+		activeTasks,
+		now, processorID, now)
+	return err
+}
+
+// PruneProcessorMetrics deletes metrics not updated since `before`
+func (db *DB) PruneProcessorMetrics(ctx context.Context, before int64) (int64, error) {
+	ctx, cancel := db.maintenance(ctx)
+	defer cancel()
+
+	result, err := db.QueuedExec(ctx, `DELETE FROM processor_metrics WHERE last_updated < ?`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,152 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+)
+
+const rateLimitStateColumns = `user_id, algorithm, window_start, count, prev_count, tokens, updated_at`
+
+func scanRateLimitState(row rowScanner) (*RateLimitState, error) {
+	var state RateLimitState
+	err := row.Scan(
+		&state.UserID, &state.Algorithm, &state.WindowStart, &state.Count,
+		&state.PrevCount, &state.Tokens, &state.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// GetRateLimitState returns the stored counter of the algorithm for the user, or nil if none
+func (db *DB) GetRateLimitState(ctx context.Context, userID, algorithm string) (*RateLimitState, error) {
+	return scanRateLimitState(db.QueuedQueryRow(ctx, `
+		SELECT `+rateLimitStateColumns+`
+		FROM rate_limit_state
+		WHERE user_id = ? AND algorithm = ?
+	`, userID, algorithm))
+}
+
+// UpdateRateLimitState reads the counter of the algorithm for the user, passes it to
+// update (nil if none) and stores the returned state, all in one write transaction,
+// so concurrent updates of the same counter do not overwrite each other
+func (db *DB) UpdateRateLimitState(ctx context.Context, userID, algorithm string, update func(*RateLimitState) *RateLimitState) error {
+	return db.QueuedTransaction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		state, err := scanRateLimitState(tx.QueryRowContext(ctx, `
+			SELECT `+rateLimitStateColumns+`
+			FROM rate_limit_state
+			WHERE user_id = ? AND algorithm = ?
+		`, userID, algorithm))
+		if err != nil {
+			return err
+		}
+
+		state = update(state)
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO rate_limit_state (`+rateLimitStateColumns+`)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(user_id, algorithm) DO UPDATE SET
+				window_start = excluded.window_start,
+				count = excluded.count,
+				prev_count = excluded.prev_count,
+				tokens = excluded.tokens,
+				updated_at = excluded.updated_at
+		`, state.UserID, state.Algorithm, state.WindowStart, state.Count,
+			state.PrevCount, state.Tokens, state.UpdatedAt,
+		)
+		return err
+	})
+}
+
+const listRateLimitLogQuery = `
+	SELECT created_at FROM rate_limit_log
+	WHERE user_id = ? AND created_at > ?
+	ORDER BY created_at ASC
+`
+
+func scanRateLimitLog(rows *sql.Rows) ([]int64, error) {
+	var log []int64
+	for rows.Next() {
+		var createdAt int64
+		if err := rows.Scan(&createdAt); err != nil {
+			return nil, err
+		}
+		log = append(log, createdAt)
+	}
+
+	return log, rows.Err()
+}
+
+// ListRateLimitLog returns the user's request timestamps (unix ms) newer than since, oldest first
+func (db *DB) ListRateLimitLog(ctx context.Context, userID string, since int64) ([]int64, error) {
+	rows, err := db.QueuedQuery(ctx, listRateLimitLogQuery, userID, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanRateLimitLog(rows.Rows)
+}
+
+// AddRateLimitLog records a request at the given time, drops the user's entries
+// at or before pruneBefore (unix ms) and returns the remaining ones, oldest first.
+// Everything happens in one write transaction, so the returned log includes every
+// request recorded before this one.
+func (db *DB) AddRateLimitLog(ctx context.Context, userID string, at, pruneBefore int64) ([]int64, error) {
+	var log []int64
+	err := db.QueuedTransaction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM rate_limit_log WHERE user_id = ? AND created_at <= ?`, userID, pruneBefore); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO rate_limit_log (user_id, created_at) VALUES (?, ?)`, userID, at); err != nil {
+			return err
+		}
+		rows, err := tx.QueryContext(ctx, listRateLimitLogQuery, userID, pruneBefore)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		log, err = scanRateLimitLog(rows)
+		return err
+	})
+	return log, err
+}
+
+// PruneRateLimits removes counters and log entries not touched since before (unix ms)
+func (db *DB) PruneRateLimits(ctx context.Context, before int64) (int64, error) {
+	ctx, cancel := db.maintenance(ctx)
+	defer cancel()
+
+	var removed int64
+
+	err := db.QueuedTransaction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		removed = 0
+		for _, query := range []string{
+			`DELETE FROM rate_limits WHERE last_request < ?`,
+			`DELETE FROM rate_limit_state WHERE updated_at < ?`,
+			`DELETE FROM rate_limit_log WHERE created_at < ?`,
+		} {
+			result, err := tx.ExecContext(ctx, query, before)
+			if err != nil {
+				return err
+			}
+			n, _ := result.RowsAffected()
+			removed += n
+		}
+		return nil
+	})
+
+	return removed, err
+}
+
+// CountRateLimitRecords returns the number of stored rate limit counters
+func (db *DB) CountRateLimitRecords(ctx context.Context) (int64, error) {
+	var count int64
+	err := db.QueuedQueryRow(ctx, `SELECT COUNT(*) FROM rate_limit_state`).Scan(&count)
+	return count, err
+}
@@ -6,55 +6,114 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"sync"
+	"strings"
 	"time"
 
+	"github.com/ad/go-llm-manager/internal/metrics"
+	"github.com/ad/go-llm-manager/migrations"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 	_ "modernc.org/sqlite"
 )
 
-// RequestQueue manages database requests to prevent BUSY errors
-type RequestQueue struct {
-	mutex     sync.Mutex
-	semaphore chan struct{}
+// Timeouts are the deadlines of database operations by class. Zero means no
+// deadline besides the one of the caller's context.
+type Timeouts struct {
+	Read        time.Duration // SELECT
+	Write       time.Duration // INSERT/UPDATE/DELETE and transactions
+	Maintenance time.Duration // migrations, cleanup of old rows
 }
 
-// NewRequestQueue creates a new request queue with specified concurrency limit
-func NewRequestQueue(maxConcurrency int) *RequestQueue {
-	return &RequestQueue{
-		semaphore: make(chan struct{}, maxConcurrency),
-	}
+// DefaultTimeouts are used until SetTimeouts is called
+var DefaultTimeouts = Timeouts{
+	Read:        10 * time.Second,
+	Write:       30 * time.Second,
+	Maintenance: 10 * time.Minute,
 }
 
-// Execute runs a function with controlled concurrency
-func (rq *RequestQueue) Execute(ctx context.Context, fn func() error) error {
-	// Try to acquire semaphore with context timeout
-	select {
-	case rq.semaphore <- struct{}{}:
-		defer func() { <-rq.semaphore }()
-		return fn()
-	case <-ctx.Done():
-		return ctx.Err()
+type opClass int
+
+const (
+	opRead opClass = iota
+	opWrite
+	opMaintenance
+)
+
+// deadlineKey marks a context that already carries the deadline of an operation,
+// so statements of a maintenance operation are not cut to the write deadline
+type deadlineKey struct{}
+
+// DB is the SQLite database. All writes go through a single writer goroutine
+// (see writer.go) that owns the only write connection; reads use a separate pool
+// of read-only connections, which in WAL mode never wait for the writer.
+type DB struct {
+	*sql.DB // the write connection, for statements outside the writer such as PRAGMAs
+
+	path     string
+	reader   *sql.DB
+	writer   *writer
+	timeouts Timeouts
+}
+
+// Read connections in the pool
+const readConnections = 4
+
+// SetTimeouts sets the deadlines of database operations
+func (db *DB) SetTimeouts(timeouts Timeouts) {
+	db.timeouts = timeouts
+}
+
+// withDeadline applies the deadline of the operation class to ctx, unless an
+// enclosing operation has already done so
+func (db *DB) withDeadline(ctx context.Context, class opClass) (context.Context, context.CancelFunc) {
+	if ctx.Value(deadlineKey{}) != nil {
+		return context.WithCancel(ctx)
+	}
+
+	timeout := db.timeouts.Read
+	switch class {
+	case opWrite:
+		timeout = db.timeouts.Write
+	case opMaintenance:
+		timeout = db.timeouts.Maintenance
+	}
+
+	ctx = context.WithValue(ctx, deadlineKey{}, class)
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
 	}
+	return context.WithTimeout(ctx, timeout)
 }
 
-// ExecuteWithWriteLock runs a function with exclusive write access
-func (rq *RequestQueue) ExecuteWithWriteLock(ctx context.Context, fn func() error) error {
-	// For critical write operations, use mutex for exclusive access
-	rq.mutex.Lock()
-	defer rq.mutex.Unlock()
-
-	select {
-	case rq.semaphore <- struct{}{}:
-		defer func() { <-rq.semaphore }()
-		return fn()
-	case <-ctx.Done():
-		return ctx.Err()
+// startSpan starts a client span for a statement; it is a no-op span when ctx has
+// no span, so background jobs do not create root traces
+func startSpan(ctx context.Context, query string) trace.Span {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return trace.SpanFromContext(context.Background())
 	}
+
+	query = strings.TrimSpace(query)
+	operation, _, _ := strings.Cut(query, " ")
+	operation = strings.ToUpper(strings.TrimSpace(operation))
+
+	_, span := otel.Tracer("github.com/ad/go-llm-manager/internal/database").Start(ctx, "db "+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemSqlite,
+			semconv.DBOperationName(operation),
+			semconv.DBQueryText(query),
+		),
+	)
+	return span
 }
 
-type DB struct {
-	*sql.DB
-	requestQueue *RequestQueue
+func recordSpanError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
 }
 
 func NewSQLiteDB(dbPath string) (*DB, error) {
@@ -64,43 +123,78 @@ func NewSQLiteDB(dbPath string) (*DB, error) {
 		return nil, fmt.Errorf("failed to create database directory: %w", err)
 	}
 
-	// Open database
-	sqlDB, err := sql.Open("sqlite", dbPath)
+	// Единственное соединение на запись: BEGIN IMMEDIATE сразу берет write lock
+	writeDB, err := openPool(dbPath, 1, "_txlock=immediate")
 	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %w", err)
-	}
-
-	// Set connection pool settings - reduced for better control
-	sqlDB.SetMaxOpenConns(5)
-	sqlDB.SetMaxIdleConns(3)
-	sqlDB.SetConnMaxLifetime(30 * time.Minute)
-
-	// Test connection
-	if err := sqlDB.Ping(); err != nil {
-		return nil, fmt.Errorf("failed to ping database: %w", err)
+		return nil, err
 	}
 
 	db := &DB{
-		DB:           sqlDB,
-		requestQueue: NewRequestQueue(3), // Allow max 3 concurrent DB operations
+		DB:       writeDB,
+		path:     dbPath,
+		reader:   writeDB,
+		timeouts: DefaultTimeouts,
 	}
 
-	// Enable foreign keys and other SQLite optimizations
+	// Режим WAL хранится в файле БД, поэтому включается до открытия читателей
 	if err := db.initSQLite(); err != nil {
+		writeDB.Close()
 		return nil, fmt.Errorf("failed to initialize SQLite: %w", err)
 	}
 
+	// In-memory БД читаем через то же соединение: у :memory: оно свое у каждого
+	// соединения, а shared cache блокирует таблицы вместо WAL
+	if !isMemory(dbPath) {
+		db.reader, err = openPool(dbPath, readConnections, "_pragma=query_only(1)")
+		if err != nil {
+			writeDB.Close()
+			return nil, err
+		}
+	}
+
+	db.writer = newWriter(writeDB, maxWriteBatch)
 	return db, nil
 }
 
+// openPool opens a connection pool to the database. PRAGMAs are passed in the DSN,
+// so every connection of the pool gets them, not only the first one.
+func openPool(dbPath string, maxConns int, params ...string) (*sql.DB, error) {
+	sep := "?"
+	if strings.Contains(dbPath, "?") {
+		sep = "&"
+	}
+	dsn := dbPath + sep + strings.Join(append([]string{
+		"_pragma=busy_timeout(30000)", // 30 seconds timeout for BUSY
+		"_pragma=foreign_keys(1)",
+		"_pragma=synchronous(NORMAL)",
+		"_pragma=cache_size(1000)",
+		"_pragma=temp_store(MEMORY)",
+	}, params...), "&")
+
+	sqlDB, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	sqlDB.SetMaxOpenConns(maxConns)
+	sqlDB.SetMaxIdleConns(maxConns)
+	sqlDB.SetConnMaxLifetime(30 * time.Minute)
+
+	// Test connection
+	if err := sqlDB.Ping(); err != nil {
+		sqlDB.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+	return sqlDB, nil
+}
+
+func isMemory(dbPath string) bool {
+	return dbPath == ":memory:" || strings.Contains(dbPath, "mode=memory")
+}
+
 func (db *DB) initSQLite() error {
 	pragmas := []string{
-		"PRAGMA foreign_keys = ON",
 		"PRAGMA journal_mode = WAL",
-		"PRAGMA synchronous = NORMAL",
-		"PRAGMA cache_size = 1000",
-		"PRAGMA temp_store = MEMORY",
-		"PRAGMA busy_timeout = 30000", // 30 seconds timeout for BUSY
 		"PRAGMA wal_autocheckpoint = 1000",
 		"PRAGMA wal_checkpoint(TRUNCATE)", // Clean up WAL file
 	}
@@ -114,153 +208,145 @@ func (db *DB) initSQLite() error {
 	return nil
 }
 
+// Close stops the writer after the queued writes and closes the connections
 func (db *DB) Close() error {
+	db.writer.close()
+	if db.reader != db.DB {
+		db.reader.Close()
+	}
 	return db.DB.Close()
 }
 
-// Transaction helper
-func (db *DB) WithTransaction(fn func(*sql.Tx) error) error {
-	tx, err := db.Begin()
-	if err != nil {
-		return err
-	}
+// RunMigrations applies the migrations embedded into the binary
+func (db *DB) RunMigrations(ctx context.Context) error {
+	_, err := db.Migrate(ctx, migrations.FS)
+	return err
+}
 
-	defer func() {
-		if p := recover(); p != nil {
-			tx.Rollback()
-			panic(p)
-		} else if err != nil {
-			tx.Rollback()
-		} else {
-			err = tx.Commit()
-		}
-	}()
+// Rows are the result of QueuedQuery. Close also returns the read connection to
+// the pool and releases the deadline of the query.
+type Rows struct {
+	*sql.Rows
+	conn   *sql.Conn
+	cancel context.CancelFunc
+}
 
-	err = fn(tx)
+func (r *Rows) Close() error {
+	err := r.Rows.Close()
+	r.conn.Close()
+	r.cancel()
 	return err
 }
 
-// RunMigrations executes database migrations
-func (db *DB) RunMigrations() error {
-	migrationSQL := `
-	-- Основная таблица задач
-	CREATE TABLE IF NOT EXISTS tasks (
-		id TEXT PRIMARY KEY,
-		user_id TEXT NOT NULL,
-		product_data TEXT NOT NULL,
-		status TEXT NOT NULL CHECK (status IN ('pending', 'processing', 'completed', 'failed')),
-		result TEXT,
-		error_message TEXT,
-		created_at INTEGER NOT NULL,
-		updated_at INTEGER NOT NULL,
-		completed_at INTEGER,
-		priority INTEGER DEFAULT 0,
-		retry_count INTEGER DEFAULT 0,
-		max_retries INTEGER DEFAULT 3,
-		processor_id TEXT,
-		processing_started_at INTEGER,
-		heartbeat_at INTEGER,
-		timeout_at INTEGER,
-		ollama_params TEXT,
-		estimated_duration INTEGER DEFAULT 300000,
-		actual_duration INTEGER,
-		rating TEXT CHECK (rating IN ('upvote', 'downvote', NULL))
-	);
-
-	-- Rate limiting
-	CREATE TABLE IF NOT EXISTS rate_limits (
-		user_id TEXT PRIMARY KEY,
-		request_count INTEGER NOT NULL DEFAULT 0,
-		window_start INTEGER NOT NULL,
-		last_request INTEGER NOT NULL
-	);
-
-	-- Метрики процессоров
-	CREATE TABLE IF NOT EXISTS processor_metrics (
-		processor_id TEXT PRIMARY KEY,
-		cpu_usage REAL NOT NULL DEFAULT 0.0,
-		memory_usage REAL NOT NULL DEFAULT 0.0,
-		queue_size INTEGER NOT NULL DEFAULT 0,
-		active_tasks INTEGER NOT NULL DEFAULT 0,
-		last_updated INTEGER NOT NULL,
-		created_at INTEGER NOT NULL DEFAULT (unixepoch() * 1000)
-	);
-
-	-- Индексы для производительности
-	CREATE INDEX IF NOT EXISTS idx_tasks_status ON tasks(status);
-	CREATE INDEX IF NOT EXISTS idx_tasks_user_id ON tasks(user_id);
-	CREATE INDEX IF NOT EXISTS idx_tasks_processor_id ON tasks(processor_id);
-	CREATE INDEX IF NOT EXISTS idx_tasks_created_at ON tasks(created_at);
-	CREATE INDEX IF NOT EXISTS idx_tasks_timeout_at ON tasks(timeout_at);
-	CREATE INDEX IF NOT EXISTS idx_tasks_rating ON tasks(rating);
-	CREATE INDEX IF NOT EXISTS idx_rate_limits_window_start ON rate_limits(window_start);
-	CREATE INDEX IF NOT EXISTS idx_processor_metrics_last_updated ON processor_metrics(last_updated);
-	`
-
-	_, err := db.Exec(migrationSQL)
-	return err
+// Row is the result of QueuedQueryRow. Errors of getting a connection are returned by Scan.
+type Row struct {
+	row    *sql.Row
+	conn   *sql.Conn
+	err    error
+	cancel context.CancelFunc
 }
 
-// QueuedQuery executes a SELECT query through the request queue
-func (db *DB) QueuedQuery(query string, args ...interface{}) (*sql.Rows, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+func (r *Row) Scan(dest ...interface{}) error {
+	defer r.cancel()
+	if r.err != nil {
+		return r.err
+	}
+	defer r.conn.Close()
+	return r.row.Scan(dest...)
+}
 
-	var rows *sql.Rows
-	var err error
+// readConn takes a connection from the read pool, waiting until one is free or
+// ctx is done
+func (db *DB) readConn(ctx context.Context) (*sql.Conn, error) {
+	start := time.Now()
+	conn, err := db.reader.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	metrics.DBQueueWait.Observe(time.Since(start).Seconds(), "read")
+	return conn, nil
+}
 
-	err = db.requestQueue.Execute(ctx, func() error {
-		rows, err = db.Query(query, args...)
-		return err
-	})
+// QueuedQuery executes a SELECT query on a read connection. The query is
+// cancelled when ctx is done or the read deadline passes; rows must be closed.
+func (db *DB) QueuedQuery(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	ctx, cancel := db.withDeadline(ctx, opRead)
 
-	return rows, err
+	span := startSpan(ctx, query)
+	defer span.End()
+
+	conn, err := db.readConn(ctx)
+	if err != nil {
+		recordSpanError(span, err)
+		cancel()
+		return nil, err
+	}
+	span.AddEvent("connection acquired")
+
+	rows, err := conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		recordSpanError(span, err)
+		conn.Close()
+		cancel()
+		return nil, err
+	}
+	return &Rows{Rows: rows, conn: conn, cancel: cancel}, nil
 }
 
-// QueuedQueryRow executes a SELECT query for a single row through the request queue
-func (db *DB) QueuedQueryRow(query string, args ...interface{}) *sql.Row {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+// QueuedQueryRow executes a SELECT query for a single row on a read connection
+func (db *DB) QueuedQueryRow(ctx context.Context, query string, args ...interface{}) *Row {
+	ctx, cancel := db.withDeadline(ctx, opRead)
 
-	var row *sql.Row
+	span := startSpan(ctx, query)
+	defer span.End()
 
-	// For QueryRow, we don't need to handle error here as it's returned by Scan()
-	db.requestQueue.Execute(ctx, func() error {
-		row = db.QueryRow(query, args...)
-		return nil
-	})
+	result := &Row{cancel: cancel}
+	result.conn, result.err = db.readConn(ctx)
+	if result.err != nil {
+		recordSpanError(span, result.err)
+		return result
+	}
+	span.AddEvent("connection acquired")
 
-	return row
+	result.row = result.conn.QueryRowContext(ctx, query, args...)
+	return result
 }
 
-// QueuedExec executes an INSERT/UPDATE/DELETE query through the request queue
-func (db *DB) QueuedExec(query string, args ...interface{}) (sql.Result, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-
+// QueuedExec executes an INSERT/UPDATE/DELETE query through the writer. It may be
+// committed in one transaction with other queued writes.
+func (db *DB) QueuedExec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
 	var result sql.Result
-	var err error
-
-	err = db.requestQueue.Execute(ctx, func() error {
-		result, err = db.Exec(query, args...)
+	err := db.write(ctx, query, true, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		result, err = tx.ExecContext(ctx, query, args...)
 		return err
 	})
-
 	return result, err
 }
 
-// QueuedExecWithWriteLock executes a critical write operation with exclusive access
-func (db *DB) QueuedExecWithWriteLock(query string, args ...interface{}) (sql.Result, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+// QueuedTransaction runs fn in a transaction through the writer. fn gets the
+// context of the transaction for its statements; its changes are rolled back if
+// it returns an error.
+func (db *DB) QueuedTransaction(ctx context.Context, fn func(context.Context, *sql.Tx) error) error {
+	return db.write(ctx, "TRANSACTION", false, fn)
+}
+
+// write queues fn to the writer and waits for the commit of its batch
+func (db *DB) write(ctx context.Context, query string, single bool, fn func(context.Context, *sql.Tx) error) error {
+	ctx, cancel := db.withDeadline(ctx, opWrite)
 	defer cancel()
 
-	var result sql.Result
-	var err error
+	span := startSpan(ctx, query)
+	defer span.End()
 
-	err = db.requestQueue.ExecuteWithWriteLock(ctx, func() error {
-		result, err = db.Exec(query, args...)
-		return err
-	})
+	// writer отмечает в спане начало батча
+	err := db.writer.submit(trace.ContextWithSpan(ctx, span), single, fn)
+	recordSpanError(span, err)
+	return err
+}
 
-	return result, err
+// maintenance applies the maintenance deadline to ctx for the statements of a
+// long-running operation
+func (db *DB) maintenance(ctx context.Context) (context.Context, context.CancelFunc) {
+	return db.withDeadline(ctx, opMaintenance)
 }
@@ -0,0 +1,91 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestQueuedQueryCancelledContext(t *testing.T) {
+	db := NewTestDB(t)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	if _, err := db.QueuedQuery(ctx, `SELECT id FROM tasks`); !errors.Is(err, context.Canceled) {
+		t.Fatalf("QueuedQuery error = %v, want context.Canceled", err)
+	}
+	var n int
+	if err := db.QueuedQueryRow(ctx, `SELECT COUNT(*) FROM tasks`).Scan(&n); !errors.Is(err, context.Canceled) {
+		t.Fatalf("QueuedQueryRow error = %v, want context.Canceled", err)
+	}
+	if _, err := db.GetTask(ctx, "missing"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("GetTask error = %v, want context.Canceled", err)
+	}
+}
+
+func TestQueuedWriteWaitIsCancellable(t *testing.T) {
+	db := NewTestDB(t)
+
+	// Занимаем writer, пока второй запрос ждет в очереди
+	held := make(chan struct{})
+	release := make(chan struct{})
+	go db.QueuedTransaction(t.Context(), func(ctx context.Context, tx *sql.Tx) error {
+		close(held)
+		<-release
+		return nil
+	})
+	defer close(release)
+	<-held
+
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := db.QueuedExec(ctx, `DELETE FROM tasks`)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("QueuedExec error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("waited %s for the writer after the deadline", elapsed)
+	}
+}
+
+func TestOperationDeadlines(t *testing.T) {
+	db := NewTestDB(t)
+	db.SetTimeouts(Timeouts{Read: time.Second, Write: 2 * time.Second, Maintenance: time.Hour})
+
+	deadlineIn := func(ctx context.Context) time.Duration {
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			t.Fatal("context has no deadline")
+		}
+		return time.Until(deadline)
+	}
+
+	ctx, cancel := db.withDeadline(t.Context(), opRead)
+	defer cancel()
+	if d := deadlineIn(ctx); d > time.Second {
+		t.Errorf("read deadline in %s, want at most 1s", d)
+	}
+
+	// Запросы внутри maintenance-операции не урезаются до write deadline
+	ctx, cancel = db.maintenance(t.Context())
+	defer cancel()
+	ctx, cancelWrite := db.withDeadline(ctx, opWrite)
+	defer cancelWrite()
+	if d := deadlineIn(ctx); d < time.Minute {
+		t.Errorf("nested write deadline in %s, want the maintenance deadline", d)
+	}
+
+	// Дедлайн вызывающего короче дедлайна класса операции
+	short, cancelShort := context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancelShort()
+	ctx, cancel = db.withDeadline(short, opMaintenance)
+	defer cancel()
+	if d := deadlineIn(ctx); d > 10*time.Millisecond {
+		t.Errorf("deadline in %s, want the caller's 10ms", d)
+	}
+}
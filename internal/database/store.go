@@ -0,0 +1,163 @@
+package database
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrActiveTask is returned by CreateTask when the user already has a pending or processing task
+var ErrActiveTask = errors.New("user already has an active task")
+
+// TaskStore keeps tasks and their lifecycle log. GetTask returns sql.ErrNoRows for
+// unknown tasks; other lookups return nil, nil.
+type TaskStore interface {
+	CreateTask(ctx context.Context, task *Task) error
+	GetTask(ctx context.Context, id string) (*Task, error)
+	GetUserLatestTask(ctx context.Context, userID string) (*Task, error)
+	GetPendingTasks(ctx context.Context, limit int) ([]*Task, error)
+	ListTasks(ctx context.Context, filter TaskFilter, after *TaskCursor, limit int) (*TaskPage, error)
+	GetProcessingTasksByProcessor(ctx context.Context, processorID string) ([]*Task, error)
+	GetTimedOutTasks(ctx context.Context, heartbeatBefore int64) ([]*Task, error)
+	CountTasksByStatusAndModel(ctx context.Context) ([]*TaskCount, error)
+	GetTaskStats(ctx context.Context, retention Retention, heartbeatBefore int64) (*TaskStats, error)
+	GetQueueStats(ctx context.Context, completedSince int64) (*QueueStats, error)
+
+	ClaimTasks(ctx context.Context, processorID string, limit int, timeoutMs int64, maxConcurrency int) ([]*Task, error)
+	HeartbeatTask(ctx context.Context, taskID, processorID string) (bool, error)
+	StealTasks(ctx context.Context, processorID string, limit int, heartbeatBefore, timeoutMs int64) ([]*Task, error)
+	UpdateTaskStatus(ctx context.Context, id, status string, result, errorMessage *string) error
+	RequeueTask(ctx context.Context, taskID, processorID string, reason *string) (bool, error)
+	FailProcessingTask(ctx context.Context, taskID, processorID, errorMessage string) (bool, error)
+	UpdateTaskRating(ctx context.Context, taskID, userID string, rating *string) error
+	DeleteFinishedTasks(ctx context.Context, status string, before int64) (int64, error)
+
+	LogTaskEvent(ctx context.Context, taskID, eventType, processorID, message string) error
+	GetTaskEvents(ctx context.Context, taskID string) ([]*TaskEvent, error)
+}
+
+// ProcessorStore keeps the processor registry and reported processor metrics.
+// GetProcessor returns nil, nil for unknown processors.
+type ProcessorStore interface {
+	TouchProcessor(ctx context.Context, processorID, version, hostname string) error
+	UpsertProcessor(ctx context.Context, p *Processor) error
+	UpdateProcessor(ctx context.Context, processorID string, upd *ProcessorUpdate) error
+	GetProcessor(ctx context.Context, processorID string) (*Processor, error)
+	ListProcessors(ctx context.Context, state string) ([]*Processor, error)
+	DeleteProcessor(ctx context.Context, processorID string) (bool, error)
+	GetStaleProcessors(ctx context.Context, before int64) ([]*Processor, error)
+	MarkProcessorOffline(ctx context.Context, processorID string) (bool, error)
+	CountOnlineProcessors(ctx context.Context) (int, error)
+	GetProcessorCapacity(ctx context.Context, processorID string) (maxConcurrency, active int, err error)
+	GetProcessorLoads(ctx context.Context, since int64) ([]*ProcessorLoad, error)
+
+	UpdateProcessorMetrics(ctx context.Context, processorID string, cpuUsage, memoryUsage *float64, queueSize *int, activeTasks int) error
+	PruneProcessorMetrics(ctx context.Context, before int64) (int64, error)
+}
+
+// RatingStore aggregates user ratings of completed tasks
+type RatingStore interface {
+	GetTasksRatingStats(ctx context.Context, userID *string) (map[string]int, error)
+	GetUserRatedTasks(ctx context.Context, userID string, rating *string, limit, offset int) ([]*Task, error)
+	GetRatingStatsByPeriod(ctx context.Context, period string, count int) ([]map[string]interface{}, error)
+	GetRecentRatedTasks(ctx context.Context, limit int) ([]*Task, error)
+}
+
+// UsageStore keeps token usage reported by processors, per-user token quotas and
+// the usage ledger of finished tasks
+type UsageStore interface {
+	RecordTaskUsage(ctx context.Context, usage *TaskUsage) (bool, error)
+	GetUserTokenUsage(ctx context.Context, userID string) (*UserTokenUsage, error)
+	SumUserTokens(ctx context.Context, userID string, since int64) (int64, error)
+	ListDailyTokenUsage(ctx context.Context, userID, fromDay string) ([]*DailyTokenUsage, error)
+	GetUsageReport(ctx context.Context, filter UsageReportFilter) ([]*UsageReportRow, error)
+
+	SetUserTokenLimit(ctx context.Context, limit *UserTokenLimit) error
+	GetUserTokenLimit(ctx context.Context, userID string) (*UserTokenLimit, error)
+	ListUserTokenLimits(ctx context.Context) ([]*UserTokenLimit, error)
+	DeleteUserTokenLimit(ctx context.Context, userID string) (bool, error)
+}
+
+// APIKeyStore keeps internal API keys. Lookups return nil, nil for unknown keys.
+type APIKeyStore interface {
+	CreateAPIKey(ctx context.Context, k *APIKey) error
+	GetAPIKey(ctx context.Context, id string) (*APIKey, error)
+	GetAPIKeyByHash(ctx context.Context, keyHash string) (*APIKey, error)
+	ListAPIKeys(ctx context.Context) ([]*APIKey, error)
+	RevokeAPIKey(ctx context.Context, id string) (bool, error)
+	ExpireAPIKey(ctx context.Context, id string, expiresAt int64) error
+	TouchAPIKey(ctx context.Context, id string) error
+}
+
+// RevocationStore keeps revoked JWT IDs and per-subject revocation cutoffs
+type RevocationStore interface {
+	RevokeTokenID(ctx context.Context, jti string, expiresAt int64) error
+	RevokeTokensBefore(ctx context.Context, subjectType, subjectID string) (int64, error)
+	ListRevokedTokenIDs(ctx context.Context, now int64) (map[string]int64, error)
+	ListTokenCutoffs(ctx context.Context) (map[string]map[string]int64, error)
+	PruneRevokedTokens(ctx context.Context, before int64) (int64, error)
+}
+
+// RateLimitStore keeps per-user rate limit counters and request logs
+type RateLimitStore interface {
+	GetRateLimitState(ctx context.Context, userID, algorithm string) (*RateLimitState, error)
+	UpdateRateLimitState(ctx context.Context, userID, algorithm string, update func(*RateLimitState) *RateLimitState) error
+	ListRateLimitLog(ctx context.Context, userID string, since int64) ([]int64, error)
+	AddRateLimitLog(ctx context.Context, userID string, at, pruneBefore int64) ([]int64, error)
+	PruneRateLimits(ctx context.Context, before int64) (int64, error)
+	CountRateLimitRecords(ctx context.Context) (int64, error)
+}
+
+// SearchStore finds tasks by the text of their data, result and error
+type SearchStore interface {
+	SearchTasks(ctx context.Context, query string, filter TaskFilter, after *TaskCursor, limit int) (*TaskSearchPage, error)
+}
+
+// Store is everything the API handlers keep. It is implemented by DB (SQLite) and
+// MemoryStore. Backups, archives and health checks of the database file are SQLite-only.
+type Store interface {
+	TaskStore
+	ProcessorStore
+	RatingStore
+	UsageStore
+	APIKeyStore
+	RevocationStore
+	RateLimitStore
+	SearchStore
+}
+
+var (
+	_ Store = (*DB)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
+
+// Retention holds the cutoffs (unix ms) before which finished tasks expire, by status
+type Retention struct {
+	Completed int64
+	Failed    int64
+}
+
+// Before returns the cutoff for a finished status
+func (r Retention) Before(status string) int64 {
+	if status == TaskStatusFailed {
+		return r.Failed
+	}
+	return r.Completed
+}
+
+// TaskStats counts tasks by status for cleanup statistics
+type TaskStats struct {
+	Total      int64 `json:"totalTasks"`
+	Pending    int64 `json:"pendingTasks"`
+	Processing int64 `json:"processingTasks"`
+	Completed  int64 `json:"completedTasks"`
+	Failed     int64 `json:"failedTasks"`
+	Expired    int64 `json:"tasksOlderThan7Days"` // завершены раньше срока хранения своего статуса; имя ключа сохранено для совместимости
+	TimedOut   int64 `json:"timedoutTasks"`       // в обработке без heartbeat с heartbeatBefore
+}
+
+// QueueStats describes the queue for wait time estimates
+type QueueStats struct {
+	Pending         int
+	Completed       int     // завершено с completedSince
+	AvgProcessingMs float64 // среднее время обработки завершённых, 0 если их нет
+}
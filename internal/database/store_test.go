@@ -0,0 +1,904 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSQLiteStoreContract(t *testing.T) {
+	testStoreContract(t, func(t *testing.T) Store { return NewTestDB(t) })
+}
+
+func TestMemoryStoreContract(t *testing.T) {
+	testStoreContract(t, func(t *testing.T) Store { return NewMemoryStore() })
+}
+
+// testStoreContract checks the behaviour handlers rely on, shared by all Store implementations
+func testStoreContract(t *testing.T, newStore func(t *testing.T) Store) {
+	// Метки времени в будущем делают только что взятые задачи «просроченными»
+	future := func() int64 { return time.Now().UnixMilli() + 1000 }
+
+	createPending := func(t *testing.T, s Store, id string, priority int) {
+		t.Helper()
+		task := &Task{ID: id, UserID: "user-" + id, ProductData: "data", Status: TaskStatusPending, Priority: priority, MaxRetries: 3}
+		if err := s.CreateTask(t.Context(), task); err != nil {
+			t.Fatalf("create task %s: %v", id, err)
+		}
+	}
+
+	t.Run("CreateAndGet", func(t *testing.T) {
+		ctx := t.Context()
+		s := newStore(t)
+		params := `{"model":"llama3"}`
+		task := &Task{ID: "t-1", UserID: "u-1", ProductData: "data", Status: TaskStatusPending, Priority: 2, MaxRetries: 3, OllamaParams: &params}
+		if err := s.CreateTask(ctx, task); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+
+		got, err := s.GetTask(ctx, "t-1")
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		if got.UserID != "u-1" || got.Status != TaskStatusPending || got.Priority != 2 || got.MaxRetries != 3 || got.CreatedAt == 0 {
+			t.Errorf("unexpected task: %+v", got)
+		}
+		if got.OllamaParams == nil || *got.OllamaParams != params {
+			t.Errorf("expected ollama params %s, got %v", params, got.OllamaParams)
+		}
+
+		if _, err := s.GetTask(ctx, "missing"); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("expected sql.ErrNoRows for unknown task, got %v", err)
+		}
+
+		err = s.CreateTask(ctx, &Task{ID: "t-2", UserID: "u-1", ProductData: "data", Status: TaskStatusPending})
+		if !errors.Is(err, ErrActiveTask) {
+			t.Errorf("expected ErrActiveTask, got %v", err)
+		}
+
+		latest, err := s.GetUserLatestTask(ctx, "u-1")
+		if err != nil || latest == nil || latest.ID != "t-1" {
+			t.Errorf("expected latest task t-1, got %v (%v)", latest, err)
+		}
+		if latest, err := s.GetUserLatestTask(ctx, "nobody"); err != nil || latest != nil {
+			t.Errorf("expected no latest task, got %v (%v)", latest, err)
+		}
+
+		if page, err := s.ListTasks(ctx, TaskFilter{UserID: "u-1"}, nil, 10); err != nil || len(page.Tasks) != 1 || page.Total != 1 {
+			t.Errorf("expected 1 task of the user, got %+v (%v)", page, err)
+		}
+	})
+
+	t.Run("ClaimByPriority", func(t *testing.T) {
+		ctx := t.Context()
+		s := newStore(t)
+		createPending(t, s, "low", 0)
+		createPending(t, s, "high", 5)
+		createPending(t, s, "mid", 2)
+
+		pending, err := s.GetPendingTasks(ctx, 10)
+		if err != nil || len(pending) != 3 || pending[0].ID != "high" || pending[2].ID != "low" {
+			t.Fatalf("unexpected pending order: %v (%v)", pending, err)
+		}
+
+		claimed, err := s.ClaimTasks(ctx, "p-1", 2, 60000, 0)
+		if err != nil {
+			t.Fatalf("claim: %v", err)
+		}
+		if len(claimed) != 2 || claimed[0].ID != "high" || claimed[1].ID != "mid" {
+			t.Fatalf("expected high and mid claimed, got %v", claimed)
+		}
+		for _, task := range claimed {
+			if task.Status != TaskStatusProcessing || task.ProcessorID == nil || *task.ProcessorID != "p-1" ||
+				task.HeartbeatAt == nil || task.TimeoutAt == nil || *task.TimeoutAt <= *task.HeartbeatAt {
+				t.Errorf("unexpected claimed task: %+v", task)
+			}
+		}
+
+		held, err := s.GetProcessingTasksByProcessor(ctx, "p-1")
+		if err != nil || len(held) != 2 {
+			t.Errorf("expected 2 tasks held by p-1, got %d (%v)", len(held), err)
+		}
+		if pending, _ := s.GetPendingTasks(ctx, 10); len(pending) != 1 || pending[0].ID != "low" {
+			t.Errorf("expected only low pending, got %v", pending)
+		}
+	})
+
+	t.Run("ClaimRespectsMaxConcurrency", func(t *testing.T) {
+		ctx := t.Context()
+		s := newStore(t)
+		for i := range 5 {
+			createPending(t, s, fmt.Sprintf("t-%d", i), 0)
+		}
+
+		if claimed, err := s.ClaimTasks(ctx, "p-1", 2, 60000, 3); err != nil || len(claimed) != 2 {
+			t.Fatalf("expected 2 claimed, got %d (%v)", len(claimed), err)
+		}
+		if claimed, err := s.ClaimTasks(ctx, "p-1", 5, 60000, 3); err != nil || len(claimed) != 1 {
+			t.Fatalf("expected 1 claimed up to the limit, got %d (%v)", len(claimed), err)
+		}
+		if claimed, err := s.ClaimTasks(ctx, "p-1", 5, 60000, 3); err != nil || len(claimed) != 0 {
+			t.Fatalf("expected saturated processor to claim nothing, got %d (%v)", len(claimed), err)
+		}
+
+		maxConcurrency, active, err := s.GetProcessorCapacity(ctx, "p-1")
+		if err != nil || maxConcurrency != 0 || active != 3 {
+			t.Errorf("expected 0/3 capacity of unregistered processor, got %d/%d (%v)", maxConcurrency, active, err)
+		}
+	})
+
+	t.Run("HeartbeatChecksOwner", func(t *testing.T) {
+		ctx := t.Context()
+		s := newStore(t)
+		createPending(t, s, "t-1", 0)
+		if _, err := s.ClaimTasks(ctx, "p-1", 1, 60000, 0); err != nil {
+			t.Fatalf("claim: %v", err)
+		}
+
+		if ok, err := s.HeartbeatTask(ctx, "t-1", "p-1"); err != nil || !ok {
+			t.Errorf("expected heartbeat of owner to succeed, got %v (%v)", ok, err)
+		}
+		if ok, err := s.HeartbeatTask(ctx, "t-1", "p-2"); err != nil || ok {
+			t.Errorf("expected heartbeat of another processor to fail, got %v (%v)", ok, err)
+		}
+		if ok, err := s.HeartbeatTask(ctx, "missing", "p-1"); err != nil || ok {
+			t.Errorf("expected heartbeat of unknown task to fail, got %v (%v)", ok, err)
+		}
+	})
+
+	t.Run("CompleteRequeueAndFail", func(t *testing.T) {
+		ctx := t.Context()
+		s := newStore(t)
+		createPending(t, s, "t-1", 0)
+		createPending(t, s, "t-2", 0)
+		createPending(t, s, "t-3", 0)
+		if _, err := s.ClaimTasks(ctx, "p-1", 3, 60000, 0); err != nil {
+			t.Fatalf("claim: %v", err)
+		}
+
+		result := "done"
+		if err := s.UpdateTaskStatus(ctx, "t-1", TaskStatusCompleted, &result, nil); err != nil {
+			t.Fatalf("complete: %v", err)
+		}
+		task, _ := s.GetTask(ctx, "t-1")
+		if task.Status != TaskStatusCompleted || task.CompletedAt == nil || task.Result == nil || *task.Result != result {
+			t.Errorf("unexpected completed task: %+v", task)
+		}
+
+		reason := "processor shutdown"
+		if ok, err := s.RequeueTask(ctx, "t-2", "p-2", &reason); err != nil || ok {
+			t.Errorf("expected requeue by another processor to fail, got %v (%v)", ok, err)
+		}
+		if ok, err := s.RequeueTask(ctx, "t-2", "p-1", &reason); err != nil || !ok {
+			t.Fatalf("expected requeue to succeed, got %v (%v)", ok, err)
+		}
+		task, _ = s.GetTask(ctx, "t-2")
+		if task.Status != TaskStatusPending || task.ProcessorID != nil || task.HeartbeatAt != nil || task.RetryCount != 1 ||
+			task.ErrorMessage == nil || *task.ErrorMessage != reason {
+			t.Errorf("unexpected requeued task: %+v", task)
+		}
+
+		if ok, err := s.FailProcessingTask(ctx, "t-3", "p-1", "boom"); err != nil || !ok {
+			t.Fatalf("expected fail to succeed, got %v (%v)", ok, err)
+		}
+		if ok, _ := s.FailProcessingTask(ctx, "t-3", "p-1", "boom"); ok {
+			t.Error("expected second fail to be a no-op")
+		}
+		task, _ = s.GetTask(ctx, "t-3")
+		if task.Status != TaskStatusFailed || task.CompletedAt == nil || task.ErrorMessage == nil || *task.ErrorMessage != "boom" {
+			t.Errorf("unexpected failed task: %+v", task)
+		}
+	})
+
+	t.Run("StealFromOverloadedProcessor", func(t *testing.T) {
+		ctx := t.Context()
+		s := newStore(t)
+		for i := range stealMinActiveTasks + 1 {
+			createPending(t, s, fmt.Sprintf("busy-%d", i), i)
+		}
+		createPending(t, s, "light", 0)
+		if _, err := s.ClaimTasks(ctx, "busy", stealMinActiveTasks+1, 60000, 0); err != nil {
+			t.Fatalf("claim: %v", err)
+		}
+		if _, err := s.ClaimTasks(ctx, "light", 1, 60000, 0); err != nil {
+			t.Fatalf("claim: %v", err)
+		}
+
+		if stolen, err := s.StealTasks(ctx, "idle", 2, 0, 60000); err != nil || len(stolen) != 0 {
+			t.Errorf("expected fresh heartbeats to protect tasks, got %d (%v)", len(stolen), err)
+		}
+
+		stolen, err := s.StealTasks(ctx, "idle", 2, future(), 60000)
+		if err != nil {
+			t.Fatalf("steal: %v", err)
+		}
+		if len(stolen) != 2 || stolen[0].ID != fmt.Sprintf("busy-%d", stealMinActiveTasks) {
+			t.Fatalf("expected 2 highest priority tasks of the overloaded processor, got %v", stolen)
+		}
+		for _, task := range stolen {
+			if task.Status != TaskStatusProcessing || task.ProcessorID == nil || *task.ProcessorID != "idle" {
+				t.Errorf("unexpected stolen task: %+v", task)
+			}
+		}
+		if held, _ := s.GetProcessingTasksByProcessor(ctx, "idle"); len(held) != 2 {
+			t.Errorf("expected idle to hold 2 tasks, got %d", len(held))
+		}
+
+		// У busy осталось не больше stealMinActiveTasks задач — красть больше нечего
+		if stolen, err := s.StealTasks(ctx, "idle", 2, future(), 60000); err != nil || len(stolen) != 0 {
+			t.Errorf("expected nothing to steal, got %d (%v)", len(stolen), err)
+		}
+	})
+
+	t.Run("TimeoutsStatsAndCleanup", func(t *testing.T) {
+		ctx := t.Context()
+		s := newStore(t)
+		createPending(t, s, "pending", 0)
+		createPending(t, s, "processing", 1)
+		createPending(t, s, "done", 2)
+		if _, err := s.ClaimTasks(ctx, "p-1", 2, 60000, 0); err != nil {
+			t.Fatalf("claim: %v", err)
+		}
+		if err := s.UpdateTaskStatus(ctx, "done", TaskStatusCompleted, nil, nil); err != nil {
+			t.Fatalf("complete: %v", err)
+		}
+
+		if tasks, err := s.GetTimedOutTasks(ctx, 0); err != nil || len(tasks) != 0 {
+			t.Errorf("expected no timed out tasks, got %d (%v)", len(tasks), err)
+		}
+		tasks, err := s.GetTimedOutTasks(ctx, future())
+		if err != nil || len(tasks) != 1 || tasks[0].ID != "processing" || tasks[0].MaxRetries != 3 {
+			t.Errorf("expected processing task timed out, got %v (%v)", tasks, err)
+		}
+
+		stats, err := s.GetTaskStats(ctx, Retention{Completed: future(), Failed: future()}, future())
+		if err != nil {
+			t.Fatalf("stats: %v", err)
+		}
+		want := TaskStats{Total: 3, Pending: 1, Processing: 1, Completed: 1, Expired: 1, TimedOut: 1}
+		if *stats != want {
+			t.Errorf("expected stats %+v, got %+v", want, *stats)
+		}
+
+		queue, err := s.GetQueueStats(ctx, 0)
+		if err != nil || queue.Pending != 1 || queue.Completed != 1 || queue.AvgProcessingMs < 0 {
+			t.Errorf("unexpected queue stats %+v (%v)", queue, err)
+		}
+
+		if stats, err := s.GetTaskStats(ctx, Retention{Completed: 0, Failed: future()}, future()); err != nil || stats.Expired != 0 {
+			t.Errorf("expected completed task within its retention, got %+v (%v)", stats, err)
+		}
+
+		if deleted, err := s.DeleteFinishedTasks(ctx, TaskStatusCompleted, 0); err != nil || deleted != 0 {
+			t.Errorf("expected recent tasks kept, deleted %d (%v)", deleted, err)
+		}
+		if deleted, err := s.DeleteFinishedTasks(ctx, TaskStatusFailed, future()); err != nil || deleted != 0 {
+			t.Errorf("expected completed task kept by failed retention, deleted %d (%v)", deleted, err)
+		}
+		if deleted, err := s.DeleteFinishedTasks(ctx, TaskStatusCompleted, future()); err != nil || deleted != 1 {
+			t.Errorf("expected 1 finished task deleted, got %d (%v)", deleted, err)
+		}
+		if _, err := s.GetTask(ctx, "done"); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("expected deleted task to be gone, got %v", err)
+		}
+	})
+
+	t.Run("CountByStatusAndModel", func(t *testing.T) {
+		ctx := t.Context()
+		s := newStore(t)
+		params := `{"model":"llama3"}`
+		if err := s.CreateTask(ctx, &Task{ID: "t-1", UserID: "u-1", ProductData: "data", Status: TaskStatusPending, OllamaParams: &params}); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		createPending(t, s, "t-2", 0)
+
+		counts, err := s.CountTasksByStatusAndModel(ctx)
+		if err != nil {
+			t.Fatalf("count: %v", err)
+		}
+		got := make(map[string]int)
+		for _, c := range counts {
+			got[c.Status+"/"+c.Model] = c.Count
+		}
+		if len(got) != 2 || got["pending/llama3"] != 1 || got["pending/"] != 1 {
+			t.Errorf("unexpected counts: %v", got)
+		}
+	})
+
+	t.Run("ListTasks", func(t *testing.T) {
+		ctx := t.Context()
+		s := newStore(t)
+		params := `{"model":"llama3"}`
+		if err := s.CreateTask(ctx, &Task{ID: "t-0", UserID: "u-0", ProductData: "data", Status: TaskStatusPending, OllamaParams: &params}); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		for i := 1; i < 5; i++ {
+			createPending(t, s, fmt.Sprintf("t-%d", i), i)
+		}
+		failure := "boom"
+		if err := s.UpdateTaskStatus(ctx, "t-1", TaskStatusFailed, nil, &failure); err != nil {
+			t.Fatalf("fail: %v", err)
+		}
+		if err := s.UpdateTaskStatus(ctx, "t-2", TaskStatusCompleted, nil, nil); err != nil {
+			t.Fatalf("complete: %v", err)
+		}
+		if err := s.UpdateTaskRating(ctx, "t-2", "user-t-2", ptr("upvote")); err != nil {
+			t.Fatalf("rate: %v", err)
+		}
+
+		two, three := 2, 3
+		hasError, noError := true, false
+		filters := []struct {
+			name   string
+			filter TaskFilter
+			want   int
+		}{
+			{"all", TaskFilter{}, 5},
+			{"statuses", TaskFilter{Statuses: []string{TaskStatusCompleted, TaskStatusFailed}}, 2},
+			{"model", TaskFilter{Model: "llama3"}, 1},
+			{"rated", TaskFilter{Rating: "upvote"}, 1},
+			{"unrated", TaskFilter{Rating: RatingNone}, 4},
+			{"priority", TaskFilter{PriorityMin: &two, PriorityMax: &three}, 2},
+			{"error", TaskFilter{HasError: &hasError}, 1},
+			{"no error", TaskFilter{HasError: &noError, Statuses: []string{TaskStatusFailed}}, 0},
+			{"completed", TaskFilter{CompletedFrom: 1, CompletedTo: future()}, 2},
+			{"created", TaskFilter{CreatedTo: 1}, 0},
+		}
+		for _, f := range filters {
+			page, err := s.ListTasks(ctx, f.filter, nil, 10)
+			if err != nil {
+				t.Fatalf("%s: %v", f.name, err)
+			}
+			if page.Total != f.want || len(page.Tasks) != f.want || page.NextCursor != "" {
+				t.Errorf("%s: got %d tasks of %d (cursor %q), want %d", f.name, len(page.Tasks), page.Total, page.NextCursor, f.want)
+			}
+		}
+
+		// Страницы по 2 дают тот же порядок, что и одна большая
+		all, err := s.ListTasks(ctx, TaskFilter{}, nil, 10)
+		if err != nil {
+			t.Fatalf("list: %v", err)
+		}
+		var paged []string
+		var after *TaskCursor
+		for range 5 {
+			page, err := s.ListTasks(ctx, TaskFilter{}, after, 2)
+			if err != nil {
+				t.Fatalf("page: %v", err)
+			}
+			if page.Total != 5 {
+				t.Errorf("page total = %d, want 5", page.Total)
+			}
+			for _, task := range page.Tasks {
+				paged = append(paged, task.ID)
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			if after, err = ParseTaskCursor(page.NextCursor); err != nil {
+				t.Fatalf("parse cursor: %v", err)
+			}
+		}
+		var want []string
+		for _, task := range all.Tasks {
+			want = append(want, task.ID)
+		}
+		if fmt.Sprint(paged) != fmt.Sprint(want) {
+			t.Errorf("paged tasks %v, want %v", paged, want)
+		}
+
+		if page, err := s.ListTasks(ctx, TaskFilter{}, nil, 0); err != nil || page.Total != 5 || len(page.Tasks) != 0 {
+			t.Errorf("count only: got %+v (%v)", page, err)
+		}
+		if _, err := ParseTaskCursor("not a cursor"); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("expected ErrInvalidCursor, got %v", err)
+		}
+	})
+
+	t.Run("Rating", func(t *testing.T) {
+		ctx := t.Context()
+		s := newStore(t)
+		createPending(t, s, "t-1", 0)
+		upvote := "upvote"
+
+		if err := s.UpdateTaskRating(ctx, "t-1", "user-t-1", &upvote); err == nil {
+			t.Error("expected rating of a pending task to fail")
+		}
+		if err := s.UpdateTaskStatus(ctx, "t-1", TaskStatusCompleted, nil, nil); err != nil {
+			t.Fatalf("complete: %v", err)
+		}
+		if err := s.UpdateTaskRating(ctx, "t-1", "someone-else", &upvote); err == nil {
+			t.Error("expected rating by another user to fail")
+		}
+		if err := s.UpdateTaskRating(ctx, "t-1", "user-t-1", &upvote); err != nil {
+			t.Fatalf("rate: %v", err)
+		}
+		if task, _ := s.GetTask(ctx, "t-1"); task.UserRating == nil || *task.UserRating != upvote {
+			t.Errorf("expected upvote, got %v", task.UserRating)
+		}
+		if err := s.UpdateTaskRating(ctx, "t-1", "user-t-1", nil); err != nil {
+			t.Fatalf("remove rating: %v", err)
+		}
+		if task, _ := s.GetTask(ctx, "t-1"); task.UserRating != nil {
+			t.Errorf("expected rating removed, got %v", *task.UserRating)
+		}
+	})
+
+	t.Run("Events", func(t *testing.T) {
+		ctx := t.Context()
+		s := newStore(t)
+		createPending(t, s, "t-1", 0)
+		if err := s.LogTaskEvent(ctx, "t-1", TaskEventRequeued, "p-1", "timeout"); err != nil {
+			t.Fatalf("log: %v", err)
+		}
+		if err := s.LogTaskEvent(ctx, "t-1", TaskEventFailed, "", ""); err != nil {
+			t.Fatalf("log: %v", err)
+		}
+
+		events, err := s.GetTaskEvents(ctx, "t-1")
+		if err != nil || len(events) != 2 {
+			t.Fatalf("expected 2 events, got %d (%v)", len(events), err)
+		}
+		if events[0].EventType != TaskEventRequeued || events[0].ProcessorID == nil || *events[0].ProcessorID != "p-1" ||
+			events[0].Message == nil || *events[0].Message != "timeout" {
+			t.Errorf("unexpected first event: %+v", events[0])
+		}
+		if events[1].ProcessorID != nil || events[1].Message != nil {
+			t.Errorf("expected empty processor and message stored as null: %+v", events[1])
+		}
+		if events, _ := s.GetTaskEvents(ctx, "t-2"); len(events) != 0 {
+			t.Errorf("expected no events of another task, got %d", len(events))
+		}
+	})
+
+	t.Run("ProcessorRegistry", func(t *testing.T) {
+		ctx := t.Context()
+		s := newStore(t)
+		p := &Processor{ID: "p-1", Version: "1.0", Hostname: "host-a", Labels: map[string]string{"gpu": "a100"}, SupportedModels: []string{"llama3"}, MaxConcurrency: 4}
+		if err := s.UpsertProcessor(ctx, p); err != nil {
+			t.Fatalf("upsert: %v", err)
+		}
+		if err := s.UpsertProcessor(ctx, &Processor{ID: "p-2", State: "broken"}); err == nil {
+			t.Error("expected invalid state to be rejected")
+		}
+
+		got, err := s.GetProcessor(ctx, "p-1")
+		if err != nil || got == nil {
+			t.Fatalf("get: %v (%v)", got, err)
+		}
+		if got.State != ProcessorStateOnline || got.Labels["gpu"] != "a100" || len(got.SupportedModels) != 1 || got.MaxConcurrency != 4 {
+			t.Errorf("unexpected processor: %+v", got)
+		}
+		if got, err := s.GetProcessor(ctx, "missing"); err != nil || got != nil {
+			t.Errorf("expected nil for unknown processor, got %v (%v)", got, err)
+		}
+
+		draining := ProcessorStateDraining
+		if err := s.UpdateProcessor(ctx, "p-1", &ProcessorUpdate{State: &draining}); err != nil {
+			t.Fatalf("update: %v", err)
+		}
+		if err := s.UpdateProcessor(ctx, "missing", &ProcessorUpdate{State: &draining}); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("expected sql.ErrNoRows for unknown processor, got %v", err)
+		}
+
+		// Heartbeat не выводит процессор из draining и не затирает версию пустой строкой
+		if err := s.TouchProcessor(ctx, "p-1", "", "host-b"); err != nil {
+			t.Fatalf("touch: %v", err)
+		}
+		got, _ = s.GetProcessor(ctx, "p-1")
+		if got.State != ProcessorStateDraining || got.Version != "1.0" || got.Hostname != "host-b" {
+			t.Errorf("unexpected processor after touch: %+v", got)
+		}
+
+		if err := s.TouchProcessor(ctx, "p-2", "2.0", ""); err != nil {
+			t.Fatalf("touch: %v", err)
+		}
+		if n, err := s.CountOnlineProcessors(ctx); err != nil || n != 1 {
+			t.Errorf("expected 1 online processor, got %d (%v)", n, err)
+		}
+		if list, err := s.ListProcessors(ctx, ProcessorStateDraining); err != nil || len(list) != 1 || list[0].ID != "p-1" {
+			t.Errorf("expected draining p-1, got %v (%v)", list, err)
+		}
+		if list, err := s.ListProcessors(ctx, ""); err != nil || len(list) != 2 {
+			t.Errorf("expected 2 processors, got %d (%v)", len(list), err)
+		}
+
+		if stale, err := s.GetStaleProcessors(ctx, 0); err != nil || len(stale) != 0 {
+			t.Errorf("expected no stale processors, got %d (%v)", len(stale), err)
+		}
+		if stale, err := s.GetStaleProcessors(ctx, future()); err != nil || len(stale) != 2 {
+			t.Errorf("expected 2 stale processors, got %d (%v)", len(stale), err)
+		}
+
+		if changed, err := s.MarkProcessorOffline(ctx, "p-2"); err != nil || !changed {
+			t.Errorf("expected p-2 marked offline, got %v (%v)", changed, err)
+		}
+		if changed, _ := s.MarkProcessorOffline(ctx, "p-2"); changed {
+			t.Error("expected second mark offline to be a no-op")
+		}
+		if stale, _ := s.GetStaleProcessors(ctx, future()); len(stale) != 1 {
+			t.Errorf("expected offline processors not to be stale, got %d", len(stale))
+		}
+
+		if deleted, err := s.DeleteProcessor(ctx, "p-2"); err != nil || !deleted {
+			t.Errorf("expected p-2 deleted, got %v (%v)", deleted, err)
+		}
+		if deleted, _ := s.DeleteProcessor(ctx, "p-2"); deleted {
+			t.Error("expected second delete to be a no-op")
+		}
+	})
+
+	t.Run("ProcessorLoadsAndMetrics", func(t *testing.T) {
+		ctx := t.Context()
+		s := newStore(t)
+		for _, id := range []string{"loaded", "free", "gone"} {
+			if err := s.TouchProcessor(ctx, id, "", ""); err != nil {
+				t.Fatalf("touch: %v", err)
+			}
+		}
+		if _, err := s.MarkProcessorOffline(ctx, "gone"); err != nil {
+			t.Fatalf("mark offline: %v", err)
+		}
+
+		cpu, mem, queue := 80.0, 40.0, 3
+		if err := s.UpdateProcessorMetrics(ctx, "loaded", &cpu, &mem, &queue, 0); err != nil {
+			t.Fatalf("metrics: %v", err)
+		}
+		// Частичное обновление сохраняет прежние значения
+		lower := 50.0
+		if err := s.UpdateProcessorMetrics(ctx, "loaded", &lower, nil, nil, 1); err != nil {
+			t.Fatalf("metrics: %v", err)
+		}
+		createPending(t, s, "t-1", 0)
+		if _, err := s.ClaimTasks(ctx, "free", 1, 60000, 0); err != nil {
+			t.Fatalf("claim: %v", err)
+		}
+
+		loads, err := s.GetProcessorLoads(ctx, time.Now().UnixMilli()-60000)
+		if err != nil {
+			t.Fatalf("loads: %v", err)
+		}
+		if len(loads) != 2 || loads[0].ID != "free" || loads[1].ID != "loaded" {
+			t.Fatalf("expected free before loaded, got %v", loads)
+		}
+		if loads[0].ActiveTasks != 1 || loads[0].CPUUsage != 0 {
+			t.Errorf("unexpected load of free: %+v", loads[0])
+		}
+		if loads[1].CPUUsage != lower || loads[1].MemoryUsage != mem || loads[1].QueueSize != queue {
+			t.Errorf("unexpected load of loaded: %+v", loads[1])
+		}
+
+		if pruned, err := s.PruneProcessorMetrics(ctx, 0); err != nil || pruned != 0 {
+			t.Errorf("expected recent metrics kept, pruned %d (%v)", pruned, err)
+		}
+		if pruned, err := s.PruneProcessorMetrics(ctx, future()); err != nil || pruned != 1 {
+			t.Errorf("expected 1 metrics record pruned, got %d (%v)", pruned, err)
+		}
+	})
+
+	t.Run("RatingStats", func(t *testing.T) {
+		ctx := t.Context()
+		s := newStore(t)
+		for _, id := range []string{"t-1", "t-2", "t-3"} {
+			createPending(t, s, id, 0)
+			if err := s.UpdateTaskStatus(ctx, id, TaskStatusCompleted, nil, nil); err != nil {
+				t.Fatalf("complete: %v", err)
+			}
+		}
+		upvote, downvote := "upvote", "downvote"
+		for id, rating := range map[string]*string{"t-1": &upvote, "t-2": &upvote, "t-3": &downvote} {
+			if err := s.UpdateTaskRating(ctx, id, "user-"+id, rating); err != nil {
+				t.Fatalf("rate %s: %v", id, err)
+			}
+		}
+
+		stats, err := s.GetTasksRatingStats(ctx, nil)
+		if err != nil || stats["upvote"] != 2 || stats["downvote"] != 1 {
+			t.Errorf("unexpected rating stats %v (%v)", stats, err)
+		}
+		user := "user-t-3"
+		if stats, _ := s.GetTasksRatingStats(ctx, &user); stats["upvote"] != 0 || stats["downvote"] != 1 {
+			t.Errorf("unexpected rating stats of %s: %v", user, stats)
+		}
+		if rated, err := s.GetUserRatedTasks(ctx, "user-t-1", &upvote, 10, 0); err != nil || len(rated) != 1 || rated[0].ID != "t-1" {
+			t.Errorf("expected t-1 upvoted, got %v (%v)", rated, err)
+		}
+		if rated, _ := s.GetUserRatedTasks(ctx, "user-t-1", &downvote, 10, 0); len(rated) != 0 {
+			t.Errorf("expected no downvoted tasks of user-t-1, got %v", rated)
+		}
+		if recent, err := s.GetRecentRatedTasks(ctx, 2); err != nil || len(recent) != 2 {
+			t.Errorf("expected 2 recent rated tasks, got %v (%v)", recent, err)
+		}
+
+		periods, err := s.GetRatingStatsByPeriod(ctx, PeriodDay, 7)
+		if err != nil || len(periods) != 1 {
+			t.Fatalf("expected one day of ratings, got %v (%v)", periods, err)
+		}
+		if p := periods[0]; p["period"] != time.Now().Format(time.DateOnly) || p["upvotes"] != 2 || p["downvotes"] != 1 || p["total_rated"] != 3 {
+			t.Errorf("unexpected period stats %v", p)
+		}
+		if _, err := s.GetRatingStatsByPeriod(ctx, "year", 1); err == nil {
+			t.Error("expected unsupported period to fail")
+		}
+	})
+
+	t.Run("UsageAndLedger", func(t *testing.T) {
+		ctx := t.Context()
+		s := newStore(t)
+		createPending(t, s, "t-1", 0)
+		createPending(t, s, "t-2", 0)
+		if _, err := s.ClaimTasks(ctx, "p-1", 2, 60000, 0); err != nil {
+			t.Fatalf("claim: %v", err)
+		}
+		if err := s.UpdateTaskStatus(ctx, "t-1", TaskStatusCompleted, nil, nil); err != nil {
+			t.Fatalf("complete: %v", err)
+		}
+		if failed, err := s.FailProcessingTask(ctx, "t-2", "p-1", "boom"); err != nil || !failed {
+			t.Fatalf("fail: %v (%v)", failed, err)
+		}
+
+		usage := &TaskUsage{TaskID: "t-1", UserID: "user-t-1", Model: "llama3", PromptTokens: 10, CompletionTokens: 5}
+		if recorded, err := s.RecordTaskUsage(ctx, usage); err != nil || !recorded {
+			t.Fatalf("record: %v (%v)", recorded, err)
+		}
+		if usage.TotalTokens != 15 || usage.CreatedAt == 0 {
+			t.Errorf("expected defaults filled in, got %+v", usage)
+		}
+		if recorded, _ := s.RecordTaskUsage(ctx, &TaskUsage{TaskID: "t-1", UserID: "user-t-1", TotalTokens: 100}); recorded {
+			t.Error("expected usage to be recorded once per task")
+		}
+
+		if total, err := s.GetUserTokenUsage(ctx, "user-t-1"); err != nil || total.TotalTokens != 15 || total.TaskCount != 1 {
+			t.Errorf("unexpected user usage %+v (%v)", total, err)
+		}
+		if total, err := s.GetUserTokenUsage(ctx, "nobody"); err != nil || total.UserID != "nobody" || total.TotalTokens != 0 {
+			t.Errorf("expected zero usage, got %+v (%v)", total, err)
+		}
+		if sum, err := s.SumUserTokens(ctx, "user-t-1", 0); err != nil || sum != 15 {
+			t.Errorf("expected 15 tokens, got %d (%v)", sum, err)
+		}
+		if sum, _ := s.SumUserTokens(ctx, "user-t-1", future()); sum != 0 {
+			t.Errorf("expected no tokens after now, got %d", sum)
+		}
+		days, err := s.ListDailyTokenUsage(ctx, "user-t-1", "2000-01-01")
+		if err != nil || len(days) != 1 || days[0].Day != usageDay(usage.CreatedAt) || days[0].Model != "llama3" || days[0].TotalTokens != 15 {
+			t.Errorf("unexpected daily usage %v (%v)", days, err)
+		}
+
+		report, err := s.GetUsageReport(ctx, UsageReportFilter{Period: PeriodDay, To: future(), GroupBy: []string{UsageGroupStatus}})
+		if err != nil || len(report) != 2 {
+			t.Fatalf("expected completed and failed rows, got %v (%v)", report, err)
+		}
+		completed, failed := report[0], report[1]
+		if completed.Status != TaskStatusCompleted || completed.Completed != 1 || completed.TasksWithTokens != 1 || completed.TotalTokens != 15 {
+			t.Errorf("unexpected completed row %+v", completed)
+		}
+		if failed.Status != TaskStatusFailed || failed.Failed != 1 || failed.FailureRate != 1 || failed.TasksWithTokens != 0 {
+			t.Errorf("unexpected failed row %+v", failed)
+		}
+		if report, _ := s.GetUsageReport(ctx, UsageReportFilter{Period: PeriodDay, To: future(), UserID: "user-t-2"}); len(report) != 1 || report[0].Tasks != 1 || report[0].Status != "" {
+			t.Errorf("unexpected report of user-t-2: %v", report)
+		}
+
+		// Учёт переживает удаление задач
+		if _, err := s.DeleteFinishedTasks(ctx, TaskStatusCompleted, future()); err != nil {
+			t.Fatalf("cleanup: %v", err)
+		}
+		if report, _ := s.GetUsageReport(ctx, UsageReportFilter{Period: PeriodMonth, To: future()}); len(report) != 1 || report[0].Tasks != 2 {
+			t.Errorf("expected both tasks in the report after cleanup, got %v", report)
+		}
+		if _, err := s.GetUsageReport(ctx, UsageReportFilter{Period: PeriodDay, GroupBy: []string{"processor"}}); err == nil {
+			t.Error("expected unsupported group to fail")
+		}
+		if _, err := s.GetUsageReport(ctx, UsageReportFilter{Period: "year"}); err == nil {
+			t.Error("expected unsupported period to fail")
+		}
+	})
+
+	t.Run("TokenLimits", func(t *testing.T) {
+		ctx := t.Context()
+		s := newStore(t)
+		if limit, err := s.GetUserTokenLimit(ctx, "u-1"); err != nil || limit != nil {
+			t.Errorf("expected no limit, got %v (%v)", limit, err)
+		}
+		for _, limit := range []*UserTokenLimit{{UserID: "u-2", MaxTokens: 10}, {UserID: "u-1", MaxTokens: 100, WindowMs: 1000}, {UserID: "u-1", MaxTokens: 200}} {
+			if err := s.SetUserTokenLimit(ctx, limit); err != nil || limit.UpdatedAt == 0 {
+				t.Fatalf("set limit: %v", err)
+			}
+		}
+		if limit, _ := s.GetUserTokenLimit(ctx, "u-1"); limit == nil || limit.MaxTokens != 200 || limit.WindowMs != 0 {
+			t.Errorf("expected the limit replaced, got %+v", limit)
+		}
+		if limits, err := s.ListUserTokenLimits(ctx); err != nil || len(limits) != 2 || limits[0].UserID != "u-1" {
+			t.Errorf("unexpected limits %v (%v)", limits, err)
+		}
+		if deleted, err := s.DeleteUserTokenLimit(ctx, "u-1"); err != nil || !deleted {
+			t.Errorf("expected the limit deleted, got %v (%v)", deleted, err)
+		}
+		if deleted, _ := s.DeleteUserTokenLimit(ctx, "u-1"); deleted {
+			t.Error("expected second delete to be a no-op")
+		}
+	})
+
+	t.Run("APIKeys", func(t *testing.T) {
+		ctx := t.Context()
+		s := newStore(t)
+		for i, id := range []string{"k-1", "k-2"} {
+			k := &APIKey{ID: id, Name: id, KeyHash: "hash-" + id, KeyPrefix: "llm_" + id, CreatedAt: int64(1000 + i)}
+			if err := s.CreateAPIKey(ctx, k); err != nil {
+				t.Fatalf("create %s: %v", id, err)
+			}
+			if k.Scopes == nil {
+				t.Error("expected nil scopes stored as empty")
+			}
+		}
+		if err := s.CreateAPIKey(ctx, &APIKey{ID: "k-3", KeyHash: "hash-k-1"}); err == nil {
+			t.Error("expected duplicate key hash to fail")
+		}
+
+		if k, err := s.GetAPIKeyByHash(ctx, "hash-k-1"); err != nil || k == nil || k.ID != "k-1" {
+			t.Errorf("expected k-1 by hash, got %v (%v)", k, err)
+		}
+		if k, err := s.GetAPIKey(ctx, "missing"); err != nil || k != nil {
+			t.Errorf("expected no key, got %v (%v)", k, err)
+		}
+		if keys, err := s.ListAPIKeys(ctx); err != nil || len(keys) != 2 || keys[0].ID != "k-2" {
+			t.Errorf("expected newest key first, got %v (%v)", keys, err)
+		}
+
+		if err := s.TouchAPIKey(ctx, "k-1"); err != nil {
+			t.Fatalf("touch: %v", err)
+		}
+		if err := s.ExpireAPIKey(ctx, "k-1", 5000); err != nil {
+			t.Fatalf("expire: %v", err)
+		}
+		if err := s.ExpireAPIKey(ctx, "k-1", 9000); err != nil {
+			t.Fatalf("expire: %v", err)
+		}
+		if k, _ := s.GetAPIKey(ctx, "k-1"); k.LastUsedAt == nil || k.ExpiresAt == nil || *k.ExpiresAt != 5000 {
+			t.Errorf("expected k-1 touched and expiring at 5000, got %+v", k)
+		}
+
+		if revoked, err := s.RevokeAPIKey(ctx, "k-2"); err != nil || !revoked {
+			t.Errorf("expected k-2 revoked, got %v (%v)", revoked, err)
+		}
+		if revoked, _ := s.RevokeAPIKey(ctx, "k-2"); revoked {
+			t.Error("expected second revoke to be a no-op")
+		}
+		if err := s.ExpireAPIKey(ctx, "k-2", 5000); err != nil {
+			t.Fatalf("expire: %v", err)
+		}
+		if k, _ := s.GetAPIKey(ctx, "k-2"); k.RevokedAt == nil || k.ExpiresAt != nil {
+			t.Errorf("expected revoked k-2 without expiry, got %+v", k)
+		}
+	})
+
+	t.Run("TokenRevocation", func(t *testing.T) {
+		ctx := t.Context()
+		s := newStore(t)
+		for _, exp := range []int64{2000, 1000} {
+			if err := s.RevokeTokenID(ctx, "jti-1", exp); err != nil {
+				t.Fatalf("revoke: %v", err)
+			}
+		}
+		if err := s.RevokeTokenID(ctx, "jti-2", 500); err != nil {
+			t.Fatalf("revoke: %v", err)
+		}
+		revoked, err := s.ListRevokedTokenIDs(ctx, 600)
+		if err != nil || len(revoked) != 1 || revoked["jti-1"] != 2000 {
+			t.Errorf("expected jti-1 kept until 2000, got %v (%v)", revoked, err)
+		}
+		if pruned, err := s.PruneRevokedTokens(ctx, 500); err != nil || pruned != 1 {
+			t.Errorf("expected 1 pruned, got %d (%v)", pruned, err)
+		}
+
+		notBefore, err := s.RevokeTokensBefore(ctx, TokenSubjectUser, "u-1")
+		if err != nil || notBefore%1000 != 0 {
+			t.Fatalf("expected a whole-second cutoff, got %d (%v)", notBefore, err)
+		}
+		if cutoffs, err := s.ListTokenCutoffs(ctx); err != nil || cutoffs[TokenSubjectUser]["u-1"] != notBefore {
+			t.Errorf("unexpected cutoffs %v (%v)", cutoffs, err)
+		}
+	})
+
+	t.Run("RateLimits", func(t *testing.T) {
+		ctx := t.Context()
+		s := newStore(t)
+		if state, err := s.GetRateLimitState(ctx, "u-1", "fixed_window"); err != nil || state != nil {
+			t.Errorf("expected no state, got %v (%v)", state, err)
+		}
+		increment := func(state *RateLimitState) *RateLimitState {
+			if state == nil {
+				state = &RateLimitState{UserID: "u-1", Algorithm: "fixed_window"}
+			}
+			state.Count++
+			state.UpdatedAt = 1000
+			return state
+		}
+		for range 2 {
+			if err := s.UpdateRateLimitState(ctx, "u-1", "fixed_window", increment); err != nil {
+				t.Fatalf("update: %v", err)
+			}
+		}
+		if state, _ := s.GetRateLimitState(ctx, "u-1", "fixed_window"); state == nil || state.Count != 2 {
+			t.Errorf("expected count 2, got %+v", state)
+		}
+
+		for _, at := range []int64{100, 200} {
+			if _, err := s.AddRateLimitLog(ctx, "u-1", at, 0); err != nil {
+				t.Fatalf("log: %v", err)
+			}
+		}
+		if log, err := s.AddRateLimitLog(ctx, "u-1", 300, 100); err != nil || len(log) != 2 || log[0] != 200 || log[1] != 300 {
+			t.Errorf("expected [200 300], got %v (%v)", log, err)
+		}
+		if log, err := s.ListRateLimitLog(ctx, "u-1", 200); err != nil || len(log) != 1 || log[0] != 300 {
+			t.Errorf("expected [300], got %v (%v)", log, err)
+		}
+
+		if n, err := s.CountRateLimitRecords(ctx); err != nil || n != 1 {
+			t.Errorf("expected 1 counter, got %d (%v)", n, err)
+		}
+		if removed, err := s.PruneRateLimits(ctx, 1001); err != nil || removed != 3 {
+			t.Errorf("expected counter and 2 log entries removed, got %d (%v)", removed, err)
+		}
+		if n, _ := s.CountRateLimitRecords(ctx); n != 0 {
+			t.Errorf("expected no counters after prune, got %d", n)
+		}
+	})
+
+	t.Run("Search", func(t *testing.T) {
+		ctx := t.Context()
+		s := newStore(t)
+		create := func(id, productData string) {
+			t.Helper()
+			if err := s.CreateTask(ctx, &Task{ID: id, UserID: "user-" + id, ProductData: productData, Status: TaskStatusPending}); err != nil {
+				t.Fatalf("create %s: %v", id, err)
+			}
+		}
+		create("phone", `{"title":"Смартфон Galaxy","note":"<b>новинка</b>"}`)
+		create("laptop", `{"title":"Ноутбук ThinkPad"}`)
+		result := "Отличный СМАРТФОН для работы"
+		if err := s.UpdateTaskStatus(ctx, "laptop", TaskStatusCompleted, &result, nil); err != nil {
+			t.Fatalf("complete: %v", err)
+		}
+
+		for query, want := range map[string]int{"смартфон": 2, "смарт*": 2, `"смартфон galaxy"`: 1, `"galaxy смартфон"`: 0, "смартфон thinkpad": 1, "OR": 0} {
+			page, err := s.SearchTasks(ctx, query, TaskFilter{}, nil, 10)
+			if err != nil || page.Total != want || len(page.Tasks) != want {
+				t.Errorf("%q: got %+v (%v), want %d hits", query, page, err, want)
+			}
+		}
+		if page, _ := s.SearchTasks(ctx, "смартфон", TaskFilter{Statuses: []string{TaskStatusCompleted}}, nil, 10); page.Total != 1 || page.Tasks[0].ID != "laptop" {
+			t.Errorf("expected only laptop among completed, got %+v", page)
+		}
+
+		page, err := s.SearchTasks(ctx, "новинка", TaskFilter{}, nil, 10)
+		if err != nil || len(page.Tasks) != 1 {
+			t.Fatalf("search: %+v %v", page, err)
+		}
+		want := `{&#34;title&#34;:&#34;Смартфон Galaxy&#34;,&#34;note&#34;:&#34;&lt;b&gt;<mark>новинка</mark>&lt;/b&gt;&#34;}`
+		if snippets := page.Tasks[0].Snippets; len(snippets) != 1 || snippets["product_data"] != want {
+			t.Errorf("snippets = %v, want product_data %s", snippets, want)
+		}
+		page, _ = s.SearchTasks(ctx, "смартфон", TaskFilter{UserID: "user-laptop"}, nil, 10)
+		if len(page.Tasks) != 1 || page.Tasks[0].Snippets["result"] != "Отличный <mark>СМАРТФОН</mark> для работы" {
+			t.Errorf("unexpected result snippet %v", page.Tasks)
+		}
+
+		page, err = s.SearchTasks(ctx, "смартфон", TaskFilter{}, nil, 1)
+		if err != nil || len(page.Tasks) != 1 || page.NextCursor == "" {
+			t.Fatalf("expected a next page, got %+v (%v)", page, err)
+		}
+		after, _ := ParseTaskCursor(page.NextCursor)
+		if next, _ := s.SearchTasks(ctx, "смартфон", TaskFilter{}, after, 1); len(next.Tasks) != 1 || next.Tasks[0].ID == page.Tasks[0].ID || next.NextCursor != "" {
+			t.Errorf("unexpected second page %+v", next)
+		}
+
+		if _, err := s.SearchTasks(ctx, ` "" * - `, TaskFilter{}, nil, 10); !errors.Is(err, ErrInvalidSearchQuery) {
+			t.Errorf("expected ErrInvalidSearchQuery, got %v", err)
+		}
+	})
+}
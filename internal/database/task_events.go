@@ -0,0 +1,62 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// LogTaskEvent appends an entry to the task lifecycle log
+func (db *DB) LogTaskEvent(ctx context.Context, taskID, eventType, processorID, message string) error {
+	query := `
+		INSERT INTO task_events (task_id, event_type, processor_id, message, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`
+
+	var procID, msg interface{}
+	if processorID != "" {
+		procID = processorID
+	}
+	if message != "" {
+		msg = message
+	}
+
+	_, err := db.QueuedExec(ctx, query, taskID, eventType, procID, msg, time.Now().UnixMilli())
+	return err
+}
+
+// GetTaskEvents returns the lifecycle log of a task, oldest first
+func (db *DB) GetTaskEvents(ctx context.Context, taskID string) ([]*TaskEvent, error) {
+	query := `
+		SELECT id, task_id, event_type, processor_id, message, created_at
+		FROM task_events
+		WHERE task_id = ?
+		ORDER BY created_at ASC, id ASC
+	`
+
+	rows, err := db.QueuedQuery(ctx, query, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]*TaskEvent, 0)
+	for rows.Next() {
+		var event TaskEvent
+		var processorID, message sql.NullString
+
+		if err := rows.Scan(&event.ID, &event.TaskID, &event.EventType, &processorID, &message, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		if processorID.Valid {
+			event.ProcessorID = &processorID.String
+		}
+		if message.Valid {
+			event.Message = &message.String
+		}
+
+		events = append(events, &event)
+	}
+
+	return events, rows.Err()
+}
@@ -0,0 +1,211 @@
+package database
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// RatingNone selects tasks without a rating in TaskFilter.Rating
+const RatingNone = "none"
+
+// ErrInvalidCursor is returned by ParseTaskCursor for a malformed cursor
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// TaskFilter selects tasks for ListTasks. Zero fields match all tasks; time bounds
+// are unix ms, From inclusive and To exclusive.
+type TaskFilter struct {
+	UserID        string
+	Statuses      []string
+	ProcessorID   string
+	Model         string // ollama_params.model
+	Rating        string // upvote, downvote или RatingNone
+	PriorityMin   *int
+	PriorityMax   *int
+	CreatedFrom   int64
+	CreatedTo     int64
+	CompletedFrom int64
+	CompletedTo   int64
+	HasError      *bool
+}
+
+// TaskCursor is the position of the last task of a page. Tasks are listed newest
+// first, by created_at and then id, so pages stay stable while tasks are added.
+type TaskCursor struct {
+	CreatedAt int64
+	ID        string
+}
+
+// String encodes the cursor for clients, which pass it back as is
+func (c *TaskCursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(c.CreatedAt, 10) + ":" + c.ID))
+}
+
+// ParseTaskCursor decodes a cursor returned in TaskPage.NextCursor
+func ParseTaskCursor(s string) (*TaskCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	createdAt, id, found := strings.Cut(string(raw), ":")
+	if !found || id == "" {
+		return nil, ErrInvalidCursor
+	}
+	c := &TaskCursor{ID: id}
+	if c.CreatedAt, err = strconv.ParseInt(createdAt, 10, 64); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// TaskPage is a page of ListTasks. Total counts all tasks matching the filter;
+// NextCursor is empty on the last page.
+type TaskPage struct {
+	Tasks      []*Task `json:"tasks"`
+	Total      int     `json:"total"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+}
+
+// where returns the SQL condition of the filter over the tasks table, "1" if empty
+func (f *TaskFilter) where() (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	add := func(cond string, values ...interface{}) {
+		conds = append(conds, cond)
+		args = append(args, values...)
+	}
+
+	if f.UserID != "" {
+		add("tasks.user_id = ?", f.UserID)
+	}
+	if len(f.Statuses) > 0 {
+		placeholders, values := inList(len(f.Statuses), func(i int) interface{} { return f.Statuses[i] })
+		add("tasks.status IN ("+placeholders+")", values...)
+	}
+	if f.ProcessorID != "" {
+		add("tasks.processor_id = ?", f.ProcessorID)
+	}
+	if f.Model != "" {
+		add("CASE WHEN json_valid(tasks.ollama_params) THEN json_extract(tasks.ollama_params, '$.model') END = ?", f.Model)
+	}
+	switch f.Rating {
+	case "":
+	case RatingNone:
+		add("tasks.rating IS NULL")
+	default:
+		add("tasks.rating = ?", f.Rating)
+	}
+	if f.PriorityMin != nil {
+		add("tasks.priority >= ?", *f.PriorityMin)
+	}
+	if f.PriorityMax != nil {
+		add("tasks.priority <= ?", *f.PriorityMax)
+	}
+	if f.CreatedFrom != 0 {
+		add("tasks.created_at >= ?", f.CreatedFrom)
+	}
+	if f.CreatedTo != 0 {
+		add("tasks.created_at < ?", f.CreatedTo)
+	}
+	if f.CompletedFrom != 0 {
+		add("tasks.completed_at >= ?", f.CompletedFrom)
+	}
+	if f.CompletedTo != 0 {
+		add("tasks.completed_at < ?", f.CompletedTo)
+	}
+	if f.HasError != nil {
+		if *f.HasError {
+			add("COALESCE(tasks.error_message, '') != ''")
+		} else {
+			add("COALESCE(tasks.error_message, '') = ''")
+		}
+	}
+
+	if len(conds) == 0 {
+		return "1", nil
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// match reports whether the task passes the filter, as where does in SQL
+func (f *TaskFilter) match(t *Task) bool {
+	switch {
+	case f.UserID != "" && t.UserID != f.UserID,
+		len(f.Statuses) > 0 && !slices.Contains(f.Statuses, t.Status),
+		f.ProcessorID != "" && (t.ProcessorID == nil || *t.ProcessorID != f.ProcessorID),
+		f.Model != "" && modelOf(t) != f.Model,
+		f.Rating == RatingNone && t.UserRating != nil,
+		f.Rating != "" && f.Rating != RatingNone && (t.UserRating == nil || *t.UserRating != f.Rating),
+		f.PriorityMin != nil && t.Priority < *f.PriorityMin,
+		f.PriorityMax != nil && t.Priority > *f.PriorityMax,
+		f.CreatedFrom != 0 && t.CreatedAt < f.CreatedFrom,
+		f.CreatedTo != 0 && t.CreatedAt >= f.CreatedTo,
+		f.CompletedFrom != 0 && (t.CompletedAt == nil || *t.CompletedAt < f.CompletedFrom),
+		f.CompletedTo != 0 && (t.CompletedAt == nil || *t.CompletedAt >= f.CompletedTo),
+		f.HasError != nil && *f.HasError != (t.ErrorMessage != nil && *t.ErrorMessage != ""):
+		return false
+	}
+	return true
+}
+
+// afterCursor reports whether the task comes after the cursor in ListTasks order
+func afterCursor(t *Task, c *TaskCursor) bool {
+	return t.CreatedAt < c.CreatedAt || (t.CreatedAt == c.CreatedAt && t.ID < c.ID)
+}
+
+// ListTasks returns up to limit tasks matching the filter, newest first, starting
+// after the cursor (nil for the first page). With limit 0 only Total is counted.
+func (db *DB) ListTasks(ctx context.Context, filter TaskFilter, after *TaskCursor, limit int) (*TaskPage, error) {
+	where, args := filter.where()
+
+	result := &TaskPage{Tasks: []*Task{}}
+	if err := db.QueuedQueryRow(ctx, `SELECT COUNT(*) FROM tasks WHERE `+where, args...).Scan(&result.Total); err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		return result, nil
+	}
+
+	if after != nil {
+		where += " AND (tasks.created_at < ? OR (tasks.created_at = ? AND tasks.id < ?))"
+		args = append(args, after.CreatedAt, after.CreatedAt, after.ID)
+	}
+	query := `
+		SELECT id, user_id, product_data, status, result, error_message,
+			   created_at, updated_at, completed_at, priority, retry_count,
+			   max_retries, processor_id, processing_started_at, heartbeat_at,
+			   timeout_at, ollama_params, estimated_duration, actual_duration, rating
+		FROM tasks
+		WHERE ` + where + `
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?
+	`
+
+	// Лишняя строка показывает, есть ли следующая страница
+	rows, err := db.QueuedQuery(ctx, query, append(args, limit+1)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		result.Tasks = append(result.Tasks, task)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(result.Tasks) > limit {
+		result.Tasks = result.Tasks[:limit]
+		last := result.Tasks[limit-1]
+		result.NextCursor = (&TaskCursor{CreatedAt: last.CreatedAt, ID: last.ID}).String()
+	}
+	return result, nil
+}
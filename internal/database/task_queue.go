@@ -0,0 +1,304 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Queue operations of processors: claim, heartbeat, work stealing and cleanup
+
+// Work stealing takes tasks from processors holding more than stealMinActiveTasks
+const stealMinActiveTasks = 5
+
+// ClaimTasks assigns up to limit pending tasks, highest priority first, to the
+// processor. If maxConcurrency > 0 the processor never holds more than
+// maxConcurrency processing tasks.
+func (db *DB) ClaimTasks(ctx context.Context, processorID string, limit int, timeoutMs int64, maxConcurrency int) ([]*Task, error) {
+	claimed := make([]*Task, 0)
+	now := time.Now().UnixMilli()
+	timeoutAt := now + timeoutMs
+
+	err := db.QueuedTransaction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		n := limit
+		if maxConcurrency > 0 {
+			var active int
+			err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM tasks WHERE processor_id = ? AND status = 'processing'`, processorID).Scan(&active)
+			if err != nil {
+				return err
+			}
+			n = min(n, maxConcurrency-active)
+		}
+		if n <= 0 {
+			return nil
+		}
+
+		rows, err := tx.QueryContext(ctx, `
+			SELECT `+pendingTaskColumns+`
+			FROM tasks
+			WHERE status = 'pending'
+			ORDER BY priority DESC, created_at ASC
+			LIMIT ?
+		`, n)
+		if err != nil {
+			return err
+		}
+		var tasks []*Task
+		for rows.Next() {
+			task, err := scanPendingTask(rows)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			tasks = append(tasks, task)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, task := range tasks {
+			result, err := tx.ExecContext(ctx, `
+				UPDATE tasks
+				SET status = 'processing',
+					processor_id = ?,
+					processing_started_at = ?,
+					heartbeat_at = ?,
+					timeout_at = ?,
+					updated_at = ?
+				WHERE id = ? AND status = 'pending'
+			`, processorID, now, now, timeoutAt, now, task.ID)
+			if err != nil {
+				return err
+			}
+			if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+				continue
+			}
+
+			task.Status = TaskStatusProcessing
+			task.ProcessorID = &processorID
+			task.ProcessingStartedAt = &now
+			task.HeartbeatAt = &now
+			task.TimeoutAt = &timeoutAt
+			task.UpdatedAt = now
+			claimed = append(claimed, task)
+		}
+		return nil
+	})
+
+	return claimed, err
+}
+
+// HeartbeatTask extends a processing task held by the processor.
+// Returns false if the task is not processing on that processor.
+func (db *DB) HeartbeatTask(ctx context.Context, taskID, processorID string) (bool, error) {
+	query := `
+		UPDATE tasks
+		SET heartbeat_at = ?, updated_at = ?
+		WHERE processor_id = ? AND id = ? AND status = 'processing'
+	`
+
+	now := time.Now().UnixMilli()
+	result, err := db.QueuedExec(ctx, query, now, now, processorID, taskID)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected > 0, nil
+}
+
+// StealTasks reassigns to the processor up to limit processing tasks of overloaded
+// processors (more than stealMinActiveTasks tasks) whose last heartbeat is older than
+// heartbeatBefore. The most loaded processors and highest priorities go first.
+func (db *DB) StealTasks(ctx context.Context, processorID string, limit int, heartbeatBefore, timeoutMs int64) ([]*Task, error) {
+	stolen := make([]*Task, 0)
+	now := time.Now().UnixMilli()
+	timeoutAt := now + timeoutMs
+
+	err := db.QueuedTransaction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, `
+			WITH processor_loads AS (
+				SELECT
+					processor_id,
+					COUNT(*) as active_tasks
+				FROM tasks
+				WHERE status = 'processing'
+				AND processor_id IS NOT NULL
+				GROUP BY processor_id
+				HAVING active_tasks > ?
+			)
+			SELECT
+				t.id,
+				t.user_id,
+				t.product_data,
+				t.priority,
+				t.retry_count,
+				t.estimated_duration,
+				t.ollama_params,
+				t.trace_parent
+			FROM tasks t
+			JOIN processor_loads pl ON t.processor_id = pl.processor_id
+			WHERE
+				t.status = 'processing'
+				AND t.heartbeat_at < ?
+				AND t.processor_id != ?
+			ORDER BY pl.active_tasks DESC, t.priority DESC
+			LIMIT ?
+		`, stealMinActiveTasks, heartbeatBefore, processorID, limit)
+		if err != nil {
+			return err
+		}
+
+		var tasks []*Task
+		for rows.Next() {
+			task := &Task{Status: TaskStatusProcessing}
+			var ollamaParamsJSON sql.NullString
+
+			err := rows.Scan(
+				&task.ID, &task.UserID, &task.ProductData, &task.Priority,
+				&task.RetryCount, &task.EstimatedDuration, &ollamaParamsJSON,
+				&task.TraceParent,
+			)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+
+			if ollamaParamsJSON.Valid && ollamaParamsJSON.String != "" {
+				task.OllamaParams = &ollamaParamsJSON.String
+			}
+			tasks = append(tasks, task)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, task := range tasks {
+			_, err := tx.ExecContext(ctx, `
+				UPDATE tasks
+				SET processor_id = ?,
+					heartbeat_at = ?,
+					timeout_at = ?,
+					updated_at = ?
+				WHERE id = ?
+			`, processorID, now, timeoutAt, now, task.ID)
+			if err != nil {
+				return err
+			}
+
+			task.ProcessorID = &processorID
+			task.HeartbeatAt = &now
+			task.TimeoutAt = &timeoutAt
+			task.UpdatedAt = now
+			stolen = append(stolen, task)
+		}
+		return nil
+	})
+
+	return stolen, err
+}
+
+// GetTimedOutTasks returns processing tasks without a heartbeat since heartbeatBefore
+func (db *DB) GetTimedOutTasks(ctx context.Context, heartbeatBefore int64) ([]*Task, error) {
+	query := `
+		SELECT id, user_id, product_data, status, result, error_message,
+			   created_at, updated_at, completed_at, priority, retry_count,
+			   max_retries, processor_id, processing_started_at, heartbeat_at,
+			   timeout_at, ollama_params, estimated_duration, actual_duration, rating
+		FROM tasks
+		WHERE status = 'processing' AND (heartbeat_at < ? OR heartbeat_at IS NULL)
+	`
+
+	rows, err := db.QueuedQuery(ctx, query, heartbeatBefore)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tasks []*Task
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+
+	return tasks, rows.Err()
+}
+
+// DeleteFinishedTasks deletes tasks of a finished status (completed or failed)
+// finished before `before`
+func (db *DB) DeleteFinishedTasks(ctx context.Context, status string, before int64) (int64, error) {
+	ctx, cancel := db.maintenance(ctx)
+	defer cancel()
+
+	query := `
+		DELETE FROM tasks
+		WHERE status = ?
+		AND completed_at < ?
+	`
+
+	result, err := db.QueuedExec(ctx, query, status, before)
+	if err != nil {
+		return 0, err
+	}
+	deleted, _ := result.RowsAffected()
+	return deleted, nil
+}
+
+// GetTaskStats counts tasks by status, finished tasks past their retention and
+// processing tasks without a heartbeat since heartbeatBefore
+func (db *DB) GetTaskStats(ctx context.Context, retention Retention, heartbeatBefore int64) (*TaskStats, error) {
+	query := `
+		SELECT
+			COUNT(*) as total_tasks,
+			COALESCE(SUM(CASE WHEN status = 'pending' THEN 1 ELSE 0 END), 0) as pending_tasks,
+			COALESCE(SUM(CASE WHEN status = 'processing' THEN 1 ELSE 0 END), 0) as processing_tasks,
+			COALESCE(SUM(CASE WHEN status = 'completed' THEN 1 ELSE 0 END), 0) as completed_tasks,
+			COALESCE(SUM(CASE WHEN status = 'failed' THEN 1 ELSE 0 END), 0) as failed_tasks,
+			COALESCE(SUM(CASE WHEN (status = 'completed' AND completed_at < ?) OR (status = 'failed' AND completed_at < ?) THEN 1 ELSE 0 END), 0) as expired_tasks,
+			COALESCE(SUM(CASE WHEN status = 'processing' AND heartbeat_at < ? THEN 1 ELSE 0 END), 0) as timedout_tasks
+		FROM tasks
+	`
+
+	var stats TaskStats
+	err := db.QueuedQueryRow(ctx, query, retention.Completed, retention.Failed, heartbeatBefore).Scan(
+		&stats.Total, &stats.Pending, &stats.Processing, &stats.Completed,
+		&stats.Failed, &stats.Expired, &stats.TimedOut,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
+
+// GetQueueStats returns the number of pending tasks and the average processing time
+// of tasks completed since completedSince
+func (db *DB) GetQueueStats(ctx context.Context, completedSince int64) (*QueueStats, error) {
+	var stats QueueStats
+
+	err := db.QueuedQueryRow(ctx, `SELECT COUNT(*) FROM tasks WHERE status = 'pending'`).Scan(&stats.Pending)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT
+			COALESCE(AVG(completed_at - processing_started_at), 0) as avg_processing_time,
+			COUNT(*) as completed_count
+		FROM tasks
+		WHERE status = 'completed'
+			AND completed_at > ?
+			AND processing_started_at IS NOT NULL
+			AND completed_at IS NOT NULL
+	`
+	err = db.QueuedQueryRow(ctx, query, completedSince).Scan(&stats.AvgProcessingMs, &stats.Completed)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
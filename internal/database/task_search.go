@@ -0,0 +1,186 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"html"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ErrInvalidSearchQuery is returned by SearchTasks for a query without words
+var ErrInvalidSearchQuery = errors.New("invalid search query")
+
+// Маркеры snippet(): управляющие символы не встречаются в обычном тексте и переживают html.EscapeString
+const (
+	markOpen  = "\x02"
+	markClose = "\x03"
+)
+
+// searchColumns are the indexed columns of tasks_fts in index order
+var searchColumns = []string{"product_data", "result", "error_message"}
+
+// TaskSearchHit is a task found by SearchTasks. Snippets holds a fragment of each
+// matching field: HTML-escaped text with the matches wrapped in <mark>.
+type TaskSearchHit struct {
+	*Task
+	Snippets map[string]string `json:"snippets"`
+}
+
+// TaskSearchPage is a page of SearchTasks, paged like TaskPage
+type TaskSearchPage struct {
+	Tasks      []*TaskSearchHit `json:"tasks"`
+	Total      int              `json:"total"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+// SearchTasks returns up to limit tasks whose product_data, result or error_message
+// match the query and which pass the filter, newest first, starting after the cursor.
+//
+// Words of the query must all occur, in any of the fields; "quoted text" matches a
+// phrase and a trailing * a prefix. Case and diacritics are ignored. FTS5 operators
+// are not interpreted, so any input is a valid query.
+func (db *DB) SearchTasks(ctx context.Context, query string, filter TaskFilter, after *TaskCursor, limit int) (*TaskSearchPage, error) {
+	match, err := searchQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	where, args := filter.where()
+	from := `
+		FROM tasks_fts
+		JOIN task_search_docs ON task_search_docs.rowid = tasks_fts.rowid
+		JOIN tasks ON tasks.id = task_search_docs.task_id
+		WHERE tasks_fts MATCH ? AND ` + where
+	args = append([]interface{}{match}, args...)
+
+	result := &TaskSearchPage{Tasks: []*TaskSearchHit{}}
+	if err := db.QueuedQueryRow(ctx, `SELECT COUNT(*) `+from, args...).Scan(&result.Total); err != nil {
+		return nil, err
+	}
+	if limit <= 0 || result.Total == 0 {
+		return result, nil
+	}
+
+	if after != nil {
+		from += " AND (tasks.created_at < ? OR (tasks.created_at = ? AND tasks.id < ?))"
+		args = append(args, after.CreatedAt, after.CreatedAt, after.ID)
+	}
+	var snippets string
+	var snippetArgs []interface{}
+	for i := range searchColumns {
+		snippets += ", snippet(tasks_fts, " + strconv.Itoa(i) + ", ?, ?, '…', 16)"
+		snippetArgs = append(snippetArgs, markOpen, markClose)
+	}
+	args = append(append(snippetArgs, args...), limit+1)
+	rows, err := db.QueuedQuery(ctx, `
+		SELECT tasks.id, tasks.user_id, tasks.product_data, tasks.status, tasks.result, tasks.error_message,
+			   tasks.created_at, tasks.updated_at, tasks.completed_at, tasks.priority, tasks.retry_count,
+			   tasks.max_retries, tasks.processor_id, tasks.processing_started_at, tasks.heartbeat_at,
+			   tasks.timeout_at, tasks.ollama_params, tasks.estimated_duration, tasks.actual_duration, tasks.rating`+snippets+`
+		`+from+`
+		ORDER BY tasks.created_at DESC, tasks.id DESC
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		fragments := make([]sql.NullString, len(searchColumns))
+		extra := make([]interface{}, len(fragments))
+		for i := range fragments {
+			extra[i] = &fragments[i]
+		}
+		task, err := scanTask(withColumns{rows, extra})
+		if err != nil {
+			return nil, err
+		}
+
+		hit := &TaskSearchHit{Task: task, Snippets: make(map[string]string)}
+		for i, fragment := range fragments {
+			// snippet() возвращает начало поля и без совпадений в нем
+			if strings.Contains(fragment.String, markOpen) {
+				hit.Snippets[searchColumns[i]] = highlight(fragment.String)
+			}
+		}
+		result.Tasks = append(result.Tasks, hit)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(result.Tasks) > limit {
+		result.Tasks = result.Tasks[:limit]
+		last := result.Tasks[limit-1]
+		result.NextCursor = (&TaskCursor{CreatedAt: last.CreatedAt, ID: last.ID}).String()
+	}
+	return result, nil
+}
+
+// searchTerm is a word or quoted phrase of a search query; prefix marks a trailing *
+type searchTerm struct {
+	text   string
+	prefix bool
+}
+
+// searchTerms splits user input into words and "quoted phrases", skipping terms
+// without letters or digits. An unclosed quote runs to the end of the input.
+func searchTerms(input string) []searchTerm {
+	var terms []searchTerm
+	add := func(text string, prefix bool) {
+		if strings.ContainsFunc(text, isWordRune) {
+			terms = append(terms, searchTerm{text, prefix})
+		}
+	}
+
+	for rest := strings.TrimSpace(input); rest != ""; rest = strings.TrimSpace(rest) {
+		if phrase, found := strings.CutPrefix(rest, `"`); found {
+			// Незакрытая кавычка тянет фразу до конца строки
+			text, after, _ := strings.Cut(phrase, `"`)
+			add(text, false)
+			rest = after
+			continue
+		}
+		word, after := rest, ""
+		if i := strings.IndexFunc(rest, unicode.IsSpace); i >= 0 {
+			word, after = rest[:i], rest[i:]
+		}
+		prefixWord, prefix := strings.CutSuffix(word, "*")
+		add(prefixWord, prefix)
+		rest = after
+	}
+	return terms
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// searchQuery turns user input into an FTS5 query of quoted phrases, so that
+// operators and punctuation in the input are searched for rather than parsed
+func searchQuery(input string) (string, error) {
+	var terms []string
+	for _, t := range searchTerms(input) {
+		term := `"` + strings.ReplaceAll(t.text, `"`, `""`) + `"`
+		if t.prefix {
+			term += "*"
+		}
+		terms = append(terms, term)
+	}
+
+	if len(terms) == 0 {
+		return "", ErrInvalidSearchQuery
+	}
+	return strings.Join(terms, " "), nil
+}
+
+// highlight escapes a snippet for HTML and turns its match markers into <mark>
+func highlight(fragment string) string {
+	fragment = html.EscapeString(fragment)
+	fragment = strings.ReplaceAll(fragment, markOpen, "<mark>")
+	return strings.ReplaceAll(fragment, markClose, "</mark>")
+}
@@ -0,0 +1,157 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSearchTasks(t *testing.T) {
+	ctx := t.Context()
+	db := NewTestDB(t)
+
+	create := func(id, productData string) {
+		t.Helper()
+		if err := db.CreateTask(ctx, &Task{ID: id, UserID: "user-" + id, ProductData: productData, Status: TaskStatusPending}); err != nil {
+			t.Fatalf("create %s: %v", id, err)
+		}
+	}
+	create("phone", `{"title":"Смартфон Galaxy","note":"<b>новинка</b>"}`)
+	create("laptop", `{"title":"Ноутбук ThinkPad"}`)
+	create("kettle", `{"title":"Электрический чайник"}`)
+
+	result := "Отличный СМАРТФОН для работы"
+	if err := db.UpdateTaskStatus(ctx, "laptop", TaskStatusCompleted, &result, nil); err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	failure := "connection refused by ollama"
+	if err := db.UpdateTaskStatus(ctx, "kettle", TaskStatusFailed, nil, &failure); err != nil {
+		t.Fatalf("fail: %v", err)
+	}
+
+	ids := func(page *TaskSearchPage) map[string]bool {
+		got := make(map[string]bool)
+		for _, hit := range page.Tasks {
+			got[hit.ID] = true
+		}
+		return got
+	}
+
+	tests := []struct {
+		query  string
+		filter TaskFilter
+		want   []string
+	}{
+		{"смартфон", TaskFilter{}, []string{"phone", "laptop"}},
+		{"смартфон", TaskFilter{Statuses: []string{TaskStatusCompleted}}, []string{"laptop"}},
+		{"смарт*", TaskFilter{}, []string{"phone", "laptop"}},
+		{`"connection refused"`, TaskFilter{}, []string{"kettle"}},
+		{`"refused connection"`, TaskFilter{}, nil},
+		{"ollama ноутбук", TaskFilter{}, nil},
+		{"thinkpad OR", TaskFilter{}, nil}, // OR ищется как слово, а не оператор
+		{"NEAR(chat", TaskFilter{}, nil},
+	}
+	for _, tt := range tests {
+		page, err := db.SearchTasks(ctx, tt.query, tt.filter, nil, 10)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.query, err)
+		}
+		got := ids(page)
+		if len(got) != len(tt.want) || page.Total != len(tt.want) {
+			t.Errorf("%q: got %v (total %d), want %v", tt.query, got, page.Total, tt.want)
+			continue
+		}
+		for _, id := range tt.want {
+			if !got[id] {
+				t.Errorf("%q: got %v, want %v", tt.query, got, tt.want)
+			}
+		}
+	}
+
+	page, err := db.SearchTasks(ctx, "новинка", TaskFilter{}, nil, 10)
+	if err != nil || len(page.Tasks) != 1 {
+		t.Fatalf("search: %+v %v", page, err)
+	}
+	snippet := page.Tasks[0].Snippets["product_data"]
+	if want := `{&#34;title&#34;:&#34;Смартфон Galaxy&#34;,&#34;note&#34;:&#34;&lt;b&gt;<mark>новинка</mark>&lt;/b&gt;&#34;}`; snippet != want {
+		t.Errorf("snippet = %s, want %s", snippet, want)
+	}
+	if len(page.Tasks[0].Snippets) != 1 {
+		t.Errorf("expected only the matching field, got %v", page.Tasks[0].Snippets)
+	}
+
+	// Индекс следует за изменениями и удалением задач
+	if _, err := db.Exec(`UPDATE tasks SET error_message = 'timeout' WHERE id = 'kettle'`); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if _, err := db.Exec(`DELETE FROM tasks WHERE id = 'laptop'`); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	for query, want := range map[string]int{"refused": 0, "timeout": 1, "thinkpad": 0, "смартфон": 1} {
+		page, err := db.SearchTasks(ctx, query, TaskFilter{}, nil, 10)
+		if err != nil || page.Total != want {
+			t.Errorf("%q after changes: total %d (%v), want %d", query, page.Total, err, want)
+		}
+	}
+
+	if _, err := db.SearchTasks(ctx, ` "" * - `, TaskFilter{}, nil, 10); !errors.Is(err, ErrInvalidSearchQuery) {
+		t.Errorf("expected ErrInvalidSearchQuery, got %v", err)
+	}
+}
+
+func TestSearchTasksPages(t *testing.T) {
+	ctx := t.Context()
+	db := NewTestDB(t)
+	for _, id := range []string{"a", "b", "c"} {
+		if err := db.CreateTask(ctx, &Task{ID: id, UserID: id, ProductData: "same text", Status: TaskStatusPending}); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+	db.Exec(`UPDATE tasks SET created_at = 1000`)
+
+	var got []string
+	var after *TaskCursor
+	for {
+		page, err := db.SearchTasks(ctx, "text", TaskFilter{}, after, 2)
+		if err != nil || page.Total != 3 {
+			t.Fatalf("page: total %d, %v", page.Total, err)
+		}
+		for _, hit := range page.Tasks {
+			got = append(got, hit.ID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		if after, err = ParseTaskCursor(page.NextCursor); err != nil {
+			t.Fatalf("cursor: %v", err)
+		}
+	}
+	if len(got) != 3 || got[0] != "c" || got[1] != "b" || got[2] != "a" {
+		t.Errorf("paged hits %v, want [c b a]", got)
+	}
+}
+
+func TestSearchQuery(t *testing.T) {
+	tests := map[string]string{
+		`error`:                `"error"`,
+		`  two   words `:       `"two" "words"`,
+		"tab\tseparated":       `"tab" "separated"`,
+		`pref*`:                `"pref"*`,
+		`"exact phrase" next`:  `"exact phrase" "next"`,
+		`"unclosed phrase`:     `"unclosed phrase"`,
+		`a"b`:                  `"a""b"`,
+		`NOT col:value OR (x)`: `"NOT" "col:value" "OR" "(x)"`,
+		`- * ""`:               ``,
+	}
+	for input, want := range tests {
+		got, err := searchQuery(input)
+		if want == "" {
+			if !errors.Is(err, ErrInvalidSearchQuery) {
+				t.Errorf("searchQuery(%q) = %q, %v; want ErrInvalidSearchQuery", input, got, err)
+			}
+			continue
+		}
+		if err != nil || got != want {
+			t.Errorf("searchQuery(%q) = %q, %v; want %q", input, got, err, want)
+		}
+	}
+}
@@ -1,104 +1,73 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"strings"
 	"time"
 )
 
 // Task operations
 
-// retryOnBusy executes a function with retry logic for SQLite BUSY errors
-func retryOnBusy(maxRetries int, fn func() error) error {
-	var err error
-	for i := 0; i < maxRetries; i++ {
-		err = fn()
-		if err == nil {
-			return nil
-		}
+// CreateTask inserts a pending task. The check for an active task of the user and
+// the insert run in one transaction, so concurrent requests can't both pass it.
+func (db *DB) CreateTask(ctx context.Context, task *Task) error {
+	query := `
+		INSERT INTO tasks (
+			id, user_id, product_data, status, created_at, updated_at, 
+			priority, max_retries, estimated_duration, ollama_params, trace_parent
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
 
-		// Check if it's a SQLite BUSY error and retry
-		if i < maxRetries-1 {
-			errStr := strings.ToLower(err.Error())
-			isBusyError := strings.Contains(errStr, "database is locked") ||
-				strings.Contains(errStr, "database table is locked") ||
-				strings.Contains(errStr, "busy") ||
-				strings.Contains(errStr, "sqlite_busy") ||
-				strings.Contains(errStr, "locked")
-
-			if isBusyError {
-				// Exponential backoff with jitter for BUSY errors
-				baseDelay := time.Duration(i+1) * 100 * time.Millisecond
-				jitter := time.Duration(i*50) * time.Millisecond
-				time.Sleep(baseDelay + jitter)
-				continue
-			}
-			// For other errors, also retry but with less delay
-			time.Sleep(time.Duration(i+1) * 20 * time.Millisecond)
-		}
+	now := time.Now().UnixMilli()
+	ollamaParamsJSON := ""
+	if task.OllamaParams != nil {
+		ollamaParamsJSON = *task.OllamaParams
 	}
-	return err
-}
 
-func (db *DB) CreateTask(task *Task) error {
-	return retryOnBusy(3, func() error { // Reduced retries since we have queue now
-		// Check if user already has an active task
-		hasActiveTask, err := db.CheckUserActiveTask(task.UserID)
+	return db.QueuedTransaction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		var active int
+		err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM tasks WHERE user_id = ? AND status IN ('pending', 'processing')`, task.UserID).Scan(&active)
 		if err != nil {
 			return err
 		}
-
-		if hasActiveTask {
-			return fmt.Errorf("user already has an active task")
-		}
-
-		query := `
-			INSERT INTO tasks (
-				id, user_id, product_data, status, created_at, updated_at, 
-				priority, max_retries, estimated_duration, ollama_params
-			) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-		`
-
-		now := time.Now().UnixMilli()
-		ollamaParamsJSON := ""
-		if task.OllamaParams != nil {
-			ollamaParamsJSON = *task.OllamaParams
+		if active > 0 {
+			return ErrActiveTask
 		}
 
-		_, err = db.QueuedExec(query,
+		_, err = tx.ExecContext(ctx, query,
 			task.ID, task.UserID, task.ProductData, task.Status,
 			now, now, task.Priority, task.MaxRetries,
-			task.EstimatedDuration, ollamaParamsJSON,
+			task.EstimatedDuration, ollamaParamsJSON, task.TraceParent,
 		)
 		return err
 	})
 }
 
-func (db *DB) GetTask(id string) (*Task, error) {
+func (db *DB) GetTask(ctx context.Context, id string) (*Task, error) {
 	var task Task
 	var ollamaParamsJSON sql.NullString
 	var completedAt, processingStartedAt, heartbeatAt, timeoutAt sql.NullInt64
-	var result, errorMessage, processorID, userRating sql.NullString
+	var result, errorMessage, processorID, userRating, traceParent sql.NullString
 	var actualDuration sql.NullInt64
 
-	err := retryOnBusy(3, func() error {
-		query := `
-			SELECT id, user_id, product_data, status, result, error_message,
-				   created_at, updated_at, completed_at, priority, retry_count,
-				   max_retries, processor_id, processing_started_at, heartbeat_at,
-				   timeout_at, ollama_params, estimated_duration, actual_duration, rating
-			FROM tasks WHERE id = ?
-		`
+	query := `
+		SELECT id, user_id, product_data, status, result, error_message,
+			   created_at, updated_at, completed_at, priority, retry_count,
+			   max_retries, processor_id, processing_started_at, heartbeat_at,
+			   timeout_at, ollama_params, estimated_duration, actual_duration, rating,
+			   trace_parent
+		FROM tasks WHERE id = ?
+	`
 
-		return db.QueuedQueryRow(query, id).Scan(
-			&task.ID, &task.UserID, &task.ProductData, &task.Status,
-			&result, &errorMessage, &task.CreatedAt, &task.UpdatedAt,
-			&completedAt, &task.Priority, &task.RetryCount, &task.MaxRetries,
-			&processorID, &processingStartedAt, &heartbeatAt, &timeoutAt,
-			&ollamaParamsJSON, &task.EstimatedDuration, &actualDuration, &userRating,
-		)
-	})
+	err := db.QueuedQueryRow(ctx, query, id).Scan(
+		&task.ID, &task.UserID, &task.ProductData, &task.Status,
+		&result, &errorMessage, &task.CreatedAt, &task.UpdatedAt,
+		&completedAt, &task.Priority, &task.RetryCount, &task.MaxRetries,
+		&processorID, &processingStartedAt, &heartbeatAt, &timeoutAt,
+		&ollamaParamsJSON, &task.EstimatedDuration, &actualDuration, &userRating,
+		&traceParent,
+	)
 
 	if err != nil {
 		return nil, err
@@ -132,6 +101,9 @@ func (db *DB) GetTask(id string) (*Task, error) {
 	if userRating.Valid {
 		task.UserRating = &userRating.String
 	}
+	if traceParent.Valid {
+		task.TraceParent = &traceParent.String
+	}
 
 	// Parse ollama params
 	if ollamaParamsJSON.Valid && ollamaParamsJSON.String != "" {
@@ -141,34 +113,59 @@ func (db *DB) GetTask(id string) (*Task, error) {
 	return &task, nil
 }
 
-func (db *DB) UpdateTaskStatus(id, status string, result, errorMessage *string) error {
-	return retryOnBusy(3, func() error {
-		query := `
-			UPDATE tasks 
-			SET status = ?, updated_at = ?, result = ?, error_message = ?,
-				completed_at = CASE WHEN ? IN ('completed', 'failed') THEN ? ELSE completed_at END
-			WHERE id = ?
-		`
+func (db *DB) UpdateTaskStatus(ctx context.Context, id, status string, result, errorMessage *string) error {
+	query := `
+		UPDATE tasks 
+		SET status = ?, updated_at = ?, result = ?, error_message = ?,
+			completed_at = CASE WHEN ? IN ('completed', 'failed') THEN ? ELSE completed_at END
+		WHERE id = ?
+	`
 
-		now := time.Now().UnixMilli()
-		_, err := db.QueuedExecWithWriteLock(query, status, now, result, errorMessage, status, now, id)
-		return err
+	now := time.Now().UnixMilli()
+	return db.QueuedTransaction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, query, status, now, result, errorMessage, status, now, id); err != nil {
+			return err
+		}
+		return recordUsageLedger(ctx, tx, id)
 	})
 }
 
-func (db *DB) GetPendingTasks(limit int) ([]*Task, error) {
-	// log.Printf("Fetching up to %d pending tasks", limit)
+// pendingTaskColumns are the columns of tasks waiting in the queue, read by scanPendingTask
+const pendingTaskColumns = `id, user_id, product_data, status, created_at, updated_at,
+	priority, max_retries, estimated_duration, ollama_params, error_message, trace_parent`
+
+func scanPendingTask(rows rowScanner) (*Task, error) {
+	var task Task
+	var ollamaParamsJSON sql.NullString
+
+	err := rows.Scan(
+		&task.ID, &task.UserID, &task.ProductData, &task.Status,
+		&task.CreatedAt, &task.UpdatedAt, &task.Priority, &task.MaxRetries,
+		&task.EstimatedDuration, &ollamaParamsJSON, &task.ErrorMessage,
+		&task.TraceParent,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse ollama params
+	if ollamaParamsJSON.Valid && ollamaParamsJSON.String != "" {
+		task.OllamaParams = &ollamaParamsJSON.String
+	}
+
+	return &task, nil
+}
 
+func (db *DB) GetPendingTasks(ctx context.Context, limit int) ([]*Task, error) {
 	query := `
-		SELECT id, user_id, product_data, status, created_at, updated_at,
-			   priority, max_retries, estimated_duration, ollama_params, error_message
+		SELECT ` + pendingTaskColumns + `
 		FROM tasks 
 		WHERE status = 'pending' 
 		ORDER BY priority DESC, created_at ASC 
 		LIMIT ?
 	`
 
-	rows, err := db.QueuedQuery(query, limit)
+	rows, err := db.QueuedQuery(ctx, query, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -176,154 +173,59 @@ func (db *DB) GetPendingTasks(limit int) ([]*Task, error) {
 
 	var tasks []*Task
 	for rows.Next() {
-		var task Task
-		var ollamaParamsJSON sql.NullString
-
-		err := rows.Scan(
-			&task.ID, &task.UserID, &task.ProductData, &task.Status,
-			&task.CreatedAt, &task.UpdatedAt, &task.Priority, &task.MaxRetries,
-			&task.EstimatedDuration, &ollamaParamsJSON, &task.ErrorMessage,
-		)
+		task, err := scanPendingTask(rows)
 		if err != nil {
 			return nil, err
 		}
-
-		// Parse ollama params
-		if ollamaParamsJSON.Valid && ollamaParamsJSON.String != "" {
-			task.OllamaParams = &ollamaParamsJSON.String
-		}
-
-		tasks = append(tasks, &task)
+		tasks = append(tasks, task)
 	}
 
-	// log.Printf("Fetched %d pending tasks", len(tasks))
-
 	return tasks, rows.Err()
 }
 
-func (db *DB) GetAllTasks(userID *string, limit, offset int) ([]*Task, error) {
-	var query string
-	var args []interface{}
-
-	if userID != nil {
-		query = `
-			SELECT id, user_id, product_data, status, result, error_message,
-				   created_at, updated_at, completed_at, priority, retry_count,
-				   max_retries, processor_id, processing_started_at, heartbeat_at,
-				   timeout_at, ollama_params, estimated_duration, actual_duration, rating
-			FROM tasks 
-			WHERE user_id = ?
-			ORDER BY created_at DESC 
-			LIMIT ? OFFSET ?
-		`
-		args = []interface{}{*userID, limit, offset}
-	} else {
-		query = `
-			SELECT id, user_id, product_data, status, result, error_message,
-				   created_at, updated_at, completed_at, priority, retry_count,
-				   max_retries, processor_id, processing_started_at, heartbeat_at,
-				   timeout_at, ollama_params, estimated_duration, actual_duration, rating
-			FROM tasks 
-			ORDER BY created_at DESC 
-			LIMIT ? OFFSET ?
-		`
-		args = []interface{}{limit, offset}
-	}
+// TaskCount is the number of tasks with the given status and model
+type TaskCount struct {
+	Status string
+	Model  string
+	Count  int
+}
 
-	rows, err := db.Query(query, args...)
+// CountTasksByStatusAndModel counts tasks grouped by status and ollama_params.model
+func (db *DB) CountTasksByStatusAndModel(ctx context.Context) ([]*TaskCount, error) {
+	rows, err := db.QueuedQuery(ctx, `
+		SELECT status,
+			COALESCE(CASE WHEN json_valid(ollama_params) THEN json_extract(ollama_params, '$.model') END, '') AS model,
+			COUNT(*)
+		FROM tasks
+		GROUP BY status, model
+	`)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var tasks []*Task
+	var counts []*TaskCount
 	for rows.Next() {
-		task, err := scanTask(rows)
-		if err != nil {
+		var c TaskCount
+		if err := rows.Scan(&c.Status, &c.Model, &c.Count); err != nil {
 			return nil, err
 		}
-		tasks = append(tasks, task)
+		counts = append(counts, &c)
 	}
 
-	return tasks, rows.Err()
-}
-
-// Rate limiting operations
-
-func (db *DB) CheckRateLimit(userID string, windowMs int64, maxRequests int) (*RateLimit, error) {
-	var rl RateLimit
-
-	err := retryOnBusy(5, func() error { // Reduced retries since we have queue now
-		now := time.Now().UnixMilli()
-		windowStart := now - windowMs
-
-		// Try to get existing rate limit
-		query := `
-			SELECT user_id, request_count, window_start, last_request
-			FROM rate_limits 
-			WHERE user_id = ?
-		`
-
-		err := db.QueuedQueryRow(query, userID).Scan(
-			&rl.UserID, &rl.RequestCount, &rl.WindowStart, &rl.LastRequest,
-		)
-
-		if err == sql.ErrNoRows {
-			// First request for this user - use UPSERT
-			rl = RateLimit{
-				UserID:       userID,
-				RequestCount: 1,
-				WindowStart:  now,
-				LastRequest:  now,
-			}
-		} else if err != nil {
-			return fmt.Errorf("failed to query rate limit for user %s: %w", userID, err)
-		} else {
-			// Existing user - check if window has expired
-			if rl.WindowStart < windowStart {
-				// Reset window
-				rl.RequestCount = 1
-				rl.WindowStart = now
-				rl.LastRequest = now
-			} else {
-				// Increment count
-				rl.RequestCount++
-				rl.LastRequest = now
-			}
-		}
-
-		// Use UPSERT to handle concurrent requests safely - with write lock for safety
-		upsertQuery := `
-			INSERT INTO rate_limits (user_id, request_count, window_start, last_request)
-			VALUES (?, ?, ?, ?)
-			ON CONFLICT(user_id) DO UPDATE SET
-				request_count = excluded.request_count,
-				window_start = excluded.window_start,
-				last_request = excluded.last_request
-		`
-		_, err = db.QueuedExecWithWriteLock(upsertQuery, rl.UserID, rl.RequestCount, rl.WindowStart, rl.LastRequest)
-		if err != nil {
-			return fmt.Errorf("failed to upsert rate limit for user %s: %w", userID, err)
-		}
-
-		return nil
-	})
-
-	return &rl, err
+	return counts, rows.Err()
 }
 
 // CheckUserActiveTask checks if user has any active (pending or processing) tasks
-func (db *DB) CheckUserActiveTask(userID string) (bool, error) {
+func (db *DB) CheckUserActiveTask(ctx context.Context, userID string) (bool, error) {
 	var count int
-	err := retryOnBusy(3, func() error {
-		query := `
-			SELECT COUNT(*) 
-			FROM tasks 
-			WHERE user_id = ? AND status IN ('pending', 'processing')
-		`
+	query := `
+		SELECT COUNT(*) 
+		FROM tasks 
+		WHERE user_id = ? AND status IN ('pending', 'processing')
+	`
 
-		return db.QueuedQueryRow(query, userID).Scan(&count)
-	})
+	err := db.QueuedQueryRow(ctx, query, userID).Scan(&count)
 
 	if err != nil {
 		return false, err
@@ -333,33 +235,31 @@ func (db *DB) CheckUserActiveTask(userID string) (bool, error) {
 }
 
 // GetUserLatestTask gets the latest task for a user (most recent by created_at)
-func (db *DB) GetUserLatestTask(userID string) (*Task, error) {
+func (db *DB) GetUserLatestTask(ctx context.Context, userID string) (*Task, error) {
 	var task Task
 	var ollamaParamsJSON sql.NullString
 	var completedAt, processingStartedAt, heartbeatAt, timeoutAt sql.NullInt64
 	var result, errorMessage, processorID, userRating sql.NullString
 	var actualDuration sql.NullInt64
 
-	err := retryOnBusy(3, func() error {
-		query := `
-			SELECT id, user_id, product_data, status, result, error_message,
-				   created_at, updated_at, completed_at, priority, retry_count,
-				   max_retries, processor_id, processing_started_at, heartbeat_at,
-				   timeout_at, ollama_params, estimated_duration, actual_duration, rating
-			FROM tasks 
-			WHERE user_id = ? 
-			ORDER BY created_at DESC 
-			LIMIT 1
-		`
+	query := `
+		SELECT id, user_id, product_data, status, result, error_message,
+			   created_at, updated_at, completed_at, priority, retry_count,
+			   max_retries, processor_id, processing_started_at, heartbeat_at,
+			   timeout_at, ollama_params, estimated_duration, actual_duration, rating
+		FROM tasks 
+		WHERE user_id = ? 
+		ORDER BY created_at DESC 
+		LIMIT 1
+	`
 
-		return db.QueuedQueryRow(query, userID).Scan(
-			&task.ID, &task.UserID, &task.ProductData, &task.Status,
-			&result, &errorMessage, &task.CreatedAt, &task.UpdatedAt,
-			&completedAt, &task.Priority, &task.RetryCount, &task.MaxRetries,
-			&processorID, &processingStartedAt, &heartbeatAt, &timeoutAt,
-			&ollamaParamsJSON, &task.EstimatedDuration, &actualDuration, &userRating,
-		)
-	})
+	err := db.QueuedQueryRow(ctx, query, userID).Scan(
+		&task.ID, &task.UserID, &task.ProductData, &task.Status,
+		&result, &errorMessage, &task.CreatedAt, &task.UpdatedAt,
+		&completedAt, &task.Priority, &task.RetryCount, &task.MaxRetries,
+		&processorID, &processingStartedAt, &heartbeatAt, &timeoutAt,
+		&ollamaParamsJSON, &task.EstimatedDuration, &actualDuration, &userRating,
+	)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -405,40 +305,8 @@ func (db *DB) GetUserLatestTask(userID string) (*Task, error) {
 	return &task, nil
 }
 
-// GetUserRateLimit gets current rate limit data for a user
-func (db *DB) GetUserRateLimit(userID string) (*RateLimit, error) {
-	var rl RateLimit
-
-	err := retryOnBusy(3, func() error {
-		query := `
-			SELECT user_id, request_count, window_start, last_request
-			FROM rate_limits 
-			WHERE user_id = ?
-		`
-
-		return db.QueuedQueryRow(query, userID).Scan(
-			&rl.UserID, &rl.RequestCount, &rl.WindowStart, &rl.LastRequest,
-		)
-	})
-
-	if err != nil {
-		if err == sql.ErrNoRows {
-			// Return empty rate limit if not found
-			return &RateLimit{
-				UserID:       userID,
-				RequestCount: 0,
-				WindowStart:  0,
-				LastRequest:  0,
-			}, nil
-		}
-		return nil, err
-	}
-
-	return &rl, nil
-}
-
 // Helper function to scan task from rows
-func scanTask(rows *sql.Rows) (*Task, error) {
+func scanTask(rows rowScanner) (*Task, error) {
 	var task Task
 	var ollamaParamsJSON sql.NullString
 	var completedAt, processingStartedAt, heartbeatAt, timeoutAt sql.NullInt64
@@ -494,86 +362,145 @@ func scanTask(rows *sql.Rows) (*Task, error) {
 	return &task, nil
 }
 
-func (db *DB) RequeueTask(taskID, processorID string, reason *string) error {
-	return retryOnBusy(3, func() error {
-		var query string
-		var args []interface{}
-		if reason != nil {
-			query = `
-				UPDATE tasks
-				SET status = 'pending',
-					processor_id = NULL,
-					heartbeat_at = NULL,
-					processing_started_at = NULL,
-					timeout_at = NULL,
-					retry_count = retry_count + 1,
-					error_message = ?
-				WHERE id = ? AND processor_id = ? AND status = 'processing'
-			`
-			args = []interface{}{*reason, taskID, processorID}
-		} else {
-			query = `
-				UPDATE tasks
-				SET status = 'pending',
-					processor_id = NULL,
-					heartbeat_at = NULL,
-					processing_started_at = NULL,
-					timeout_at = NULL,
-					retry_count = retry_count + 1
-				WHERE id = ? AND processor_id = ? AND status = 'processing'
-			`
-			args = []interface{}{taskID, processorID}
-		}
-		_, err := db.QueuedExecWithWriteLock(query, args...)
-		return err
-	})
+// RequeueTask returns a processing task to the pending pool.
+// Returns false if the task is no longer processing on that processor.
+func (db *DB) RequeueTask(ctx context.Context, taskID, processorID string, reason *string) (bool, error) {
+	var query string
+	var args []interface{}
+	if reason != nil {
+		query = `
+			UPDATE tasks
+			SET status = 'pending',
+				processor_id = NULL,
+				heartbeat_at = NULL,
+				processing_started_at = NULL,
+				timeout_at = NULL,
+				retry_count = retry_count + 1,
+				error_message = ?
+			WHERE id = ? AND processor_id = ? AND status = 'processing'
+		`
+		args = []interface{}{*reason, taskID, processorID}
+	} else {
+		query = `
+			UPDATE tasks
+			SET status = 'pending',
+				processor_id = NULL,
+				heartbeat_at = NULL,
+				processing_started_at = NULL,
+				timeout_at = NULL,
+				retry_count = retry_count + 1
+			WHERE id = ? AND processor_id = ? AND status = 'processing'
+		`
+		args = []interface{}{taskID, processorID}
+	}
+	result, err := db.QueuedExec(ctx, query, args...)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected > 0, nil
 }
 
-// UpdateTaskRating updates the rating for a task
-func (db *DB) UpdateTaskRating(taskID, userID string, rating *string) error {
-	return retryOnBusy(3, func() error {
-		// First, check if task exists, belongs to user, and is completed
-		var task Task
-		query := `
-			SELECT id, user_id, status 
-			FROM tasks 
-			WHERE id = ? AND user_id = ?
-		`
+// GetProcessingTasksByProcessor returns tasks currently held by a processor
+func (db *DB) GetProcessingTasksByProcessor(ctx context.Context, processorID string) ([]*Task, error) {
+	query := `
+		SELECT id, user_id, product_data, status, result, error_message,
+			   created_at, updated_at, completed_at, priority, retry_count,
+			   max_retries, processor_id, processing_started_at, heartbeat_at,
+			   timeout_at, ollama_params, estimated_duration, actual_duration, rating
+		FROM tasks
+		WHERE processor_id = ? AND status = 'processing'
+		ORDER BY processing_started_at ASC
+	`
 
-		err := db.QueuedQueryRow(query, taskID, userID).Scan(
-			&task.ID, &task.UserID, &task.Status,
-		)
+	rows, err := db.QueuedQuery(ctx, query, processorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tasks []*Task
+	for rows.Next() {
+		task, err := scanTask(rows)
 		if err != nil {
-			if err == sql.ErrNoRows {
-				return fmt.Errorf("task not found or not owned by user")
-			}
-			return fmt.Errorf("failed to get task: %w", err)
+			return nil, err
 		}
+		tasks = append(tasks, task)
+	}
 
-		// Check if task is completed
-		if task.Status != "completed" {
-			return fmt.Errorf("task must be completed to rate it")
-		}
+	return tasks, rows.Err()
+}
 
-		// Update the rating
-		updateQuery := `
-			UPDATE tasks 
-			SET rating = ?, updated_at = ?
-			WHERE id = ? AND user_id = ?
-		`
+// FailProcessingTask marks a task held by processorID as failed.
+// Returns false if the task is no longer processing on that processor.
+func (db *DB) FailProcessingTask(ctx context.Context, taskID, processorID, errorMessage string) (bool, error) {
+	var failed bool
 
-		now := time.Now().UnixMilli()
-		_, err = db.QueuedExec(updateQuery, rating, now, taskID, userID)
+	query := `
+		UPDATE tasks SET status = 'failed', error_message = ?, completed_at = ?, updated_at = ?
+		WHERE id = ? AND processor_id = ? AND status = 'processing'
+	`
+
+	now := time.Now().UnixMilli()
+	failed = false
+	err := db.QueuedTransaction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, query, errorMessage, now, now, taskID, processorID)
 		if err != nil {
-			return fmt.Errorf("failed to update task rating: %w", err)
+			return err
 		}
-
-		return nil
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			return nil
+		}
+		failed = true
+		return recordUsageLedger(ctx, tx, taskID)
 	})
+
+	return failed, err
+}
+
+// UpdateTaskRating updates the rating for a task
+func (db *DB) UpdateTaskRating(ctx context.Context, taskID, userID string, rating *string) error {
+	// First, check if task exists, belongs to user, and is completed
+	var task Task
+	query := `
+		SELECT id, user_id, status 
+		FROM tasks 
+		WHERE id = ? AND user_id = ?
+	`
+
+	err := db.QueuedQueryRow(ctx, query, taskID, userID).Scan(
+		&task.ID, &task.UserID, &task.Status,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("task not found or not owned by user")
+		}
+		return fmt.Errorf("failed to get task: %w", err)
+	}
+
+	// Check if task is completed
+	if task.Status != "completed" {
+		return fmt.Errorf("task must be completed to rate it")
+	}
+
+	// Update the rating
+	updateQuery := `
+		UPDATE tasks 
+		SET rating = ?, updated_at = ?
+		WHERE id = ? AND user_id = ?
+	`
+
+	now := time.Now().UnixMilli()
+	_, err = db.QueuedExec(ctx, updateQuery, rating, now, taskID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update task rating: %w", err)
+	}
+
+	return nil
 }
 
 // GetTasksRatingStats gets rating statistics for tasks
-func (db *DB) GetTasksRatingStats(userID *string) (map[string]int, error) {
+func (db *DB) GetTasksRatingStats(ctx context.Context, userID *string) (map[string]int, error) {
 	var query string
 	var args []interface{}
 
@@ -595,7 +522,7 @@ func (db *DB) GetTasksRatingStats(userID *string) (map[string]int, error) {
 		args = []interface{}{}
 	}
 
-	rows, err := db.Query(query, args...)
+	rows, err := db.QueuedQuery(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -615,7 +542,7 @@ func (db *DB) GetTasksRatingStats(userID *string) (map[string]int, error) {
 }
 
 // GetUserRatedTasks gets tasks with user ratings
-func (db *DB) GetUserRatedTasks(userID string, rating *string, limit, offset int) ([]*Task, error) {
+func (db *DB) GetUserRatedTasks(ctx context.Context, userID string, rating *string, limit, offset int) ([]*Task, error) {
 	var query string
 	var args []interface{}
 
@@ -645,7 +572,7 @@ func (db *DB) GetUserRatedTasks(userID string, rating *string, limit, offset int
 		args = []interface{}{userID, limit, offset}
 	}
 
-	rows, err := db.Query(query, args...)
+	rows, err := db.QueuedQuery(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -664,20 +591,19 @@ func (db *DB) GetUserRatedTasks(userID string, rating *string, limit, offset int
 }
 
 // GetRatingStatsByPeriod gets rating statistics grouped by time period
-func (db *DB) GetRatingStatsByPeriod(period string, count int) ([]map[string]interface{}, error) {
+func (db *DB) GetRatingStatsByPeriod(ctx context.Context, period string, count int) ([]map[string]interface{}, error) {
 	var results []map[string]interface{}
 
 	// Define time format and grouping based on period
-	var timeFormat, groupBy string
-	switch period {
-	case "hour":
-		timeFormat = "%Y-%m-%d %H"
-		groupBy = "datetime(completed_at/1000, 'unixepoch', 'localtime', 'start of hour')"
-	case "day":
-		timeFormat = "%Y-%m-%d"
-		groupBy = "date(completed_at/1000, 'unixepoch', 'localtime')"
-	default:
-		return results, fmt.Errorf("unsupported period: %s", period)
+	groupBy, timeFormat, err := periodStart(period, "completed_at", true)
+	if err != nil {
+		return results, err
+	}
+
+	// Окно "последние count периодов" в модификаторах SQLite (недель в них нет)
+	span := fmt.Sprintf("-%d %s", count, period)
+	if period == PeriodWeek {
+		span = fmt.Sprintf("-%d days", count*7)
 	}
 
 	query := fmt.Sprintf(`
@@ -690,7 +616,7 @@ func (db *DB) GetRatingStatsByPeriod(period string, count int) ([]map[string]int
 			FROM tasks 
 			WHERE status = 'completed' 
 			  AND completed_at IS NOT NULL
-			  AND %s >= datetime('now', '-%d %s', 'localtime')
+			  AND %s >= datetime('now', '%s', 'localtime')
 			GROUP BY %s
 			ORDER BY period_start DESC
 			LIMIT ?
@@ -698,9 +624,9 @@ func (db *DB) GetRatingStatsByPeriod(period string, count int) ([]map[string]int
 		SELECT period_label, upvotes, downvotes, total_rated
 		FROM periods 
 		ORDER BY period_start ASC
-	`, groupBy, timeFormat, groupBy, groupBy, count, period, groupBy)
+	`, groupBy, timeFormat, groupBy, groupBy, span, groupBy)
 
-	rows, err := db.Query(query, count)
+	rows, err := db.QueuedQuery(ctx, query, count)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get rating stats by period: %w", err)
 	}
@@ -733,7 +659,7 @@ func (db *DB) GetRatingStatsByPeriod(period string, count int) ([]map[string]int
 }
 
 // GetRecentRatedTasks gets the most recently rated tasks
-func (db *DB) GetRecentRatedTasks(limit int) ([]*Task, error) {
+func (db *DB) GetRecentRatedTasks(ctx context.Context, limit int) ([]*Task, error) {
 	query := `
 		SELECT id, user_id, product_data, status, result, error_message,
 			   created_at, updated_at, completed_at, priority, retry_count,
@@ -745,7 +671,7 @@ func (db *DB) GetRecentRatedTasks(limit int) ([]*Task, error) {
 		LIMIT ?
 	`
 
-	rows, err := db.Query(query, limit)
+	rows, err := db.QueuedQuery(ctx, query, limit)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get recent rated tasks: %w", err)
 	}
@@ -5,13 +5,14 @@ import (
 )
 
 func setupTestDB(t *testing.T) *DB {
+	ctx := t.Context()
 	db, err := NewSQLiteDB("file:memdb_rating?mode=memory&cache=shared")
 	if err != nil {
 		t.Fatalf("failed to create test db: %v", err)
 	}
 
 	// Run migrations to set up the database schema including rating column
-	err = db.RunMigrations()
+	err = db.RunMigrations(ctx)
 	if err != nil {
 		t.Fatalf("failed to run migrations: %v", err)
 	}
@@ -20,6 +21,7 @@ func setupTestDB(t *testing.T) *DB {
 }
 
 func TestUpdateTaskRating(t *testing.T) {
+	ctx := t.Context()
 	db := setupTestDB(t)
 	defer db.Close()
 
@@ -34,20 +36,20 @@ func TestUpdateTaskRating(t *testing.T) {
 		MaxRetries:  3,
 	}
 
-	err := db.CreateTask(task)
+	err := db.CreateTask(ctx, task)
 	if err != nil {
 		t.Fatalf("Failed to create task: %v", err)
 	}
 
 	// Test upvote
 	upvote := "upvote"
-	err = db.UpdateTaskRating(task.ID, userID, &upvote)
+	err = db.UpdateTaskRating(ctx, task.ID, userID, &upvote)
 	if err != nil {
 		t.Fatalf("Failed to update task rating: %v", err)
 	}
 
 	// Verify rating was set
-	updatedTask, err := db.GetTask(task.ID)
+	updatedTask, err := db.GetTask(ctx, task.ID)
 	if err != nil {
 		t.Fatalf("Failed to get updated task: %v", err)
 	}
@@ -57,12 +59,12 @@ func TestUpdateTaskRating(t *testing.T) {
 
 	// Test downvote (should replace upvote)
 	downvote := "downvote"
-	err = db.UpdateTaskRating(task.ID, userID, &downvote)
+	err = db.UpdateTaskRating(ctx, task.ID, userID, &downvote)
 	if err != nil {
 		t.Fatalf("Failed to update task rating to downvote: %v", err)
 	}
 
-	updatedTask, err = db.GetTask(task.ID)
+	updatedTask, err = db.GetTask(ctx, task.ID)
 	if err != nil {
 		t.Fatalf("Failed to get updated task: %v", err)
 	}
@@ -71,12 +73,12 @@ func TestUpdateTaskRating(t *testing.T) {
 	}
 
 	// Test removing rating
-	err = db.UpdateTaskRating(task.ID, userID, nil)
+	err = db.UpdateTaskRating(ctx, task.ID, userID, nil)
 	if err != nil {
 		t.Fatalf("Failed to remove task rating: %v", err)
 	}
 
-	updatedTask, err = db.GetTask(task.ID)
+	updatedTask, err = db.GetTask(ctx, task.ID)
 	if err != nil {
 		t.Fatalf("Failed to get updated task: %v", err)
 	}
@@ -86,6 +88,7 @@ func TestUpdateTaskRating(t *testing.T) {
 }
 
 func TestUpdateTaskRating_NotOwner(t *testing.T) {
+	ctx := t.Context()
 	db := setupTestDB(t)
 	defer db.Close()
 
@@ -99,20 +102,21 @@ func TestUpdateTaskRating_NotOwner(t *testing.T) {
 		MaxRetries:  3,
 	}
 
-	err := db.CreateTask(task)
+	err := db.CreateTask(ctx, task)
 	if err != nil {
 		t.Fatalf("Failed to create task: %v", err)
 	}
 
 	// Try to rate with different user
 	upvote := "upvote"
-	err = db.UpdateTaskRating(task.ID, "different_user", &upvote)
+	err = db.UpdateTaskRating(ctx, task.ID, "different_user", &upvote)
 	if err == nil {
 		t.Fatal("Expected error when non-owner tries to rate task")
 	}
 }
 
 func TestUpdateTaskRating_NotCompleted(t *testing.T) {
+	ctx := t.Context()
 	db := setupTestDB(t)
 	defer db.Close()
 
@@ -126,20 +130,21 @@ func TestUpdateTaskRating_NotCompleted(t *testing.T) {
 		MaxRetries:  3,
 	}
 
-	err := db.CreateTask(task)
+	err := db.CreateTask(ctx, task)
 	if err != nil {
 		t.Fatalf("Failed to create task: %v", err)
 	}
 
 	// Try to rate pending task
 	upvote := "upvote"
-	err = db.UpdateTaskRating(task.ID, "test_user", &upvote)
+	err = db.UpdateTaskRating(ctx, task.ID, "test_user", &upvote)
 	if err == nil {
 		t.Fatal("Expected error when trying to rate non-completed task")
 	}
 }
 
 func TestGetTasksRatingStats(t *testing.T) {
+	ctx := t.Context()
 	db := setupTestDB(t)
 	defer db.Close()
 
@@ -172,7 +177,7 @@ func TestGetTasksRatingStats(t *testing.T) {
 	}
 
 	for _, task := range tasks {
-		err := db.CreateTask(task)
+		err := db.CreateTask(ctx, task)
 		if err != nil {
 			t.Fatalf("Failed to create task %s: %v", task.ID, err)
 		}
@@ -182,23 +187,23 @@ func TestGetTasksRatingStats(t *testing.T) {
 	upvote := "upvote"
 	downvote := "downvote"
 
-	err := db.UpdateTaskRating("task_stats_1", "user1", &upvote)
+	err := db.UpdateTaskRating(ctx, "task_stats_1", "user1", &upvote)
 	if err != nil {
 		t.Fatalf("Failed to add upvote: %v", err)
 	}
 
-	err = db.UpdateTaskRating("task_stats_2", "user1", &downvote)
+	err = db.UpdateTaskRating(ctx, "task_stats_2", "user1", &downvote)
 	if err != nil {
 		t.Fatalf("Failed to add downvote: %v", err)
 	}
 
-	err = db.UpdateTaskRating("task_stats_3", "user2", &upvote)
+	err = db.UpdateTaskRating(ctx, "task_stats_3", "user2", &upvote)
 	if err != nil {
 		t.Fatalf("Failed to add upvote: %v", err)
 	}
 
 	// Test global stats
-	stats, err := db.GetTasksRatingStats(nil)
+	stats, err := db.GetTasksRatingStats(ctx, nil)
 	if err != nil {
 		t.Fatalf("Failed to get global rating stats: %v", err)
 	}
@@ -212,7 +217,7 @@ func TestGetTasksRatingStats(t *testing.T) {
 
 	// Test user-specific stats
 	user1 := "user1"
-	userStats, err := db.GetTasksRatingStats(&user1)
+	userStats, err := db.GetTasksRatingStats(ctx, &user1)
 	if err != nil {
 		t.Fatalf("Failed to get user rating stats: %v", err)
 	}
@@ -226,6 +231,7 @@ func TestGetTasksRatingStats(t *testing.T) {
 }
 
 func TestGetUserRatedTasks(t *testing.T) {
+	ctx := t.Context()
 	db := setupTestDB(t)
 	defer db.Close()
 
@@ -260,7 +266,7 @@ func TestGetUserRatedTasks(t *testing.T) {
 	}
 
 	for _, task := range tasks {
-		err := db.CreateTask(task)
+		err := db.CreateTask(ctx, task)
 		if err != nil {
 			t.Fatalf("Failed to create task %s: %v", task.ID, err)
 		}
@@ -270,18 +276,18 @@ func TestGetUserRatedTasks(t *testing.T) {
 	upvote := "upvote"
 	downvote := "downvote"
 
-	err := db.UpdateTaskRating("rated_task_1", userID, &upvote)
+	err := db.UpdateTaskRating(ctx, "rated_task_1", userID, &upvote)
 	if err != nil {
 		t.Fatalf("Failed to add upvote: %v", err)
 	}
 
-	err = db.UpdateTaskRating("rated_task_2", userID, &downvote)
+	err = db.UpdateTaskRating(ctx, "rated_task_2", userID, &downvote)
 	if err != nil {
 		t.Fatalf("Failed to add downvote: %v", err)
 	}
 
 	// Test getting all rated tasks
-	ratedTasks, err := db.GetUserRatedTasks(userID, nil, 100, 0)
+	ratedTasks, err := db.GetUserRatedTasks(ctx, userID, nil, 100, 0)
 	if err != nil {
 		t.Fatalf("Failed to get user rated tasks: %v", err)
 	}
@@ -291,7 +297,7 @@ func TestGetUserRatedTasks(t *testing.T) {
 	}
 
 	// Test getting only upvoted tasks
-	upvotedTasks, err := db.GetUserRatedTasks(userID, &upvote, 100, 0)
+	upvotedTasks, err := db.GetUserRatedTasks(ctx, userID, &upvote, 100, 0)
 	if err != nil {
 		t.Fatalf("Failed to get user upvoted tasks: %v", err)
 	}
@@ -305,7 +311,7 @@ func TestGetUserRatedTasks(t *testing.T) {
 	}
 
 	// Test getting only downvoted tasks
-	downvotedTasks, err := db.GetUserRatedTasks(userID, &downvote, 100, 0)
+	downvotedTasks, err := db.GetUserRatedTasks(ctx, userID, &downvote, 100, 0)
 	if err != nil {
 		t.Fatalf("Failed to get user downvoted tasks: %v", err)
 	}
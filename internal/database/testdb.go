@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"os"
 	"testing"
 )
@@ -17,7 +18,7 @@ func NewTestDB(t *testing.T) *DB {
 		os.Remove(tmpfile.Name())
 		t.Fatalf("failed to create test db: %v", err)
 	}
-	if err := db.RunMigrations(); err != nil {
+	if err := db.RunMigrations(context.Background()); err != nil {
 		db.Close()
 		os.Remove(tmpfile.Name())
 		t.Fatalf("failed to run migrations: %v", err)
@@ -0,0 +1,109 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Token subject types for token_cutoffs
+const (
+	TokenSubjectProcessor = "processor"
+	TokenSubjectUser      = "user"
+)
+
+// RevokeTokensBefore invalidates all tokens of the subject issued before the
+// current second. The cutoff is in unix ms but whole seconds, as iat is.
+func (db *DB) RevokeTokensBefore(ctx context.Context, subjectType, subjectID string) (int64, error) {
+	notBefore := time.Now().Truncate(time.Second).UnixMilli()
+
+	query := `
+		INSERT INTO token_cutoffs (subject_type, subject_id, not_before)
+		VALUES (?, ?, ?)
+		ON CONFLICT(subject_type, subject_id) DO UPDATE SET not_before = excluded.not_before
+	`
+	_, err := db.QueuedExec(ctx, query, subjectType, subjectID, notBefore)
+	return notBefore, err
+}
+
+// GetTokenCutoff returns the revocation cutoff (unix ms) for the subject, or 0 if none
+func (db *DB) GetTokenCutoff(ctx context.Context, subjectType, subjectID string) (int64, error) {
+	var notBefore int64
+
+	query := `SELECT not_before FROM token_cutoffs WHERE subject_type = ? AND subject_id = ?`
+	err := db.QueuedQueryRow(ctx, query, subjectType, subjectID).Scan(&notBefore)
+
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return notBefore, err
+}
+
+// ListTokenCutoffs returns all revocation cutoffs keyed by subject type and ID
+func (db *DB) ListTokenCutoffs(ctx context.Context) (map[string]map[string]int64, error) {
+	rows, err := db.QueuedQuery(ctx, `SELECT subject_type, subject_id, not_before FROM token_cutoffs`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cutoffs := make(map[string]map[string]int64)
+	for rows.Next() {
+		var subjectType, subjectID string
+		var notBefore int64
+		if err := rows.Scan(&subjectType, &subjectID, &notBefore); err != nil {
+			return nil, err
+		}
+		if cutoffs[subjectType] == nil {
+			cutoffs[subjectType] = make(map[string]int64)
+		}
+		cutoffs[subjectType][subjectID] = notBefore
+	}
+
+	return cutoffs, rows.Err()
+}
+
+// RevokeTokenID adds a token ID (jti) to the denylist until expiresAt (unix ms)
+func (db *DB) RevokeTokenID(ctx context.Context, jti string, expiresAt int64) error {
+	query := `
+		INSERT INTO revoked_tokens (jti, expires_at, revoked_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT(jti) DO UPDATE SET expires_at = MAX(expires_at, excluded.expires_at)
+	`
+	_, err := db.QueuedExec(ctx, query, jti, expiresAt, time.Now().UnixMilli())
+	return err
+}
+
+// ListRevokedTokenIDs returns revoked token IDs that have not expired yet, with their expiry (unix ms)
+func (db *DB) ListRevokedTokenIDs(ctx context.Context, now int64) (map[string]int64, error) {
+	rows, err := db.QueuedQuery(ctx, `SELECT jti, expires_at FROM revoked_tokens WHERE expires_at > ?`, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revoked := make(map[string]int64)
+	for rows.Next() {
+		var jti string
+		var expiresAt int64
+		if err := rows.Scan(&jti, &expiresAt); err != nil {
+			return nil, err
+		}
+		revoked[jti] = expiresAt
+	}
+
+	return revoked, rows.Err()
+}
+
+// PruneRevokedTokens removes denylist entries of tokens that expired before `before` (unix ms)
+func (db *DB) PruneRevokedTokens(ctx context.Context, before int64) (int64, error) {
+	ctx, cancel := db.maintenance(ctx)
+	defer cancel()
+
+	result, err := db.QueuedExec(ctx, `DELETE FROM revoked_tokens WHERE expires_at <= ?`, before)
+	if err != nil {
+		return 0, err
+	}
+	deleted, _ := result.RowsAffected()
+	return deleted, nil
+}
@@ -0,0 +1,209 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// usageDay returns the UTC day of a unix ms timestamp for daily_token_usage
+func usageDay(ms int64) string {
+	return time.UnixMilli(ms).UTC().Format(time.DateOnly)
+}
+
+// RecordTaskUsage stores the token usage of a task and adds it to the user and daily
+// totals. Usage is recorded once per task; repeated calls return false.
+func (db *DB) RecordTaskUsage(ctx context.Context, usage *TaskUsage) (bool, error) {
+	if usage.TotalTokens == 0 {
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
+	if usage.CreatedAt == 0 {
+		usage.CreatedAt = time.Now().UnixMilli()
+	}
+
+	var recorded bool
+	err := db.QueuedTransaction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, `
+			INSERT INTO task_usage (task_id, user_id, model, prompt_tokens, completion_tokens, total_tokens, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(task_id) DO NOTHING
+		`, usage.TaskID, usage.UserID, usage.Model, usage.PromptTokens, usage.CompletionTokens, usage.TotalTokens, usage.CreatedAt)
+		if err != nil {
+			return err
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return nil
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO user_token_usage (user_id, prompt_tokens, completion_tokens, total_tokens, task_count, updated_at)
+			VALUES (?, ?, ?, ?, 1, ?)
+			ON CONFLICT(user_id) DO UPDATE SET
+				prompt_tokens = prompt_tokens + excluded.prompt_tokens,
+				completion_tokens = completion_tokens + excluded.completion_tokens,
+				total_tokens = total_tokens + excluded.total_tokens,
+				task_count = task_count + 1,
+				updated_at = excluded.updated_at
+		`, usage.UserID, usage.PromptTokens, usage.CompletionTokens, usage.TotalTokens, usage.CreatedAt)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO daily_token_usage (user_id, day, model, prompt_tokens, completion_tokens, total_tokens, task_count)
+			VALUES (?, ?, ?, ?, ?, ?, 1)
+			ON CONFLICT(user_id, day, model) DO UPDATE SET
+				prompt_tokens = prompt_tokens + excluded.prompt_tokens,
+				completion_tokens = completion_tokens + excluded.completion_tokens,
+				total_tokens = total_tokens + excluded.total_tokens,
+				task_count = task_count + 1
+		`, usage.UserID, usageDay(usage.CreatedAt), usage.Model, usage.PromptTokens, usage.CompletionTokens, usage.TotalTokens)
+		if err != nil {
+			return err
+		}
+
+		recorded = true
+		// Токены приходят вместе с завершением задачи, обновляем её строку в учёте
+		return recordUsageLedger(ctx, tx, usage.TaskID)
+	})
+
+	return recorded, err
+}
+
+// GetTaskUsage returns the token usage of a task, or nil if none was reported
+func (db *DB) GetTaskUsage(ctx context.Context, taskID string) (*TaskUsage, error) {
+	var usage TaskUsage
+
+	err := db.QueuedQueryRow(ctx, `
+		SELECT task_id, user_id, model, prompt_tokens, completion_tokens, total_tokens, created_at
+		FROM task_usage WHERE task_id = ?
+	`, taskID).Scan(
+		&usage.TaskID, &usage.UserID, &usage.Model, &usage.PromptTokens,
+		&usage.CompletionTokens, &usage.TotalTokens, &usage.CreatedAt,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &usage, nil
+}
+
+// GetUserTokenUsage returns the all-time token usage of a user (zero if none)
+func (db *DB) GetUserTokenUsage(ctx context.Context, userID string) (*UserTokenUsage, error) {
+	usage := UserTokenUsage{UserID: userID}
+
+	err := db.QueuedQueryRow(ctx, `
+		SELECT prompt_tokens, completion_tokens, total_tokens, task_count, updated_at
+		FROM user_token_usage WHERE user_id = ?
+	`, userID).Scan(&usage.PromptTokens, &usage.CompletionTokens, &usage.TotalTokens, &usage.TaskCount, &usage.UpdatedAt)
+
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	return &usage, nil
+}
+
+// SumUserTokens returns the total tokens of the user's tasks reported after since (unix ms)
+func (db *DB) SumUserTokens(ctx context.Context, userID string, since int64) (int64, error) {
+	var total int64
+
+	err := db.QueuedQueryRow(ctx, `
+		SELECT COALESCE(SUM(total_tokens), 0) FROM task_usage
+		WHERE user_id = ? AND created_at > ?
+	`, userID, since).Scan(&total)
+
+	return total, err
+}
+
+// ListDailyTokenUsage returns the user's daily usage from the given day (YYYY-MM-DD), newest first
+func (db *DB) ListDailyTokenUsage(ctx context.Context, userID, fromDay string) ([]*DailyTokenUsage, error) {
+	rows, err := db.QueuedQuery(ctx, `
+		SELECT user_id, day, model, prompt_tokens, completion_tokens, total_tokens, task_count
+		FROM daily_token_usage
+		WHERE user_id = ? AND day >= ?
+		ORDER BY day DESC, model ASC
+	`, userID, fromDay)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var days []*DailyTokenUsage
+	for rows.Next() {
+		var d DailyTokenUsage
+		if err := rows.Scan(&d.UserID, &d.Day, &d.Model, &d.PromptTokens, &d.CompletionTokens, &d.TotalTokens, &d.TaskCount); err != nil {
+			return nil, err
+		}
+		days = append(days, &d)
+	}
+
+	return days, rows.Err()
+}
+
+// SetUserTokenLimit creates or replaces the token quota of a user
+func (db *DB) SetUserTokenLimit(ctx context.Context, limit *UserTokenLimit) error {
+	limit.UpdatedAt = time.Now().UnixMilli()
+
+	_, err := db.QueuedExec(ctx, `
+		INSERT INTO user_token_limits (user_id, max_tokens, window_ms, updated_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET
+			max_tokens = excluded.max_tokens,
+			window_ms = excluded.window_ms,
+			updated_at = excluded.updated_at
+	`, limit.UserID, limit.MaxTokens, limit.WindowMs, limit.UpdatedAt)
+	return err
+}
+
+// GetUserTokenLimit returns the token quota of a user, or nil if none is set
+func (db *DB) GetUserTokenLimit(ctx context.Context, userID string) (*UserTokenLimit, error) {
+	var limit UserTokenLimit
+
+	err := db.QueuedQueryRow(ctx, `
+		SELECT user_id, max_tokens, window_ms, updated_at FROM user_token_limits WHERE user_id = ?
+	`, userID).Scan(&limit.UserID, &limit.MaxTokens, &limit.WindowMs, &limit.UpdatedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &limit, nil
+}
+
+// ListUserTokenLimits returns all per-user token quotas
+func (db *DB) ListUserTokenLimits(ctx context.Context) ([]*UserTokenLimit, error) {
+	rows, err := db.QueuedQuery(ctx, `SELECT user_id, max_tokens, window_ms, updated_at FROM user_token_limits ORDER BY user_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var limits []*UserTokenLimit
+	for rows.Next() {
+		var limit UserTokenLimit
+		if err := rows.Scan(&limit.UserID, &limit.MaxTokens, &limit.WindowMs, &limit.UpdatedAt); err != nil {
+			return nil, err
+		}
+		limits = append(limits, &limit)
+	}
+
+	return limits, rows.Err()
+}
+
+// DeleteUserTokenLimit removes the token quota of a user. Returns false if none was set.
+func (db *DB) DeleteUserTokenLimit(ctx context.Context, userID string) (bool, error) {
+	result, err := db.QueuedExec(ctx, `DELETE FROM user_token_limits WHERE user_id = ?`, userID)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
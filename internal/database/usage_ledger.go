@@ -0,0 +1,208 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// usageLedgerSelect builds ledger rows from finished tasks and their reported token usage
+const usageLedgerSelect = `
+	SELECT t.id, t.user_id,
+		COALESCE(NULLIF(u.model, ''), CASE WHEN json_valid(t.ollama_params) THEN json_extract(t.ollama_params, '$.model') END, ''),
+		t.status, t.created_at, t.processing_started_at, COALESCE(t.completed_at, t.updated_at),
+		CASE WHEN t.processing_started_at IS NOT NULL THEN t.processing_started_at - t.created_at END,
+		CASE WHEN t.processing_started_at IS NOT NULL THEN COALESCE(t.completed_at, t.updated_at) - t.processing_started_at END,
+		t.retry_count, u.prompt_tokens, u.completion_tokens, u.total_tokens, ?
+	FROM tasks t LEFT JOIN task_usage u ON u.task_id = t.id
+`
+
+const usageLedgerColumns = `task_id, user_id, model, status, created_at, started_at, completed_at,
+	queue_wait_ms, processing_ms, retry_count, prompt_tokens, completion_tokens, total_tokens, recorded_at`
+
+// usageLedgerUpsert records (or refreshes) the ledger row of one finished task
+const usageLedgerUpsert = `INSERT INTO usage_ledger (` + usageLedgerColumns + `)` + usageLedgerSelect + `
+	WHERE t.id = ? AND t.status IN ('completed', 'failed')
+	ON CONFLICT(task_id) DO UPDATE SET
+		model = excluded.model,
+		status = excluded.status,
+		started_at = excluded.started_at,
+		completed_at = excluded.completed_at,
+		queue_wait_ms = excluded.queue_wait_ms,
+		processing_ms = excluded.processing_ms,
+		retry_count = excluded.retry_count,
+		prompt_tokens = excluded.prompt_tokens,
+		completion_tokens = excluded.completion_tokens,
+		total_tokens = excluded.total_tokens,
+		recorded_at = excluded.recorded_at
+`
+
+// usageLedgerBackfill adds finished tasks missing from the ledger
+const usageLedgerBackfill = `INSERT OR IGNORE INTO usage_ledger (` + usageLedgerColumns + `)` + usageLedgerSelect + `
+	WHERE t.status IN ('completed', 'failed')
+`
+
+// recordUsageLedger writes the ledger row of a task if it is completed or failed.
+// It runs in the transaction that finishes the task or records its usage.
+func recordUsageLedger(ctx context.Context, tx *sql.Tx, taskID string) error {
+	_, err := tx.ExecContext(ctx, usageLedgerUpsert, time.Now().UnixMilli(), taskID)
+	return err
+}
+
+// UsageLedgerEntry is the accounting record of a finished task. It is kept after the task is cleaned up.
+type UsageLedgerEntry struct {
+	TaskID           string `json:"task_id" db:"task_id"`
+	UserID           string `json:"user_id" db:"user_id"`
+	Model            string `json:"model" db:"model"`
+	Status           string `json:"status" db:"status"`
+	CreatedAt        int64  `json:"created_at" db:"created_at"`
+	StartedAt        *int64 `json:"started_at,omitempty" db:"started_at"`
+	CompletedAt      int64  `json:"completed_at" db:"completed_at"`
+	QueueWaitMs      *int64 `json:"queue_wait_ms,omitempty" db:"queue_wait_ms"`
+	ProcessingMs     *int64 `json:"processing_ms,omitempty" db:"processing_ms"`
+	RetryCount       int    `json:"retry_count" db:"retry_count"`
+	PromptTokens     *int64 `json:"prompt_tokens,omitempty" db:"prompt_tokens"`
+	CompletionTokens *int64 `json:"completion_tokens,omitempty" db:"completion_tokens"`
+	TotalTokens      *int64 `json:"total_tokens,omitempty" db:"total_tokens"`
+}
+
+// GetUsageLedgerEntry returns the ledger row of a task, or nil if the task is not finished
+func (db *DB) GetUsageLedgerEntry(ctx context.Context, taskID string) (*UsageLedgerEntry, error) {
+	var e UsageLedgerEntry
+
+	err := db.QueuedQueryRow(ctx, `
+		SELECT task_id, user_id, model, status, created_at, started_at, completed_at,
+			queue_wait_ms, processing_ms, retry_count, prompt_tokens, completion_tokens, total_tokens
+		FROM usage_ledger WHERE task_id = ?
+	`, taskID).Scan(
+		&e.TaskID, &e.UserID, &e.Model, &e.Status, &e.CreatedAt, &e.StartedAt, &e.CompletedAt,
+		&e.QueueWaitMs, &e.ProcessingMs, &e.RetryCount, &e.PromptTokens, &e.CompletionTokens, &e.TotalTokens,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &e, nil
+}
+
+// Dimensions a usage report can be grouped by besides the period
+const (
+	UsageGroupUser   = "user"
+	UsageGroupModel  = "model"
+	UsageGroupStatus = "status"
+)
+
+var usageGroupColumns = map[string]string{
+	UsageGroupUser:   "user_id",
+	UsageGroupModel:  "model",
+	UsageGroupStatus: "status",
+}
+
+// UsageReportFilter selects ledger rows finished in [From, To) (unix ms, UTC periods)
+type UsageReportFilter struct {
+	Period  string
+	From    int64
+	To      int64
+	UserID  string
+	GroupBy []string
+}
+
+// UsageReportRow is the usage of one period and group. Group fields are empty when not grouped by them.
+type UsageReportRow struct {
+	Period           string  `json:"period"`
+	UserID           string  `json:"user_id,omitempty"`
+	Model            string  `json:"model,omitempty"`
+	Status           string  `json:"status,omitempty"`
+	Tasks            int64   `json:"tasks"`
+	Completed        int64   `json:"completed"`
+	Failed           int64   `json:"failed"`
+	FailureRate      float64 `json:"failure_rate"` // доля failed, 0..1
+	ProcessingMs     int64   `json:"processing_ms"`
+	AvgProcessingMs  float64 `json:"avg_processing_ms"`
+	AvgQueueWaitMs   float64 `json:"avg_queue_wait_ms"`
+	TasksWithTokens  int64   `json:"tasks_with_tokens"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	TotalTokens      int64   `json:"total_tokens"`
+}
+
+// GetUsageReport aggregates the usage ledger by period and the requested dimensions
+func (db *DB) GetUsageReport(ctx context.Context, filter UsageReportFilter) ([]*UsageReportRow, error) {
+	start, labelFormat, err := periodStart(filter.Period, "completed_at", false)
+	if err != nil {
+		return nil, err
+	}
+
+	selectCols := []string{fmt.Sprintf("strftime('%s', %s) AS period", labelFormat, start)}
+	groupCols := []string{start}
+	for _, dim := range []string{UsageGroupUser, UsageGroupModel, UsageGroupStatus} {
+		column := "''"
+		for _, g := range filter.GroupBy {
+			if g == dim {
+				column = usageGroupColumns[dim]
+				groupCols = append(groupCols, column)
+				break
+			}
+		}
+		selectCols = append(selectCols, column)
+	}
+	for _, g := range filter.GroupBy {
+		if _, ok := usageGroupColumns[g]; !ok {
+			return nil, fmt.Errorf("unsupported group: %s", g)
+		}
+	}
+
+	where := []string{"completed_at >= ?", "completed_at < ?"}
+	args := []interface{}{filter.From, filter.To}
+	if filter.UserID != "" {
+		where = append(where, "user_id = ?")
+		args = append(args, filter.UserID)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT %s,
+			COUNT(*),
+			COALESCE(SUM(CASE WHEN status = 'completed' THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN status = 'failed' THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(processing_ms), 0),
+			COALESCE(AVG(processing_ms), 0),
+			COALESCE(AVG(queue_wait_ms), 0),
+			COUNT(total_tokens),
+			COALESCE(SUM(prompt_tokens), 0),
+			COALESCE(SUM(completion_tokens), 0),
+			COALESCE(SUM(total_tokens), 0)
+		FROM usage_ledger
+		WHERE %s
+		GROUP BY %s
+		ORDER BY %s
+	`, strings.Join(selectCols, ", "), strings.Join(where, " AND "), strings.Join(groupCols, ", "), strings.Join(groupCols, ", "))
+
+	rows, err := db.QueuedQuery(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get usage report: %w", err)
+	}
+	defer rows.Close()
+
+	var report []*UsageReportRow
+	for rows.Next() {
+		var r UsageReportRow
+		if err := rows.Scan(
+			&r.Period, &r.UserID, &r.Model, &r.Status,
+			&r.Tasks, &r.Completed, &r.Failed, &r.ProcessingMs, &r.AvgProcessingMs, &r.AvgQueueWaitMs,
+			&r.TasksWithTokens, &r.PromptTokens, &r.CompletionTokens, &r.TotalTokens,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan usage report: %w", err)
+		}
+		if r.Tasks > 0 {
+			r.FailureRate = float64(r.Failed) / float64(r.Tasks)
+		}
+		report = append(report, &r)
+	}
+
+	return report, rows.Err()
+}
@@ -0,0 +1,208 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/ad/go-llm-manager/internal/metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Writes that wait in the queue when a batch starts are committed together, up to
+// maxWriteBatch of them in one transaction
+const maxWriteBatch = 64
+
+// ErrClosed is returned for writes submitted after the database was closed
+var ErrClosed = errors.New("database is closed")
+
+// writeRequest is a write waiting for the writer
+type writeRequest struct {
+	ctx    context.Context
+	fn     func(context.Context, *sql.Tx) error
+	single bool // одна инструкция: SQLite сам откатывает ее при ошибке, savepoint не нужен
+	queued time.Time
+	done   chan error
+}
+
+// writer is the goroutine that owns the write connection. It takes all writes
+// waiting in the queue and runs them in one transaction, each multi-statement
+// write under its own savepoint, so heartbeats, metrics and status changes arriving
+// together cost one commit and a failing write is rolled back without affecting
+// the others.
+// Since nothing else writes, SQLite never returns BUSY to the writes of this process.
+type writer struct {
+	db       *sql.DB
+	maxBatch int
+	requests chan *writeRequest // без буфера: очередь - это отправители, ждущие writer
+	stop     chan struct{}
+	stopped  chan struct{}
+}
+
+func newWriter(db *sql.DB, maxBatch int) *writer {
+	w := &writer{
+		db:       db,
+		maxBatch: maxBatch,
+		requests: make(chan *writeRequest),
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// submit queues fn and waits for the result of its batch. It stops waiting in the
+// queue when ctx is done; a write that has already started is committed or rolled
+// back with its batch, and the result is what happened in the database.
+func (w *writer) submit(ctx context.Context, single bool, fn func(context.Context, *sql.Tx) error) error {
+	req := &writeRequest{ctx: ctx, fn: fn, single: single, queued: time.Now(), done: make(chan error, 1)}
+
+	select {
+	case w.requests <- req:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-w.stop:
+		return ErrClosed
+	}
+	return <-req.done
+}
+
+// close stops the writer once the running batch is committed
+func (w *writer) close() {
+	select {
+	case <-w.stop:
+	default:
+		close(w.stop)
+	}
+	<-w.stopped
+}
+
+func (w *writer) run() {
+	defer close(w.stopped)
+
+	for {
+		var batch []*writeRequest
+		select {
+		case req := <-w.requests:
+			batch = append(batch, req)
+		case <-w.stop:
+			return
+		}
+
+		// Даем клиентам, которых разбудил предыдущий батч, встать в очередь и
+		// забираем все, что в ней накопилось
+		runtime.Gosched()
+	collect:
+		for len(batch) < w.maxBatch {
+			select {
+			case req := <-w.requests:
+				batch = append(batch, req)
+			default:
+				break collect
+			}
+		}
+
+		w.execute(batch)
+	}
+}
+
+// execute runs the batch in one transaction and reports the result of every write
+func (w *writer) execute(batch []*writeRequest) {
+	start := time.Now()
+
+	// Запросы, которые отменили, пока они ждали в очереди, не выполняем
+	pending := make([]*writeRequest, 0, len(batch))
+	for _, req := range batch {
+		metrics.DBQueueWait.Observe(start.Sub(req.queued).Seconds(), "write")
+		if err := req.ctx.Err(); err != nil {
+			req.done <- err
+			continue
+		}
+		pending = append(pending, req)
+	}
+	if len(pending) == 0 {
+		return
+	}
+	metrics.DBWriteBatchSize.Observe(float64(len(pending)))
+	for _, req := range pending {
+		trace.SpanFromContext(req.ctx).AddEvent("batch started", trace.WithAttributes(attribute.Int("db.batch_size", len(pending))))
+	}
+
+	ctx, cancel := batchContext(pending)
+	defer cancel()
+
+	errs := make([]error, len(pending))
+	err := w.transaction(ctx, func(tx *sql.Tx) error {
+		for i, req := range pending {
+			if req.single {
+				errs[i] = runWrite(ctx, tx, req.fn)
+				continue
+			}
+
+			if _, err := tx.ExecContext(ctx, `SAVEPOINT batch_write`); err != nil {
+				return err
+			}
+			errs[i] = runWrite(ctx, tx, req.fn)
+			if errs[i] != nil {
+				if _, err := tx.ExecContext(ctx, `ROLLBACK TO batch_write`); err != nil {
+					return err
+				}
+			}
+			if _, err := tx.ExecContext(ctx, `RELEASE batch_write`); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	for i, req := range pending {
+		if errs[i] == nil {
+			errs[i] = err
+		}
+		req.done <- errs[i]
+	}
+}
+
+// transaction runs fn in a transaction on the write connection
+func (w *writer) transaction(ctx context.Context, fn func(*sql.Tx) error) error {
+	tx, err := w.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// runWrite runs one write of a batch; a panic fails only that write
+func runWrite(ctx context.Context, tx *sql.Tx, fn func(context.Context, *sql.Tx) error) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("database write panicked: %v", p)
+		}
+	}()
+	return fn(ctx, tx)
+}
+
+// batchContext returns the context of a batch: it ends at the latest deadline of
+// its writes, so a write with a short deadline does not interrupt the others.
+// Cancelling one statement would make SQLite roll back the whole transaction.
+func batchContext(batch []*writeRequest) (context.Context, context.CancelFunc) {
+	var latest time.Time
+	for _, req := range batch {
+		deadline, ok := req.ctx.Deadline()
+		if !ok {
+			return context.WithCancel(context.Background())
+		}
+		if deadline.After(latest) {
+			latest = deadline
+		}
+	}
+	return context.WithDeadline(context.Background(), latest)
+}
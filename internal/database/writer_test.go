@@ -0,0 +1,223 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ad/go-llm-manager/internal/metrics"
+)
+
+func TestWriterBatchIsolatesFailures(t *testing.T) {
+	ctx := t.Context()
+	db := NewTestDB(t)
+
+	// Пока writer занят, остальные записи копятся и уходят одним батчем
+	held := make(chan struct{})
+	release := make(chan struct{})
+	blocker := make(chan error, 1)
+	go func() {
+		blocker <- db.QueuedTransaction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+			close(held)
+			<-release
+			return nil
+		})
+	}()
+	<-held
+
+	errFailed := errors.New("write failed")
+	writes := []func(ctx context.Context, tx *sql.Tx) error{
+		insertProcessor("p1"),
+		func(ctx context.Context, tx *sql.Tx) error {
+			if err := insertProcessor("p2")(ctx, tx); err != nil {
+				return err
+			}
+			return errFailed
+		},
+		insertProcessor("p3"),
+		func(ctx context.Context, tx *sql.Tx) error {
+			panic("boom")
+		},
+		insertProcessor("p4"),
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(writes))
+	for i, fn := range writes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = db.QueuedTransaction(ctx, fn)
+		}()
+		time.Sleep(5 * time.Millisecond) // порядок в очереди
+	}
+	close(release)
+	wg.Wait()
+	if err := <-blocker; err != nil {
+		t.Fatalf("blocking write: %v", err)
+	}
+
+	if errs[0] != nil || errs[2] != nil || errs[4] != nil {
+		t.Fatalf("successful writes returned errors: %v", errs)
+	}
+	if !errors.Is(errs[1], errFailed) {
+		t.Errorf("failed write error = %v, want %v", errs[1], errFailed)
+	}
+	if errs[3] == nil {
+		t.Error("panicking write returned no error")
+	}
+
+	for id, want := range map[string]bool{"p1": true, "p2": false, "p3": true, "p4": true} {
+		p, err := db.GetProcessor(ctx, id)
+		if err != nil {
+			t.Fatalf("GetProcessor(%s): %v", id, err)
+		}
+		if got := p != nil; got != want {
+			t.Errorf("processor %s stored = %v, want %v", id, got, want)
+		}
+	}
+}
+
+func TestWriterClosed(t *testing.T) {
+	db, err := NewSQLiteDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	db.Close()
+
+	if _, err := db.QueuedExec(t.Context(), `PRAGMA user_version = 1`); !errors.Is(err, ErrClosed) {
+		t.Fatalf("QueuedExec after Close error = %v, want ErrClosed", err)
+	}
+}
+
+func insertProcessor(id string) func(ctx context.Context, tx *sql.Tx) error {
+	return func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `INSERT INTO processors (id, first_seen, last_seen, state) VALUES (?, 0, 0, 'online')`, id)
+		return err
+	}
+}
+
+// mutexQueue is the previous write path, kept for comparison: a shared pool of 5
+// connections, a 3-slot semaphore for all operations and a global mutex for the
+// critical writes. Heartbeats went through the semaphore only.
+type mutexQueue struct {
+	db        *sql.DB
+	mu        sync.Mutex
+	semaphore chan struct{}
+}
+
+func (q *mutexQueue) exec(ctx context.Context, query string, args ...interface{}) error {
+	q.semaphore <- struct{}{}
+	defer func() { <-q.semaphore }()
+	_, err := q.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (q *mutexQueue) execWithLock(ctx context.Context, query string, args ...interface{}) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.exec(ctx, query, args...)
+}
+
+const benchTasks = 100
+
+// BenchmarkConcurrentWrites compares the mix of heartbeats, metrics upserts and
+// status changes that processors produce, written through the previous request
+// queue and through the writer, by 32 concurrent clients per CPU.
+func BenchmarkConcurrentWrites(b *testing.B) {
+	heartbeat := `UPDATE tasks SET heartbeat_at = ?, updated_at = ? WHERE id = ?`
+	upsertMetrics := `
+		INSERT INTO processor_metrics (processor_id, cpu_usage, memory_usage, queue_size, active_tasks, last_updated, created_at)
+		VALUES (?, 0.5, 0.5, 1, 1, ?, ?)
+		ON CONFLICT(processor_id) DO UPDATE SET last_updated = excluded.last_updated
+	`
+	status := `UPDATE tasks SET status = 'processing', updated_at = ? WHERE id = ?`
+
+	b.Run("mutex_queue", func(b *testing.B) {
+		db := newBenchDB(b)
+		pool, err := openPool(db.path, 5)
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer pool.Close()
+		q := &mutexQueue{db: pool, semaphore: make(chan struct{}, 3)}
+
+		runWriteMix(b, func(ctx context.Context, i int64) error {
+			now := time.Now().UnixMilli()
+			taskID := fmt.Sprintf("task-%d", i%benchTasks)
+			switch i % 3 {
+			case 0:
+				return q.exec(ctx, heartbeat, now, now, taskID)
+			case 1:
+				return q.exec(ctx, upsertMetrics, fmt.Sprintf("proc-%d", i%10), now, now)
+			default:
+				return q.execWithLock(ctx, status, now, taskID)
+			}
+		})
+	})
+
+	b.Run("writer", func(b *testing.B) {
+		db := newBenchDB(b)
+		batches := metrics.DBWriteBatchSize.Count()
+
+		runWriteMix(b, func(ctx context.Context, i int64) error {
+			now := time.Now().UnixMilli()
+			taskID := fmt.Sprintf("task-%d", i%benchTasks)
+			var err error
+			switch i % 3 {
+			case 0:
+				_, err = db.QueuedExec(ctx, heartbeat, now, now, taskID)
+			case 1:
+				_, err = db.QueuedExec(ctx, upsertMetrics, fmt.Sprintf("proc-%d", i%10), now, now)
+			default:
+				_, err = db.QueuedExec(ctx, status, now, taskID)
+			}
+			return err
+		})
+		b.ReportMetric(float64(b.N)/float64(metrics.DBWriteBatchSize.Count()-batches), "writes/commit")
+	})
+}
+
+type benchDB struct {
+	*DB
+	path string
+}
+
+func newBenchDB(b *testing.B) *benchDB {
+	path := filepath.Join(b.TempDir(), "bench.db")
+	db, err := NewSQLiteDB(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { db.Close() })
+	if err := db.RunMigrations(b.Context()); err != nil {
+		b.Fatal(err)
+	}
+	for i := range benchTasks {
+		task := &Task{ID: fmt.Sprintf("task-%d", i), UserID: fmt.Sprintf("user-%d", i), ProductData: "{}", Status: TaskStatusPending}
+		if err := db.CreateTask(b.Context(), task); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return &benchDB{DB: db, path: path}
+}
+
+func runWriteMix(b *testing.B, write func(ctx context.Context, i int64) error) {
+	var n atomic.Int64
+	b.SetParallelism(32)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := write(b.Context(), n.Add(1)); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,142 @@
+// Package logging configures the slog logger of the manager: JSON or text output,
+// the level, standard field names and request correlation taken from the context.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/ad/go-llm-manager/internal/config"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Output formats supported by LOG_FORMAT
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// Standard field names. Use them (or the helpers below) instead of ad-hoc keys,
+// so logs can be filtered by task, processor or user across components.
+const (
+	KeyRequestID   = "request_id"
+	KeyTraceID     = "trace_id"
+	KeySpanID      = "span_id"
+	KeyTaskID      = "task_id"
+	KeyProcessorID = "processor_id"
+	KeyUserID      = "user_id"
+	KeyError       = "error"
+)
+
+// Redacted replaces the value of secret fields
+const Redacted = "[REDACTED]"
+
+func TaskID(id string) slog.Attr      { return slog.String(KeyTaskID, id) }
+func ProcessorID(id string) slog.Attr { return slog.String(KeyProcessorID, id) }
+func UserID(id string) slog.Attr      { return slog.String(KeyUserID, id) }
+func Err(err error) slog.Attr         { return slog.Any(KeyError, err) }
+
+// ParseLevel parses debug, info, warn or error. Empty means info.
+func ParseLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q", name)
+}
+
+// New returns a logger writing to w in the configured format and level.
+// Records get request_id and trace_id/span_id from the context, and attributes
+// with secret-looking names are redacted.
+func New(w io.Writer, cfg config.LoggingConfig) (*slog.Logger, error) {
+	level, err := ParseLevel(cfg.Level)
+	if err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: redactAttr}
+
+	var handler slog.Handler
+	switch strings.ToLower(cfg.Format) {
+	case "", FormatJSON:
+		handler = slog.NewJSONHandler(w, opts)
+	case FormatText:
+		handler = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("unknown log format %q", cfg.Format)
+	}
+
+	return slog.New(contextHandler{handler}), nil
+}
+
+// Setup installs New(w, cfg) as the default logger. Output of the standard log
+// package goes through it as well.
+func Setup(w io.Writer, cfg config.LoggingConfig) error {
+	logger, err := New(w, cfg)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns ctx carrying the request ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID from ctx, or "" if there is none
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// contextHandler adds the correlation fields of the context to every record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String(KeyRequestID, id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String(KeyTraceID, sc.TraceID().String()), slog.String(KeySpanID, sc.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// redactAttr hides values of attributes whose names look like credentials
+func redactAttr(_ []string, a slog.Attr) slog.Attr {
+	if a.Value.Kind() != slog.KindGroup && isSecretKey(a.Key) {
+		return slog.String(a.Key, Redacted)
+	}
+	return a
+}
+
+func isSecretKey(key string) bool {
+	key = strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(key))
+	switch key {
+	case "token", "password", "authorization", "apikey":
+		return true
+	}
+	return strings.HasSuffix(key, "secret") || strings.HasSuffix(key, "apikey") || strings.HasSuffix(key, "password")
+}
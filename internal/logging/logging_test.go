@@ -0,0 +1,82 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/ad/go-llm-manager/internal/config"
+	"github.com/ad/go-llm-manager/internal/tracing"
+)
+
+func TestJSONRecordHasContextFieldsAndRedacts(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, config.LoggingConfig{Level: "info", Format: "json"})
+	if err != nil {
+		t.Fatalf("new logger failed: %v", err)
+	}
+
+	ctx := WithRequestID(context.Background(), "req-1")
+	ctx = tracing.ContextWithTraceParent(ctx, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	cfg := &config.Config{Auth: config.AuthConfig{JWTSecret: "jwt-secret", InternalAPIKey: "internal-key"}}
+
+	logger.DebugContext(ctx, "hidden")
+	logger.InfoContext(ctx, "Task claimed", TaskID("t-1"), ProcessorID("p-1"), UserID("u-1"),
+		"api_key", "sk-live", Err(errors.New("boom")), "config", cfg)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected only the info record, got %d lines", len(lines))
+	}
+	var record map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("invalid JSON record: %v", err)
+	}
+
+	want := map[string]any{
+		KeyRequestID:   "req-1",
+		KeyTraceID:     "4bf92f3577b34da6a3ce929d0e0e4736",
+		KeySpanID:      "00f067aa0ba902b7",
+		KeyTaskID:      "t-1",
+		KeyProcessorID: "p-1",
+		KeyUserID:      "u-1",
+		KeyError:       "boom",
+		"api_key":      Redacted,
+	}
+	for key, value := range want {
+		if record[key] != value {
+			t.Errorf("%s: expected %v, got %v", key, value, record[key])
+		}
+	}
+
+	auth := record["config"].(map[string]any)["AUTH"].(map[string]any)
+	if auth["JWT_SECRET"] != Redacted || auth["INTERNAL_API_KEY"] != Redacted || auth["JWT_KEY_GRACE_PERIOD"] != "0s" {
+		t.Errorf("secrets not redacted in config: %v", auth)
+	}
+	if strings.Contains(buf.String(), "jwt-secret") || strings.Contains(buf.String(), "internal-key") {
+		t.Error("secret leaked into the log")
+	}
+}
+
+func TestNewRejectsUnknownSettings(t *testing.T) {
+	if _, err := New(&bytes.Buffer{}, config.LoggingConfig{Level: "loud"}); err == nil {
+		t.Error("expected error for unknown level")
+	}
+	if _, err := New(&bytes.Buffer{}, config.LoggingConfig{Format: "xml"}); err == nil {
+		t.Error("expected error for unknown format")
+	}
+
+	var buf bytes.Buffer
+	logger, err := New(&buf, config.LoggingConfig{Level: "debug", Format: "text"})
+	if err != nil {
+		t.Fatalf("new logger failed: %v", err)
+	}
+	logger.Debug("details", slog.String("token", "abc"))
+	if !strings.Contains(buf.String(), "level=DEBUG") || !strings.Contains(buf.String(), "token="+Redacted) {
+		t.Errorf("unexpected text output: %s", buf.String())
+	}
+}
@@ -0,0 +1,74 @@
+package metrics
+
+// Default is the registry served on /metrics
+var Default = NewRegistry()
+
+// HTTP
+var (
+	HTTPRequests = Default.NewCounterVec("llm_manager_http_requests_total",
+		"HTTP requests by route pattern, method and status code", "route", "method", "code")
+	HTTPRequestDuration = Default.NewHistogramVec("llm_manager_http_request_duration_seconds",
+		"HTTP request latency by route pattern and method", DefBuckets, "route", "method")
+)
+
+// Задачи: текущая очередь обновляется при каждом scrape, остальное считается по событиям
+var (
+	Tasks = Default.NewGaugeVec("llm_manager_tasks",
+		"Tasks in the database by status and model", "status", "model")
+	TaskQueueWait = Default.NewHistogramVec("llm_manager_task_queue_wait_seconds",
+		"Time from task creation to claim by a processor", LatencyBuckets)
+	TaskProcessing = Default.NewHistogramVec("llm_manager_task_processing_seconds",
+		"Time from claim to completion reported by the processor", LatencyBuckets, "status")
+	TaskClaims = Default.NewCounterVec("llm_manager_task_claims_total",
+		"Tasks claimed by processors")
+	TaskSteals = Default.NewCounterVec("llm_manager_task_steals_total",
+		"Tasks taken over by work-stealing")
+	TaskRequeues = Default.NewCounterVec("llm_manager_task_requeues_total",
+		"Tasks returned to the queue, by who requeued them (processor or manager)", "source")
+	TaskFailures = Default.NewCounterVec("llm_manager_task_failures_total",
+		"Tasks marked failed, by who failed them (processor or manager)", "source")
+	TasksArchived = Default.NewCounterVec("llm_manager_tasks_archived_total",
+		"Finished tasks moved from the database to the archive, by status", "status")
+)
+
+// Sources of requeues and failures
+const (
+	SourceProcessor = "processor"
+	SourceManager   = "manager"
+)
+
+// Процессоры, по данным последнего heartbeat
+var (
+	ProcessorActiveTasks = Default.NewGaugeVec("llm_manager_processor_active_tasks",
+		"Tasks processing on the processor", "processor_id")
+	ProcessorCPUUsage = Default.NewGaugeVec("llm_manager_processor_cpu_usage",
+		"CPU usage reported by the processor", "processor_id")
+	ProcessorMemoryUsage = Default.NewGaugeVec("llm_manager_processor_memory_usage",
+		"Memory usage reported by the processor", "processor_id")
+)
+
+// SSE
+var (
+	SSEClients = Default.NewGaugeVec("llm_manager_sse_clients",
+		"Open SSE connections by type (task or processor)", "type")
+	SSEDroppedEvents = Default.NewCounterVec("llm_manager_sse_dropped_events_total",
+		"SSE events dropped because the client buffer was full, by client type", "type")
+)
+
+// База данных: записи ждут очереди единственного writer, чтения - соединения из пула
+var (
+	DBQueueWait = Default.NewHistogramVec("llm_manager_db_queue_wait_seconds",
+		"Time a database operation waits by mode: read for a pool connection, write for the writer", DBWaitBuckets, "mode")
+	DBWriteBatchSize = Default.NewHistogramVec("llm_manager_db_write_batch_size",
+		"Writes committed in one transaction by the writer", BatchBuckets)
+)
+
+// Резервные копии БД
+var (
+	BackupDuration = Default.NewHistogramVec("llm_manager_backup_duration_seconds",
+		"Time to write a database snapshot, including compression and checksum", LatencyBuckets)
+	BackupFailures = Default.NewCounterVec("llm_manager_backup_failures_total",
+		"Database snapshots that failed")
+	BackupLastSuccess = Default.NewGaugeVec("llm_manager_backup_last_success_timestamp_seconds",
+		"Unix time of the last successful database snapshot")
+)
@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegistryTextFormat(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("http_requests_total", "Requests\nby code", "code")
+	claims := r.NewCounterVec("claims_total", "Claims")
+	queue := r.NewGaugeVec("queue", "Queue depth", "status", "model")
+	latency := r.NewHistogramVec("latency_seconds", "Latency", []float64{1, 0.1}, "route")
+
+	requests.Inc("200")
+	requests.Add(2, "500")
+	requests.Add(-1, "500")
+	queue.Set(3, "pending", `llama "3"`)
+	latency.Observe(0.05, "/a")
+	latency.Observe(0.5, "/a")
+	latency.Observe(5, "/a")
+
+	var b strings.Builder
+	if err := r.Write(&b); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	want := `# HELP http_requests_total Requests\nby code
+# TYPE http_requests_total counter
+http_requests_total{code="200"} 1
+http_requests_total{code="500"} 2
+# HELP claims_total Claims
+# TYPE claims_total counter
+claims_total 0
+# HELP queue Queue depth
+# TYPE queue gauge
+queue{status="pending",model="llama \"3\""} 3
+# HELP latency_seconds Latency
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/a",le="0.1"} 1
+latency_seconds_bucket{route="/a",le="1"} 2
+latency_seconds_bucket{route="/a",le="+Inf"} 3
+latency_seconds_sum{route="/a"} 5.55
+latency_seconds_count{route="/a"} 3
+`
+	if b.String() != want {
+		t.Errorf("unexpected output:\n%s\nwant:\n%s", b.String(), want)
+	}
+
+	claims.Inc()
+	queue.Reset()
+	if claims.Value() != 1 || queue.Value("pending", `llama "3"`) != 0 || latency.Count("/a") != 3 {
+		t.Errorf("unexpected values after update")
+	}
+}
+
+func TestLabelCountMismatchPanics(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("c", "c", "a", "b")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic on wrong label count")
+		}
+	}()
+	c.Inc("x")
+}
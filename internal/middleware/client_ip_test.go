@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	resolver, err := NewClientIPResolver([]string{"10.0.0.0/8", "192.168.1.1"})
+	if err != nil {
+		t.Fatalf("failed to create resolver: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		realIP     string
+		want       string
+	}{
+		{"direct client", "203.0.113.5:1234", "", "", "203.0.113.5"},
+		{"untrusted client spoofs headers", "203.0.113.5:1234", "1.1.1.1", "2.2.2.2", "203.0.113.5"},
+		{"trusted proxy", "10.0.0.2:80", "198.51.100.7", "", "198.51.100.7"},
+		{"proxy chain", "10.0.0.2:80", "1.1.1.1, 198.51.100.7, 10.0.0.3", "", "198.51.100.7"},
+		{"only trusted hops", "192.168.1.1:80", "10.0.0.4, 10.0.0.3", "", "10.0.0.4"},
+		{"real ip from trusted proxy", "192.168.1.1:80", "", "198.51.100.9", "198.51.100.9"},
+		{"garbage header", "10.0.0.2:80", "not-an-ip", "", "10.0.0.2"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		if tt.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		if tt.realIP != "" {
+			r.Header.Set("X-Real-IP", tt.realIP)
+		}
+		if got := resolver.ClientIP(r); got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.want, got)
+		}
+	}
+
+	if _, err := NewClientIPResolver([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("expected error for invalid CIDR")
+	}
+}
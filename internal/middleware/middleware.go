@@ -1,10 +1,16 @@
 package middleware
 
 import (
-	"fmt"
+	"log/slog"
+	"math"
 	"net/http"
-	"strings"
+	"strconv"
 	"time"
+
+	"github.com/ad/go-llm-manager/internal/logging"
+	"github.com/ad/go-llm-manager/internal/metrics"
+	"github.com/ad/go-llm-manager/internal/ratelimit"
+	"github.com/google/uuid"
 )
 
 // CORS middleware
@@ -12,7 +18,8 @@ func CORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Request-ID")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID")
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
 
 		// Handle preflight requests
@@ -25,45 +32,91 @@ func CORS(next http.Handler) http.Handler {
 	})
 }
 
-// Logging middleware
-func Logging(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-
-		// Подсчет длины заголовков
-		headersLen := 0
-		for k, v := range r.Header {
-			headersLen += len(k)
-			for _, vv := range v {
-				headersLen += len(vv)
+// Logging middleware. The client address is taken from clientIP, so access logs
+// show the same IP that route rate limits are keyed by.
+func Logging(clientIP func(r *http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			// Подсчет длины заголовков
+			headersLen := 0
+			for k, v := range r.Header {
+				headersLen += len(k)
+				for _, vv := range v {
+					headersLen += len(vv)
+				}
 			}
-		}
 
-		// Подсчет длины тела
-		var bodyLen int
-		if r.ContentLength > 0 {
-			bodyLen = int(r.ContentLength)
-		} else {
-			// Если ContentLength неизвестен, читаем body вручную (но не изменяем r.Body для хендлеров)
-			// Можно реализовать через io.TeeReader, если нужно точное значение
-		}
+			// Подсчет длины тела
+			var bodyLen int
+			if r.ContentLength > 0 {
+				bodyLen = int(r.ContentLength)
+			} else {
+				// Если ContentLength неизвестен, читаем body вручную (но не изменяем r.Body для хендлеров)
+				// Можно реализовать через io.TeeReader, если нужно точное значение
+			}
+
+			// Обертка для захвата кода ответа
+			rw := &loggingResponseWriter{ResponseWriter: w, statusCode: 200}
+			next.ServeHTTP(rw, r)
+			duration := time.Since(start)
+
+			// Маршрут берем из шаблона ServeMux, чтобы ID в путях не плодили серии
+			route := r.Pattern
+			if route == "" {
+				route = "unmatched"
+			}
+			metrics.HTTPRequests.Inc(route, r.Method, strconv.Itoa(rw.statusCode))
+			metrics.HTTPRequestDuration.Observe(duration.Seconds(), route, r.Method)
 
-		// Обертка для захвата кода ответа
-		rw := &loggingResponseWriter{ResponseWriter: w, statusCode: 200}
-		next.ServeHTTP(rw, r)
-		duration := time.Since(start)
-		// ua := r.Header.Get("User-Agent")
-		ip := r.RemoteAddr
-		if ipHeader := r.Header.Get("X-Real-IP"); ipHeader != "" {
-			ip = ipHeader
-		} else if ipHeader := r.Header.Get("X-Forwarded-For"); ipHeader != "" {
-			ip = ipHeader
+			level := slog.LevelInfo
+			if rw.statusCode >= http.StatusInternalServerError {
+				level = slog.LevelError
+			}
+			slog.LogAttrs(r.Context(), level, "request",
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.String("route", route),
+				slog.String("ip", clientIP(r)),
+				slog.Int("status", rw.statusCode),
+				slog.Float64("duration_ms", float64(duration.Microseconds())/1000),
+				slog.Int("headers_bytes", headersLen),
+				slog.Int("body_bytes", bodyLen),
+			)
+		})
+	}
+}
+
+// RequestIDHeader carries the request ID to and from clients
+const RequestIDHeader = "X-Request-ID"
+
+// RequestID middleware: takes the request ID from X-Request-ID or generates one,
+// puts it into the context for logging and returns it in the response header
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.NewString()
 		}
-		logLine := fmt.Sprintf("[REQ] %s %s from %s | %v | code=%d | headers=%dB | body=%dB", r.Method, r.URL.Path, ip, duration, rw.statusCode, headersLen, bodyLen)
-		println(logLine)
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
 	})
 }
 
+// validRequestID accepts short printable IDs from clients, so they can't inject into logs
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
 type loggingResponseWriter struct {
 	http.ResponseWriter
 	statusCode        int
@@ -98,35 +151,84 @@ func Chain(h http.Handler, middlewares ...func(http.Handler) http.Handler) http.
 	return h
 }
 
-// Rate limiting middleware
-func RateLimit(checker func(userID string) bool) func(http.Handler) http.Handler {
+// Rate limiting middleware keyed by keyFunc. allow reports whether the request fits
+// into the limit and, if not, how long the client should wait. Requests with an
+// empty key are not limited.
+func RateLimitBy(keyFunc func(r *http.Request) string, allow func(key string) (bool, time.Duration)) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Extract user ID from JWT or request
-			userID := extractUserID(r)
-			if userID != "" && !checker(userID) {
-				w.WriteHeader(http.StatusTooManyRequests)
-				w.Write([]byte(`{"error": "Rate limit exceeded"}`))
+			if key := keyFunc(r); key != "" {
+				if ok, retryAfter := allow(key); !ok {
+					tooManyRequests(w, retryAfter)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Per-route rate limiting: every route from limits gets its own token buckets,
+// one set per key function (client IP, JWT subject). A request is rejected when
+// any of its keys is over the limit. Routes without a limit pass through.
+func RateLimitRoutes(limits map[string]ratelimit.RouteLimit, keyFuncs ...func(r *http.Request) string) func(http.Handler) http.Handler {
+	buckets := make(map[string]*ratelimit.TokenBucket, len(limits))
+	for path, limit := range limits {
+		buckets[path] = ratelimit.NewTokenBucket(limit.Rate, limit.Burst)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			bucket, ok := buckets[r.URL.Path]
+			if !ok || r.Method == http.MethodOptions {
+				next.ServeHTTP(w, r)
 				return
 			}
+
+			for _, keyFunc := range keyFuncs {
+				key := keyFunc(r)
+				if key == "" {
+					continue
+				}
+				if ok, retryAfter := bucket.Allow(key); !ok {
+					slog.WarnContext(r.Context(), "Route rate limit exceeded", "method", r.Method, "path", r.URL.Path, "limit_key", key)
+					tooManyRequests(w, retryAfter)
+					return
+				}
+			}
 			next.ServeHTTP(w, r)
 		})
 	}
 }
 
-// Helper to extract user ID from request
-func extractUserID(r *http.Request) string {
-	// Try to get from JWT token first
-	auth := r.Header.Get("Authorization")
-	if auth != "" {
-		// Parse "Bearer <token>" format
-		parts := strings.SplitN(auth, " ", 2)
-		if len(parts) == 2 && parts[0] == "Bearer" && parts[1] != "" {
-			// TODO: Decode JWT and extract user_id from parts[1]
-			return ""
-		}
+// Concurrency limiting middleware: at most the limiter maximum of requests per key
+// are served at once (long-lived SSE streams). Requests with an empty key are not limited.
+func LimitConcurrency(keyFunc func(r *http.Request) string, limiter *ratelimit.ConcurrencyLimiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := keyFunc(r)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if !limiter.Acquire(key) {
+				slog.WarnContext(r.Context(), "Too many concurrent streams", "path", r.URL.Path, "limit_key", key)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusTooManyRequests)
+				w.Write([]byte(`{"error": "Too many concurrent connections"}`))
+				return
+			}
+			defer limiter.Release(key)
+
+			next.ServeHTTP(w, r)
+		})
 	}
+}
 
-	// Fallback to query parameter
-	return r.URL.Query().Get("user_id")
+func tooManyRequests(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(max(1, int(math.Ceil(retryAfter.Seconds())))))
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.Write([]byte(`{"error": "Rate limit exceeded"}`))
 }
@@ -0,0 +1,188 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ad/go-llm-manager/internal/logging"
+	"github.com/ad/go-llm-manager/internal/ratelimit"
+)
+
+func TestRateLimitBy(t *testing.T) {
+	allowed := map[string]bool{"a": true}
+	handler := RateLimitBy(
+		func(r *http.Request) string { return r.Header.Get("X-Key") },
+		func(key string) (bool, time.Duration) { return allowed[key], 1500 * time.Millisecond },
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set("X-Key", "a")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected request to pass, got %d", rr.Code)
+	}
+
+	req.Header.Set("X-Key", "b")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", rr.Code)
+	}
+	if rr.Header().Get("Retry-After") != "2" {
+		t.Errorf("expected Retry-After 2, got %q", rr.Header().Get("Retry-After"))
+	}
+}
+
+func TestRateLimitRoutes(t *testing.T) {
+	limits := map[string]ratelimit.RouteLimit{"/api/get": {Rate: 0.001, Burst: 2}}
+	handler := RateLimitRoutes(limits,
+		func(r *http.Request) string { return "ip:" + r.Header.Get("X-IP") },
+		func(r *http.Request) string { return r.Header.Get("X-Subject") },
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	do := func(path, ip, subject string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-IP", ip)
+		if subject != "" {
+			req.Header.Set("X-Subject", subject)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	for i := 0; i < 2; i++ {
+		if code := do("/api/get", "a", ""); code != http.StatusNoContent {
+			t.Fatalf("request %d within burst rejected: %d", i, code)
+		}
+	}
+	if code := do("/api/get", "a", ""); code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 over the IP limit, got %d", code)
+	}
+
+	// Маршруты без лимита не ограничиваются
+	if code := do("/api/result", "a", ""); code != http.StatusNoContent {
+		t.Fatalf("expected unlimited route to pass, got %d", code)
+	}
+
+	// Субъект ограничивается независимо от IP
+	do("/api/get", "b", "user:1")
+	do("/api/get", "c", "user:1")
+	if code := do("/api/get", "d", "user:1"); code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 over the subject limit, got %d", code)
+	}
+}
+
+func TestLimitConcurrency(t *testing.T) {
+	limiter := ratelimit.NewConcurrencyLimiter(1)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	handler := LimitConcurrency(
+		func(r *http.Request) string { return r.Header.Get("X-Key") },
+		limiter,
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Block") != "" {
+			close(started)
+			<-release
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/result-polling", nil)
+	req.Header.Set("X-Key", "user:1")
+
+	done := make(chan struct{})
+	go func() {
+		blocking := req.Clone(req.Context())
+		blocking.Header.Set("X-Block", "1")
+		handler.ServeHTTP(httptest.NewRecorder(), blocking)
+		close(done)
+	}()
+	<-started
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 for second stream, got %d", rr.Code)
+	}
+
+	close(release)
+	<-done
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNoContent || limiter.Active("user:1") != 0 {
+		t.Fatalf("expected slot to be released, got %d (active %d)", rr.Code, limiter.Active("user:1"))
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = logging.RequestID(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(RequestIDHeader, "client-id-1")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if seen != "client-id-1" || rr.Header().Get(RequestIDHeader) != "client-id-1" {
+		t.Errorf("expected client request ID to be kept, got context %q header %q", seen, rr.Header().Get(RequestIDHeader))
+	}
+
+	// Небезопасный для логов ID заменяется сгенерированным
+	req.Header.Set(RequestIDHeader, "bad id\ninjected")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if seen == "" || seen == "bad id\ninjected" || rr.Header().Get(RequestIDHeader) != seen {
+		t.Errorf("expected a generated request ID, got context %q header %q", seen, rr.Header().Get(RequestIDHeader))
+	}
+}
+
+func TestLoggingUsesResolvedClientIP(t *testing.T) {
+	var buf bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	resolver, err := NewClientIPResolver([]string{"10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := Logging(resolver.ClientIP)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, tc := range []struct {
+		remote, want string
+	}{
+		// Клиент напрямую не может подставить адрес заголовками
+		{"203.0.113.7:1234", "203.0.113.7"},
+		{"10.0.0.1:1234", "198.51.100.2"},
+	} {
+		buf.Reset()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tc.remote
+		req.Header.Set("X-Real-IP", "1.1.1.1")
+		req.Header.Set("X-Forwarded-For", "198.51.100.2")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		var entry struct {
+			IP string `json:"ip"`
+		}
+		if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+			t.Fatalf("decode log entry %q: %v", buf.String(), err)
+		}
+		if entry.IP != tc.want {
+			t.Errorf("remote %s: logged ip %q, want %q", tc.remote, entry.IP, tc.want)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package ratelimit
+
+import "sync"
+
+// ConcurrencyLimiter caps the number of simultaneous requests (e.g. open SSE
+// streams) per key. A non-positive max disables the limit.
+type ConcurrencyLimiter struct {
+	max int
+
+	mu     sync.Mutex
+	active map[string]int
+}
+
+func NewConcurrencyLimiter(max int) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{max: max, active: make(map[string]int)}
+}
+
+// Acquire takes a slot for the key. Every successful Acquire must be paired with Release.
+func (l *ConcurrencyLimiter) Acquire(key string) bool {
+	if l.max <= 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.active[key] >= l.max {
+		return false
+	}
+	l.active[key]++
+	return true
+}
+
+// Release frees a slot taken by Acquire
+func (l *ConcurrencyLimiter) Release(key string) {
+	if l.max <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.active[key] <= 1 {
+		delete(l.active, key)
+		return
+	}
+	l.active[key]--
+}
+
+// Active returns the number of slots held for the key
+func (l *ConcurrencyLimiter) Active(key string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.active[key]
+}
@@ -0,0 +1,260 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/ad/go-llm-manager/internal/database"
+)
+
+// Rate limit algorithms for per-user task quotas
+const (
+	AlgorithmFixedWindow   = "fixed_window"   // счетчик сбрасывается через Window после первого запроса
+	AlgorithmSlidingLog    = "sliding_log"    // точный учет каждого запроса за последние Window
+	AlgorithmSlidingWindow = "sliding_window" // взвешенная сумма текущего и предыдущего окна
+	AlgorithmTokenBucket   = "token_bucket"   // Limit токенов, пополняются равномерно за Window
+)
+
+// ValidAlgorithm reports whether name is a supported algorithm. Empty means the default.
+func ValidAlgorithm(name string) bool {
+	switch name {
+	case "", AlgorithmFixedWindow, AlgorithmSlidingLog, AlgorithmSlidingWindow, AlgorithmTokenBucket:
+		return true
+	}
+	return false
+}
+
+// DefaultPolicy is used when neither config nor token set a limit
+var DefaultPolicy = Policy{Algorithm: AlgorithmFixedWindow, Limit: 100, Window: 24 * time.Hour}
+
+// Policy allows Limit requests per Window using Algorithm
+type Policy struct {
+	Algorithm string
+	Limit     int
+	Window    time.Duration
+}
+
+// Override returns the policy with non-zero fields of the token rate_limit claim applied
+func (p Policy) Override(claim *database.RateLimitConfig) Policy {
+	if claim == nil {
+		return p
+	}
+	if claim.Algorithm != "" {
+		p.Algorithm = claim.Algorithm
+	}
+	if claim.MaxRequests > 0 {
+		p.Limit = claim.MaxRequests
+	}
+	if claim.WindowMs > 0 {
+		p.Window = time.Duration(claim.WindowMs) * time.Millisecond
+	}
+	return p
+}
+
+// Status is the quota state reported in X-RateLimit-* headers
+type Status struct {
+	Allowed     bool
+	Limit       int
+	Remaining   int
+	Reset       time.Time     // когда квота полностью восстановится (fixed_window: конец окна)
+	RetryAfter  time.Duration // через сколько будет разрешен следующий запрос, 0 если уже разрешен
+	LastRequest int64         // unix ms последнего учтенного запроса, 0 если не было
+}
+
+// Limiter keeps per-user quotas in the store. Peek checks the quota without
+// charging it; Charge consumes one request and should be called only once the
+// request actually succeeded.
+type Limiter struct {
+	store database.RateLimitStore
+	now   func() time.Time
+}
+
+func NewLimiter(store database.RateLimitStore) *Limiter {
+	return &Limiter{store: store, now: time.Now}
+}
+
+// Peek returns the current quota of the user without consuming it
+func (l *Limiter) Peek(ctx context.Context, userID string, p Policy) (Status, error) {
+	return l.apply(ctx, userID, p, false)
+}
+
+// Charge consumes one request from the user quota, even if it is already exhausted
+func (l *Limiter) Charge(ctx context.Context, userID string, p Policy) (Status, error) {
+	return l.apply(ctx, userID, p, true)
+}
+
+func (l *Limiter) apply(ctx context.Context, userID string, p Policy, charge bool) (Status, error) {
+	if p.Algorithm == "" {
+		p.Algorithm = AlgorithmFixedWindow
+	}
+	if !ValidAlgorithm(p.Algorithm) {
+		return Status{}, fmt.Errorf("unknown rate limit algorithm %q", p.Algorithm)
+	}
+	if p.Limit <= 0 || p.Window <= 0 {
+		return Status{}, fmt.Errorf("invalid rate limit policy: %d per %s", p.Limit, p.Window)
+	}
+
+	now := l.now().UnixMilli()
+	window := p.Window.Milliseconds()
+
+	if p.Algorithm == AlgorithmSlidingLog {
+		var entries []int64
+		var err error
+		if charge {
+			entries, err = l.store.AddRateLimitLog(ctx, userID, now, now-window)
+		} else {
+			entries, err = l.store.ListRateLimitLog(ctx, userID, now-window)
+		}
+		if err != nil {
+			return Status{}, err
+		}
+		return slidingLog(p.Limit, window, entries, now), nil
+	}
+
+	var status Status
+	compute := func(state *database.RateLimitState) *database.RateLimitState {
+		if state == nil {
+			state = &database.RateLimitState{UserID: userID, Algorithm: p.Algorithm, Tokens: float64(p.Limit)}
+		}
+		switch p.Algorithm {
+		case AlgorithmSlidingWindow:
+			status = slidingWindow(p.Limit, window, state, now, charge)
+		case AlgorithmTokenBucket:
+			status = tokenBucket(p.Limit, window, state, now, charge)
+		default:
+			status = fixedWindow(p.Limit, window, state, now, charge)
+		}
+		if charge {
+			state.UpdatedAt = now
+		}
+		status.LastRequest = state.UpdatedAt
+		return state
+	}
+
+	// Charge считает и сохраняет счетчик в одной транзакции писателя,
+	// иначе параллельные запросы затирают списания друг друга
+	if charge {
+		if err := l.store.UpdateRateLimitState(ctx, userID, p.Algorithm, compute); err != nil {
+			return Status{}, err
+		}
+		return status, nil
+	}
+
+	state, err := l.store.GetRateLimitState(ctx, userID, p.Algorithm)
+	if err != nil {
+		return Status{}, err
+	}
+	compute(state)
+
+	return status, nil
+}
+
+// newStatus fills Allowed and Remaining from the number of used requests
+func newStatus(limit, used int, reset, retryAfter int64, now int64) Status {
+	status := Status{
+		Allowed:   used < limit,
+		Limit:     limit,
+		Remaining: max(limit-used, 0),
+		Reset:     time.UnixMilli(reset),
+	}
+	if status.Remaining == 0 && retryAfter > now {
+		status.RetryAfter = time.Duration(retryAfter-now) * time.Millisecond
+	}
+	return status
+}
+
+func fixedWindow(limit int, window int64, state *database.RateLimitState, now int64, charge bool) Status {
+	if state.WindowStart+window <= now {
+		state.WindowStart = now
+		state.Count = 0
+	}
+	if charge {
+		state.Count++
+	}
+
+	reset := state.WindowStart + window
+	return newStatus(limit, state.Count, reset, reset, now)
+}
+
+// slidingWindow approximates the count over the last window as the current
+// window count plus the previous one weighted by its remaining overlap
+func slidingWindow(limit int, window int64, state *database.RateLimitState, now int64, charge bool) Status {
+	current := now - now%window
+	switch state.WindowStart {
+	case current:
+	case current - window:
+		state.PrevCount, state.Count = state.Count, 0
+	default:
+		state.PrevCount, state.Count = 0, 0
+	}
+	state.WindowStart = current
+	if charge {
+		state.Count++
+	}
+
+	elapsed := now - current
+	weighted := float64(state.PrevCount) * float64(window-elapsed) / float64(window)
+	used := state.Count + int(weighted)
+
+	// Следующий запрос разрешится, когда взвешенный вклад предыдущего окна
+	// станет строго меньше оставшейся квоты текущего
+	var retryAt int64
+	if used < limit {
+		retryAt = now
+	} else if state.Count < limit {
+		share := float64(limit-state.Count) / float64(state.PrevCount)
+		retryAt = current + int64(float64(window)*(1-share)) + 1
+	} else {
+		share := float64(limit) / float64(state.Count)
+		retryAt = current + window + int64(float64(window)*(1-share)) + 1
+	}
+
+	// Квота полностью восстанавливается, когда оба окна выходят из расчета
+	reset := current + window
+	if state.Count > 0 {
+		reset += window
+	}
+
+	return newStatus(limit, used, reset, retryAt, now)
+}
+
+// slidingLog counts every request with a timestamp in (now-window, now]
+func slidingLog(limit int, window int64, entries []int64, now int64) Status {
+	used := len(entries)
+
+	reset := now
+	if used > 0 {
+		reset = entries[used-1] + window
+	}
+
+	// Место освобождается, когда из окна выходит запрос, после которого осталось limit-1
+	var retryAt int64
+	if used >= limit {
+		retryAt = entries[used-limit] + window
+	}
+
+	return newStatus(limit, used, reset, retryAt, now)
+}
+
+// tokenBucket holds up to limit tokens and refills limit tokens per window
+func tokenBucket(limit int, window int64, state *database.RateLimitState, now int64, charge bool) Status {
+	if state.UpdatedAt > 0 {
+		refilled := float64(now-state.UpdatedAt) * float64(limit) / float64(window)
+		state.Tokens = math.Min(float64(limit), state.Tokens+refilled)
+	}
+	if charge {
+		state.Tokens--
+	}
+
+	used := limit - int(math.Floor(state.Tokens))
+	// Через window/limit восстанавливается один токен
+	refill := func(tokens float64) int64 {
+		return int64(math.Ceil(tokens * float64(window) / float64(limit)))
+	}
+	reset := now + refill(float64(limit)-state.Tokens)
+	retryAt := now + refill(1-state.Tokens)
+
+	return newStatus(limit, used, reset, retryAt, now)
+}
@@ -0,0 +1,177 @@
+package ratelimit
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ad/go-llm-manager/internal/database"
+)
+
+// Начало часового окна, чтобы границы sliding_window были предсказуемы
+var limiterEpoch = time.Unix(3600*480000, 0)
+
+func newTestLimiter(t *testing.T) (*Limiter, *time.Time) {
+	t.Helper()
+	now := limiterEpoch
+	l := NewLimiter(database.NewTestDB(t))
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func expectStatus(t *testing.T, status Status, allowed bool, remaining int, retryAfter time.Duration) {
+	t.Helper()
+	if status.Allowed != allowed || status.Remaining != remaining || status.RetryAfter != retryAfter {
+		t.Fatalf("expected allowed=%v remaining=%d retry=%v, got %+v", allowed, remaining, retryAfter, status)
+	}
+}
+
+func charge(t *testing.T, l *Limiter, p Policy, n int) Status {
+	t.Helper()
+	var status Status
+	for i := 0; i < n; i++ {
+		var err error
+		if status, err = l.Charge(t.Context(), "u-1", p); err != nil {
+			t.Fatalf("charge failed: %v", err)
+		}
+	}
+	return status
+}
+
+func peek(t *testing.T, l *Limiter, p Policy) Status {
+	t.Helper()
+	status, err := l.Peek(t.Context(), "u-1", p)
+	if err != nil {
+		t.Fatalf("peek failed: %v", err)
+	}
+	return status
+}
+
+func TestLimiterFixedWindow(t *testing.T) {
+	l, now := newTestLimiter(t)
+	p := Policy{Algorithm: AlgorithmFixedWindow, Limit: 2, Window: time.Hour}
+
+	// Peek не расходует квоту
+	for i := 0; i < 3; i++ {
+		expectStatus(t, peek(t, l, p), true, 2, 0)
+	}
+
+	expectStatus(t, charge(t, l, p, 2), false, 0, time.Hour)
+	if status := peek(t, l, p); !status.Reset.Equal(limiterEpoch.Add(time.Hour)) {
+		t.Errorf("expected reset at window end, got %v", status.Reset)
+	}
+
+	*now = now.Add(30 * time.Minute)
+	expectStatus(t, peek(t, l, p), false, 0, 30*time.Minute)
+
+	*now = now.Add(30 * time.Minute)
+	expectStatus(t, peek(t, l, p), true, 2, 0)
+}
+
+func TestLimiterSlidingLog(t *testing.T) {
+	l, now := newTestLimiter(t)
+	p := Policy{Algorithm: AlgorithmSlidingLog, Limit: 2, Window: time.Hour}
+
+	charge(t, l, p, 1)
+	*now = now.Add(20 * time.Minute)
+	expectStatus(t, charge(t, l, p, 1), false, 0, 40*time.Minute)
+
+	*now = now.Add(10 * time.Minute)
+	expectStatus(t, peek(t, l, p), false, 0, 30*time.Minute)
+
+	// Первый запрос вышел из окна, второй еще учитывается
+	*now = limiterEpoch.Add(time.Hour)
+	status := peek(t, l, p)
+	expectStatus(t, status, true, 1, 0)
+	if !status.Reset.Equal(limiterEpoch.Add(80 * time.Minute)) {
+		t.Errorf("expected reset when the last request leaves the window, got %v", status.Reset)
+	}
+}
+
+func TestLimiterSlidingWindow(t *testing.T) {
+	l, now := newTestLimiter(t)
+	p := Policy{Algorithm: AlgorithmSlidingWindow, Limit: 4, Window: time.Hour}
+
+	expectStatus(t, charge(t, l, p, 4), false, 0, time.Hour+time.Millisecond)
+
+	// В следующем окне предыдущее учитывается с весом 0.75: 3 из 4
+	*now = limiterEpoch.Add(75 * time.Minute)
+	expectStatus(t, peek(t, l, p), true, 1, 0)
+	expectStatus(t, charge(t, l, p, 1), false, 0, time.Millisecond)
+
+	*now = now.Add(time.Millisecond)
+	expectStatus(t, peek(t, l, p), true, 1, 0)
+
+	// Через два окна без запросов квота полностью восстановлена
+	*now = limiterEpoch.Add(3 * time.Hour)
+	expectStatus(t, peek(t, l, p), true, 4, 0)
+}
+
+func TestLimiterTokenBucket(t *testing.T) {
+	l, now := newTestLimiter(t)
+	p := Policy{Algorithm: AlgorithmTokenBucket, Limit: 2, Window: time.Hour}
+
+	status := charge(t, l, p, 2)
+	expectStatus(t, status, false, 0, 30*time.Minute)
+	if !status.Reset.Equal(limiterEpoch.Add(time.Hour)) {
+		t.Errorf("expected full refill in one window, got %v", status.Reset)
+	}
+
+	*now = now.Add(30 * time.Minute)
+	expectStatus(t, peek(t, l, p), true, 1, 0)
+
+	*now = now.Add(5 * time.Hour)
+	expectStatus(t, peek(t, l, p), true, 2, 0)
+}
+
+func TestLimiterConcurrentCharges(t *testing.T) {
+	const n = 20
+
+	for _, algorithm := range []string{AlgorithmFixedWindow, AlgorithmSlidingLog, AlgorithmSlidingWindow, AlgorithmTokenBucket} {
+		t.Run(algorithm, func(t *testing.T) {
+			l, _ := newTestLimiter(t)
+			p := Policy{Algorithm: algorithm, Limit: 2 * n, Window: time.Hour}
+
+			var wg sync.WaitGroup
+			for range n {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if _, err := l.Charge(t.Context(), "u-1", p); err != nil {
+						t.Errorf("charge failed: %v", err)
+					}
+				}()
+			}
+			wg.Wait()
+
+			// Ни одно списание не потеряно
+			if status := peek(t, l, p); status.Remaining != n {
+				t.Fatalf("expected %d remaining after %d concurrent charges, got %+v", n, n, status)
+			}
+		})
+	}
+}
+
+func TestLimiterRejectsInvalidPolicy(t *testing.T) {
+	l, _ := newTestLimiter(t)
+
+	for _, p := range []Policy{
+		{Algorithm: "leaky", Limit: 1, Window: time.Hour},
+		{Algorithm: AlgorithmFixedWindow, Limit: 0, Window: time.Hour},
+		{Algorithm: AlgorithmTokenBucket, Limit: 1},
+	} {
+		if _, err := l.Peek(t.Context(), "u-1", p); err == nil {
+			t.Errorf("expected error for %+v", p)
+		}
+	}
+}
+
+func TestPolicyOverride(t *testing.T) {
+	p := DefaultPolicy.Override(&database.RateLimitConfig{MaxRequests: 5, Algorithm: AlgorithmTokenBucket})
+	if p.Limit != 5 || p.Algorithm != AlgorithmTokenBucket || p.Window != DefaultPolicy.Window {
+		t.Fatalf("unexpected policy: %+v", p)
+	}
+	if DefaultPolicy.Override(nil) != DefaultPolicy {
+		t.Fatal("nil claim must keep the policy")
+	}
+}
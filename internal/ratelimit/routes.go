@@ -0,0 +1,51 @@
+package ratelimit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RouteLimit is a token bucket limit of one route: Rate requests per second, Burst at once
+type RouteLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// ParseRouteLimits parses a comma-separated list of `path=rate:burst` entries,
+// e.g. "/api/result=5:20,/api/get=0.5:10". Burst defaults to ceil(rate).
+func ParseRouteLimits(spec string) (map[string]RouteLimit, error) {
+	limits := make(map[string]RouteLimit)
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		path, value, ok := strings.Cut(entry, "=")
+		if !ok || !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("invalid route limit %q: expected /path=rate:burst", entry)
+		}
+
+		rateStr, burstStr, hasBurst := strings.Cut(value, ":")
+		rate, err := strconv.ParseFloat(rateStr, 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("invalid rate in route limit %q", entry)
+		}
+
+		burst := int(rate)
+		if float64(burst) < rate {
+			burst++
+		}
+		if hasBurst {
+			if burst, err = strconv.Atoi(burstStr); err != nil || burst <= 0 {
+				return nil, fmt.Errorf("invalid burst in route limit %q", entry)
+			}
+		}
+
+		limits[path] = RouteLimit{Rate: rate, Burst: burst}
+	}
+
+	return limits, nil
+}
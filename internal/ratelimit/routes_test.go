@@ -0,0 +1,47 @@
+package ratelimit
+
+import "testing"
+
+func TestParseRouteLimits(t *testing.T) {
+	limits, err := ParseRouteLimits(" /api/result=5:20, /api/get=0.5 ,")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if limits["/api/result"] != (RouteLimit{Rate: 5, Burst: 20}) {
+		t.Errorf("unexpected /api/result limit: %+v", limits["/api/result"])
+	}
+	if limits["/api/get"] != (RouteLimit{Rate: 0.5, Burst: 1}) {
+		t.Errorf("unexpected /api/get limit: %+v", limits["/api/get"])
+	}
+
+	for _, spec := range []string{"api/get=1", "/api/get", "/api/get=0", "/api/get=x", "/api/get=1:0"} {
+		if _, err := ParseRouteLimits(spec); err == nil {
+			t.Errorf("expected error for %q", spec)
+		}
+	}
+}
+
+func TestConcurrencyLimiter(t *testing.T) {
+	l := NewConcurrencyLimiter(2)
+	if !l.Acquire("a") || !l.Acquire("a") {
+		t.Fatal("expected two slots")
+	}
+	if l.Acquire("a") {
+		t.Fatal("expected third slot to be rejected")
+	}
+	if !l.Acquire("b") {
+		t.Fatal("expected independent slots for another key")
+	}
+
+	l.Release("a")
+	if !l.Acquire("a") {
+		t.Fatal("expected released slot to be reusable")
+	}
+
+	unlimited := NewConcurrencyLimiter(0)
+	for i := 0; i < 10; i++ {
+		if !unlimited.Acquire("a") {
+			t.Fatal("expected no limit with max 0")
+		}
+	}
+}
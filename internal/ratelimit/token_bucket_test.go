@@ -0,0 +1,55 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := NewTokenBucket(2, 3)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("request %d within burst rejected", i)
+		}
+	}
+	ok, wait := l.Allow("a")
+	if ok {
+		t.Fatal("expected request over burst to be rejected")
+	}
+	if wait != 500*time.Millisecond {
+		t.Fatalf("expected retry after 500ms, got %v", wait)
+	}
+
+	// Другие ключи не затрагиваются
+	if ok, _ := l.Allow("b"); !ok {
+		t.Fatal("expected independent bucket for another key")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Fatal("expected refilled token to be available")
+	}
+	if ok, _ := l.Allow("a"); ok {
+		t.Fatal("expected bucket to be empty again")
+	}
+}
+
+func TestTokenBucketSweepsIdleBuckets(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := NewTokenBucket(1, 1)
+	l.now = func() time.Time { return now }
+
+	l.Allow("a")
+	now = now.Add(bucketIdleTTL + time.Second)
+	l.Allow("b")
+
+	if _, ok := l.buckets["a"]; ok {
+		t.Fatal("expected idle bucket to be dropped")
+	}
+	if _, ok := l.buckets["b"]; !ok {
+		t.Fatal("expected active bucket to be kept")
+	}
+}
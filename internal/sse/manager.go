@@ -3,12 +3,14 @@ package sse
 import (
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"sync"
 	"time"
 
 	"github.com/ad/go-llm-manager/internal/database"
+	"github.com/ad/go-llm-manager/internal/logging"
+	"github.com/ad/go-llm-manager/internal/metrics"
 )
 
 type EventType string
@@ -77,11 +79,7 @@ func (m *Manager) BroadcastToTask(taskID string, event SSEEvent) {
 
 	for _, client := range m.clients {
 		if client.TaskID == taskID {
-			select {
-			case client.Events <- event:
-			default:
-				// Client channel is full, skip
-			}
+			client.TrySend(event)
 		}
 	}
 }
@@ -92,13 +90,67 @@ func (m *Manager) BroadcastToUser(userID string, event SSEEvent) {
 
 	for _, client := range m.clients {
 		if client.UserID == userID {
-			select {
-			case client.Events <- event:
-			default:
-				// Client channel is full, skip
-			}
+			client.TrySend(event)
+		}
+	}
+}
+
+// ClientCounts returns the number of open connections by client type
+func (m *Manager) ClientCounts() map[string]int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	counts := map[string]int{ClientTypeTask: 0, ClientTypeProcessor: 0}
+	for _, client := range m.clients {
+		counts[client.Type()]++
+	}
+	return counts
+}
+
+// ProcessorConnected reports whether the processor has an open task-stream connection
+func (m *Manager) ProcessorConnected(processorID string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, client := range m.clients {
+		if client.UserID == processorID && client.TaskID == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// ConnectedProcessors returns IDs of processors with an open task-stream connection
+func (m *Manager) ConnectedProcessors() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	var ids []string
+	for _, client := range m.clients {
+		if client.UserID != "" && client.TaskID == "" && !seen[client.UserID] {
+			seen[client.UserID] = true
+			ids = append(ids, client.UserID)
+		}
+	}
+	return ids
+}
+
+// DisconnectProcessor closes all task-stream connections of the processor and
+// removes them, so broadcasts no longer reach them
+func (m *Manager) DisconnectProcessor(processorID string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	closed := 0
+	for id, client := range m.clients {
+		if client.UserID == processorID && client.TaskID == "" {
+			client.Close()
+			delete(m.clients, id)
+			closed++
 		}
 	}
+	return closed
 }
 
 // Broadcasts a new pending task to all connected processor clients
@@ -108,26 +160,37 @@ func (m *Manager) BroadcastPendingTaskToProcessors(task *database.Task) {
 	for _, client := range m.clients {
 		if client.UserID != "" && client.TaskID == "" {
 			// Логируем broadcast задачи процессорам
-			log.Printf("[BROADCAST] Новая задача %s от пользователя %s отправлена процессору %s (%s)", task.ID, task.UserID, client.UserID, client.ID)
+			slog.Debug("Pending task broadcast to processor", logging.TaskID(task.ID), logging.UserID(task.UserID), logging.ProcessorID(client.UserID), "client_id", client.ID)
 
-			select {
-			case client.Events <- SSEEvent{
+			client.TrySend(SSEEvent{
 				Type: EventTaskAvailable,
 				Data: map[string]interface{}{
 					"taskId":       task.ID,
 					"priority":     task.Priority,
 					"productData":  task.ProductData,
 					"ollamaParams": task.OllamaParams,
+					"traceparent":  task.TraceParent,
 				},
 				Timestamp: time.Now().UnixMilli(),
-			}:
-			default:
-				// Channel full, skip
-			}
+			})
 		}
 	}
 }
 
+// Client types: users watching a task and processors on the task stream
+const (
+	ClientTypeTask      = "task"
+	ClientTypeProcessor = "processor"
+)
+
+// Type returns ClientTypeTask for task result streams and ClientTypeProcessor otherwise
+func (c *Client) Type() string {
+	if c.TaskID != "" {
+		return ClientTypeTask
+	}
+	return ClientTypeProcessor
+}
+
 func NewClient(id, userID, taskID string, w http.ResponseWriter, onClose func(clientID string)) *Client {
 	flusher, ok := w.(http.Flusher)
 	if !ok {
@@ -157,6 +220,25 @@ func (c *Client) Close() {
 	}
 }
 
+// TrySend queues an event without blocking. Returns false if the client is
+// closed or its channel is full.
+func (c *Client) TrySend(event SSEEvent) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return false
+	}
+
+	select {
+	case c.Events <- event:
+		return true
+	default:
+		metrics.SSEDroppedEvents.Inc(c.Type())
+		return false
+	}
+}
+
 func (c *Client) SendEvent(event SSEEvent) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -0,0 +1,29 @@
+package sse
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDisconnectProcessorRemovesClients(t *testing.T) {
+	m := NewManager()
+	stream := NewClient("c1", "proc-1", "", httptest.NewRecorder(), nil)
+	watcher := NewClient("c2", "proc-1", "t-1", httptest.NewRecorder(), nil)
+	m.AddClient(stream)
+	m.AddClient(watcher)
+
+	if n := m.DisconnectProcessor("proc-1"); n != 1 {
+		t.Fatalf("DisconnectProcessor closed %d streams, want 1", n)
+	}
+	if m.ProcessorConnected("proc-1") {
+		t.Error("processor still connected after disconnect")
+	}
+
+	// Закрытый клиент не должен получать события до RemoveClient из обработчика
+	m.BroadcastToUser("proc-1", SSEEvent{Type: EventHeartbeat})
+	m.RemoveClient(stream.ID)
+
+	if len(watcher.Events) != 1 {
+		t.Errorf("task watcher got %d events, want 1", len(watcher.Events))
+	}
+}
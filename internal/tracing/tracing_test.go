@@ -0,0 +1,38 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ad/go-llm-manager/internal/config"
+)
+
+func TestTraceParentRoundTrip(t *testing.T) {
+	const traceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	ctx := ContextWithTraceParent(context.Background(), traceParent)
+	if got := TraceParent(ctx); got != traceParent {
+		t.Errorf("expected %s, got %s", traceParent, got)
+	}
+
+	if got := TraceParent(ContextWithTraceParent(context.Background(), "garbage")); got != "" {
+		t.Errorf("expected no traceparent for invalid input, got %s", got)
+	}
+}
+
+func TestSetupExporters(t *testing.T) {
+	shutdown, err := Setup(context.Background(), config.TracingConfig{Exporter: ExporterNone}, "test")
+	if err != nil {
+		t.Fatalf("setup with exporter none failed: %v", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown failed: %v", err)
+	}
+
+	if _, err := Setup(context.Background(), config.TracingConfig{Exporter: "jaeger"}, "test"); err == nil {
+		t.Error("expected error for unknown exporter")
+	}
+	if ValidExporter("jaeger") || !ValidExporter(ExporterOTLP) {
+		t.Error("unexpected ValidExporter result")
+	}
+}
@@ -3,6 +3,7 @@ package utils
 import (
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"time"
 )
@@ -98,23 +99,21 @@ func SendDetailedError(w http.ResponseWriter, statusCode int, code, message, det
 	}
 
 	// Structured logging of the error
-	logFields := map[string]interface{}{
-		"error_code":    code,
-		"error_message": message,
-		"status_code":   statusCode,
-		"timestamp":     errorResp.Timestamp,
+	logAttrs := []any{
+		"error_code", code,
+		"error_message", message,
+		"status_code", statusCode,
 	}
 
 	if details != "" {
-		logFields["details"] = details
+		logAttrs = append(logAttrs, "details", details)
 	}
 
 	if context != nil {
-		logFields["context"] = context
+		logAttrs = append(logAttrs, "context", context)
 	}
 
-	// Log the error (in production, you'd use a proper logging library like logrus or zap)
-	fmt.Printf("[ERROR] %+v\n", logFields)
+	slog.Error("Request failed", logAttrs...)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
@@ -131,19 +130,18 @@ func LogAndSendError(w http.ResponseWriter, statusCode int, code, publicMessage,
 	}
 
 	// Log internal details but send only public message to client
-	logFields := map[string]interface{}{
-		"error_code":       code,
-		"public_message":   publicMessage,
-		"internal_details": internalDetails,
-		"status_code":      statusCode,
-		"timestamp":        time.Now().UnixMilli(),
+	logAttrs := []any{
+		"error_code", code,
+		"public_message", publicMessage,
+		"internal_details", internalDetails,
+		"status_code", statusCode,
 	}
 
 	if err != nil {
-		logFields["underlying_error"] = err.Error()
+		logAttrs = append(logAttrs, "error", err)
 	}
 
-	fmt.Printf("[ERROR] %+v\n", logFields)
+	slog.Error("Request failed", logAttrs...)
 
 	// Send only public message to client
 	SendError(w, statusCode, publicMessage)
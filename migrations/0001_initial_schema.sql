@@ -21,8 +21,7 @@ CREATE TABLE tasks (
     timeout_at INTEGER,
     ollama_params TEXT,
     estimated_duration INTEGER DEFAULT 300000, -- 5 minutes default
-    actual_duration INTEGER,
-    rating TEXT CHECK (rating IN ('upvote', 'downvote', NULL))
+    actual_duration INTEGER
 );
 
 -- Rate limiting table
@@ -58,8 +57,6 @@ CREATE INDEX idx_tasks_processor_status ON tasks(processor_id, status);
 
 -- Add additional index for better performance on ollama_params queries
 CREATE INDEX idx_tasks_ollama_params_not_null ON tasks(ollama_params) WHERE ollama_params IS NOT NULL;
--- Index for tasks with custom models in ollama_params
-CREATE INDEX idx_tasks_ollama_model ON tasks(json_extract(ollama_params, '$.model')) WHERE json_extract(ollama_params, '$.model') IS NOT NULL;
 
 -- Enhanced indexes for work stealing and load balancing
 CREATE INDEX idx_tasks_processor_heartbeat ON tasks(processor_id, heartbeat_at) WHERE status = 'processing';
@@ -108,6 +105,3 @@ WHERE status = 'processing';
 -- Index for cleanup operations
 CREATE INDEX IF NOT EXISTS idx_tasks_cleanup ON tasks(status, completed_at, updated_at)
 WHERE status IN ('completed', 'failed');
-
--- Index for task rating statistics
-CREATE INDEX IF NOT EXISTS idx_tasks_rating ON tasks(rating);
@@ -0,0 +1,18 @@
+-- Migration: Add processor registry
+-- Version: 0003
+-- Created: 2026-10-18
+
+-- Реестр процессоров: заполняется при регистрации, heartbeat и подключении к task-stream
+CREATE TABLE IF NOT EXISTS processors (
+    id TEXT PRIMARY KEY,
+    version TEXT NOT NULL DEFAULT '',
+    hostname TEXT NOT NULL DEFAULT '',
+    labels TEXT NOT NULL DEFAULT '{}',            -- JSON object
+    supported_models TEXT NOT NULL DEFAULT '[]',  -- JSON array
+    max_concurrency INTEGER NOT NULL DEFAULT 0,   -- 0 = без ограничения
+    first_seen INTEGER NOT NULL,
+    last_seen INTEGER NOT NULL,
+    state TEXT NOT NULL DEFAULT 'online' CHECK (state IN ('online', 'draining', 'offline'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_processors_state_last_seen ON processors(state, last_seen);
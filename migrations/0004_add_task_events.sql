@@ -0,0 +1,15 @@
+-- Migration: Add task events log
+-- Version: 0004
+-- Created: 2026-10-18
+
+-- Журнал событий жизненного цикла задач (requeue, fail по таймауту и т.п.)
+CREATE TABLE IF NOT EXISTS task_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    task_id TEXT NOT NULL,
+    event_type TEXT NOT NULL,
+    processor_id TEXT,
+    message TEXT,
+    created_at INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_task_events_task_id ON task_events(task_id, created_at);
@@ -0,0 +1,11 @@
+-- Migration: Add token cutoffs
+-- Version: 0005
+-- Created: 2026-10-18
+
+-- Отзыв токенов по субъекту: токены, выпущенные не позже not_before, недействительны
+CREATE TABLE IF NOT EXISTS token_cutoffs (
+    subject_type TEXT NOT NULL,  -- 'processor', 'user'
+    subject_id TEXT NOT NULL,
+    not_before INTEGER NOT NULL, -- unix ms
+    PRIMARY KEY (subject_type, subject_id)
+);
@@ -0,0 +1,16 @@
+-- Migration: Add API keys
+-- Version: 0006
+-- Created: 2026-10-18
+
+-- Ключи внутреннего API: хранится только SHA-256 хеш ключа
+CREATE TABLE IF NOT EXISTS api_keys (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    key_hash TEXT NOT NULL UNIQUE,
+    key_prefix TEXT NOT NULL,          -- первые символы ключа для отображения
+    scopes TEXT NOT NULL DEFAULT '[]', -- JSON array скоупов из auth.AllScopes: processor, admin-read, admin-write, token-mint, introspect, metrics
+    created_at INTEGER NOT NULL,
+    expires_at INTEGER,                -- NULL = бессрочный
+    last_used_at INTEGER,
+    revoked_at INTEGER
+);
@@ -0,0 +1,12 @@
+-- Migration: Add revoked tokens
+-- Version: 0007
+-- Created: 2026-10-18
+
+-- Denylist отозванных токенов по jti; запись удаляется после истечения токена
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    jti TEXT PRIMARY KEY,
+    expires_at INTEGER NOT NULL, -- unix ms, exp токена
+    revoked_at INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expires_at ON revoked_tokens(expires_at);
@@ -0,0 +1,25 @@
+-- Migration: Add rate limit algorithm state
+-- Version: 0008
+-- Created: 2026-10-18
+
+-- Состояние алгоритмов rate limit (fixed_window, sliding_window, token_bucket).
+-- Таблица rate_limits больше не пишется и очищается cleanup-ом.
+CREATE TABLE IF NOT EXISTS rate_limit_state (
+    user_id TEXT NOT NULL,
+    algorithm TEXT NOT NULL,
+    window_start INTEGER NOT NULL DEFAULT 0,
+    count INTEGER NOT NULL DEFAULT 0,
+    prev_count INTEGER NOT NULL DEFAULT 0,
+    tokens REAL NOT NULL DEFAULT 0,
+    updated_at INTEGER NOT NULL,
+    PRIMARY KEY (user_id, algorithm)
+);
+
+-- Журнал запросов для sliding_log
+CREATE TABLE IF NOT EXISTS rate_limit_log (
+    user_id TEXT NOT NULL,
+    created_at INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_rate_limit_state_updated_at ON rate_limit_state(updated_at);
+CREATE INDEX IF NOT EXISTS idx_rate_limit_log_user_created ON rate_limit_log(user_id, created_at);
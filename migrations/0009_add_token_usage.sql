@@ -0,0 +1,47 @@
+-- Migration: Add LLM token usage accounting
+-- Version: 0009
+-- Created: 2026-10-18
+
+-- Расход токенов LLM по задачам (передаётся процессором в /api/internal/complete)
+CREATE TABLE IF NOT EXISTS task_usage (
+    task_id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    model TEXT NOT NULL DEFAULT '',
+    prompt_tokens INTEGER NOT NULL DEFAULT 0,
+    completion_tokens INTEGER NOT NULL DEFAULT 0,
+    total_tokens INTEGER NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL
+);
+
+-- Суммарный расход токенов пользователя
+CREATE TABLE IF NOT EXISTS user_token_usage (
+    user_id TEXT PRIMARY KEY,
+    prompt_tokens INTEGER NOT NULL DEFAULT 0,
+    completion_tokens INTEGER NOT NULL DEFAULT 0,
+    total_tokens INTEGER NOT NULL DEFAULT 0,
+    task_count INTEGER NOT NULL DEFAULT 0,
+    updated_at INTEGER NOT NULL
+);
+
+-- Расход токенов по дням (UTC) и моделям
+CREATE TABLE IF NOT EXISTS daily_token_usage (
+    user_id TEXT NOT NULL,
+    day TEXT NOT NULL, -- YYYY-MM-DD
+    model TEXT NOT NULL DEFAULT '',
+    prompt_tokens INTEGER NOT NULL DEFAULT 0,
+    completion_tokens INTEGER NOT NULL DEFAULT 0,
+    total_tokens INTEGER NOT NULL DEFAULT 0,
+    task_count INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_id, day, model)
+);
+
+-- Индивидуальные лимиты токенов пользователей
+CREATE TABLE IF NOT EXISTS user_token_limits (
+    user_id TEXT PRIMARY KEY,
+    max_tokens INTEGER NOT NULL,
+    window_ms INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_task_usage_user_created ON task_usage(user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_daily_token_usage_day ON daily_token_usage(day);
@@ -0,0 +1,39 @@
+-- Migration: Add usage ledger for usage reports
+-- Version: 0010
+-- Created: 2026-10-18
+
+-- Учёт завершённых задач для отчётов; не очищается вместе с задачами
+CREATE TABLE IF NOT EXISTS usage_ledger (
+    task_id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    model TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL,
+    created_at INTEGER NOT NULL,
+    started_at INTEGER,
+    completed_at INTEGER NOT NULL,
+    queue_wait_ms INTEGER,
+    processing_ms INTEGER,
+    retry_count INTEGER NOT NULL DEFAULT 0,
+    prompt_tokens INTEGER,
+    completion_tokens INTEGER,
+    total_tokens INTEGER,
+    recorded_at INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_usage_ledger_completed_at ON usage_ledger(completed_at);
+CREATE INDEX IF NOT EXISTS idx_usage_ledger_user_completed ON usage_ledger(user_id, completed_at);
+
+-- Завершённые ранее задачи, которые ещё не удалены очисткой
+INSERT OR IGNORE INTO usage_ledger (
+    task_id, user_id, model, status, created_at, started_at, completed_at,
+    queue_wait_ms, processing_ms, retry_count, prompt_tokens, completion_tokens, total_tokens, recorded_at
+)
+SELECT t.id, t.user_id,
+    COALESCE(NULLIF(u.model, ''), CASE WHEN json_valid(t.ollama_params) THEN json_extract(t.ollama_params, '$.model') END, ''),
+    t.status, t.created_at, t.processing_started_at, COALESCE(t.completed_at, t.updated_at),
+    CASE WHEN t.processing_started_at IS NOT NULL THEN t.processing_started_at - t.created_at END,
+    CASE WHEN t.processing_started_at IS NOT NULL THEN COALESCE(t.completed_at, t.updated_at) - t.processing_started_at END,
+    t.retry_count, u.prompt_tokens, u.completion_tokens, u.total_tokens,
+    CAST(strftime('%s', 'now') AS INTEGER) * 1000
+FROM tasks t LEFT JOIN task_usage u ON u.task_id = t.id
+WHERE t.status IN ('completed', 'failed');
@@ -0,0 +1,6 @@
+-- Migration: Add task trace context
+-- Version: 0011
+-- Created: 2026-10-18
+
+-- W3C traceparent запроса, создавшего задачу: процессоры продолжают этот трейс
+ALTER TABLE tasks ADD COLUMN trace_parent TEXT;
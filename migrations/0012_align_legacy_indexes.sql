@@ -0,0 +1,25 @@
+-- Migration: Align indexes of databases created before versioned migrations
+-- Version: 0012
+-- Created: 2026-10-18
+
+-- Базы, созданные встроенной схемой, не получили индексы из 0001. На новых базах ничего не делает.
+CREATE INDEX IF NOT EXISTS idx_tasks_priority ON tasks(priority DESC, created_at ASC);
+CREATE INDEX IF NOT EXISTS idx_tasks_timeout ON tasks(timeout_at) WHERE timeout_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_tasks_timeout_processing ON tasks(timeout_at) WHERE status = 'processing';
+CREATE INDEX IF NOT EXISTS idx_tasks_heartbeat ON tasks(heartbeat_at) WHERE heartbeat_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_tasks_processor_status ON tasks(processor_id, status);
+CREATE INDEX IF NOT EXISTS idx_tasks_ollama_params_not_null ON tasks(ollama_params) WHERE ollama_params IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_tasks_processor_heartbeat ON tasks(processor_id, heartbeat_at) WHERE status = 'processing';
+CREATE INDEX IF NOT EXISTS idx_tasks_steal_candidates ON tasks(processor_id, heartbeat_at, priority) WHERE status = 'processing';
+CREATE INDEX IF NOT EXISTS idx_tasks_priority_created ON tasks(priority DESC, created_at ASC) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_processor_metrics_load ON processor_metrics(cpu_usage, memory_usage, queue_size);
+CREATE INDEX IF NOT EXISTS idx_tasks_claim_pending ON tasks(status, priority DESC, created_at ASC) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_tasks_processor_management ON tasks(processor_id, status, heartbeat_at);
+CREATE INDEX IF NOT EXISTS idx_tasks_work_steal ON tasks(processor_id, heartbeat_at, priority DESC) WHERE status = 'processing';
+CREATE INDEX IF NOT EXISTS idx_tasks_timeout_detection ON tasks(status, timeout_at) WHERE status = 'processing' AND timeout_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_processor_metrics_active ON processor_metrics(last_updated, cpu_usage, memory_usage) WHERE last_updated > 0;
+CREATE INDEX IF NOT EXISTS idx_tasks_completed_analysis ON tasks(status, completed_at, processing_started_at) WHERE status = 'completed' AND completed_at IS NOT NULL AND processing_started_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_tasks_user_status ON tasks(user_id, status, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_tasks_priority_processor ON tasks(priority DESC, processor_id, created_at ASC);
+CREATE INDEX IF NOT EXISTS idx_tasks_active_processing ON tasks(processor_id, processing_started_at, heartbeat_at) WHERE status = 'processing';
+CREATE INDEX IF NOT EXISTS idx_tasks_cleanup ON tasks(status, completed_at, updated_at) WHERE status IN ('completed', 'failed');
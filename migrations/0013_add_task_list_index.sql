@@ -0,0 +1,6 @@
+-- Migration: Add task list index
+-- Version: 0013
+-- Created: 2026-10-18
+
+-- Курсорная пагинация списка задач: ORDER BY created_at DESC, id DESC
+CREATE INDEX IF NOT EXISTS idx_tasks_created_id ON tasks(created_at DESC, id DESC);
@@ -0,0 +1,47 @@
+-- Migration: Add full-text search over tasks
+-- Version: 0014
+-- Created: 2026-10-18
+
+-- Постоянный целочисленный ключ задачи для FTS5. rowid самой tasks не годится:
+-- у таблицы нет INTEGER PRIMARY KEY, и VACUUM (в том числе VACUUM INTO для снимков) может его поменять.
+CREATE TABLE IF NOT EXISTS task_search_docs (
+    rowid INTEGER PRIMARY KEY,
+    task_id TEXT NOT NULL UNIQUE
+);
+
+-- Текст индекс читает из tasks через это представление и сам не хранит
+CREATE VIEW IF NOT EXISTS task_search_content AS
+SELECT d.rowid AS rowid, t.product_data, t.result, t.error_message
+FROM task_search_docs d
+JOIN tasks t ON t.id = d.task_id;
+
+CREATE VIRTUAL TABLE IF NOT EXISTS tasks_fts USING fts5(
+    product_data, result, error_message,
+    content = 'task_search_content',
+    content_rowid = 'rowid',
+    tokenize = 'unicode61 remove_diacritics 2'
+);
+
+-- Удаление из индекса с внешним содержимым требует прежних значений колонок
+CREATE TRIGGER IF NOT EXISTS tasks_fts_insert AFTER INSERT ON tasks BEGIN
+    INSERT INTO task_search_docs (task_id) VALUES (new.id);
+    INSERT INTO tasks_fts (rowid, product_data, result, error_message)
+    SELECT rowid, new.product_data, new.result, new.error_message FROM task_search_docs WHERE task_id = new.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS tasks_fts_delete AFTER DELETE ON tasks BEGIN
+    INSERT INTO tasks_fts (tasks_fts, rowid, product_data, result, error_message)
+    SELECT 'delete', rowid, old.product_data, old.result, old.error_message FROM task_search_docs WHERE task_id = old.id;
+    DELETE FROM task_search_docs WHERE task_id = old.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS tasks_fts_update AFTER UPDATE OF product_data, result, error_message ON tasks BEGIN
+    INSERT INTO tasks_fts (tasks_fts, rowid, product_data, result, error_message)
+    SELECT 'delete', rowid, old.product_data, old.result, old.error_message FROM task_search_docs WHERE task_id = old.id;
+    INSERT INTO tasks_fts (rowid, product_data, result, error_message)
+    SELECT rowid, new.product_data, new.result, new.error_message FROM task_search_docs WHERE task_id = new.id;
+END;
+
+-- Индексируем уже существующие задачи
+INSERT OR IGNORE INTO task_search_docs (task_id) SELECT id FROM tasks;
+INSERT INTO tasks_fts (tasks_fts) VALUES ('rebuild');
@@ -0,0 +1,8 @@
+// Package migrations embeds the SQL schema migrations into the binary.
+// Files are named NNNN_description.sql and applied in version order.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS